@@ -80,7 +80,7 @@ func main() {
 	platform.InitializePlatforms(db, log, authTypes)
 
 	// Setup Router
-	router := service.NewRouter(db, log, authTypes, env.MrthnWebsiteURL)
+	router := service.NewRouter(db, log, authTypes, env)
 
 	// Prepare the server
 	srv := &http.Server{
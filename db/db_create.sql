@@ -5,22 +5,84 @@ SET SEARCH_PATH = "public";
 CREATE TABLE "user"(
     id SERIAL PRIMARY KEY
 );
+CREATE TABLE organization(
+    id         SERIAL      PRIMARY KEY,
+    name       VARCHAR(50) NOT NULL,
+    created_at TIMESTAMP   NOT NULL DEFAULT now()
+);
 CREATE TABLE client(
     id     SERIAL       PRIMARY KEY,
     name   VARCHAR(50)  NOT NULL,
-    secret BYTEA,
     password TEXT NOT NULL,
-    callback TEXT
+    callback TEXT,
+    organization_id INTEGER NOT NULL REFERENCES organization(id),
+    failed_sign_ins INTEGER NOT NULL DEFAULT 0,
+    locked_until    TIMESTAMP,
+    email           VARCHAR(254), -- Where password reset tokens are sent. Clients without one can't reset their password
+    session_generation INTEGER NOT NULL DEFAULT 0 -- Bumped when the password changes, to sign out existing sessions
+);
+CREATE TABLE organization_member(
+    organization_id INTEGER     REFERENCES organization(id),
+    member_id       INTEGER     REFERENCES client(id), -- Client account that can manage the organization's clients
+    role            VARCHAR(16) NOT NULL,
+    joined_at       TIMESTAMP   NOT NULL DEFAULT now(),
+    PRIMARY KEY (organization_id, member_id)
+);
+CREATE TABLE organization_invite(
+    id              SERIAL      PRIMARY KEY,
+    organization_id INTEGER     REFERENCES organization(id),
+    invitee_id      INTEGER     REFERENCES client(id),
+    invited_by      INTEGER     REFERENCES client(id),
+    role            VARCHAR(16) NOT NULL,
+    created_at      TIMESTAMP   NOT NULL DEFAULT now(),
+    expires_at      TIMESTAMP   NOT NULL
+);
+CREATE TABLE client_password_reset(
+    id         SERIAL    PRIMARY KEY,
+    client_id  INTEGER   REFERENCES client(id),
+    token_hash BYTEA     NOT NULL,
+    expires_at TIMESTAMP NOT NULL
+);
+CREATE TABLE client_key(
+    id         SERIAL      PRIMARY KEY,
+    client_id  INTEGER     REFERENCES client(id),
+    label      VARCHAR(64) NOT NULL,
+    secret     BYTEA       NOT NULL,
+    legacy     BOOLEAN     NOT NULL DEFAULT false, -- Secret the client had before keys, used by tokens without a 'kid'
+    created_at TIMESTAMP   NOT NULL DEFAULT now(),
+    last_used  TIMESTAMP,
+    expires_at TIMESTAMP,
+    revoked_at TIMESTAMP
+);
+CREATE INDEX client_key_client_index ON client_key(client_id);
+CREATE TABLE client_redirect_uri(
+    id         SERIAL    PRIMARY KEY,
+    client_id  INTEGER   REFERENCES client(id),
+    uri        TEXT      NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT now(),
+    UNIQUE (client_id, uri)
+);
+CREATE TABLE client_scope(
+    client_id  INTEGER     REFERENCES client(id),
+    scope      VARCHAR(32) NOT NULL, -- Access to sensitive data, granted by mrthn after reviewing the client
+    granted_at TIMESTAMP   NOT NULL DEFAULT now(),
+    PRIMARY KEY (client_id, scope)
 );
 CREATE TABLE userbase(
-    id        SERIAL  PRIMARY KEY,
-    user_id   INTEGER REFERENCES "user"(id),
-    client_id INTEGER REFERENCES client(id)
+    id           SERIAL    PRIMARY KEY,
+    user_id      INTEGER   REFERENCES "user"(id),
+    client_id    INTEGER   REFERENCES client(id),
+    public_id    VARCHAR(32) NOT NULL UNIQUE, -- Random ID the client knows the user by
+    external_ref VARCHAR(255), -- Client's own ID for the user
+    created_at   TIMESTAMP NOT NULL DEFAULT now(),
+    UNIQUE (user_id, client_id),
+    UNIQUE (client_id, external_ref)
 );
 CREATE TABLE platform(
     id          SERIAL      PRIMARY KEY,
     name        VARCHAR(64) NOT NULL,
-    domain      VARCHAR(128) NOT NULL
+    domain      VARCHAR(128) NOT NULL,
+    revoke_url  VARCHAR(128) -- Where the platform's tokens are revoked. Tokens aren't revoked when not set
 );
 CREATE INDEX plat_name_index ON platform(name);
 CREATE TABLE credentials(
@@ -28,12 +90,77 @@ CREATE TABLE credentials(
     user_id           INTEGER     REFERENCES "user"(id),
     platform_id       INTEGER     REFERENCES platform(id),
     upid              VARCHAR(32) NOT NULL, -- User-Platform ID (ID of an user for an specific platform)
-    connection_string TEXT        NOT NULL
+    label             VARCHAR(64), -- Name the client gave the account, to tell apart accounts of the same platform
+    connection_string TEXT        NOT NULL,
+    linked_at         TIMESTAMP   NOT NULL DEFAULT now(),
+    last_refreshed    TIMESTAMP,
+    refresh_failed_at TIMESTAMP, -- Set while the tokens can't be refreshed, e.g. because the user revoked access
+    refresh_error     TEXT
 );
 CREATE INDEX credentials_upid_index ON credentials(upid);
+CREATE TABLE user_event(
+    id         SERIAL      PRIMARY KEY,
+    user_id    INTEGER     REFERENCES "user"(id),
+    client_id  INTEGER     REFERENCES client(id), -- Client that took the action
+    event      VARCHAR(32) NOT NULL,
+    details    TEXT,
+    created_at TIMESTAMP   NOT NULL DEFAULT now()
+);
+CREATE INDEX user_event_user_index ON user_event(user_id);
+CREATE TABLE client_event(
+    id           SERIAL      PRIMARY KEY,
+    client_id    INTEGER     REFERENCES client(id), -- Client to notify
+    event        VARCHAR(32) NOT NULL,
+    public_id    VARCHAR(32) NOT NULL, -- ID the client knew the user by
+    external_ref VARCHAR(255),
+    created_at   TIMESTAMP   NOT NULL DEFAULT now()
+);
+CREATE INDEX client_event_client_index ON client_event(client_id, id);
+CREATE TABLE consent(
+    id          SERIAL    PRIMARY KEY,
+    user_id     INTEGER   REFERENCES "user"(id),
+    client_id   INTEGER   REFERENCES client(id),
+    platform_id INTEGER   REFERENCES platform(id), -- Platform the user authorized through
+    scopes      TEXT      NOT NULL, -- Space separated scopes the user granted
+    granted_at  TIMESTAMP NOT NULL DEFAULT now(),
+    expires_at  TIMESTAMP -- Consent lasts until the user is removed when not set
+);
+CREATE INDEX consent_user_client_index ON consent(user_id, client_id);
+CREATE TABLE sharing_preference(
+    user_id       INTEGER     REFERENCES "user"(id),
+    client_id     INTEGER     REFERENCES client(id),
+    withheld_type VARCHAR(16) NOT NULL, -- 'resource' or 'platform'
+    withheld_name VARCHAR(32) NOT NULL, -- Resource or platform the client can't read
+    PRIMARY KEY (user_id, client_id, withheld_type, withheld_name)
+);
+CREATE TABLE platform_priority(
+    user_id   INTEGER     REFERENCES "user"(id),
+    client_id INTEGER     REFERENCES client(id), -- Client that stored the priority
+    resource  VARCHAR(32) NOT NULL,
+    platform  VARCHAR(32) NOT NULL,
+    rank      INTEGER     NOT NULL, -- Lower ranks are trusted more. Unused for excluded platforms
+    excluded  BOOLEAN     NOT NULL DEFAULT false, -- The platform is left out of the resource
+    PRIMARY KEY (user_id, client_id, resource, platform)
+);
+CREATE TABLE user_alias(
+    public_id  VARCHAR(32) PRIMARY KEY, -- Public ID the client knew a merged user by
+    client_id  INTEGER     REFERENCES client(id),
+    user_id    INTEGER     REFERENCES "user"(id), -- User it was merged into
+    merged_at  TIMESTAMP   NOT NULL DEFAULT now()
+);
+CREATE TABLE user_merge_authorization(
+    client_id          INTEGER   REFERENCES client(id),
+    source_user_id     INTEGER   REFERENCES "user"(id),
+    target_user_id     INTEGER   REFERENCES "user"(id),
+    authorized_user_id INTEGER   REFERENCES "user"(id), -- User the person logged in to, to show they own it
+    expires_at         TIMESTAMP NOT NULL,
+    PRIMARY KEY (client_id, source_user_id, target_user_id, authorized_user_id)
+);
 -- Insert initial setup values
-INSERT INTO client (name, password, callback)
-VALUES ('Passive Marathon', 'bad_hash', 'test_callback');
+INSERT INTO organization (name) VALUES ('Passive Marathon');
+INSERT INTO client (name, password, callback, organization_id)
+VALUES ('Passive Marathon', 'bad_hash', 'https://mrthn.dev/callback', 1);
+INSERT INTO organization_member (organization_id, member_id, role) VALUES (1, 1, 'owner');
 
 CREATE TABLE user_data(
     id                SERIAL      PRIMARY KEY,
@@ -45,6 +172,6 @@ CREATE TABLE user_data(
     distance          FLOAT       NOT NULL
 );
 
-INSERT INTO platform (name, domain) VALUES ('google', 'https://www.googleapis.com/fitness/v1');
-INSERT INTO platform (name, domain) VALUES ('fitbit', 'https://api.fitbit.com/1');
-INSERT INTO platform (name, domain) VALUES ('strava', 'https://www.strava.com/api/v3');
+INSERT INTO platform (name, domain, revoke_url) VALUES ('google', 'https://www.googleapis.com/fitness/v1', 'https://oauth2.googleapis.com/revoke');
+INSERT INTO platform (name, domain, revoke_url) VALUES ('fitbit', 'https://api.fitbit.com/1', 'https://api.fitbit.com/oauth2/revoke');
+INSERT INTO platform (name, domain, revoke_url) VALUES ('strava', 'https://www.strava.com/api/v3', 'https://www.strava.com/oauth/deauthorize');
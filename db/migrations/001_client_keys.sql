@@ -0,0 +1,24 @@
+-- Moves each client's single secret into client_key, so tokens signed with it keep working after the upgrade.
+-- Tokens signed with the old secret have no 'kid' header, and are validated against the client's legacy key
+-- until the client revokes it
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS client_key(
+    id         SERIAL      PRIMARY KEY,
+    client_id  INTEGER     REFERENCES client(id),
+    label      VARCHAR(64) NOT NULL,
+    secret     BYTEA       NOT NULL,
+    legacy     BOOLEAN     NOT NULL DEFAULT false,
+    created_at TIMESTAMP   NOT NULL DEFAULT now(),
+    last_used  TIMESTAMP,
+    expires_at TIMESTAMP,
+    revoked_at TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS client_key_client_index ON client_key(client_id);
+
+INSERT INTO client_key (client_id, label, secret, legacy)
+SELECT id, 'legacy', secret, true FROM client WHERE secret IS NOT NULL;
+
+ALTER TABLE client DROP COLUMN secret;
+
+COMMIT;
@@ -0,0 +1,10 @@
+-- Revocation URLs used to be hardcoded to the production hosts. They are now configured along with each platform's domain
+BEGIN;
+
+ALTER TABLE platform ADD COLUMN revoke_url VARCHAR(128);
+
+UPDATE platform SET revoke_url = 'https://oauth2.googleapis.com/revoke' WHERE name = 'google';
+UPDATE platform SET revoke_url = 'https://api.fitbit.com/oauth2/revoke' WHERE name = 'fitbit';
+UPDATE platform SET revoke_url = 'https://www.strava.com/oauth/deauthorize' WHERE name = 'strava';
+
+COMMIT;
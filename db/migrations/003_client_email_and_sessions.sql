@@ -0,0 +1,8 @@
+-- Password reset tokens are emailed to the client instead of being handed out by organization owners,
+-- and changing the password signs out the client's existing sessions
+BEGIN;
+
+ALTER TABLE client ADD COLUMN email VARCHAR(254);
+ALTER TABLE client ADD COLUMN session_generation INTEGER NOT NULL DEFAULT 0;
+
+COMMIT;
@@ -0,0 +1,13 @@
+-- Users are only merged after the person logs in to both of them, since every client that has either one ends up with the merged user
+BEGIN;
+
+CREATE TABLE user_merge_authorization(
+    client_id          INTEGER   REFERENCES client(id),
+    source_user_id     INTEGER   REFERENCES "user"(id),
+    target_user_id     INTEGER   REFERENCES "user"(id),
+    authorized_user_id INTEGER   REFERENCES "user"(id), -- User the person logged in to, to show they own it
+    expires_at         TIMESTAMP NOT NULL,
+    PRIMARY KEY (client_id, source_user_id, target_user_id, authorized_user_id)
+);
+
+COMMIT;
@@ -0,0 +1,21 @@
+-- Users already in a userbase are given the random public ID their client will know them by from now on,
+-- and each user can only be in a client's userbase once
+BEGIN;
+
+CREATE EXTENSION IF NOT EXISTS pgcrypto;
+
+ALTER TABLE userbase ADD COLUMN IF NOT EXISTS public_id VARCHAR(32);
+
+DELETE FROM userbase a USING userbase b
+WHERE a.user_id = b.user_id AND a.client_id = b.client_id AND a.id > b.id;
+
+-- Same format as the IDs generated by the API: 16 random bytes, base64url encoded without padding
+UPDATE userbase
+SET public_id = translate(rtrim(encode(gen_random_bytes(16), 'base64'), '='), '+/', '-_')
+WHERE public_id IS NULL;
+
+ALTER TABLE userbase ALTER COLUMN public_id SET NOT NULL;
+ALTER TABLE userbase ADD CONSTRAINT userbase_public_id_key UNIQUE (public_id);
+ALTER TABLE userbase ADD CONSTRAINT userbase_user_id_client_id_key UNIQUE (user_id, client_id);
+
+COMMIT;
@@ -0,0 +1,46 @@
+-- Every client now belongs to an organization. Existing clients are each given a personal organization named
+-- after them, that they own, the same as new clients get when signing up
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS organization(
+    id         SERIAL      PRIMARY KEY,
+    name       VARCHAR(50) NOT NULL,
+    created_at TIMESTAMP   NOT NULL DEFAULT now()
+);
+CREATE TABLE IF NOT EXISTS organization_member(
+    organization_id INTEGER     REFERENCES organization(id),
+    member_id       INTEGER     REFERENCES client(id),
+    role            VARCHAR(16) NOT NULL,
+    joined_at       TIMESTAMP   NOT NULL DEFAULT now(),
+    PRIMARY KEY (organization_id, member_id)
+);
+CREATE TABLE IF NOT EXISTS organization_invite(
+    id              SERIAL      PRIMARY KEY,
+    organization_id INTEGER     REFERENCES organization(id),
+    invitee_id      INTEGER     REFERENCES client(id),
+    invited_by      INTEGER     REFERENCES client(id),
+    role            VARCHAR(16) NOT NULL,
+    created_at      TIMESTAMP   NOT NULL DEFAULT now(),
+    expires_at      TIMESTAMP   NOT NULL
+);
+
+ALTER TABLE client ADD COLUMN IF NOT EXISTS organization_id INTEGER REFERENCES organization(id);
+
+-- Remembers which client each new organization was made for, until the client points to it
+ALTER TABLE organization ADD COLUMN owner_client_id INTEGER;
+
+INSERT INTO organization (name, owner_client_id)
+SELECT name, id FROM client WHERE organization_id IS NULL;
+
+UPDATE client c SET organization_id = o.id
+FROM organization o
+WHERE o.owner_client_id = c.id;
+
+INSERT INTO organization_member (organization_id, member_id, role)
+SELECT id, owner_client_id, 'owner' FROM organization WHERE owner_client_id IS NOT NULL;
+
+ALTER TABLE organization DROP COLUMN owner_client_id;
+
+ALTER TABLE client ALTER COLUMN organization_id SET NOT NULL;
+
+COMMIT;
@@ -24,8 +24,11 @@ MRTHN_PID=$!
 
 sleep 1 # Give the server time to start
 
-# Generate JWT for authentication
-curl -s "http://localhost:$PORT/get-token?id=1" -H "Origin: https://mrthn.dev"> token.txt
+# Sign in as the test client and generate JWT for authentication
+# Creating a token changes state, so it needs the CSRF token of the session
+CSRF_TOKEN=$(curl -s -c cookies.txt "http://localhost:$PORT/signin" -H "Origin: https://mrthn.dev" \
+  -F "name=Sandwich" -F "password=Sandwich_Password" | sed -n 's/.*"csrfToken":"\([^"]*\)".*/\1/p')
+curl -s -b cookies.txt -X POST "http://localhost:$PORT/get-token" -H "X-CSRF-Token: $CSRF_TOKEN" > token.txt
 
 # Run mock third-party server
 rackup integration/sandwich/server/config.ru > log/test-server.log 2>&1 &
@@ -45,6 +48,7 @@ kill -2 $SERVER_PID
 
 rm ./mrthn
 rm ./token.txt
+rm ./cookies.txt
 
 # Clear database
 psql -a $DB_CONNECTION_STRING -f integration/sql/clear-db.sql > log/db_script.log
@@ -14,7 +14,7 @@ class SandwichTest < Minitest::Test
     end
 
     def test_get_steps_fitbit
-        response = HTTParty.get('http://localhost:8080/user/1/steps/daily?date=2020-02-13', {
+        response = HTTParty.get('http://localhost:8080/user/sandwich_user_1/steps/daily?date=2020-02-13', {
             headers: {
                 "User-Agent" => "Sandwich",
                 "Authorization" => "Bearer #{@jwt}",
@@ -22,13 +22,13 @@ class SandwichTest < Minitest::Test
         })
 
         parsed = JSON.parse(response.body)
-        assert_equal 1, parsed["id"]
+        assert_equal "sandwich_user_1", parsed["id"]
         assert_equal 'fitbit', parsed["result"][0]["platform"]
         assert_equal 2020, parsed["result"][0]["value"]
     end
 
     def test_get_steps_google
-        response = HTTParty.get('http://localhost:8080/user/2/steps/daily?date=2020-02-13', {
+        response = HTTParty.get('http://localhost:8080/user/sandwich_user_2/steps/daily?date=2020-02-13', {
             headers: {
                 "User-Agent" => "Sandwich",
                  "Authorization" => "Bearer #{@jwt}",
@@ -36,13 +36,13 @@ class SandwichTest < Minitest::Test
         })
 
         parsed = JSON.parse(response.body)
-        assert_equal 2, parsed["id"]
+        assert_equal "sandwich_user_2", parsed["id"]
         assert_equal 'google', parsed["result"][0]["platform"]
         assert_equal 500, parsed["result"][0]["value"]
     end
 
     def test_get_calories_google
-        response = HTTParty.get('http://localhost:8080/user/2/calories/daily?date=2020-02-13', {
+        response = HTTParty.get('http://localhost:8080/user/sandwich_user_2/calories/daily?date=2020-02-13', {
             headers: {
                 "User-Agent" => "Sandwich",
                  "Authorization" => "Bearer #{@jwt}",
@@ -50,13 +50,13 @@ class SandwichTest < Minitest::Test
         })
 
         parsed = JSON.parse(response.body)
-        assert_equal 2, parsed["id"]
+        assert_equal "sandwich_user_2", parsed["id"]
         assert_equal 'google', parsed["result"][0]["platform"]
         assert_equal 1635, parsed["result"][0]["value"]
     end
 
     def test_get_distance_google
-        response = HTTParty.get('http://localhost:8080/user/2/distance/daily?date=2020-02-13', {
+        response = HTTParty.get('http://localhost:8080/user/sandwich_user_2/distance/daily?date=2020-02-13', {
             headers: {
                 "User-Agent" => "Sandwich",
                 "Authorization" => "Bearer #{@jwt}",
@@ -64,13 +64,13 @@ class SandwichTest < Minitest::Test
         })
 
         parsed = JSON.parse(response.body)
-        assert_equal 2, parsed["id"]
+        assert_equal "sandwich_user_2", parsed["id"]
         assert_equal 'google', parsed["result"][0]["platform"]
         assert_equal 3.456, parsed["result"][0]["value"]
     end
 
     def test_get_distance_strava
-        response = HTTParty.get('http://localhost:8080/user/4/distance/daily?date=2020-02-13', {
+        response = HTTParty.get('http://localhost:8080/user/sandwich_user_4/distance/daily?date=2020-02-13', {
             headers: {
                 "User-Agent" => "Sandwich",
                 "Authorization" => "Bearer #{@jwt}",
@@ -78,20 +78,20 @@ class SandwichTest < Minitest::Test
         })
 
         parsed = JSON.parse(response.body)
-        assert_equal 4, parsed["id"]
+        assert_equal "sandwich_user_4", parsed["id"]
         assert_equal 'strava', parsed["result"][0]["platform"]
         assert_equal 1.304, parsed["result"][0]["value"]
     end
 
     def test_get_calories_all_platforms
-        response = HTTParty.get('http://localhost:8080/user/3/calories/daily?date=2020-02-13', {
+        response = HTTParty.get('http://localhost:8080/user/sandwich_user_3/calories/daily?date=2020-02-13', {
             headers: {
                 "User-Agent" => "Sandwich",
                 "Authorization" => "Bearer #{@jwt}",
             }
         })
         parsed = JSON.parse(response.body)
-        assert_equal 3, parsed["id"]
+        assert_equal "sandwich_user_3", parsed["id"]
 
         # It appears fitbit will come before google in the return object
         assert_equal 'fitbit', parsed["result"][0]["platform"]
@@ -105,7 +105,7 @@ class SandwichTest < Minitest::Test
     end
 
     def test_get_max_calories
-        response = HTTParty.get('http://localhost:8080/user/3/calories/daily?largestOnly=true&date=2020-02-13', {
+        response = HTTParty.get('http://localhost:8080/user/sandwich_user_3/calories/daily?largestOnly=true&date=2020-02-13', {
             headers: {
                 "User-Agent" => "Sandwich",
                 "Authorization" => "Bearer #{@jwt}",
@@ -113,7 +113,7 @@ class SandwichTest < Minitest::Test
         })
 
         parsed = JSON.parse(response.body)
-        assert_equal 3, parsed["id"]
+        assert_equal "sandwich_user_3", parsed["id"]
 
         # There should only be the google platform returned, because it has more calories than Fitbit
         assert_equal 'google', parsed["result"][0]["platform"]
@@ -121,7 +121,7 @@ class SandwichTest < Minitest::Test
     end
 
     def test_get_max_steps
-        response = HTTParty.get('http://localhost:8080/user/3/steps/daily?largestOnly=true&date=2020-02-13', {
+        response = HTTParty.get('http://localhost:8080/user/sandwich_user_3/steps/daily?largestOnly=true&date=2020-02-13', {
             headers: {
                 "User-Agent" => "Sandwich",
                 "Authorization" => "Bearer #{@jwt}",
@@ -129,7 +129,7 @@ class SandwichTest < Minitest::Test
         })
 
         parsed = JSON.parse(response.body)
-        assert_equal 3, parsed["id"]
+        assert_equal "sandwich_user_3", parsed["id"]
 
         # The Fitbit platform has more steps than Google, so it should return the Fitbit Amount
         assert_equal 'fitbit', parsed["result"][0]["platform"]
@@ -137,7 +137,7 @@ class SandwichTest < Minitest::Test
     end
 
     def test_get_max_distance
-        response = HTTParty.get('http://localhost:8080/user/3/distance/daily?largestOnly=true&date=2020-02-13', {
+        response = HTTParty.get('http://localhost:8080/user/sandwich_user_3/distance/daily?largestOnly=true&date=2020-02-13', {
             headers: {
                 "User-Agent" => "Sandwich",
                 "Authorization" => "Bearer #{@jwt}",
@@ -145,7 +145,7 @@ class SandwichTest < Minitest::Test
         })
 
         parsed = JSON.parse(response.body)
-        assert_equal 3, parsed["id"]
+        assert_equal "sandwich_user_3", parsed["id"]
 
         # Google has more distance than fitbit
         assert_equal 'google', parsed["result"][0]["platform"]
@@ -246,7 +246,7 @@ class SandwichTest < Minitest::Test
         response = HTTParty.post('http://localhost:8080/client/34/callback', {
             :multipart => true,
             :body => {
-                :callback => 'Sandwich'
+                :callback => 'https://sandwich.example.com/callback'
             },
             :headers => {
                 'Content-Type' => 'multipart/form-data',
@@ -263,7 +263,7 @@ class SandwichTest < Minitest::Test
         response = HTTParty.post('http://localhost:8080/client/notaninteger/callback', {
             :multipart => true,
             :body => {
-                :callback => 'Sandwich'
+                :callback => 'https://sandwich.example.com/callback'
             },
             :headers => {
                 'Content-Type' => 'multipart/form-data',
@@ -280,7 +280,7 @@ class SandwichTest < Minitest::Test
         response = HTTParty.post('http://localhost:8080/client/1/callback', {
             :multipart => true,
             :body => {
-                :callback => 'Sandwich_callback'
+                :callback => 'https://sandwich.example.com/new_callback'
             },
             :headers => {
                 'Content-Type' => 'multipart/form-data',
@@ -290,6 +290,6 @@ class SandwichTest < Minitest::Test
         parsed = JSON.parse(response.body)
 
         assert_equal true, parsed["success"]
-        assert_equal 'Sandwich_callback', parsed["updatedCallback"]
+        assert_equal 'https://sandwich.example.com/new_callback', parsed["updatedCallback"]
     end
 end
\ No newline at end of file
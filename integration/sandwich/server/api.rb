@@ -6,6 +6,12 @@ module TestServer
 
         # Mocks Fitbit endpoints
         resource :fitbit do
+            resource :oauth2 do
+                post :revoke do
+                    {}
+                end
+            end
+
             resource :user do
                 route_param :user_id do
                     resource :activities do
@@ -46,6 +52,10 @@ module TestServer
 
        # Mocks Google endpoints
        resource :google do
+            post :revoke do
+                {}
+            end
+
             resource :fitness do
                 resource :v1 do
                     resource :users do
@@ -103,6 +113,12 @@ module TestServer
 
        # Mocks Strava endpoints
        resource :strava do
+              resource :oauth do
+                     post :deauthorize do
+                            {}
+                     end
+              end
+
               resource :athlete do
                      get :"activities" do
 
@@ -1,14 +1,36 @@
 -- Restart DB
 DELETE FROM credentials;
+DELETE FROM user_event;
+DELETE FROM user_alias;
+DELETE FROM user_merge_authorization;
+DELETE FROM sharing_preference;
+DELETE FROM platform_priority;
+DELETE FROM consent;
+DELETE FROM client_event;
 DELETE FROM platform;
 DELETE FROM userbase;
+DELETE FROM client_key;
+DELETE FROM client_password_reset;
+DELETE FROM client_redirect_uri;
+DELETE FROM client_scope;
+DELETE FROM organization_invite;
+DELETE FROM organization_member;
 DELETE FROM client;
+DELETE FROM organization;
 DELETE FROM user_data;
 DELETE FROM "user";
 
 ALTER SEQUENCE credentials_id_seq RESTART WITH 1;
+ALTER SEQUENCE user_event_id_seq RESTART WITH 1;
+ALTER SEQUENCE client_event_id_seq RESTART WITH 1;
+ALTER SEQUENCE consent_id_seq RESTART WITH 1;
 ALTER SEQUENCE platform_id_seq RESTART WITH 1;
 ALTER SEQUENCE userbase_id_seq RESTART WITH 1;
+ALTER SEQUENCE client_key_id_seq RESTART WITH 1;
+ALTER SEQUENCE client_password_reset_id_seq RESTART WITH 1;
+ALTER SEQUENCE client_redirect_uri_id_seq RESTART WITH 1;
+ALTER SEQUENCE organization_invite_id_seq RESTART WITH 1;
 ALTER SEQUENCE client_id_seq RESTART WITH 1;
+ALTER SEQUENCE organization_id_seq RESTART WITH 1;
 ALTER SEQUENCE user_id_seq RESTART WITH 1;
 
@@ -10,6 +10,7 @@ import (
 	"io"
 	"io/ioutil"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/msgurgel/mrthn/pkg/environment"
@@ -30,6 +31,12 @@ type OAuth2Result struct {
 	Token        *oauth2.Token
 	ClientID     int
 	UserID       int
+	MergeUserID  int      // User to merge into UserID. The user logs in to authorize the merge instead of linking an account
+	ExternalRef  string   // Client's own ID for the user, if it gave one at login
+	Label        string   // Name the client gave the platform account, if any
+	ConsentDays  int      // Days the user's consent lasts. Zero if it doesn't expire
+	Scopes       []string // Scopes the user granted on the platform
+	Portal       bool     // The user is signing in to the user portal, instead of linking an account for a client
 	PlatformName string
 	PlatformID   string
 }
@@ -37,12 +44,17 @@ type OAuth2Result struct {
 // When a user needs to request OAuth2 authorization, we need to save the important information in the state object
 // When the callback occurs, we compare the StateObject with the one that we got back
 type StateKeys struct {
-	UserID   int
-	Platform string
-	State    []byte
-	URL      string
-	Callback string
-	ClientID int
+	UserID      int
+	MergeUserID int
+	ExternalRef string
+	Label       string
+	ConsentDays int
+	Portal      bool
+	Platform    string
+	State       []byte
+	URL         string
+	Callback    string
+	ClientID    int
 }
 
 // CreateStateObjectParams encapsulates all the params needed to call the CreateStateObject func
@@ -50,7 +62,12 @@ type CreateStateObjectParams struct {
 	CallbackURL string
 	Service     string
 	ClientID    int
-	UserID      int // Optional parameter
+	UserID      int    // Optional parameter
+	MergeUserID int    // Optional parameter. Needs UserID
+	ExternalRef string // Optional parameter
+	Label       string // Optional parameter
+	ConsentDays int    // Optional parameter
+	Portal      bool   // Set when signing in to the user portal. ClientID is not used then
 }
 
 // UserProfileResponse is a json structure representing the response of calling the users google profile
@@ -109,6 +126,12 @@ func (o *OAuth2) ObtainUserTokens(stateKey string, code string) (result OAuth2Re
 				Token:        token,
 				ClientID:     returnedState.ClientID,
 				UserID:       returnedState.UserID,
+				MergeUserID:  returnedState.MergeUserID,
+				ExternalRef:  returnedState.ExternalRef,
+				Label:        returnedState.Label,
+				ConsentDays:  returnedState.ConsentDays,
+				Scopes:       grantedScopes(token, o.Configs["fitbit"]),
+				Portal:       returnedState.Portal,
 				PlatformName: returnedState.Platform,
 				PlatformID:   token.Extra("user_id").(string),
 			}
@@ -127,6 +150,12 @@ func (o *OAuth2) ObtainUserTokens(stateKey string, code string) (result OAuth2Re
 			Token:        tokens,
 			ClientID:     returnedState.ClientID,
 			UserID:       returnedState.UserID,
+			MergeUserID:  returnedState.MergeUserID,
+			ExternalRef:  returnedState.ExternalRef,
+			Label:        returnedState.Label,
+			ConsentDays:  returnedState.ConsentDays,
+			Scopes:       grantedScopes(tokens, o.Configs[returnedState.Platform]),
+			Portal:       returnedState.Portal,
 			PlatformName: returnedState.Platform,
 		}
 
@@ -166,6 +195,12 @@ func (o *OAuth2) ObtainUserTokens(stateKey string, code string) (result OAuth2Re
 			Token:        tokens,
 			ClientID:     returnedState.ClientID,
 			UserID:       returnedState.UserID,
+			MergeUserID:  returnedState.MergeUserID,
+			ExternalRef:  returnedState.ExternalRef,
+			Label:        returnedState.Label,
+			ConsentDays:  returnedState.ConsentDays,
+			Scopes:       grantedScopes(tokens, o.Configs[returnedState.Platform]),
+			Portal:       returnedState.Portal,
 			PlatformName: returnedState.Platform,
 			PlatformID:   fmt.Sprintf("%f", tokens.Extra("athlete").(map[string]interface{})["id"].(float64)),
 		}
@@ -199,6 +234,11 @@ func (o *OAuth2) CreateStateObject(p CreateStateObjectParams) (StateKeys, error)
 		returnedKeys.Callback = p.CallbackURL
 		returnedKeys.ClientID = p.ClientID
 		returnedKeys.UserID = p.UserID
+		returnedKeys.MergeUserID = p.MergeUserID
+		returnedKeys.ExternalRef = p.ExternalRef
+		returnedKeys.Label = p.Label
+		returnedKeys.ConsentDays = p.ConsentDays
+		returnedKeys.Portal = p.Portal
 
 		// Add this state to the state map
 		o.CurrentStates[string(returnedKeys.State)] = returnedKeys
@@ -207,6 +247,15 @@ func (o *OAuth2) CreateStateObject(p CreateStateObjectParams) (StateKeys, error)
 	return returnedKeys, nil
 }
 
+// grantedScopes returns the scopes the user agreed to. Platforms that don't say which were granted got the ones requested
+func grantedScopes(tokens *oauth2.Token, conf *oauth2.Config) []string {
+	if scope, ok := tokens.Extra("scope").(string); ok && scope != "" {
+		return strings.Fields(scope)
+	}
+
+	return conf.Scopes
+}
+
 func RefreshOAuth2Tokens(tokens *oauth2.Token, conf *oauth2.Config) (*oauth2.Token, error) {
 	// Attempt to refresh token
 	tokenSource := conf.TokenSource(context.Background(), tokens)
@@ -226,7 +275,7 @@ func initializeOAuth2Map(configs *environment.MrthnConfig) map[string]*oauth2.Co
 		RedirectURL:  configs.Callback,
 		ClientID:     configs.Fitbit.ClientID,
 		ClientSecret: configs.Fitbit.ClientSecret,
-		Scopes:       []string{"activity", "profile", "settings", "heartrate"},
+		Scopes:       []string{"activity", "profile", "settings", "heartrate", "sleep", "weight"},
 		Endpoint:     endpoints.Fitbit,
 	}
 
@@ -234,7 +283,7 @@ func initializeOAuth2Map(configs *environment.MrthnConfig) map[string]*oauth2.Co
 		RedirectURL:  configs.Callback,
 		ClientID:     configs.Google.ClientID,
 		ClientSecret: configs.Google.ClientSecret,
-		Scopes:       []string{"https://www.googleapis.com/auth/fitness.activity.read", "https://www.googleapis.com/auth/fitness.location.read", "https://www.googleapis.com/auth/gmail.readonly"},
+		Scopes:       []string{"https://www.googleapis.com/auth/fitness.activity.read", "https://www.googleapis.com/auth/fitness.location.read", "https://www.googleapis.com/auth/fitness.heart_rate.read", "https://www.googleapis.com/auth/fitness.sleep.read", "https://www.googleapis.com/auth/fitness.body.read", "https://www.googleapis.com/auth/gmail.readonly"},
 		Endpoint:     endpoints.Google,
 	}
 
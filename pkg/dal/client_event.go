@@ -0,0 +1,61 @@
+package dal
+
+import (
+	"database/sql"
+	"time"
+)
+
+// Events clients are notified of
+const (
+	ClientEventAccessRevoked = "access_revoked" // A user took away the client's access to their data
+)
+
+type ClientEvent struct {
+	ID          int
+	Event       string
+	PublicID    string // ID the client knew the user by
+	ExternalRef string // Client's own ID for the user, if it had set one
+	CreatedAt   time.Time
+}
+
+func insertClientEvent(tx *sql.Tx, clientID int, event string, publicID string, externalRef string) error {
+	_, err := tx.Exec(
+		`INSERT INTO client_event (client_id, event, public_id, external_ref) VALUES ($1, $2, $3, NULLIF($4, ''))`,
+		clientID,
+		event,
+		publicID,
+		externalRef,
+	)
+	return err
+}
+
+// GetClientEvents returns up to limit of the client's events that came after the event with ID afterID, oldest first
+func GetClientEvents(db *sql.DB, clientID int, afterID int, limit int) ([]ClientEvent, error) {
+	rows, err := db.Query(
+		`SELECT id, event, public_id, COALESCE(external_ref, ''), created_at FROM client_event
+				WHERE client_id = $1 AND id > $2
+				ORDER BY id
+				LIMIT $3`,
+		clientID,
+		afterID,
+		limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	var events []ClientEvent
+	for rows.Next() {
+		var event ClientEvent
+		err := rows.Scan(&event.ID, &event.Event, &event.PublicID, &event.ExternalRef, &event.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		events = append(events, event)
+	}
+
+	return events, nil
+}
@@ -0,0 +1,164 @@
+package dal
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// MaxActiveClientKeys is how many keys a client can have that are neither revoked nor expired
+const MaxActiveClientKeys = 10
+
+var ErrTooManyClientKeys = errors.New("client already has the maximum amount of active keys")
+
+// ClientKey is one of the named signing keys a client uses to generate JWTs.
+// The secret itself is never returned when listing keys
+type ClientKey struct {
+	ID        int
+	Label     string
+	CreatedAt time.Time
+	LastUsed  sql.NullTime
+	ExpiresAt sql.NullTime
+}
+
+// InsertClientKey stores a new signing key for the client and returns its ID.
+// An invalid expiresAt means that the key never expires. It returns ErrTooManyClientKeys
+// if the client already has MaxActiveClientKeys active keys
+func InsertClientKey(db *sql.DB, clientID int, label string, secret []byte, expiresAt sql.NullTime) (keyID int, err error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		} else {
+			err = tx.Commit()
+		}
+	}()
+
+	// Locking the client makes concurrent requests count the keys one after the other, so none of them goes over the limit
+	_, err = tx.Exec(`SELECT id FROM client WHERE id = $1 FOR UPDATE`, clientID)
+	if err != nil {
+		return 0, err
+	}
+
+	var activeKeys int
+	err = tx.QueryRow(
+		`SELECT COUNT(*) FROM client_key
+				WHERE client_id = $1 AND revoked_at IS NULL AND (expires_at IS NULL OR expires_at > now())`,
+		clientID,
+	).Scan(&activeKeys)
+	if err != nil {
+		return 0, err
+	}
+
+	if activeKeys >= MaxActiveClientKeys {
+		return 0, ErrTooManyClientKeys
+	}
+
+	err = tx.QueryRow(
+		`INSERT INTO client_key (client_id, label, secret, expires_at) VALUES ($1, $2, $3, $4) RETURNING id`,
+		clientID,
+		label,
+		secret,
+		expiresAt,
+	).Scan(&keyID)
+	if err != nil {
+		return 0, err
+	}
+
+	return keyID, nil
+}
+
+// GetClientKeys returns all the keys of a client that have not been revoked, including expired ones
+func GetClientKeys(db *sql.DB, clientID int) ([]ClientKey, error) {
+	rows, err := db.Query(
+		`SELECT id, label, created_at, last_used, expires_at FROM client_key
+				WHERE client_id = $1 AND revoked_at IS NULL
+				ORDER BY created_at`,
+		clientID,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	var keys []ClientKey
+	for rows.Next() {
+		var key ClientKey
+		err := rows.Scan(&key.ID, &key.Label, &key.CreatedAt, &key.LastUsed, &key.ExpiresAt)
+		if err != nil {
+			return nil, err
+		}
+
+		keys = append(keys, key)
+	}
+
+	return keys, nil
+}
+
+// GetClientKeySecret returns the secret of a key, as long as the key belongs to the client,
+// has not been revoked and has not expired. Otherwise, sql.ErrNoRows is returned
+func GetClientKeySecret(db *sql.DB, clientID int, keyID int) ([]byte, error) {
+	var secret []byte
+	err := db.QueryRow(
+		`SELECT secret FROM client_key
+				WHERE id = $1 AND client_id = $2 AND revoked_at IS NULL
+				AND (expires_at IS NULL OR expires_at > now())`,
+		keyID,
+		clientID,
+	).Scan(&secret)
+	if err != nil {
+		return nil, err
+	}
+
+	return secret, nil
+}
+
+// GetClientLegacyKey returns the ID and secret of the key migrated from the secret the client had before keys,
+// as long as it has not been revoked or expired. Otherwise, sql.ErrNoRows is returned
+func GetClientLegacyKey(db *sql.DB, clientID int) (int, []byte, error) {
+	var keyID int
+	var secret []byte
+	err := db.QueryRow(
+		`SELECT id, secret FROM client_key
+				WHERE client_id = $1 AND legacy AND revoked_at IS NULL
+				AND (expires_at IS NULL OR expires_at > now())
+				ORDER BY id LIMIT 1`,
+		clientID,
+	).Scan(&keyID, &secret)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	return keyID, secret, nil
+}
+
+// UpdateClientKeyLastUsed sets the last used time of a key to the current time
+func UpdateClientKeyLastUsed(db *sql.DB, keyID int) error {
+	_, err := db.Exec(`UPDATE client_key SET last_used = now() WHERE id = $1`, keyID)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// RevokeClientKey revokes a key of the client. It returns false if the client has no active key with that ID
+func RevokeClientKey(db *sql.DB, clientID int, keyID int) (bool, error) {
+	result, err := db.Exec(
+		`UPDATE client_key SET revoked_at = now()
+				WHERE id = $1 AND client_id = $2 AND revoked_at IS NULL`,
+		keyID,
+		clientID,
+	)
+	if err != nil {
+		return false, err
+	}
+
+	rows, _ := result.RowsAffected()
+	return rows == 1, nil
+}
@@ -0,0 +1,177 @@
+package dal
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInsertClientKey_ShouldInsertKey(t *testing.T) {
+	// Prepare params and expected results
+	clientID := 1
+	label := "production"
+	secret := []byte("my_secret")
+	expiresAt := sql.NullTime{Time: time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC), Valid: true}
+
+	// Mock expected SQL queries
+	Mock.ExpectBegin()
+	Mock.ExpectExec(`^SELECT id FROM client WHERE id = \$1 FOR UPDATE$`).WithArgs(clientID).WillReturnResult(sqlmock.NewResult(0, 1))
+	Mock.ExpectQuery(`^SELECT COUNT\(\*\) FROM client_key WHERE client_id = \$1 (.+)$`).
+		WithArgs(clientID).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(MaxActiveClientKeys - 1))
+	Mock.ExpectQuery(`^INSERT INTO client_key \(client_id, label, secret, expires_at\) VALUES \(\$1, \$2, \$3, \$4\) RETURNING id$`).
+		WithArgs(clientID, label, secret, expiresAt).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(7))
+	Mock.ExpectCommit()
+
+	// Call the func that we are testing
+	keyID, err := InsertClientKey(DB, clientID, label, secret, expiresAt)
+
+	// Assertions
+	if err != nil {
+		t.Errorf("error was not expected when inserting key: %s", err)
+	}
+
+	// We make sure that all expectations were met
+	if err := Mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+
+	assert.Equal(t, 7, keyID)
+}
+
+func TestInsertClientKey_ShouldRefuseOverTheLimit(t *testing.T) {
+	// Prepare params and expected results
+	clientID := 1
+	label := "production"
+	secret := []byte("my_secret")
+
+	// No row is inserted when the client already has too many active keys
+	Mock.ExpectBegin()
+	Mock.ExpectExec(`^SELECT id FROM client WHERE id = \$1 FOR UPDATE$`).WithArgs(clientID).WillReturnResult(sqlmock.NewResult(0, 1))
+	Mock.ExpectQuery(`^SELECT COUNT\(\*\) FROM client_key WHERE client_id = \$1 (.+)$`).
+		WithArgs(clientID).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(MaxActiveClientKeys))
+	Mock.ExpectRollback()
+
+	// Call the func that we are testing
+	_, err := InsertClientKey(DB, clientID, label, secret, sql.NullTime{})
+
+	// We make sure that all expectations were met
+	if err := Mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+
+	assert.Equal(t, ErrTooManyClientKeys, err)
+}
+
+func TestGetClientLegacyKey_ShouldGetMigratedSecret(t *testing.T) {
+	// Prepare params and expected results
+	clientID := 1
+	secret := []byte("old_secret")
+
+	// Mock expected SQL queries
+	Mock.ExpectQuery(`^SELECT id, secret FROM client_key WHERE client_id = \$1 AND legacy AND revoked_at IS NULL`).
+		WithArgs(clientID).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "secret"}).AddRow(3, secret))
+
+	// Call the func that we are testing
+	keyID, actualSecret, err := GetClientLegacyKey(DB, clientID)
+
+	// Assertions
+	if err != nil {
+		t.Errorf("error was not expected when getting legacy key: %s", err)
+	}
+
+	// We make sure that all expectations were met
+	if err := Mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+
+	assert.Equal(t, 3, keyID)
+	assert.Equal(t, secret, actualSecret)
+}
+
+func TestGetClientKeySecret_ShouldGetSecret(t *testing.T) {
+	// Prepare params and expected results
+	clientID := 1
+	keyID := 7
+	secret := []byte("my_secret")
+
+	// Mock expected SQL queries
+	Mock.ExpectQuery(`^SELECT secret FROM client_key WHERE id = \$1 AND client_id = \$2 AND revoked_at IS NULL`).
+		WithArgs(keyID, clientID).
+		WillReturnRows(sqlmock.NewRows([]string{"secret"}).AddRow(secret))
+
+	// Call the func that we are testing
+	actualSecret, err := GetClientKeySecret(DB, clientID, keyID)
+
+	// Assertions
+	if err != nil {
+		t.Errorf("error was not expected when getting secret: %s", err)
+	}
+
+	// We make sure that all expectations were met
+	if err := Mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+
+	assert.Equal(t, secret, actualSecret)
+}
+
+func TestGetClientKeys_ShouldGetActiveKeys(t *testing.T) {
+	clientID := 1
+	createdAt := time.Date(2020, 3, 1, 12, 0, 0, 0, time.UTC)
+	lastUsed := time.Date(2020, 3, 2, 12, 0, 0, 0, time.UTC)
+
+	cols := []string{"id", "label", "created_at", "last_used", "expires_at"}
+	rows := sqlmock.NewRows(cols).
+		AddRow(1, "production", createdAt, lastUsed, nil).
+		AddRow(2, "staging", createdAt, nil, nil)
+
+	Mock.ExpectQuery(`^SELECT id, label, created_at, last_used, expires_at FROM client_key WHERE client_id = \$1 AND revoked_at IS NULL`).
+		WithArgs(clientID).
+		WillReturnRows(rows)
+
+	// Call the func that we are testing
+	keys, err := GetClientKeys(DB, clientID)
+	if err != nil {
+		t.Errorf("error was not expected when getting keys: %s", err)
+	}
+
+	// We make sure that all expectations were met
+	if err := Mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+
+	assert.Len(t, keys, 2)
+	assert.Equal(t, "production", keys[0].Label)
+	assert.True(t, keys[0].LastUsed.Valid)
+	assert.False(t, keys[1].LastUsed.Valid)
+	assert.False(t, keys[1].ExpiresAt.Valid)
+}
+
+func TestRevokeClientKey_NoActiveKeyShouldReturnFalse(t *testing.T) {
+	clientID := 1
+	keyID := 7
+
+	Mock.ExpectExec(`^UPDATE client_key SET revoked_at = now\(\)`).
+		WithArgs(keyID, clientID).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	// Call the func that we are testing
+	revoked, err := RevokeClientKey(DB, clientID, keyID)
+	if err != nil {
+		t.Errorf("error was not expected when revoking key: %s", err)
+	}
+
+	// We make sure that all expectations were met
+	if err := Mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+
+	assert.False(t, revoked)
+}
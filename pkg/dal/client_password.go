@@ -0,0 +1,297 @@
+package dal
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"errors"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// passwordCost is the bcrypt cost used to hash client passwords.
+// Hashes created with a lower cost are upgraded the next time the client signs in
+const passwordCost = bcrypt.DefaultCost
+
+// After maxFailedSignIns consecutive failures, the client can't sign in until lockoutDuration has passed
+const maxFailedSignIns = 5
+const lockoutDuration = 15 * time.Minute
+
+// ErrInvalidCredentials is returned both for unknown client names and incorrect passwords,
+// so callers can't use it to find out which client names exist
+var ErrInvalidCredentials = errors.New("incorrect client name or password")
+
+var ErrClientLocked = errors.New("client is locked after too many failed sign in attempts")
+
+var ErrInvalidResetToken = errors.New("password reset token is invalid or has expired")
+
+// dummyHash is compared against when the client name doesn't exist, so that unknown names
+// take as long to reject as incorrect passwords
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("mrthn-dummy-password"), passwordCost)
+
+type clientPassword struct {
+	id            int
+	hash          string
+	failedSignIns int
+	lockedUntil   sql.NullTime
+}
+
+func SignInClient(db *sql.DB, clientName string, enteredPassword string) (int, error) {
+	record := clientPassword{}
+	err := db.QueryRow(
+		`SELECT id, password, failed_sign_ins, locked_until FROM client WHERE name = $1`,
+		clientName,
+	).Scan(&record.id, &record.hash, &record.failedSignIns, &record.lockedUntil)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			_ = bcrypt.CompareHashAndPassword(dummyHash, []byte(enteredPassword))
+			return 0, ErrInvalidCredentials
+		}
+
+		return 0, err
+	}
+
+	err = verifyClientPassword(db, record, enteredPassword)
+	if err != nil {
+		return 0, err
+	}
+
+	return record.id, nil
+}
+
+// ChangeClientPassword replaces the password of a client, as long as currentPassword is correct.
+// Failed attempts count towards the client's lockout. Sessions issued before stop working,
+// and the new session generation is returned so the caller's session can be issued again
+func ChangeClientPassword(db *sql.DB, clientID int, currentPassword string, newPassword string) (int, error) {
+	err := VerifyClientPassword(db, clientID, currentPassword)
+	if err != nil {
+		return 0, err
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(newPassword), passwordCost)
+	if err != nil {
+		return 0, err
+	}
+
+	var generation int
+	err = db.QueryRow(
+		`UPDATE client SET password = $1, session_generation = session_generation + 1 WHERE id = $2
+				RETURNING session_generation`,
+		string(hash),
+		clientID,
+	).Scan(&generation)
+	if err != nil {
+		return 0, err
+	}
+
+	return generation, nil
+}
+
+// GetClientSessionGeneration returns the session generation of the client. Sessions issued with
+// an older generation were issued before the password changed, and must not be accepted
+func GetClientSessionGeneration(db *sql.DB, clientID int) (int, error) {
+	var generation int
+	err := db.QueryRow(`SELECT session_generation FROM client WHERE id = $1`, clientID).Scan(&generation)
+	if err != nil {
+		return 0, err
+	}
+
+	return generation, nil
+}
+
+// GetClientEmail returns the ID and email of the client with the given name.
+// If the client doesn't exist, or has no email, the email is empty
+func GetClientEmail(db *sql.DB, clientName string) (int, string, error) {
+	var clientID int
+	var email sql.NullString
+	err := db.QueryRow(`SELECT id, email FROM client WHERE name = $1`, clientName).Scan(&clientID, &email)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return 0, "", nil
+		}
+
+		return 0, "", err
+	}
+
+	return clientID, email.String, nil
+}
+
+// SetClientEmail replaces the email password reset tokens are sent to
+func SetClientEmail(db *sql.DB, clientID int, email string) error {
+	_, err := db.Exec(`UPDATE client SET email = $1 WHERE id = $2`, email, clientID)
+	return err
+}
+
+// VerifyClientPassword checks the password of a client that is already known by its ID.
+// Failed attempts count towards the client's lockout
+func VerifyClientPassword(db *sql.DB, clientID int, enteredPassword string) error {
+	record := clientPassword{}
+	err := db.QueryRow(
+		`SELECT id, password, failed_sign_ins, locked_until FROM client WHERE id = $1`,
+		clientID,
+	).Scan(&record.id, &record.hash, &record.failedSignIns, &record.lockedUntil)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return ErrInvalidCredentials
+		}
+
+		return err
+	}
+
+	return verifyClientPassword(db, record, enteredPassword)
+}
+
+// InsertPasswordResetToken stores a one-time token that can be used to reset the password of the client.
+// Only the hash of the token is stored, and any token issued before for the client stops working
+func InsertPasswordResetToken(db *sql.DB, clientID int, token string, expiresAt time.Time) (err error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		} else {
+			err = tx.Commit()
+		}
+	}()
+
+	_, err = tx.Exec(`DELETE FROM client_password_reset WHERE client_id = $1`, clientID)
+	if err != nil {
+		return err
+	}
+
+	tokenHash := sha256.Sum256([]byte(token))
+	_, err = tx.Exec(
+		`INSERT INTO client_password_reset (client_id, token_hash, expires_at) VALUES ($1, $2, $3)`,
+		clientID,
+		tokenHash[:],
+		expiresAt,
+	)
+
+	return err
+}
+
+// ResetClientPassword sets a new password for the client using a reset token. The token can only be used once.
+// Resetting the password also unlocks the client, and signs out every session it had
+func ResetClientPassword(db *sql.DB, clientName string, token string, newPassword string) (clientID int, err error) {
+	tokenHash := sha256.Sum256([]byte(token))
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(newPassword), passwordCost)
+	if err != nil {
+		return 0, err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		} else {
+			err = tx.Commit()
+		}
+	}()
+
+	// The token is consumed as it's checked, so two requests can't both use it
+	err = tx.QueryRow(
+		`DELETE FROM client_password_reset r USING client c
+				WHERE r.client_id = c.id AND c.name = $1 AND r.token_hash = $2 AND r.expires_at > now()
+				RETURNING c.id`,
+		clientName,
+		tokenHash[:],
+	).Scan(&clientID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return 0, ErrInvalidResetToken
+		}
+
+		return 0, err
+	}
+
+	_, err = tx.Exec(
+		`UPDATE client SET password = $1, failed_sign_ins = 0, locked_until = NULL,
+				session_generation = session_generation + 1 WHERE id = $2`,
+		string(hash),
+		clientID,
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	return clientID, nil
+}
+
+func verifyClientPassword(db *sql.DB, record clientPassword, enteredPassword string) error {
+	if record.lockedUntil.Valid && record.lockedUntil.Time.After(time.Now()) {
+		// Locked clients take as long to reject as incorrect passwords
+		_ = bcrypt.CompareHashAndPassword(dummyHash, []byte(enteredPassword))
+		return ErrClientLocked
+	}
+
+	err := bcrypt.CompareHashAndPassword([]byte(record.hash), []byte(enteredPassword))
+	if err != nil {
+		if err != bcrypt.ErrMismatchedHashAndPassword {
+			return err
+		}
+
+		// Count the failure in the database, so attempts made at the same time are all counted
+		var failedSignIns int
+		err = db.QueryRow(
+			`UPDATE client SET failed_sign_ins = failed_sign_ins + 1 WHERE id = $1 RETURNING failed_sign_ins`,
+			record.id,
+		).Scan(&failedSignIns)
+		if err != nil {
+			return err
+		}
+
+		// Lock the client if there were too many failures
+		if failedSignIns >= maxFailedSignIns {
+			_, err = db.Exec(
+				`UPDATE client SET failed_sign_ins = 0, locked_until = $1 WHERE id = $2`,
+				time.Now().Add(lockoutDuration),
+				record.id,
+			)
+			if err != nil {
+				return err
+			}
+		}
+
+		return ErrInvalidCredentials
+	}
+
+	// The password is correct. Forget about previous failures
+	if record.failedSignIns > 0 || record.lockedUntil.Valid {
+		_, err = db.Exec(
+			`UPDATE client SET failed_sign_ins = 0, locked_until = NULL WHERE id = $1`,
+			record.id,
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Upgrade the hash if it was created with a lower cost than the one we use now
+	cost, err := bcrypt.Cost([]byte(record.hash))
+	if err == nil && cost < passwordCost {
+		err = updateClientPassword(db, record.id, enteredPassword)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func updateClientPassword(db *sql.DB, clientID int, password string) error {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), passwordCost)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(`UPDATE client SET password = $1 WHERE id = $2`, string(hash), clientID)
+	return err
+}
@@ -0,0 +1,259 @@
+package dal
+
+import (
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestSignIn_ShouldSignInExistingClient(t *testing.T) {
+	clientName := "Registered_Client"
+	clientPassword := "Client_Password"
+	clientID := 1
+
+	hashedPassword, _ := bcrypt.GenerateFromPassword([]byte(clientPassword), passwordCost)
+
+	// Mock SQL rows
+	cols := []string{
+		"id",
+		"password",
+		"failed_sign_ins",
+		"locked_until",
+	}
+	rows := sqlmock.NewRows(cols).AddRow(clientID, hashedPassword, 0, nil)
+	Mock.ExpectQuery(`^SELECT id, password, failed_sign_ins, locked_until FROM client WHERE name = \$1$`).
+		WithArgs(clientName).
+		WillReturnRows(rows)
+
+	// call the function we are testing
+	returnedId, err := SignInClient(DB, clientName, clientPassword)
+	if err != nil {
+		t.Errorf("error was not expected when signing in a client: %s", err)
+	}
+
+	// We make sure that all expectations were met
+	if err := Mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+
+	assert.Equal(t, clientID, returnedId)
+}
+
+func TestSignIn_UnknownNameAndWrongPasswordShouldReturnSameError(t *testing.T) {
+	clientName := "Registered_Client"
+	clientID := 1
+
+	hashedPassword, _ := bcrypt.GenerateFromPassword([]byte("Client_Password"), passwordCost)
+
+	cols := []string{
+		"id",
+		"password",
+		"failed_sign_ins",
+		"locked_until",
+	}
+
+	// Unknown client name
+	Mock.ExpectQuery(`^SELECT id, password, failed_sign_ins, locked_until FROM client WHERE name = \$1$`).
+		WithArgs("Unknown_Client").
+		WillReturnRows(sqlmock.NewRows(cols))
+
+	// Known client name, but wrong password. The failure should be counted
+	Mock.ExpectQuery(`^SELECT id, password, failed_sign_ins, locked_until FROM client WHERE name = \$1$`).
+		WithArgs(clientName).
+		WillReturnRows(sqlmock.NewRows(cols).AddRow(clientID, hashedPassword, 0, nil))
+	Mock.ExpectQuery(`^UPDATE client SET failed_sign_ins = failed_sign_ins \+ 1 WHERE id = \$1 RETURNING failed_sign_ins$`).
+		WithArgs(clientID).
+		WillReturnRows(sqlmock.NewRows([]string{"failed_sign_ins"}).AddRow(1))
+
+	// call the function we are testing
+	_, unknownNameErr := SignInClient(DB, "Unknown_Client", "Client_Password")
+	_, wrongPasswordErr := SignInClient(DB, clientName, "Wrong_Password")
+
+	// We make sure that all expectations were met
+	if err := Mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+
+	assert.Equal(t, ErrInvalidCredentials, unknownNameErr)
+	assert.Equal(t, ErrInvalidCredentials, wrongPasswordErr)
+}
+
+func TestSignIn_TooManyFailuresShouldLockClient(t *testing.T) {
+	clientName := "Registered_Client"
+	clientID := 1
+
+	hashedPassword, _ := bcrypt.GenerateFromPassword([]byte("Client_Password"), passwordCost)
+
+	cols := []string{
+		"id",
+		"password",
+		"failed_sign_ins",
+		"locked_until",
+	}
+	// The count read with the client is stale, as other attempts were made at the same time
+	rows := sqlmock.NewRows(cols).AddRow(clientID, hashedPassword, 0, nil)
+	Mock.ExpectQuery(`^SELECT id, password, failed_sign_ins, locked_until FROM client WHERE name = \$1$`).
+		WithArgs(clientName).
+		WillReturnRows(rows)
+
+	// The client is locked from the count the database returns
+	Mock.ExpectQuery(`^UPDATE client SET failed_sign_ins = failed_sign_ins \+ 1 WHERE id = \$1 RETURNING failed_sign_ins$`).
+		WithArgs(clientID).
+		WillReturnRows(sqlmock.NewRows([]string{"failed_sign_ins"}).AddRow(maxFailedSignIns))
+
+	// The failure counter is reset and the lock time is set
+	Mock.ExpectExec(`^UPDATE client SET failed_sign_ins = 0, locked_until = \$1 WHERE id = \$2$`).
+		WithArgs(sqlmock.AnyArg(), clientID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	// call the function we are testing
+	_, err := SignInClient(DB, clientName, "Wrong_Password")
+
+	// We make sure that all expectations were met
+	if err := Mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+
+	assert.Equal(t, ErrInvalidCredentials, err)
+}
+
+func TestSignIn_LowCostHashShouldBeUpgraded(t *testing.T) {
+	clientName := "Registered_Client"
+	clientPassword := "Client_Password"
+	clientID := 1
+
+	hashedPassword, _ := bcrypt.GenerateFromPassword([]byte(clientPassword), bcrypt.MinCost)
+
+	cols := []string{
+		"id",
+		"password",
+		"failed_sign_ins",
+		"locked_until",
+	}
+	rows := sqlmock.NewRows(cols).AddRow(clientID, hashedPassword, 0, nil)
+	Mock.ExpectQuery(`^SELECT id, password, failed_sign_ins, locked_until FROM client WHERE name = \$1$`).
+		WithArgs(clientName).
+		WillReturnRows(rows)
+
+	Mock.ExpectExec(`^UPDATE client SET password = \$1 WHERE id = \$2$`).
+		WithArgs(sqlmock.AnyArg(), clientID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	// call the function we are testing
+	returnedId, err := SignInClient(DB, clientName, clientPassword)
+	if err != nil {
+		t.Errorf("error was not expected when signing in a client: %s", err)
+	}
+
+	// We make sure that all expectations were met
+	if err := Mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+
+	assert.Equal(t, clientID, returnedId)
+}
+
+func TestResetClientPassword_InvalidTokenShouldFail(t *testing.T) {
+	clientName := "Registered_Client"
+
+	// The token is consumed as it's checked
+	Mock.ExpectBegin()
+	Mock.ExpectQuery(`^DELETE FROM client_password_reset r USING client c WHERE (.+) AND c.name = \$1 AND r.token_hash = \$2 (.+) RETURNING c.id$`).
+		WithArgs(clientName, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	Mock.ExpectRollback()
+
+	// call the function we are testing
+	_, err := ResetClientPassword(DB, clientName, "not_a_token", "New_Password")
+
+	// We make sure that all expectations were met
+	if err := Mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+
+	assert.Equal(t, ErrInvalidResetToken, err)
+}
+
+func TestResetClientPassword_ShouldSignOutSessions(t *testing.T) {
+	clientName := "Registered_Client"
+	clientID := 1
+
+	Mock.ExpectBegin()
+	Mock.ExpectQuery(`^DELETE FROM client_password_reset r USING client c WHERE (.+) RETURNING c.id$`).
+		WithArgs(clientName, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(clientID))
+	Mock.ExpectExec(`^UPDATE client SET password = \$1, (.+) session_generation = session_generation \+ 1 WHERE id = \$2$`).
+		WithArgs(sqlmock.AnyArg(), clientID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	Mock.ExpectCommit()
+
+	// call the function we are testing
+	returnedID, err := ResetClientPassword(DB, clientName, "a_token", "New_Password")
+	if err != nil {
+		t.Errorf("error was not expected when resetting a password: %s", err)
+	}
+
+	// We make sure that all expectations were met
+	if err := Mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+
+	assert.Equal(t, clientID, returnedID)
+}
+
+func TestChangeClientPassword_ShouldReturnNewSessionGeneration(t *testing.T) {
+	clientPassword := "Client_Password"
+	clientID := 1
+
+	hashedPassword, _ := bcrypt.GenerateFromPassword([]byte(clientPassword), passwordCost)
+
+	cols := []string{
+		"id",
+		"password",
+		"failed_sign_ins",
+		"locked_until",
+	}
+	Mock.ExpectQuery(`^SELECT id, password, failed_sign_ins, locked_until FROM client WHERE id = \$1$`).
+		WithArgs(clientID).
+		WillReturnRows(sqlmock.NewRows(cols).AddRow(clientID, hashedPassword, 0, nil))
+	Mock.ExpectQuery(`^UPDATE client SET password = \$1, session_generation = session_generation \+ 1 WHERE id = \$2 RETURNING session_generation$`).
+		WithArgs(sqlmock.AnyArg(), clientID).
+		WillReturnRows(sqlmock.NewRows([]string{"session_generation"}).AddRow(4))
+
+	// call the function we are testing
+	generation, err := ChangeClientPassword(DB, clientID, clientPassword, "New_Password")
+	if err != nil {
+		t.Errorf("error was not expected when changing a password: %s", err)
+	}
+
+	// We make sure that all expectations were met
+	if err := Mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+
+	assert.Equal(t, 4, generation)
+}
+
+func TestGetClientEmail_UnknownClientShouldReturnNoEmail(t *testing.T) {
+	clientName := "Unknown_Client"
+
+	Mock.ExpectQuery(`^SELECT id, email FROM client WHERE name = \$1$`).
+		WithArgs(clientName).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "email"}))
+
+	// call the function we are testing
+	clientID, email, err := GetClientEmail(DB, clientName)
+	if err != nil {
+		t.Errorf("error was not expected when getting a client email: %s", err)
+	}
+
+	// We make sure that all expectations were met
+	if err := Mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+
+	assert.Equal(t, 0, clientID)
+	assert.Equal(t, "", email)
+}
@@ -0,0 +1,23 @@
+package dal
+
+import "database/sql"
+
+// Scopes give a client access to data that platforms consider sensitive. mrthn grants them after reviewing the client
+const (
+	ClientScopeIntraday = "intraday" // Steps, calories and heart rate through the day, instead of daily totals
+)
+
+// HasClientScope reports if the client was granted the scope
+func HasClientScope(db *sql.DB, clientID int, scope string) (bool, error) {
+	var granted bool
+	err := db.QueryRow(
+		`SELECT EXISTS(SELECT 1 FROM client_scope WHERE client_id = $1 AND scope = $2)`,
+		clientID,
+		scope,
+	).Scan(&granted)
+	if err != nil {
+		return false, err
+	}
+
+	return granted, nil
+}
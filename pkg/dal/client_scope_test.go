@@ -0,0 +1,29 @@
+package dal
+
+import (
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHasClientScope_ShouldNotGrantMissingScope(t *testing.T) {
+	clientID := 1
+
+	Mock.ExpectQuery(`^SELECT EXISTS\(SELECT 1 FROM client_scope WHERE client_id = \$1 AND scope = \$2\)$`).
+		WithArgs(clientID, ClientScopeIntraday).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+
+	// Call the func that we are testing
+	granted, err := HasClientScope(DB, clientID, ClientScopeIntraday)
+	if err != nil {
+		t.Errorf("error was not expected when checking client scope: %s", err)
+	}
+
+	// We make sure that all expectations were met
+	if err := Mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+
+	assert.False(t, granted)
+}
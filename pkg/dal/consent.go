@@ -0,0 +1,131 @@
+package dal
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+)
+
+// Consent is a user's authorization for a client to read their data, given when the user went through /login
+type Consent struct {
+	PublicID    string // ID the client knows the user by
+	ExternalRef string
+	Platform    string // Platform the user authorized through
+	Scopes      []string
+	GrantedAt   time.Time
+	ExpiresAt   sql.NullTime
+}
+
+// InsertConsent records that the user authorized the client through the platform
+func InsertConsent(db *sql.DB, userID int, clientID int, platformName string, scopes []string, expiresAt sql.NullTime) error {
+	_, err := db.Exec(
+		`INSERT INTO consent (user_id, client_id, platform_id, scopes, expires_at)
+				SELECT $1, $2, p.id, $4, $5 FROM platform p WHERE p.name = $3`,
+		userID,
+		clientID,
+		platformName,
+		strings.Join(scopes, " "),
+		expiresAt,
+	)
+	return err
+}
+
+// GetConsentExpiry returns when the user's latest consent to the client expires.
+// It's not valid if the consent doesn't expire, or if the user was added before consents were recorded
+func GetConsentExpiry(db *sql.DB, userID int, clientID int) (sql.NullTime, error) {
+	var expiresAt sql.NullTime
+	err := db.QueryRow(
+		`SELECT expires_at FROM consent
+				WHERE user_id = $1 AND client_id = $2
+				ORDER BY granted_at DESC, id DESC
+				LIMIT 1`,
+		userID,
+		clientID,
+	).Scan(&expiresAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return sql.NullTime{}, nil
+		}
+
+		return sql.NullTime{}, err
+	}
+
+	return expiresAt, nil
+}
+
+// GetExpiringConsents returns the latest consent of every user of the client that expires before the given time,
+// including the ones that already expired. Consents that expire first come first
+func GetExpiringConsents(db *sql.DB, clientID int, before time.Time) ([]Consent, error) {
+	rows, err := db.Query(
+		`SELECT u.public_id, COALESCE(u.external_ref, ''), p.name, c.scopes, c.granted_at, c.expires_at FROM (
+					SELECT DISTINCT ON (user_id) * FROM consent
+					WHERE client_id = $1
+					ORDER BY user_id, granted_at DESC, id DESC
+				) c
+				JOIN userbase u ON u.user_id = c.user_id AND u.client_id = c.client_id
+				JOIN platform p ON p.id = c.platform_id
+				WHERE c.expires_at IS NOT NULL AND c.expires_at <= $2
+				ORDER BY c.expires_at, u.public_id`,
+		clientID,
+		before,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	var consents []Consent
+	for rows.Next() {
+		var consent Consent
+		var scopes string
+		err := rows.Scan(
+			&consent.PublicID,
+			&consent.ExternalRef,
+			&consent.Platform,
+			&scopes,
+			&consent.GrantedAt,
+			&consent.ExpiresAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		consent.Scopes = strings.Fields(scopes)
+		consents = append(consents, consent)
+	}
+
+	return consents, nil
+}
+
+// GetUserConsents returns every consent the user gave the client, oldest first
+func GetUserConsents(db *sql.DB, userID int, clientID int) ([]Consent, error) {
+	rows, err := db.Query(
+		`SELECT p.name, c.scopes, c.granted_at, c.expires_at FROM consent c
+				JOIN platform p ON p.id = c.platform_id
+				WHERE c.user_id = $1 AND c.client_id = $2
+				ORDER BY c.granted_at, c.id`,
+		userID,
+		clientID,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	var consents []Consent
+	for rows.Next() {
+		var consent Consent
+		var scopes string
+		err := rows.Scan(&consent.Platform, &scopes, &consent.GrantedAt, &consent.ExpiresAt)
+		if err != nil {
+			return nil, err
+		}
+
+		consent.Scopes = strings.Fields(scopes)
+		consents = append(consents, consent)
+	}
+
+	return consents, nil
+}
@@ -0,0 +1,30 @@
+package dal
+
+import (
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetConsentExpiry_ShouldNotExpireUsersWithoutConsents(t *testing.T) {
+	userID := 2
+	clientID := 1
+
+	Mock.ExpectQuery(`^SELECT expires_at FROM consent (.+) LIMIT 1$`).
+		WithArgs(userID, clientID).
+		WillReturnRows(sqlmock.NewRows([]string{"expires_at"}))
+
+	// Call the func that we are testing
+	expiresAt, err := GetConsentExpiry(DB, userID, clientID)
+	if err != nil {
+		t.Errorf("error was not expected when getting consent expiry: %s", err)
+	}
+
+	// We make sure that all expectations were met
+	if err := Mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+
+	assert.False(t, expiresAt.Valid)
+}
@@ -3,7 +3,6 @@ package dal
 import (
 	"database/sql"
 	"fmt"
-	"strconv"
 	"strings"
 	"time"
 
@@ -27,9 +26,17 @@ type CredentialParams struct {
 	ClientID         int
 	PlatformName     string
 	UPID             string
+	Label            string // Optional name the client gave the account
 	ConnectionString string
 }
 
+// Account is one of the platform accounts a user has linked. A user can link several accounts of the same platform
+type Account struct {
+	CredentialID int
+	Platform     string
+	Label        string // Name the client gave the account, or the account's ID in the platform
+}
+
 func InitializeDBConn(connectionString string) (*sql.DB, error) {
 	db, err := sql.Open("postgres", connectionString)
 	if err != nil {
@@ -45,27 +52,19 @@ func InitializeDBConn(connectionString string) (*sql.DB, error) {
 	return db, nil
 }
 
-func InsertSecretInExistingClient(db *sql.DB, clientID int, secret []byte) (int64, error) {
-	result, err := db.Exec( // TODO: Use ExecContext instead
-		`UPDATE client
-				SET secret = $1
-				WHERE id = $2`,
-		secret,
-		clientID,
-	)
-
+func InsertUserToUserbase(db *sql.DB, userID int, clientID int) error {
+	publicID, err := newPublicUserID()
 	if err != nil {
-		return 0, err
+		return err
 	}
 
-	rows, _ := result.RowsAffected()
-	return rows, nil
-}
-
-func InsertUserToUserbase(db *sql.DB, userID int, clientID int) error {
-	queryString := fmt.Sprintf("INSERT INTO userbase (user_id, client_id) VALUES (%d, %d)", userID, clientID)
-
-	_, err := db.Exec(queryString)
+	_, err = db.Exec(
+		`INSERT INTO userbase (user_id, client_id, public_id) VALUES ($1, $2, $3)
+				ON CONFLICT (user_id, client_id) DO NOTHING`,
+		userID,
+		clientID,
+		publicID,
+	)
 	if err != nil {
 		return err
 	}
@@ -103,8 +102,7 @@ func InsertUserCredentials(db *sql.DB, params CredentialParams) (int, error) {
 
 	// Get platform ID by name
 	var platformID int
-	platIDQuery := fmt.Sprintf("SELECT id FROM platform WHERE name = '%s'", params.PlatformName)
-	err = db.QueryRow(platIDQuery).Scan(&platformID)
+	err = tx.QueryRow(`SELECT id FROM platform WHERE name = $1`, params.PlatformName).Scan(&platformID)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return 0, errors.New(fmt.Sprintf("Platform name '%s' does not exist", params.PlatformName))
@@ -114,26 +112,31 @@ func InsertUserCredentials(db *sql.DB, params CredentialParams) (int, error) {
 	}
 
 	// Add the user into the credentials table
-	credentialsQuery := fmt.Sprintf(
-		"INSERT INTO credentials "+
-			"(user_id, platform_id, upid, connection_string) "+
-			"VALUES (%d, %d, '%s', '%s')",
+	_, err = tx.Exec(
+		`INSERT INTO credentials (user_id, platform_id, upid, connection_string, label) VALUES ($1, $2, $3, $4, NULLIF($5, ''))`,
 		params.UserID,
 		platformID,
 		params.UPID,
 		params.ConnectionString,
+		params.Label,
 	)
+	if err != nil {
+		return 0, err
+	}
 
-	_, err = tx.Exec(credentialsQuery)
+	// The final step is to add the user to the client's userbase, unless it's already there
+	publicID, err := newPublicUserID()
 	if err != nil {
 		return 0, err
 	}
 
-	// The final step is to add the user to the appropriate row in the userbase table
-	userbaseQuery := fmt.Sprintf(
-		"INSERT INTO userbase (user_id, client_id) VALUES (%d, %d)", params.UserID, params.ClientID,
+	_, err = tx.Exec(
+		`INSERT INTO userbase (user_id, client_id, public_id) VALUES ($1, $2, $3)
+				ON CONFLICT (user_id, client_id) DO NOTHING`,
+		params.UserID,
+		params.ClientID,
+		publicID,
 	)
-	_, err = tx.Exec(userbaseQuery)
 	if err != nil {
 		return 0, err
 	}
@@ -141,73 +144,93 @@ func InsertUserCredentials(db *sql.DB, params CredentialParams) (int, error) {
 	return params.UserID, err // err will be update by the deferred func
 }
 
-func InsertNewClient(db *sql.DB, name string, password string) (int, error) {
+// InsertNewClient creates a client and a personal organization that owns it, with the client as its owner.
+// The email is optional, and is where password reset tokens are sent
+func InsertNewClient(db *sql.DB, name string, password string, email string) (clientID int, err error) {
 	// Before we insert the password in the database, we must hash it
 	// bcrypt salts this for us, so we don't have to worry about it
-	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), passwordCost)
 	if err != nil {
 		return 0, err
 	}
 
-	var clientID int
-	insertQuery := fmt.Sprintf("INSERT INTO client (name, password) VALUES ('%s','%s') RETURNING id", name, hash)
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
 
-	// TODO: Use ExecContext instead
-	err = db.QueryRow(insertQuery).Scan(&clientID)
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		} else {
+			err = tx.Commit()
+		}
+	}()
+
+	var organizationID int
+	err = tx.QueryRow(`INSERT INTO organization (name) VALUES ($1) RETURNING id`, name).Scan(&organizationID)
 	if err != nil {
 		return 0, err
 	}
 
-	return clientID, nil
-}
+	err = tx.QueryRow(
+		`INSERT INTO client (name, password, organization_id, email) VALUES ($1, $2, $3, $4) RETURNING id`,
+		name,
+		hash,
+		organizationID,
+		sql.NullString{String: email, Valid: email != ""},
+	).Scan(&clientID)
+	if err != nil {
+		return 0, err
+	}
 
-func GetClientSecret(db *sql.DB, fromClientID int) ([]byte, error) {
-	// TODO: Use QueryRowContext instead
-	var secret []byte
-	err := db.QueryRow("SELECT secret FROM client WHERE id = " + strconv.Itoa(fromClientID)).Scan(&secret)
+	_, err = tx.Exec(
+		`INSERT INTO organization_member (organization_id, member_id, role) VALUES ($1, $2, $3)`,
+		organizationID,
+		clientID,
+		RoleOwner,
+	)
 	if err != nil {
-		return nil, err
+		return 0, err
 	}
 
-	return secret, nil
+	return clientID, nil
 }
 
-func GetUserByPlatformID(db *sql.DB, platformID string, platformName string) (int, error) {
+// GetUserByPlatformID returns the user that linked the platform account, and the ID of the account's credentials
+func GetUserByPlatformID(db *sql.DB, platformID string, platformName string) (int, int, error) {
 	var userID int
+	var credentialID int
 
 	// Check if this user exists in the credentials
-	queryString := fmt.Sprintf(
-		"SELECT user_id FROM credentials c "+
-			"JOIN platform p ON c.platform_id = p.id "+
-			"WHERE p.name = '%s' AND c.upid = '%s'",
+	err := db.QueryRow(
+		`SELECT user_id, c.id FROM credentials c
+				JOIN platform p ON c.platform_id = p.id
+				WHERE p.name = $1 AND c.upid = $2`,
 		platformName,
 		platformID,
-	)
-
-	err := db.QueryRow(queryString).Scan(&userID)
+	).Scan(&userID, &credentialID)
 
 	if err != nil {
 		if err == sql.ErrNoRows {
 			// There were no rows, but otherwise no error occurred.
 			// Return a zero
-			return 0, nil
+			return 0, 0, nil
 		} else {
-			return 0, err
+			return 0, 0, err
 		}
 	}
 
-	return userID, nil
+	return userID, credentialID, nil
 }
 
 func GetUserInUserbase(db *sql.DB, userID int, clientID int) (int, error) {
 	// Check if this user exists already in the userbase
-	queryString := fmt.Sprintf(
-		"SELECT user_id FROM userbase WHERE user_id = %d AND client_id = %d",
+	err := db.QueryRow(
+		`SELECT user_id FROM userbase WHERE user_id = $1 AND client_id = $2`,
 		userID,
 		clientID,
-	)
-
-	err := db.QueryRow(queryString).Scan(&userID)
+	).Scan(&userID)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			// There were no rows, but otherwise no error occurred.
@@ -222,13 +245,18 @@ func GetUserInUserbase(db *sql.DB, userID int, clientID int) (int, error) {
 }
 
 // TODO: Make it so auth type is not hardcoded in the SQL stmt
-func GetUserTokens(db *sql.DB, fromUserID int, platform string) (*oauth2.Token, error) {
+func GetCredentialTokens(db *sql.DB, credentialID int) (*oauth2.Token, error) {
 	// Get the credentials from the database
-	connectionParams, err := GetUserConnection(db, fromUserID, platform)
+	connectionParams, err := GetCredentialConnection(db, credentialID)
 	if err != nil {
 		return &oauth2.Token{}, err
 	}
 
+	return connectionTokens(connectionParams)
+}
+
+// connectionTokens parses the OAuth2 tokens out of a connection
+func connectionTokens(connectionParams Connection) (*oauth2.Token, error) {
 	// Since we know we are going for tokens, parse them out of the connection struct
 	if connectionParams.ConnectionType != "oauth2" {
 		return &oauth2.Token{}, errors.New("expected Oauth2 authentication type, was instead " + connectionParams.ConnectionType)
@@ -249,24 +277,10 @@ func GetUserTokens(db *sql.DB, fromUserID int, platform string) (*oauth2.Token,
 	}, nil
 }
 
-func GetUserConnection(db *sql.DB, userID int, platformName string) (Connection, error) {
-	// Get ID of platform using platform's name
-	platIDQuery := fmt.Sprintf("SELECT id FROM platform WHERE name = '%s'", platformName)
-
-	var platformID int
-	err := db.QueryRow(platIDQuery).Scan(&platformID)
-	if err != nil {
-		return Connection{}, err
-	}
-
-	// Get connection string using user's ID and the platform's ID
-	connStrQuery := fmt.Sprintf(
-		"SELECT connection_string FROM credentials WHERE user_id = %d AND platform_id = %d",
-		userID,
-		platformID,
-	)
+func GetCredentialConnection(db *sql.DB, credentialID int) (Connection, error) {
+	// Get connection string of the account
 	var credentials string
-	err = db.QueryRow(connStrQuery).Scan(&credentials)
+	err := db.QueryRow(`SELECT connection_string FROM credentials WHERE id = $1`, credentialID).Scan(&credentials)
 	if err != nil {
 		return Connection{}, err
 	}
@@ -281,14 +295,12 @@ func GetUserConnection(db *sql.DB, userID int, platformName string) (Connection,
 }
 
 func GetPlatformNames(db *sql.DB, fromUserID int) ([]string, error) {
-	stmt := fmt.Sprintf(
-		"SELECT name FROM platform p "+
-			"JOIN credentials c ON p.id = c.platform_id "+
-			"WHERE user_id = %d",
+	rows, err := db.Query(
+		`SELECT DISTINCT name FROM platform p
+				JOIN credentials c ON p.id = c.platform_id
+				WHERE user_id = $1`,
 		fromUserID,
-	)
-
-	rows, err := db.Query(stmt) // TODO: Use QueryRowContext instead
+	) // TODO: Use QueryRowContext instead
 	if err != nil {
 		return nil, err
 	}
@@ -309,6 +321,40 @@ func GetPlatformNames(db *sql.DB, fromUserID int) ([]string, error) {
 	return platforms, nil
 }
 
+// accountLabel selects the label of an account of credentials c on platform p. Accounts the client didn't name
+// are named after their platform and the order they were linked in, such as 'fitbit-2'. The ID of the user
+// in the platform isn't used, since it can identify the user outside of mrthn
+const accountLabel = `COALESCE(c.label, p.name || '-' || ROW_NUMBER() OVER (PARTITION BY c.platform_id ORDER BY c.linked_at, c.id))`
+
+// GetUserAccounts returns every platform account the user has linked, in the order they were linked
+func GetUserAccounts(db *sql.DB, userID int) ([]Account, error) {
+	rows, err := db.Query(
+		`SELECT c.id, p.name, `+accountLabel+` FROM credentials c
+				JOIN platform p ON p.id = c.platform_id
+				WHERE c.user_id = $1
+				ORDER BY c.linked_at, c.id`,
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	var accounts []Account
+	for rows.Next() {
+		var account Account
+		err := rows.Scan(&account.CredentialID, &account.Platform, &account.Label)
+		if err != nil {
+			return nil, err
+		}
+
+		accounts = append(accounts, account)
+	}
+
+	return accounts, nil
+}
+
 func GetPlatformDomains(db *sql.DB) (map[string]string, error) {
 	domains := make(map[string]string)
 
@@ -332,13 +378,35 @@ func GetPlatformDomains(db *sql.DB) (map[string]string, error) {
 	return domains, nil
 }
 
+// GetPlatformRevokeURLs returns where each platform's tokens are revoked. Platforms without one are left out
+func GetPlatformRevokeURLs(db *sql.DB) (map[string]string, error) {
+	revokeURLs := make(map[string]string)
+
+	rows, err := db.Query("SELECT name, revoke_url FROM platform WHERE revoke_url IS NOT NULL")
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		var revokeURL string
+		err := rows.Scan(&name, &revokeURL)
+		if err != nil {
+			return nil, err
+		}
+
+		revokeURLs[name] = revokeURL
+	}
+
+	return revokeURLs, nil
+}
+
 // GetClientID takes in a client name, and returns the ID of the client,
 // or 0 if no client is using that name.
 func GetClientID(db *sql.DB, clientName string) (int, error) {
-	searchQuery := fmt.Sprintf("SELECT id FROM client WHERE name = '%s'", clientName)
-
 	var userId int
-	err := db.QueryRow(searchQuery).Scan(&userId) // TODO: Use QueryRowContext instead
+	err := db.QueryRow(`SELECT id FROM client WHERE name = $1`, clientName).Scan(&userId) // TODO: Use QueryRowContext instead
 	if err != nil {
 		if err == sql.ErrNoRows {
 			// There were no rows, but otherwise no error occurred.
@@ -353,15 +421,14 @@ func GetClientID(db *sql.DB, clientName string) (int, error) {
 }
 
 func UpdateCallback(db *sql.DB, clientID int, newCallback string) (bool, error) {
-	clientIDCheck, err := checkClientExistence(db, clientID)
+	clientIDCheck, err := ClientExists(db, clientID)
 	if err != nil {
 		return false, err
 	}
 
 	if clientIDCheck {
 		// Update the client callback
-		updateString := fmt.Sprintf("UPDATE client SET callback = '%s' WHERE id = %d", newCallback, clientID)
-		_, err := db.Exec(updateString)
+		_, err := db.Exec(`UPDATE client SET callback = $1 WHERE id = $2`, newCallback, clientID)
 		if err != nil {
 			return false, err
 		}
@@ -372,9 +439,8 @@ func UpdateCallback(db *sql.DB, clientID int, newCallback string) (bool, error)
 	return false, nil
 }
 
-func UpdateCredentials(db *sql.DB, userID int, credentialsString string) error {
-	updateString := fmt.Sprintf("UPDATE credentials SET connection_string = '%s' WHERE user_id = %d", credentialsString, userID)
-	_, err := db.Exec(updateString)
+func UpdateCredentials(db *sql.DB, credentialID int, credentialsString string) error {
+	_, err := db.Exec(`UPDATE credentials SET connection_string = $1 WHERE id = $2`, credentialsString, credentialID)
 	if err != nil {
 		return err
 	}
@@ -383,10 +449,8 @@ func UpdateCredentials(db *sql.DB, userID int, credentialsString string) error {
 }
 
 func GetClientCallback(db *sql.DB, clientID int) (string, error) {
-	searchQuery := fmt.Sprintf("SELECT callback FROM client WHERE id = %d", clientID)
-
 	var callbackResult string
-	err := db.QueryRow(searchQuery).Scan(&callbackResult) // TODO: Use QueryRowContext instead
+	err := db.QueryRow(`SELECT callback FROM client WHERE id = $1`, clientID).Scan(&callbackResult) // TODO: Use QueryRowContext instead
 	if err != nil {
 		if err == sql.ErrNoRows {
 			// There were no rows, but otherwise no error occurred.
@@ -401,7 +465,7 @@ func GetClientCallback(db *sql.DB, clientID int) (string, error) {
 	return callbackResult, nil
 }
 
-func UpdateCredentialsUsingOAuth2Tokens(db *sql.DB, userID int, tokens *oauth2.Token) error {
+func UpdateCredentialsUsingOAuth2Tokens(db *sql.DB, credentialID int, tokens *oauth2.Token) error {
 	connStr, err := helpers.FormatConnectionString([]string{
 		"oauth2",
 		tokens.TokenType,
@@ -413,7 +477,7 @@ func UpdateCredentialsUsingOAuth2Tokens(db *sql.DB, userID int, tokens *oauth2.T
 		return err
 	}
 
-	err = UpdateCredentials(db, userID, connStr)
+	err = UpdateCredentials(db, credentialID, connStr)
 	if err != nil {
 		return err
 	}
@@ -421,29 +485,10 @@ func UpdateCredentialsUsingOAuth2Tokens(db *sql.DB, userID int, tokens *oauth2.T
 	return nil
 }
 
-func SignInClient(db *sql.DB, clientName string, enteredPassword string) (int, error) {
-	searchQuery := fmt.Sprintf("SELECT password, id FROM client WHERE name = '%s'", clientName)
-
-	var passwordResult string
-	var userID int
-	err := db.QueryRow(searchQuery).Scan(&passwordResult, &userID)
-	if err != nil {
-		return 0, err
-	}
-
-	err = bcrypt.CompareHashAndPassword([]byte(passwordResult), []byte(enteredPassword))
-	if err != nil {
-		return userID, err
-	}
-
-	return userID, nil
-}
-
-func checkClientExistence(db *sql.DB, clientID int) (bool, error) {
-	clientQuery := fmt.Sprintf("SELECT id FROM client WHERE  id = %d", clientID)
-
+// ClientExists checks if there is a client with the given ID in the database
+func ClientExists(db *sql.DB, clientID int) (bool, error) {
 	var clientIDresult int
-	err := db.QueryRow(clientQuery).Scan(&clientIDresult)
+	err := db.QueryRow(`SELECT id FROM client WHERE id = $1`, clientID).Scan(&clientIDresult)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			// There were no rows, but otherwise no error occurred
@@ -11,8 +11,6 @@ import (
 	"github.com/msgurgel/mrthn/pkg/helpers"
 	"golang.org/x/oauth2"
 
-	"golang.org/x/crypto/bcrypt"
-
 	"github.com/stretchr/testify/assert"
 
 	"github.com/DATA-DOG/go-sqlmock"
@@ -35,80 +33,18 @@ func TestMain(m *testing.M) {
 	os.Exit(code)
 }
 
-func TestInsertSecretInExistingClient_ShouldInsertSecret(t *testing.T) {
-	// Prepare params and expected results
-	secret := []byte("my_secret")
-	clientID := 1
-
-	// Mock expected SQL queries
-	Mock.ExpectExec(`^UPDATE client SET secret`).
-		WithArgs(secret, clientID).
-		WillReturnResult(sqlmock.NewResult(1, 1))
-
-	// Call the func that we are testing
-	rowsAffected, err := InsertSecretInExistingClient(DB, clientID, secret)
-
-	// Assertions
-	if err != nil {
-		t.Errorf("error was not expected when inserting secret: %s", err)
-	}
-
-	// We make sure that all expectations were met
-	if err := Mock.ExpectationsWereMet(); err != nil {
-		t.Errorf("there were unfulfilled expectations: %s", err)
-	}
-
-	assert.Equal(t, int64(1), rowsAffected)
-}
-
-func TestGetClientSecret_ShouldGetSecret(t *testing.T) {
-	// Prepare params and expected results
-	clientID := 1
-	secret := []byte("my_secret")
-
-	// Mock SQL rows
-	cols := []string{
-		"secret",
-	}
-	rows := sqlmock.NewRows(cols).AddRow(secret)
-
-	// Mock expected SQL queries
-	expectedSQL := fmt.Sprintf("^SELECT secret FROM client WHERE id = %d$", clientID)
-	Mock.ExpectQuery(expectedSQL).WillReturnRows(rows)
-
-	// Call the func that we are testing
-	actualSecret, err := GetClientSecret(DB, clientID)
-
-	// Assertions
-	if err != nil {
-		t.Errorf("error was not expected when getting secret: %s", err)
-	}
-
-	// We make sure that all expectations were met
-	if err := Mock.ExpectationsWereMet(); err != nil {
-		t.Errorf("there were unfulfilled expectations: %s", err)
-	}
-
-	assert.Equal(t, secret, actualSecret)
-}
-
-func TestGetUserTokens_ShouldGetTokens(t *testing.T) {
-	platformName := "fitbit"
-	userID := 1
-	platformID := 1
-
-	platformIDQuery := fmt.Sprintf("^SELECT id FROM platform WHERE name = '%s'$", platformName)
-	Mock.ExpectQuery(platformIDQuery).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(platformID))
+func TestGetCredentialTokens_ShouldGetTokens(t *testing.T) {
+	credentialID := 1
 
 	cols := []string{
 		"connection_string",
 	}
 	rows := sqlmock.NewRows(cols).AddRow("oauth2;Bearer;2020-03-23T04:20:00-0400;AC3$$T0K3N;R3FR3$HT0K3N;")
 
-	expectedSQL := fmt.Sprintf("^SELECT connection_string FROM credentials WHERE user_id = %d AND platform_id = %d$", userID, platformID)
-	Mock.ExpectQuery(expectedSQL).WillReturnRows(rows)
+	expectedSQL := `^SELECT connection_string FROM credentials WHERE id = \$1$`
+	Mock.ExpectQuery(expectedSQL).WithArgs(credentialID).WillReturnRows(rows)
 
-	tokens, err := GetUserTokens(DB, userID, platformName)
+	tokens, err := GetCredentialTokens(DB, credentialID)
 	if err != nil {
 		t.Errorf("failed to get user tokens: %s", err.Error())
 		return
@@ -136,8 +72,8 @@ func TestGetPlatformNames(t *testing.T) {
 		rows = rows.AddRow(platName)
 	}
 
-	expectedSQL := fmt.Sprintf(`^SELECT name FROM platform p JOIN (.+) WHERE user_id = %d$`, userID)
-	Mock.ExpectQuery(expectedSQL).WillReturnRows(rows)
+	expectedSQL := `^SELECT DISTINCT name FROM platform p JOIN (.+) WHERE user_id = \$1$`
+	Mock.ExpectQuery(expectedSQL).WithArgs(userID).WillReturnRows(rows)
 
 	platformStr, err := GetPlatformNames(DB, userID)
 	if err != nil {
@@ -157,23 +93,21 @@ func TestGetUserByPlatformID(t *testing.T) {
 	platID := "A1B2C3"
 	platName := "fitbit"
 	expectedUserID := 420
+	expectedCredentialID := 17
 
 	cols := []string{
 		"user_id",
+		"id",
 	}
 
-	rows := sqlmock.NewRows(cols).AddRow(expectedUserID)
+	rows := sqlmock.NewRows(cols).AddRow(expectedUserID, expectedCredentialID)
 
-	expectedSQL := fmt.Sprintf(
-		"^SELECT user_id FROM credentials [a-z] "+
-			"JOIN platform [a-z]+ ON (.+) "+
-			"WHERE [a-z]+.name = '%s' AND [a-z]+.upid = '%s'$",
-		platName,
-		platID,
-	)
-	Mock.ExpectQuery(expectedSQL).WillReturnRows(rows)
+	expectedSQL := `^SELECT user_id, [a-z].id FROM credentials [a-z] ` +
+		`JOIN platform [a-z]+ ON (.+) ` +
+		`WHERE [a-z]+.name = \$1 AND [a-z]+.upid = \$2$`
+	Mock.ExpectQuery(expectedSQL).WithArgs(platName, platID).WillReturnRows(rows)
 
-	userID, err := GetUserByPlatformID(DB, platID, platName)
+	userID, credentialID, err := GetUserByPlatformID(DB, platID, platName)
 	if err != nil {
 		t.Errorf("failed to get user: %s", err.Error())
 		return
@@ -185,6 +119,7 @@ func TestGetUserByPlatformID(t *testing.T) {
 	}
 
 	assert.Equal(t, expectedUserID, userID)
+	assert.Equal(t, expectedCredentialID, credentialID)
 }
 
 func TestInsertUserCredentials_ShouldInsertCredentials(t *testing.T) {
@@ -199,17 +134,19 @@ func TestInsertUserCredentials_ShouldInsertCredentials(t *testing.T) {
 	// Mock expected DB calls in order
 	Mock.ExpectBegin()
 
-	expectedPlatIDSQL := fmt.Sprintf(`^SELECT id FROM platform WHERE name = '%s'$`, platName)
-	Mock.ExpectQuery(expectedPlatIDSQL).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(platID))
+	expectedPlatIDSQL := `^SELECT id FROM platform WHERE name = \$1$`
+	Mock.ExpectQuery(expectedPlatIDSQL).WithArgs(platName).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(platID))
 
-	expectedCredentialsSQL := `^INSERT INTO credentials (.+) VALUES \(\d+, \d+, (.+), (.+)\)$`
-	Mock.ExpectExec(expectedCredentialsSQL).WillReturnResult(sqlmock.NewResult(1, 1))
+	expectedCredentialsSQL := `^INSERT INTO credentials (.+) VALUES \(\$1, \$2, \$3, \$4, NULLIF\(\$5, ''\)\)$`
+	Mock.ExpectExec(expectedCredentialsSQL).
+		WithArgs(userID, platID, UPID, connStr, "").
+		WillReturnResult(sqlmock.NewResult(1, 1))
 
-	expectedUserbaseSQL := fmt.Sprintf(
-		`^INSERT INTO userbase (.+) VALUES \(%d, %d\)$`, // Need to escape the parenthesis or else Regex will think it's a capture group
-		userID, clientID,
-	)
-	Mock.ExpectExec(expectedUserbaseSQL).WillReturnResult(sqlmock.NewResult(1, 1))
+	// Need to escape the parenthesis or else Regex will think it's a capture group
+	expectedUserbaseSQL := `^INSERT INTO userbase \(user_id, client_id, public_id\) VALUES \(\$1, \$2, \$3\) ON CONFLICT \(user_id, client_id\) DO NOTHING$`
+	Mock.ExpectExec(expectedUserbaseSQL).
+		WithArgs(userID, clientID, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
 	Mock.ExpectCommit()
 
 	// Call the func that we are testing
@@ -234,23 +171,15 @@ func TestInsertUserCredentials_ShouldInsertCredentials(t *testing.T) {
 	assert.Equal(t, userID, actualUserID)
 }
 
-func TestGetUserConnection_ShouldGetConnection(t *testing.T) {
-	userID := 1
-	platID := 1
-	platName := "fitbit"
+func TestGetCredentialConnection_ShouldGetConnection(t *testing.T) {
+	credentialID := 1
 	connStr := "oauth2;Bearer;2020-03-23T04:20:00-0400;AC3$$T0K3N;R3FR3$HT0K3N;"
 
-	platformIDQuery := fmt.Sprintf("^SELECT id FROM platform WHERE name = '%s'$", platName)
-	Mock.ExpectQuery(platformIDQuery).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(platID))
-
-	connStrQuery := fmt.Sprintf(
-		"^SELECT connection_string FROM credentials WHERE user_id = %d AND platform_id = %d$",
-		userID, platID,
-	)
-	Mock.ExpectQuery(connStrQuery).WillReturnRows(sqlmock.NewRows([]string{"connection_string"}).AddRow(connStr))
+	connStrQuery := `^SELECT connection_string FROM credentials WHERE id = \$1$`
+	Mock.ExpectQuery(connStrQuery).WithArgs(credentialID).WillReturnRows(sqlmock.NewRows([]string{"connection_string"}).AddRow(connStr))
 
 	// Call the func that we are testing
-	actualUserConnection, err := GetUserConnection(DB, userID, platName)
+	actualUserConnection, err := GetCredentialConnection(DB, credentialID)
 
 	// Assertions
 	if err != nil {
@@ -309,22 +238,16 @@ func TestGetPlatformDomains_ShouldGetDomains(t *testing.T) {
 	assert.Equal(t, expectedResult, actualDomains)
 }
 
-func TestSignUp_ShouldInsertNewClient(t *testing.T) {
-	clientName := "New_Client"
-	clientPassword := "Client_Password"
-
-	// Mock SQL rows
-	cols := []string{
-		"id",
-	}
-	rows := sqlmock.NewRows(cols).AddRow(1)
+func TestGetPlatformRevokeURLs_ShouldGetRevokeURLs(t *testing.T) {
+	rows := sqlmock.NewRows([]string{"name", "revoke_url"}).
+		AddRow("fitbit", "http://localhost:9292/fitbit/oauth2/revoke").
+		AddRow("strava", "http://localhost:9292/strava/oauth/deauthorize")
 
-	Mock.ExpectQuery(`INSERT INTO client (.+) VALUES (.+)$`).WillReturnRows(rows)
+	Mock.ExpectQuery("^SELECT name, revoke_url FROM platform WHERE revoke_url IS NOT NULL$").WillReturnRows(rows)
 
-	// call the function we are testing
-	clientID, err := InsertNewClient(DB, clientName, clientPassword)
+	actualRevokeURLs, err := GetPlatformRevokeURLs(DB)
 	if err != nil {
-		t.Errorf("error was not expected when inserting a client: %s", err)
+		t.Errorf("error was not expected when getting revoke URLs: %s", err)
 	}
 
 	// We make sure that all expectations were met
@@ -332,28 +255,41 @@ func TestSignUp_ShouldInsertNewClient(t *testing.T) {
 		t.Errorf("there were unfulfilled expectations: %s", err)
 	}
 
-	assert.Equal(t, 1, clientID)
+	expectedResult := map[string]string{
+		"fitbit": "http://localhost:9292/fitbit/oauth2/revoke",
+		"strava": "http://localhost:9292/strava/oauth/deauthorize",
+	}
+
+	assert.Equal(t, expectedResult, actualRevokeURLs)
 }
 
-func TestSignIn_ShouldSignInExistingClient(t *testing.T) {
-	clientName := "Registered_Client"
+func TestSignUp_ShouldInsertNewClient(t *testing.T) {
+	clientName := "New_Client"
 	clientPassword := "Client_Password"
-	clientID := 1
-
-	hashedPassword, _ := bcrypt.GenerateFromPassword([]byte(clientPassword), bcrypt.DefaultCost)
+	clientEmail := "client@example.com"
 
 	// Mock SQL rows
 	cols := []string{
-		"password",
 		"id",
 	}
-	rows := sqlmock.NewRows(cols).AddRow(hashedPassword, clientID)
-	Mock.ExpectQuery(fmt.Sprintf("^SELECT password, id FROM client WHERE name = '%s'$", clientName)).WillReturnRows(rows)
+	rows := sqlmock.NewRows(cols).AddRow(1)
+
+	Mock.ExpectBegin()
+	Mock.ExpectQuery(`INSERT INTO organization (.+) VALUES (.+)`).
+		WithArgs(clientName).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(3))
+	Mock.ExpectQuery(`INSERT INTO client (.+) VALUES (.+)`).
+		WithArgs(clientName, sqlmock.AnyArg(), 3, clientEmail).
+		WillReturnRows(rows)
+	Mock.ExpectExec(`INSERT INTO organization_member (.+) VALUES (.+)`).
+		WithArgs(3, 1, RoleOwner).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	Mock.ExpectCommit()
 
 	// call the function we are testing
-	returnedId, err := SignInClient(DB, clientName, clientPassword)
+	clientID, err := InsertNewClient(DB, clientName, clientPassword, clientEmail)
 	if err != nil {
-		t.Errorf("error was not expected when signing in a client: %s", err)
+		t.Errorf("error was not expected when inserting a client: %s", err)
 	}
 
 	// We make sure that all expectations were met
@@ -361,7 +297,7 @@ func TestSignIn_ShouldSignInExistingClient(t *testing.T) {
 		t.Errorf("there were unfulfilled expectations: %s", err)
 	}
 
-	assert.Equal(t, clientID, returnedId)
+	assert.Equal(t, 1, clientID)
 }
 
 func TestCheckClientName_ShouldReturnUserId(t *testing.T) {
@@ -374,7 +310,7 @@ func TestCheckClientName_ShouldReturnUserId(t *testing.T) {
 	}
 	rows := sqlmock.NewRows(cols).AddRow(clientID)
 
-	Mock.ExpectQuery(fmt.Sprintf("^SELECT id FROM client WHERE name = '%s'$", clientName)).WillReturnRows(rows)
+	Mock.ExpectQuery(`^SELECT id FROM client WHERE name = \$1$`).WithArgs(clientName).WillReturnRows(rows)
 
 	// call the function we are testing
 	returnedId, err := GetClientID(DB, clientName)
@@ -394,7 +330,8 @@ func TestAddUserToUserbase_ShouldNotReturnError(t *testing.T) {
 	clientID := 1
 	userID := 1
 
-	Mock.ExpectExec(`^INSERT INTO userbase (.+) VALUES \(\d+, \d+\)$`).
+	Mock.ExpectExec(`^INSERT INTO userbase \(user_id, client_id, public_id\) VALUES \(\$1, \$2, \$3\) ON CONFLICT \(user_id, client_id\) DO NOTHING$`).
+		WithArgs(userID, clientID, sqlmock.AnyArg()).
 		WillReturnResult(sqlmock.NewResult(1, 1))
 
 	// call the function we are testing
@@ -421,8 +358,8 @@ func TestGetUserInUserbase_ShouldReturnUserID(t *testing.T) {
 	}
 	rows := sqlmock.NewRows(cols).AddRow(userID)
 
-	expectedSQL := fmt.Sprintf("^SELECT user_id FROM userbase WHERE user_id = %d AND client_id = %d$", userID, clientID)
-	Mock.ExpectQuery(expectedSQL).WillReturnRows(rows)
+	expectedSQL := `^SELECT user_id FROM userbase WHERE user_id = \$1 AND client_id = \$2$`
+	Mock.ExpectQuery(expectedSQL).WithArgs(userID, clientID).WillReturnRows(rows)
 
 	// call the function we are testing
 	userIDActual, err := GetUserInUserbase(DB, userID, clientID)
@@ -441,13 +378,13 @@ func TestUpdateClientCallback_ShouldReturnSuccess(t *testing.T) {
 	}
 
 	rows := sqlmock.NewRows(cols).AddRow(clientID)
-	checkQuery := fmt.Sprintf("SELECT id FROM client WHERE id = %d", clientID)
+	checkQuery := `SELECT id FROM client WHERE id = \$1`
 
 	// Expect the query to search for the clientID
-	Mock.ExpectQuery(checkQuery).WillReturnRows(rows)
+	Mock.ExpectQuery(checkQuery).WithArgs(clientID).WillReturnRows(rows)
 
 	// Expect the query to update the client callback
-	Mock.ExpectExec(fmt.Sprintf("UPDATE client SET callback = '%s' WHERE id = %d", clientCallback, clientID)).WillReturnResult(sqlmock.NewResult(1, 1))
+	Mock.ExpectExec(`UPDATE client SET callback = \$1 WHERE id = \$2`).WithArgs(clientCallback, clientID).WillReturnResult(sqlmock.NewResult(1, 1))
 
 	// Call the method we are testing
 	result, err := UpdateCallback(DB, clientID, clientCallback)
@@ -465,17 +402,15 @@ func TestUpdateClientCallback_ShouldReturnSuccess(t *testing.T) {
 }
 
 func TestUpdateCredentials_ShouldUpdateCredentials(t *testing.T) {
-	userID := 1
+	credentialID := 1
 	credentialStr := "oauth2;type;expiry;access;refresh;"
 
-	Mock.ExpectExec(fmt.Sprintf(
-		"^UPDATE credentials SET connection_string = '%s' WHERE user_id = %d$",
-		credentialStr,
-		userID,
-	)).WillReturnResult(sqlmock.NewResult(1, 1))
+	Mock.ExpectExec(`^UPDATE credentials SET connection_string = \$1 WHERE id = \$2$`).
+		WithArgs(credentialStr, credentialID).
+		WillReturnResult(sqlmock.NewResult(1, 1))
 
 	// Call the method we are testing
-	err := UpdateCredentials(DB, userID, credentialStr)
+	err := UpdateCredentials(DB, credentialID, credentialStr)
 
 	// Assertions
 	if err != nil {
@@ -491,7 +426,7 @@ func TestUpdateCredentials_ShouldUpdateCredentials(t *testing.T) {
 }
 
 func TestUpdateCredentialsUsingOAuth2Tokens_ShouldUpdateCredentials(t *testing.T) {
-	userID := 1
+	credentialID := 1
 	expiryStr := "2020-03-23T04:20:00-0400"
 	expiryDate, _ := time.Parse(helpers.ISO8601Layout, expiryStr)
 
@@ -510,14 +445,12 @@ func TestUpdateCredentialsUsingOAuth2Tokens_ShouldUpdateCredentials(t *testing.T
 		tokens.RefreshToken,
 	)
 
-	Mock.ExpectExec(fmt.Sprintf(
-		"^UPDATE credentials SET connection_string = '%s' WHERE user_id = %d$",
-		credentialStr,
-		userID,
-	)).WillReturnResult(sqlmock.NewResult(1, 1))
+	Mock.ExpectExec(`^UPDATE credentials SET connection_string = \$1 WHERE id = \$2$`).
+		WithArgs(credentialStr, credentialID).
+		WillReturnResult(sqlmock.NewResult(1, 1))
 
 	// Call the method we are testing
-	err := UpdateCredentialsUsingOAuth2Tokens(DB, userID, tokens)
+	err := UpdateCredentialsUsingOAuth2Tokens(DB, credentialID, tokens)
 
 	// Assertions
 	if err != nil {
@@ -556,7 +489,8 @@ func TestGetClientCallback_ShouldGetClientCallback(t *testing.T) {
 	callback := "testCallback"
 
 	Mock.ExpectQuery(
-		fmt.Sprintf("SELECT callback FROM client WHERE id = %d", clientID)).
+		`SELECT callback FROM client WHERE id = \$1`).
+		WithArgs(clientID).
 		WillReturnRows(sqlmock.NewRows([]string{"callback"}).AddRow(callback))
 
 	// Call the function we are testing
@@ -577,7 +511,8 @@ func TestGetClientCallback_NoClientShouldReturnBlank(t *testing.T) {
 	clientID := 1
 
 	Mock.ExpectQuery(
-		fmt.Sprintf("SELECT callback FROM client WHERE id = %d", clientID)).
+		`SELECT callback FROM client WHERE id = \$1`).
+		WithArgs(clientID).
 		WillReturnRows(sqlmock.NewRows([]string{"callback"}))
 
 	// Call the function we are testing
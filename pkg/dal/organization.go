@@ -0,0 +1,433 @@
+package dal
+
+import (
+	"database/sql"
+	"time"
+)
+
+// Roles a client account can have as a member of an organization, from most to least privileged
+const (
+	RoleOwner     = "owner"
+	RoleDeveloper = "developer"
+	RoleViewer    = "viewer"
+)
+
+type Organization struct {
+	ID   int
+	Name string
+	Role string // Role of the client that requested the organization
+}
+
+type OrganizationMember struct {
+	ClientID int
+	Name     string
+	Role     string
+	JoinedAt time.Time
+}
+
+type OrganizationInvite struct {
+	ID               int
+	OrganizationID   int
+	OrganizationName string
+	InviteeID        int
+	InviteeName      string
+	Role             string
+	CreatedAt        time.Time
+	ExpiresAt        time.Time
+}
+
+type OrganizationClient struct {
+	ID   int
+	Name string
+}
+
+func IsValidRole(role string) bool {
+	return role == RoleOwner || role == RoleDeveloper || role == RoleViewer
+}
+
+// InsertOrganization creates a new organization, with the given client account as its owner
+func InsertOrganization(db *sql.DB, name string, ownerID int) (organizationID int, err error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		} else {
+			err = tx.Commit()
+		}
+	}()
+
+	organizationID, err = insertOrganization(tx, name, ownerID)
+	if err != nil {
+		return 0, err
+	}
+
+	return organizationID, nil
+}
+
+// GetClientOrganizations returns the organizations the client account is a member of
+func GetClientOrganizations(db *sql.DB, clientID int) ([]Organization, error) {
+	rows, err := db.Query(
+		`SELECT o.id, o.name, m.role FROM organization o
+				JOIN organization_member m ON o.id = m.organization_id
+				WHERE m.member_id = $1
+				ORDER BY o.id`,
+		clientID,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	var organizations []Organization
+	for rows.Next() {
+		var organization Organization
+		err := rows.Scan(&organization.ID, &organization.Name, &organization.Role)
+		if err != nil {
+			return nil, err
+		}
+
+		organizations = append(organizations, organization)
+	}
+
+	return organizations, nil
+}
+
+// GetMemberRole returns the role of a client account in an organization, or an empty string if it is not a member
+func GetMemberRole(db *sql.DB, organizationID int, memberID int) (string, error) {
+	var role string
+	err := db.QueryRow(
+		`SELECT role FROM organization_member WHERE organization_id = $1 AND member_id = $2`,
+		organizationID,
+		memberID,
+	).Scan(&role)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return "", nil
+		}
+
+		return "", err
+	}
+
+	return role, nil
+}
+
+// GetMemberRoleForClient returns the role that a client account has in the organization that owns another client,
+// or an empty string if it is not a member of that organization
+func GetMemberRoleForClient(db *sql.DB, memberID int, clientID int) (string, error) {
+	var role string
+	err := db.QueryRow(
+		`SELECT m.role FROM organization_member m
+				JOIN client c ON c.organization_id = m.organization_id
+				WHERE c.id = $1 AND m.member_id = $2`,
+		clientID,
+		memberID,
+	).Scan(&role)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return "", nil
+		}
+
+		return "", err
+	}
+
+	return role, nil
+}
+
+func GetOrganizationMembers(db *sql.DB, organizationID int) ([]OrganizationMember, error) {
+	rows, err := db.Query(
+		`SELECT c.id, c.name, m.role, m.joined_at FROM organization_member m
+				JOIN client c ON c.id = m.member_id
+				WHERE m.organization_id = $1
+				ORDER BY m.joined_at`,
+		organizationID,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	var members []OrganizationMember
+	for rows.Next() {
+		var member OrganizationMember
+		err := rows.Scan(&member.ClientID, &member.Name, &member.Role, &member.JoinedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		members = append(members, member)
+	}
+
+	return members, nil
+}
+
+// UpdateMemberRole changes the role of a member. It returns false if the client is not a member of the organization
+func UpdateMemberRole(db *sql.DB, organizationID int, memberID int, role string) (bool, error) {
+	result, err := db.Exec(
+		`UPDATE organization_member SET role = $1 WHERE organization_id = $2 AND member_id = $3`,
+		role,
+		organizationID,
+		memberID,
+	)
+	if err != nil {
+		return false, err
+	}
+
+	rows, _ := result.RowsAffected()
+	return rows == 1, nil
+}
+
+// DeleteMember removes a member from the organization. It returns false if the client was not a member
+func DeleteMember(db *sql.DB, organizationID int, memberID int) (bool, error) {
+	result, err := db.Exec(
+		`DELETE FROM organization_member WHERE organization_id = $1 AND member_id = $2`,
+		organizationID,
+		memberID,
+	)
+	if err != nil {
+		return false, err
+	}
+
+	rows, _ := result.RowsAffected()
+	return rows == 1, nil
+}
+
+func CountOrganizationOwners(db *sql.DB, organizationID int) (int, error) {
+	var owners int
+	err := db.QueryRow(
+		`SELECT COUNT(*) FROM organization_member WHERE organization_id = $1 AND role = $2`,
+		organizationID,
+		RoleOwner,
+	).Scan(&owners)
+	if err != nil {
+		return 0, err
+	}
+
+	return owners, nil
+}
+
+// InsertOrganizationInvite invites a client account to join an organization with the given role
+func InsertOrganizationInvite(db *sql.DB, organizationID int, inviteeID int, invitedBy int, role string, expiresAt time.Time) (int, error) {
+	var inviteID int
+	err := db.QueryRow(
+		`INSERT INTO organization_invite (organization_id, invitee_id, invited_by, role, expires_at)
+				VALUES ($1, $2, $3, $4, $5)
+				RETURNING id`,
+		organizationID,
+		inviteeID,
+		invitedBy,
+		role,
+		expiresAt,
+	).Scan(&inviteID)
+	if err != nil {
+		return 0, err
+	}
+
+	return inviteID, nil
+}
+
+// GetOrganizationInvites returns the invites of an organization that haven't been answered or expired yet
+func GetOrganizationInvites(db *sql.DB, organizationID int) ([]OrganizationInvite, error) {
+	return queryOrganizationInvites(db,
+		`SELECT i.id, o.id, o.name, c.id, c.name, i.role, i.created_at, i.expires_at FROM organization_invite i
+				JOIN organization o ON o.id = i.organization_id
+				JOIN client c ON c.id = i.invitee_id
+				WHERE i.organization_id = $1 AND i.expires_at > now()
+				ORDER BY i.created_at`,
+		organizationID,
+	)
+}
+
+// GetPendingInvites returns the invites sent to a client account that haven't been answered or expired yet
+func GetPendingInvites(db *sql.DB, inviteeID int) ([]OrganizationInvite, error) {
+	return queryOrganizationInvites(db,
+		`SELECT i.id, o.id, o.name, c.id, c.name, i.role, i.created_at, i.expires_at FROM organization_invite i
+				JOIN organization o ON o.id = i.organization_id
+				JOIN client c ON c.id = i.invitee_id
+				WHERE i.invitee_id = $1 AND i.expires_at > now()
+				ORDER BY i.created_at`,
+		inviteeID,
+	)
+}
+
+// AcceptOrganizationInvite makes the invitee a member of the organization it was invited to.
+// It returns false if the invitee has no pending invite with that ID
+func AcceptOrganizationInvite(db *sql.DB, inviteID int, inviteeID int) (accepted bool, err error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return false, err
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		} else {
+			err = tx.Commit()
+		}
+	}()
+
+	var organizationID int
+	var role string
+	err = tx.QueryRow(
+		`DELETE FROM organization_invite
+				WHERE id = $1 AND invitee_id = $2 AND expires_at > now()
+				RETURNING organization_id, role`,
+		inviteID,
+		inviteeID,
+	).Scan(&organizationID, &role)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return false, nil
+		}
+
+		return false, err
+	}
+
+	// If the invitee was already a member, the invite changes its role
+	_, err = tx.Exec(
+		`INSERT INTO organization_member (organization_id, member_id, role) VALUES ($1, $2, $3)
+				ON CONFLICT (organization_id, member_id) DO UPDATE SET role = $3`,
+		organizationID,
+		inviteeID,
+		role,
+	)
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// DeleteOrganizationInvite removes an invite, either because it was declined or cancelled.
+// It returns false if the organization has no invite with that ID
+func DeleteOrganizationInvite(db *sql.DB, organizationID int, inviteID int) (bool, error) {
+	result, err := db.Exec(
+		`DELETE FROM organization_invite WHERE id = $1 AND organization_id = $2`,
+		inviteID,
+		organizationID,
+	)
+	if err != nil {
+		return false, err
+	}
+
+	rows, _ := result.RowsAffected()
+	return rows == 1, nil
+}
+
+// DeclineOrganizationInvite removes an invite sent to the invitee. It returns false if the invitee has no invite with that ID
+func DeclineOrganizationInvite(db *sql.DB, inviteID int, inviteeID int) (bool, error) {
+	result, err := db.Exec(
+		`DELETE FROM organization_invite WHERE id = $1 AND invitee_id = $2`,
+		inviteID,
+		inviteeID,
+	)
+	if err != nil {
+		return false, err
+	}
+
+	rows, _ := result.RowsAffected()
+	return rows == 1, nil
+}
+
+// GetOrganizationClients returns the clients owned by an organization
+func GetOrganizationClients(db *sql.DB, organizationID int) ([]OrganizationClient, error) {
+	rows, err := db.Query(
+		`SELECT id, name FROM client WHERE organization_id = $1 ORDER BY id`,
+		organizationID,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	var clients []OrganizationClient
+	for rows.Next() {
+		var client OrganizationClient
+		err := rows.Scan(&client.ID, &client.Name)
+		if err != nil {
+			return nil, err
+		}
+
+		clients = append(clients, client)
+	}
+
+	return clients, nil
+}
+
+// GetClientOrganizationID returns the ID of the organization that owns the client
+func GetClientOrganizationID(db *sql.DB, clientID int) (int, error) {
+	var organizationID int
+	err := db.QueryRow(`SELECT organization_id FROM client WHERE id = $1`, clientID).Scan(&organizationID)
+	if err != nil {
+		return 0, err
+	}
+
+	return organizationID, nil
+}
+
+// UpdateClientOrganization moves the ownership of a client to another organization
+func UpdateClientOrganization(db *sql.DB, clientID int, organizationID int) error {
+	_, err := db.Exec(`UPDATE client SET organization_id = $1 WHERE id = $2`, organizationID, clientID)
+	return err
+}
+
+// insertOrganization creates an organization and adds its owner inside an existing transaction
+func insertOrganization(tx *sql.Tx, name string, ownerID int) (int, error) {
+	var organizationID int
+	err := tx.QueryRow(
+		`INSERT INTO organization (name) VALUES ($1) RETURNING id`,
+		name,
+	).Scan(&organizationID)
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.Exec(
+		`INSERT INTO organization_member (organization_id, member_id, role) VALUES ($1, $2, $3)`,
+		organizationID,
+		ownerID,
+		RoleOwner,
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	return organizationID, nil
+}
+
+func queryOrganizationInvites(db *sql.DB, query string, args ...interface{}) ([]OrganizationInvite, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	var invites []OrganizationInvite
+	for rows.Next() {
+		var invite OrganizationInvite
+		err := rows.Scan(
+			&invite.ID,
+			&invite.OrganizationID,
+			&invite.OrganizationName,
+			&invite.InviteeID,
+			&invite.InviteeName,
+			&invite.Role,
+			&invite.CreatedAt,
+			&invite.ExpiresAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		invites = append(invites, invite)
+	}
+
+	return invites, nil
+}
@@ -0,0 +1,118 @@
+package dal
+
+import (
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInsertOrganization_ShouldAddOwner(t *testing.T) {
+	ownerID := 1
+	name := "Sandwich Team"
+
+	Mock.ExpectBegin()
+	Mock.ExpectQuery(`^INSERT INTO organization \(name\) VALUES \(\$1\) RETURNING id$`).
+		WithArgs(name).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(2))
+	Mock.ExpectExec(`^INSERT INTO organization_member \(organization_id, member_id, role\) VALUES \(\$1, \$2, \$3\)$`).
+		WithArgs(2, ownerID, RoleOwner).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	Mock.ExpectCommit()
+
+	// Call the func that we are testing
+	organizationID, err := InsertOrganization(DB, name, ownerID)
+	if err != nil {
+		t.Errorf("error was not expected when inserting organization: %s", err)
+	}
+
+	// We make sure that all expectations were met
+	if err := Mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+
+	assert.Equal(t, 2, organizationID)
+}
+
+func TestGetMemberRoleForClient_ShouldReturnEmptyRoleForNonMembers(t *testing.T) {
+	memberID := 1
+	clientID := 2
+
+	Mock.ExpectQuery(`^SELECT m.role FROM organization_member m (.+) WHERE c.id = \$1 AND m.member_id = \$2$`).
+		WithArgs(clientID, memberID).
+		WillReturnRows(sqlmock.NewRows([]string{"role"}).AddRow(RoleDeveloper))
+
+	Mock.ExpectQuery(`^SELECT m.role FROM organization_member m (.+) WHERE c.id = \$1 AND m.member_id = \$2$`).
+		WithArgs(clientID, 3).
+		WillReturnRows(sqlmock.NewRows([]string{"role"}))
+
+	// Call the func that we are testing
+	role, err := GetMemberRoleForClient(DB, memberID, clientID)
+	if err != nil {
+		t.Errorf("error was not expected when getting member role: %s", err)
+	}
+
+	noRole, err := GetMemberRoleForClient(DB, 3, clientID)
+	if err != nil {
+		t.Errorf("error was not expected when getting member role: %s", err)
+	}
+
+	// We make sure that all expectations were met
+	if err := Mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+
+	assert.Equal(t, RoleDeveloper, role)
+	assert.Equal(t, "", noRole)
+}
+
+func TestAcceptOrganizationInvite_ShouldAddMember(t *testing.T) {
+	inviteID := 4
+	inviteeID := 2
+
+	Mock.ExpectBegin()
+	Mock.ExpectQuery(`^DELETE FROM organization_invite WHERE id = \$1 AND invitee_id = \$2 AND expires_at > now\(\) RETURNING organization_id, role$`).
+		WithArgs(inviteID, inviteeID).
+		WillReturnRows(sqlmock.NewRows([]string{"organization_id", "role"}).AddRow(1, RoleViewer))
+	Mock.ExpectExec(`^INSERT INTO organization_member (.+) ON CONFLICT \(organization_id, member_id\) DO UPDATE SET role = \$3$`).
+		WithArgs(1, inviteeID, RoleViewer).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	Mock.ExpectCommit()
+
+	// Call the func that we are testing
+	accepted, err := AcceptOrganizationInvite(DB, inviteID, inviteeID)
+	if err != nil {
+		t.Errorf("error was not expected when accepting invite: %s", err)
+	}
+
+	// We make sure that all expectations were met
+	if err := Mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+
+	assert.True(t, accepted)
+}
+
+func TestAcceptOrganizationInvite_ShouldIgnoreOtherClientsInvites(t *testing.T) {
+	inviteID := 4
+	inviteeID := 3
+
+	Mock.ExpectBegin()
+	Mock.ExpectQuery(`^DELETE FROM organization_invite (.+) RETURNING organization_id, role$`).
+		WithArgs(inviteID, inviteeID).
+		WillReturnRows(sqlmock.NewRows([]string{"organization_id", "role"}))
+	Mock.ExpectCommit()
+
+	// Call the func that we are testing
+	accepted, err := AcceptOrganizationInvite(DB, inviteID, inviteeID)
+	if err != nil {
+		t.Errorf("error was not expected when accepting invite: %s", err)
+	}
+
+	// We make sure that all expectations were met
+	if err := Mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+
+	assert.False(t, accepted)
+}
@@ -0,0 +1,110 @@
+package dal
+
+import (
+	"database/sql"
+)
+
+// PlatformPriority is the order a user trusts a resource's platforms in, as stored by a client.
+// Excluded platforms are left out of the resource altogether
+type PlatformPriority struct {
+	Priority []string // Most trusted first
+	Excluded []string
+}
+
+// PlatformPriorities maps resources to the order the user trusts their platforms in
+type PlatformPriorities map[string]PlatformPriority
+
+// GetPlatformPriorities returns the platform priorities the client stored for the user
+func GetPlatformPriorities(db *sql.DB, userID int, clientID int) (PlatformPriorities, error) {
+	rows, err := db.Query(
+		`SELECT resource, platform, excluded FROM platform_priority
+				WHERE user_id = $1 AND client_id = $2
+				ORDER BY resource, rank, platform`,
+		userID,
+		clientID,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	priorities := make(PlatformPriorities)
+	for rows.Next() {
+		var resource string
+		var platformName string
+		var excluded bool
+		err := rows.Scan(&resource, &platformName, &excluded)
+		if err != nil {
+			return nil, err
+		}
+
+		priority := priorities[resource]
+		if excluded {
+			priority.Excluded = append(priority.Excluded, platformName)
+		} else {
+			priority.Priority = append(priority.Priority, platformName)
+		}
+		priorities[resource] = priority
+	}
+
+	return priorities, nil
+}
+
+// SetPlatformPriority replaces the platform priority the client stored for one of the user's resources.
+// An empty priority removes it
+func SetPlatformPriority(db *sql.DB, userID int, clientID int, resource string, priority PlatformPriority) (err error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		} else {
+			err = tx.Commit()
+		}
+	}()
+
+	_, err = tx.Exec(
+		`DELETE FROM platform_priority WHERE user_id = $1 AND client_id = $2 AND resource = $3`,
+		userID,
+		clientID,
+		resource,
+	)
+	if err != nil {
+		return err
+	}
+
+	for rank, platformName := range priority.Priority {
+		err = insertPlatformPriority(tx, userID, clientID, resource, platformName, rank, false)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, platformName := range priority.Excluded {
+		err = insertPlatformPriority(tx, userID, clientID, resource, platformName, 0, true)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func insertPlatformPriority(tx *sql.Tx, userID int, clientID int, resource string, platformName string, rank int, excluded bool) error {
+	_, err := tx.Exec(
+		`INSERT INTO platform_priority (user_id, client_id, resource, platform, rank, excluded) VALUES ($1, $2, $3, $4, $5, $6)
+				ON CONFLICT DO NOTHING`,
+		userID,
+		clientID,
+		resource,
+		platformName,
+		rank,
+		excluded,
+	)
+
+	return err
+}
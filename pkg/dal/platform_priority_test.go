@@ -0,0 +1,39 @@
+package dal
+
+import (
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetPlatformPriorities_ShouldGroupByResource(t *testing.T) {
+	userID := 2
+	clientID := 1
+
+	rows := sqlmock.NewRows([]string{"resource", "platform", "excluded"}).
+		AddRow("distance", "strava", false).
+		AddRow("distance", "fitbit", false).
+		AddRow("steps", "google", true).
+		AddRow("steps", "fitbit", false)
+
+	Mock.ExpectQuery(`^SELECT resource, platform, excluded FROM platform_priority (.+)$`).
+		WithArgs(userID, clientID).
+		WillReturnRows(rows)
+
+	// Call the func that we are testing
+	priorities, err := GetPlatformPriorities(DB, userID, clientID)
+	if err != nil {
+		t.Errorf("error was not expected when getting platform priorities: %s", err)
+	}
+
+	// We make sure that all expectations were met
+	if err := Mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+
+	assert.Equal(t, []string{"strava", "fitbit"}, priorities["distance"].Priority)
+	assert.Empty(t, priorities["distance"].Excluded)
+	assert.Equal(t, []string{"fitbit"}, priorities["steps"].Priority)
+	assert.Equal(t, []string{"google"}, priorities["steps"].Excluded)
+}
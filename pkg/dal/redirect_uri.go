@@ -0,0 +1,102 @@
+package dal
+
+import (
+	"database/sql"
+	"time"
+)
+
+type RedirectURI struct {
+	ID        int
+	URI       string
+	CreatedAt time.Time
+}
+
+func GetClientRedirectURIs(db *sql.DB, clientID int) ([]RedirectURI, error) {
+	rows, err := db.Query(
+		`SELECT id, uri, created_at FROM client_redirect_uri WHERE client_id = $1 ORDER BY id`,
+		clientID,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	var uris []RedirectURI
+	for rows.Next() {
+		var uri RedirectURI
+		err := rows.Scan(&uri.ID, &uri.URI, &uri.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		uris = append(uris, uri)
+	}
+
+	return uris, nil
+}
+
+// InsertClientRedirectURI registers a new redirect URI for the client and returns its ID
+func InsertClientRedirectURI(db *sql.DB, clientID int, uri string) (int, error) {
+	var uriID int
+	err := db.QueryRow(
+		`INSERT INTO client_redirect_uri (client_id, uri) VALUES ($1, $2) RETURNING id`,
+		clientID,
+		uri,
+	).Scan(&uriID)
+	if err != nil {
+		return 0, err
+	}
+
+	return uriID, nil
+}
+
+// UpdateClientRedirectURI replaces a redirect URI of the client. It returns false if the client has no URI with that ID
+func UpdateClientRedirectURI(db *sql.DB, clientID int, uriID int, uri string) (bool, error) {
+	result, err := db.Exec(
+		`UPDATE client_redirect_uri SET uri = $1 WHERE id = $2 AND client_id = $3`,
+		uri,
+		uriID,
+		clientID,
+	)
+	if err != nil {
+		return false, err
+	}
+
+	rows, _ := result.RowsAffected()
+	return rows == 1, nil
+}
+
+// DeleteClientRedirectURI removes a redirect URI of the client. It returns false if the client has no URI with that ID
+func DeleteClientRedirectURI(db *sql.DB, clientID int, uriID int) (bool, error) {
+	result, err := db.Exec(
+		`DELETE FROM client_redirect_uri WHERE id = $1 AND client_id = $2`,
+		uriID,
+		clientID,
+	)
+	if err != nil {
+		return false, err
+	}
+
+	rows, _ := result.RowsAffected()
+	return rows == 1, nil
+}
+
+// IsClientRedirectURI checks if uri is exactly one of the URIs registered by the client
+func IsClientRedirectURI(db *sql.DB, clientID int, uri string) (bool, error) {
+	var uriID int
+	err := db.QueryRow(
+		`SELECT id FROM client_redirect_uri WHERE client_id = $1 AND uri = $2`,
+		clientID,
+		uri,
+	).Scan(&uriID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return false, nil
+		}
+
+		return false, err
+	}
+
+	return true, nil
+}
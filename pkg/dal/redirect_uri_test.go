@@ -0,0 +1,63 @@
+package dal
+
+import (
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsClientRedirectURI_ShouldMatchExactURI(t *testing.T) {
+	clientID := 1
+	uri := "https://example.com/callback"
+
+	Mock.ExpectQuery(`^SELECT id FROM client_redirect_uri WHERE client_id = \$1 AND uri = \$2$`).
+		WithArgs(clientID, uri).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(3))
+
+	Mock.ExpectQuery(`^SELECT id FROM client_redirect_uri WHERE client_id = \$1 AND uri = \$2$`).
+		WithArgs(clientID, uri+"/").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+	// Call the func that we are testing
+	registered, err := IsClientRedirectURI(DB, clientID, uri)
+	if err != nil {
+		t.Errorf("error was not expected when checking redirect URI: %s", err)
+	}
+
+	notRegistered, err := IsClientRedirectURI(DB, clientID, uri+"/")
+	if err != nil {
+		t.Errorf("error was not expected when checking redirect URI: %s", err)
+	}
+
+	// We make sure that all expectations were met
+	if err := Mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+
+	assert.True(t, registered)
+	assert.False(t, notRegistered)
+}
+
+func TestUpdateClientRedirectURI_ShouldUpdateURI(t *testing.T) {
+	clientID := 1
+	uriID := 3
+	uri := "https://example.com/new-callback"
+
+	Mock.ExpectExec(`^UPDATE client_redirect_uri SET uri = \$1 WHERE id = \$2 AND client_id = \$3$`).
+		WithArgs(uri, uriID, clientID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	// Call the func that we are testing
+	updated, err := UpdateClientRedirectURI(DB, clientID, uriID, uri)
+	if err != nil {
+		t.Errorf("error was not expected when updating redirect URI: %s", err)
+	}
+
+	// We make sure that all expectations were met
+	if err := Mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+
+	assert.True(t, updated)
+}
@@ -0,0 +1,125 @@
+package dal
+
+import (
+	"database/sql"
+	"strings"
+)
+
+// Kinds of items a user can withhold from a client
+const (
+	WithheldResource = "resource"
+	WithheldPlatform = "platform"
+)
+
+// SharingPreferences are the resources and platforms a user doesn't let a client read. Everything else is shared
+type SharingPreferences struct {
+	WithheldResources []string
+	WithheldPlatforms []string
+}
+
+// Shares reports if the client may read the resource from the platform
+func (s SharingPreferences) Shares(resource string, platform string) bool {
+	return !containsName(s.WithheldResources, resource) && !containsName(s.WithheldPlatforms, platform)
+}
+
+// SharesPlatform reports if the client may read anything from the platform
+func (s SharingPreferences) SharesPlatform(platform string) bool {
+	return !containsName(s.WithheldPlatforms, platform)
+}
+
+// GetSharingPreferences returns what the user withholds from the client
+func GetSharingPreferences(db *sql.DB, userID int, clientID int) (SharingPreferences, error) {
+	rows, err := db.Query(
+		`SELECT withheld_type, withheld_name FROM sharing_preference
+				WHERE user_id = $1 AND client_id = $2
+				ORDER BY withheld_type, withheld_name`,
+		userID,
+		clientID,
+	)
+	if err != nil {
+		return SharingPreferences{}, err
+	}
+
+	defer rows.Close()
+
+	var preferences SharingPreferences
+	for rows.Next() {
+		var withheldType string
+		var withheldName string
+		err := rows.Scan(&withheldType, &withheldName)
+		if err != nil {
+			return SharingPreferences{}, err
+		}
+
+		switch withheldType {
+		case WithheldResource:
+			preferences.WithheldResources = append(preferences.WithheldResources, withheldName)
+		case WithheldPlatform:
+			preferences.WithheldPlatforms = append(preferences.WithheldPlatforms, withheldName)
+		}
+	}
+
+	return preferences, nil
+}
+
+// SetSharingPreferences replaces what the user withholds from the client, and records the change in the user's access history
+func SetSharingPreferences(db *sql.DB, userID int, clientID int, preferences SharingPreferences) (err error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		} else {
+			err = tx.Commit()
+		}
+	}()
+
+	_, err = tx.Exec(`DELETE FROM sharing_preference WHERE user_id = $1 AND client_id = $2`, userID, clientID)
+	if err != nil {
+		return err
+	}
+
+	err = insertWithheldItems(tx, userID, clientID, WithheldResource, preferences.WithheldResources)
+	if err != nil {
+		return err
+	}
+
+	err = insertWithheldItems(tx, userID, clientID, WithheldPlatform, preferences.WithheldPlatforms)
+	if err != nil {
+		return err
+	}
+
+	details := strings.Join(append(append([]string{}, preferences.WithheldResources...), preferences.WithheldPlatforms...), ", ")
+	return insertUserEvent(tx, userID, clientID, UserEventSharingChanged, details)
+}
+
+func insertWithheldItems(tx *sql.Tx, userID int, clientID int, withheldType string, names []string) error {
+	for _, name := range names {
+		_, err := tx.Exec(
+			`INSERT INTO sharing_preference (user_id, client_id, withheld_type, withheld_name) VALUES ($1, $2, $3, $4)
+					ON CONFLICT DO NOTHING`,
+			userID,
+			clientID,
+			withheldType,
+			name,
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func containsName(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+
+	return false
+}
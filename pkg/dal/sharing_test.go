@@ -0,0 +1,38 @@
+package dal
+
+import (
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetSharingPreferences_ShouldSplitResourcesAndPlatforms(t *testing.T) {
+	userID := 2
+	clientID := 1
+
+	rows := sqlmock.NewRows([]string{"withheld_type", "withheld_name"}).
+		AddRow(WithheldPlatform, "google").
+		AddRow(WithheldResource, "calories")
+
+	Mock.ExpectQuery(`^SELECT withheld_type, withheld_name FROM sharing_preference (.+)$`).
+		WithArgs(userID, clientID).
+		WillReturnRows(rows)
+
+	// Call the func that we are testing
+	preferences, err := GetSharingPreferences(DB, userID, clientID)
+	if err != nil {
+		t.Errorf("error was not expected when getting sharing preferences: %s", err)
+	}
+
+	// We make sure that all expectations were met
+	if err := Mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+
+	assert.Equal(t, []string{"calories"}, preferences.WithheldResources)
+	assert.Equal(t, []string{"google"}, preferences.WithheldPlatforms)
+	assert.True(t, preferences.Shares("steps", "fitbit"))
+	assert.False(t, preferences.Shares("calories", "fitbit"))
+	assert.False(t, preferences.Shares("steps", "google"))
+}
@@ -0,0 +1,502 @@
+package dal
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"io"
+	"strings"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+var ErrUserHasOtherClients = errors.New("user is in the userbase of other clients")
+
+// UserbaseEntry is a user as seen by one of the clients it is linked to
+type UserbaseEntry struct {
+	UserID      int
+	PublicID    string    // ID the client knows the user by
+	ExternalRef string    // Client's own ID for the user. Empty if the client hasn't set one
+	CreatedAt   time.Time // When the user was added to the client's userbase
+	Platforms   []string  // Platforms the user shares with the client
+	// Platforms the user has linked but withholds from the client
+	WithheldPlatforms []string
+}
+
+// UserData is a day of metrics synced from one of the user's platforms
+type UserData struct {
+	Platform string
+	Date     time.Time
+	Steps    int
+	Calories int
+	Distance float64
+}
+
+// PlatformLink describes the credentials a user has for one of its platform accounts
+type PlatformLink struct {
+	Platform        string
+	Label           string // Name the client gave the account, or its platform and the order it was linked in
+	LinkedAt        time.Time
+	LastRefreshed   sql.NullTime
+	RefreshFailedAt sql.NullTime
+	RefreshError    sql.NullString
+}
+
+// GetUserbase returns a page of the users in the client's userbase, oldest first, and the total amount of users in it.
+// Linked platforms are split by whether the user shares them with the client.
+// If externalRef isn't empty, only the user with that reference is returned
+func GetUserbase(db *sql.DB, clientID int, externalRef string, limit int, offset int) ([]UserbaseEntry, int, error) {
+	var total int
+	err := db.QueryRow(
+		`SELECT COUNT(*) FROM userbase WHERE client_id = $1 AND ($2 = '' OR external_ref = $2)`,
+		clientID,
+		externalRef,
+	).Scan(&total)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	rows, err := db.Query(
+		`SELECT u.user_id, u.public_id, COALESCE(u.external_ref, ''), u.created_at,
+				COALESCE(string_agg(DISTINCT p.name, ',' ORDER BY p.name) FILTER (WHERE s.withheld_name IS NULL), ''),
+				COALESCE(string_agg(DISTINCT p.name, ',' ORDER BY p.name) FILTER (WHERE s.withheld_name IS NOT NULL), '')
+				FROM userbase u
+				LEFT JOIN credentials c ON c.user_id = u.user_id
+				LEFT JOIN platform p ON p.id = c.platform_id
+				LEFT JOIN sharing_preference s ON s.user_id = u.user_id AND s.client_id = u.client_id
+					AND s.withheld_type = $5 AND s.withheld_name = p.name
+				WHERE u.client_id = $1 AND ($2 = '' OR u.external_ref = $2)
+				GROUP BY u.user_id, u.public_id, u.external_ref, u.created_at
+				ORDER BY u.created_at, u.user_id
+				LIMIT $3 OFFSET $4`,
+		clientID,
+		externalRef,
+		limit,
+		offset,
+		WithheldPlatform,
+	)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	defer rows.Close()
+
+	var users []UserbaseEntry
+	for rows.Next() {
+		var user UserbaseEntry
+		var platforms string
+		var withheldPlatforms string
+		err := rows.Scan(&user.UserID, &user.PublicID, &user.ExternalRef, &user.CreatedAt, &platforms, &withheldPlatforms)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		if platforms != "" {
+			user.Platforms = strings.Split(platforms, ",")
+		}
+
+		if withheldPlatforms != "" {
+			user.WithheldPlatforms = strings.Split(withheldPlatforms, ",")
+		}
+
+		users = append(users, user)
+	}
+
+	return users, total, nil
+}
+
+// GetUserbaseEntry returns how the client knows the user, and when the user was added to the client's userbase
+func GetUserbaseEntry(db *sql.DB, userID int, clientID int) (UserbaseEntry, error) {
+	user := UserbaseEntry{UserID: userID}
+	err := db.QueryRow(
+		`SELECT public_id, COALESCE(external_ref, ''), created_at FROM userbase WHERE user_id = $1 AND client_id = $2`,
+		userID,
+		clientID,
+	).Scan(&user.PublicID, &user.ExternalRef, &user.CreatedAt)
+	if err != nil {
+		return UserbaseEntry{}, err
+	}
+
+	return user, nil
+}
+
+// GetUserPlatformLinks returns the platforms linked to the user, and the state of their credentials
+func GetUserPlatformLinks(db *sql.DB, userID int) ([]PlatformLink, error) {
+	rows, err := db.Query(
+		`SELECT p.name, `+accountLabel+`, c.linked_at, c.last_refreshed, c.refresh_failed_at, c.refresh_error
+				FROM credentials c
+				JOIN platform p ON p.id = c.platform_id
+				WHERE c.user_id = $1
+				ORDER BY c.linked_at, c.id`,
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	var links []PlatformLink
+	for rows.Next() {
+		var link PlatformLink
+		err := rows.Scan(&link.Platform, &link.Label, &link.LinkedAt, &link.LastRefreshed, &link.RefreshFailedAt, &link.RefreshError)
+		if err != nil {
+			return nil, err
+		}
+
+		links = append(links, link)
+	}
+
+	return links, nil
+}
+
+// MarkCredentialsRefreshed records that the tokens of a platform account were successfully refreshed
+func MarkCredentialsRefreshed(db *sql.DB, credentialID int) error {
+	_, err := db.Exec(
+		`UPDATE credentials SET last_refreshed = now(), refresh_failed_at = NULL, refresh_error = NULL WHERE id = $1`,
+		credentialID,
+	)
+	return err
+}
+
+// MarkCredentialsRefreshFailed records that the tokens of a platform account couldn't be refreshed, and why
+func MarkCredentialsRefreshFailed(db *sql.DB, credentialID int, reason string) error {
+	_, err := db.Exec(
+		`UPDATE credentials SET refresh_failed_at = now(), refresh_error = $2 WHERE id = $1`,
+		credentialID,
+		reason,
+	)
+	return err
+}
+
+// DeleteUserAccount removes the credentials of one of the user's platform accounts, and records which client did it.
+// Data synced from the platform is removed along with the user's last account of it. Credentials are shared by every
+// client that has the user, so it returns ErrUserHasOtherClients unless only the client has the user.
+// It returns false if the user doesn't have the account. Otherwise, the account is returned along with
+// its tokens, so they can be revoked once the deletion is committed
+func DeleteUserAccount(db *sql.DB, userID int, clientID int, account Account) (erased ErasedAccount, deleted bool, err error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return ErasedAccount{}, false, err
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		} else {
+			err = tx.Commit()
+		}
+	}()
+
+	// Locking the user keeps other clients from adding it to their userbase while the account is deleted
+	_, err = tx.Exec(`SELECT id FROM "user" WHERE id = $1 FOR UPDATE`, userID)
+	if err != nil {
+		return ErasedAccount{}, false, err
+	}
+
+	var otherClients int
+	err = tx.QueryRow(
+		`SELECT COUNT(*) FROM userbase WHERE user_id = $1 AND client_id <> $2`,
+		userID,
+		clientID,
+	).Scan(&otherClients)
+	if err != nil {
+		return ErasedAccount{}, false, err
+	}
+
+	if otherClients > 0 {
+		return ErasedAccount{}, false, ErrUserHasOtherClients
+	}
+
+	var platformID int
+	var connectionString string
+	err = tx.QueryRow(
+		`DELETE FROM credentials WHERE id = $1 AND user_id = $2 RETURNING platform_id, connection_string`,
+		account.CredentialID,
+		userID,
+	).Scan(&platformID, &connectionString)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return ErasedAccount{}, false, nil
+		}
+
+		return ErasedAccount{}, false, err
+	}
+
+	_, err = tx.Exec(
+		`DELETE FROM user_data WHERE user_id = $1 AND platform_id = $2
+				AND NOT EXISTS (SELECT 1 FROM credentials WHERE user_id = $1 AND platform_id = $2)`,
+		userID,
+		platformID,
+	)
+	if err != nil {
+		return ErasedAccount{}, false, err
+	}
+
+	err = insertUserEvent(tx, userID, clientID, UserEventPlatformUnlinked, account.Platform+" ("+account.Label+")")
+	if err != nil {
+		return ErasedAccount{}, false, err
+	}
+
+	return ErasedAccount{Account: account, Tokens: accountTokens(connectionString)}, true, nil
+}
+
+// ErasedAccount is a platform account of an erased user, along with the tokens mrthn had for it
+type ErasedAccount struct {
+	Account
+	Tokens *oauth2.Token // nil if the account's connection couldn't be parsed
+}
+
+// DeleteUser removes the user from the client's userbase. Once no client has the user in its userbase,
+// everything mrthn knows about the user is erased. It returns true if the user was erased, along with
+// the platform accounts it had, so their tokens can be revoked once the erasure is committed
+func DeleteUser(db *sql.DB, userID int, clientID int) (erased bool, accounts []ErasedAccount, err error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return false, nil, err
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		} else {
+			err = tx.Commit()
+		}
+	}()
+
+	// Locking the user keeps other clients from adding it to their userbase while deciding whether to erase it
+	_, err = tx.Exec(`SELECT id FROM "user" WHERE id = $1 FOR UPDATE`, userID)
+	if err != nil {
+		return false, nil, err
+	}
+
+	removeQueries := []string{
+		`DELETE FROM userbase WHERE user_id = $1 AND client_id = $2`,
+		`DELETE FROM user_alias WHERE user_id = $1 AND client_id = $2`,
+		`DELETE FROM sharing_preference WHERE user_id = $1 AND client_id = $2`,
+		`DELETE FROM platform_priority WHERE user_id = $1 AND client_id = $2`,
+		`DELETE FROM consent WHERE user_id = $1 AND client_id = $2`,
+	}
+	for _, query := range removeQueries {
+		_, err = tx.Exec(query, userID, clientID)
+		if err != nil {
+			return false, nil, err
+		}
+	}
+
+	var remainingClients int
+	err = tx.QueryRow(`SELECT COUNT(*) FROM userbase WHERE user_id = $1`, userID).Scan(&remainingClients)
+	if err != nil {
+		return false, nil, err
+	}
+
+	if remainingClients > 0 {
+		err = insertUserEvent(tx, userID, clientID, UserEventRemovedFromUserbase, "")
+		if err != nil {
+			return false, nil, err
+		}
+
+		return false, nil, nil
+	}
+
+	accounts, err = deleteUserCredentials(tx, userID)
+	if err != nil {
+		return false, nil, err
+	}
+
+	eraseQueries := []string{
+		`DELETE FROM user_data WHERE user_id = $1`,
+		`DELETE FROM user_event WHERE user_id = $1`,
+		`DELETE FROM user_alias WHERE user_id = $1`,
+		`DELETE FROM user_merge_authorization WHERE source_user_id = $1 OR target_user_id = $1`,
+		`DELETE FROM sharing_preference WHERE user_id = $1`,
+		`DELETE FROM platform_priority WHERE user_id = $1`,
+		`DELETE FROM consent WHERE user_id = $1`,
+		`DELETE FROM "user" WHERE id = $1`,
+	}
+	for _, query := range eraseQueries {
+		_, err = tx.Exec(query, userID)
+		if err != nil {
+			return false, nil, err
+		}
+	}
+
+	return true, accounts, nil
+}
+
+// deleteUserCredentials removes every platform account of the user, returning them along with their tokens
+func deleteUserCredentials(tx *sql.Tx, userID int) ([]ErasedAccount, error) {
+	// Labels are numbered across the user's accounts, so they're read before any account is deleted
+	accounts, err := getErasedAccounts(tx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.Exec(`DELETE FROM credentials WHERE user_id = $1`, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return accounts, nil
+}
+
+func getErasedAccounts(tx *sql.Tx, userID int) ([]ErasedAccount, error) {
+	rows, err := tx.Query(
+		`SELECT c.id, p.name, `+accountLabel+`, c.connection_string FROM credentials c
+				JOIN platform p ON p.id = c.platform_id
+				WHERE c.user_id = $1
+				ORDER BY c.linked_at, c.id`,
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	var accounts []ErasedAccount
+	for rows.Next() {
+		var account ErasedAccount
+		var connectionString string
+		err := rows.Scan(&account.CredentialID, &account.Platform, &account.Label, &connectionString)
+		if err != nil {
+			return nil, err
+		}
+
+		account.Tokens = accountTokens(connectionString)
+		accounts = append(accounts, account)
+	}
+
+	return accounts, nil
+}
+
+// accountTokens returns the tokens stored in the connection string of a deleted account.
+// An account with a broken connection can't be revoked, but it must still be deleted, so the tokens are nil then
+func accountTokens(connectionString string) *oauth2.Token {
+	connection, err := parseConnectionString(connectionString)
+	if err != nil {
+		return nil
+	}
+
+	tokens, err := connectionTokens(connection)
+	if err != nil {
+		return nil
+	}
+
+	return tokens
+}
+
+// GetUserData returns every day of metrics stored for the user, oldest first
+func GetUserData(db *sql.DB, userID int) ([]UserData, error) {
+	rows, err := db.Query(
+		`SELECT p.name, d.date, d.steps, d.calories, d.distance FROM user_data d
+				JOIN platform p ON p.id = d.platform_id
+				WHERE d.user_id = $1
+				ORDER BY d.date, p.name`,
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	var data []UserData
+	for rows.Next() {
+		var day UserData
+		err := rows.Scan(&day.Platform, &day.Date, &day.Steps, &day.Calories, &day.Distance)
+		if err != nil {
+			return nil, err
+		}
+
+		data = append(data, day)
+	}
+
+	return data, nil
+}
+
+// GetUserByPublicID returns the ID of the user that the client knows by the public ID, and the user's current
+// public ID. Public IDs of merged users resolve to the user they were merged into.
+// The user ID is zero if the client has no such user
+func GetUserByPublicID(db *sql.DB, publicID string, clientID int) (int, string, error) {
+	var userID int
+	var currentPublicID string
+	err := db.QueryRow(
+		`SELECT user_id, public_id FROM userbase WHERE public_id = $1 AND client_id = $2
+				UNION ALL
+				SELECT u.user_id, u.public_id FROM user_alias a
+				JOIN userbase u ON u.user_id = a.user_id AND u.client_id = a.client_id
+				WHERE a.public_id = $1 AND a.client_id = $2`,
+		publicID,
+		clientID,
+	).Scan(&userID, &currentPublicID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return 0, "", nil
+		}
+
+		return 0, "", err
+	}
+
+	return userID, currentPublicID, nil
+}
+
+// GetPublicUserID returns the ID that the client knows the user by
+func GetPublicUserID(db *sql.DB, userID int, clientID int) (string, error) {
+	var publicID string
+	err := db.QueryRow(
+		`SELECT public_id FROM userbase WHERE user_id = $1 AND client_id = $2`,
+		userID,
+		clientID,
+	).Scan(&publicID)
+	if err != nil {
+		return "", err
+	}
+
+	return publicID, nil
+}
+
+// GetUserByExternalRef returns the ID of the user that the client has given the external reference to,
+// and the public ID the client knows it by. The user ID is zero if the client has no such user
+func GetUserByExternalRef(db *sql.DB, externalRef string, clientID int) (int, string, error) {
+	var userID int
+	var publicID string
+	err := db.QueryRow(
+		`SELECT user_id, public_id FROM userbase WHERE external_ref = $1 AND client_id = $2`,
+		externalRef,
+		clientID,
+	).Scan(&userID, &publicID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return 0, "", nil
+		}
+
+		return 0, "", err
+	}
+
+	return userID, publicID, nil
+}
+
+// SetUserExternalRef sets the client's own ID for the user. An empty reference removes it
+func SetUserExternalRef(db *sql.DB, userID int, clientID int, externalRef string) error {
+	_, err := db.Exec(
+		`UPDATE userbase SET external_ref = NULLIF($3, '') WHERE user_id = $1 AND client_id = $2`,
+		userID,
+		clientID,
+		externalRef,
+	)
+	return err
+}
+
+// newPublicUserID generates the random ID a client knows a user by. Each client gets a different ID for the
+// same user, so clients can't guess the IDs of other users or link users across clients
+func newPublicUserID() (string, error) {
+	id := make([]byte, 16)
+	if _, err := io.ReadFull(rand.Reader, id); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(id), nil
+}
@@ -0,0 +1,113 @@
+package dal
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+)
+
+// UserClient is a client that has the user in its userbase, as the user sees it in the user portal
+type UserClient struct {
+	ClientID       int
+	Name           string
+	AddedAt        time.Time
+	LastAccessedAt sql.NullTime
+	Resources      []string // Resources the client has read from the user's data
+}
+
+// GetUserClients returns the clients that have the user in their userbase, in the order they got the user
+func GetUserClients(db *sql.DB, userID int) ([]UserClient, error) {
+	// Details of data_accessed events start with the resource that was read
+	rows, err := db.Query(
+		`SELECT c.id, c.name, u.created_at, MAX(e.created_at),
+				COALESCE(string_agg(DISTINCT split_part(e.details, ' ', 1), ',' ORDER BY split_part(e.details, ' ', 1)), '')
+				FROM userbase u
+				JOIN client c ON c.id = u.client_id
+				LEFT JOIN user_event e ON e.user_id = u.user_id AND e.client_id = u.client_id AND e.event = $2
+				WHERE u.user_id = $1
+				GROUP BY c.id, c.name, u.created_at
+				ORDER BY u.created_at, c.id`,
+		userID,
+		UserEventDataAccessed,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	var clients []UserClient
+	for rows.Next() {
+		var client UserClient
+		var resources string
+		err := rows.Scan(&client.ClientID, &client.Name, &client.AddedAt, &client.LastAccessedAt, &resources)
+		if err != nil {
+			return nil, err
+		}
+
+		if resources != "" {
+			client.Resources = strings.Split(resources, ",")
+		}
+
+		clients = append(clients, client)
+	}
+
+	return clients, nil
+}
+
+// RevokeClientAccess removes the user from the client's userbase at the user's request, and lets the client know.
+// It returns false if the client didn't have the user
+func RevokeClientAccess(db *sql.DB, userID int, clientID int) (revoked bool, err error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return false, err
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		} else {
+			err = tx.Commit()
+		}
+	}()
+
+	var publicID string
+	var externalRef string
+	err = tx.QueryRow(
+		`DELETE FROM userbase WHERE user_id = $1 AND client_id = $2 RETURNING public_id, COALESCE(external_ref, '')`,
+		userID,
+		clientID,
+	).Scan(&publicID, &externalRef)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return false, nil
+		}
+
+		return false, err
+	}
+
+	removeQueries := []string{
+		`DELETE FROM user_alias WHERE user_id = $1 AND client_id = $2`,
+		`DELETE FROM sharing_preference WHERE user_id = $1 AND client_id = $2`,
+		`DELETE FROM platform_priority WHERE user_id = $1 AND client_id = $2`,
+		`DELETE FROM consent WHERE user_id = $1 AND client_id = $2`,
+	}
+	for _, query := range removeQueries {
+		_, err = tx.Exec(query, userID, clientID)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	err = insertUserEvent(tx, userID, clientID, UserEventAccessRevoked, "")
+	if err != nil {
+		return false, err
+	}
+
+	err = insertClientEvent(tx, clientID, ClientEventAccessRevoked, publicID, externalRef)
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
@@ -0,0 +1,50 @@
+package dal
+
+import (
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRevokeClientAccess_ShouldNotifyClient(t *testing.T) {
+	userID := 2
+	clientID := 1
+
+	Mock.ExpectBegin()
+	Mock.ExpectQuery(`^DELETE FROM userbase WHERE user_id = \$1 AND client_id = \$2 RETURNING (.+)$`).
+		WithArgs(userID, clientID).
+		WillReturnRows(sqlmock.NewRows([]string{"public_id", "external_ref"}).AddRow("Zq8nM5vJr2Te6Ya0Wk9s1B", "acct-2"))
+	Mock.ExpectExec(`^DELETE FROM user_alias WHERE user_id = \$1 AND client_id = \$2$`).
+		WithArgs(userID, clientID).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	Mock.ExpectExec(`^DELETE FROM sharing_preference WHERE user_id = \$1 AND client_id = \$2$`).
+		WithArgs(userID, clientID).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	Mock.ExpectExec(`^DELETE FROM platform_priority WHERE user_id = \$1 AND client_id = \$2$`).
+		WithArgs(userID, clientID).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	Mock.ExpectExec(`^DELETE FROM consent WHERE user_id = \$1 AND client_id = \$2$`).
+		WithArgs(userID, clientID).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	Mock.ExpectExec(`^INSERT INTO user_event (.+) VALUES (.+)$`).
+		WithArgs(userID, clientID, UserEventAccessRevoked, "").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	Mock.ExpectExec(`^INSERT INTO client_event (.+) VALUES (.+)$`).
+		WithArgs(clientID, ClientEventAccessRevoked, "Zq8nM5vJr2Te6Ya0Wk9s1B", "acct-2").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	Mock.ExpectCommit()
+
+	// Call the func that we are testing
+	revoked, err := RevokeClientAccess(DB, userID, clientID)
+	if err != nil {
+		t.Errorf("error was not expected when revoking client access: %s", err)
+	}
+
+	// We make sure that all expectations were met
+	if err := Mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+
+	assert.True(t, revoked)
+}
@@ -0,0 +1,76 @@
+package dal
+
+import (
+	"database/sql"
+	"time"
+)
+
+// Actions recorded in the audit log of a user
+const (
+	UserEventPlatformUnlinked    = "platform_unlinked"
+	UserEventDataAccessed        = "data_accessed"
+	UserEventDataExported        = "data_exported"
+	UserEventRemovedFromUserbase = "removed_from_userbase"
+	UserEventMerged              = "merged"
+	UserEventAccessRevoked       = "access_revoked"  // The user took the client's access away in the user portal
+	UserEventSharingChanged      = "sharing_changed" // The user changed what the client can read in the user portal. Details list what's withheld
+)
+
+type UserEvent struct {
+	Event     string
+	Details   string
+	CreatedAt time.Time
+}
+
+// InsertUserEvent records an action taken on a user by one of its clients
+func InsertUserEvent(db *sql.DB, userID int, clientID int, event string, details string) error {
+	_, err := db.Exec(
+		`INSERT INTO user_event (user_id, client_id, event, details) VALUES ($1, $2, $3, $4)`,
+		userID,
+		clientID,
+		event,
+		details,
+	)
+	return err
+}
+
+// GetUserEvents returns the actions a client took on a user, oldest first
+func GetUserEvents(db *sql.DB, userID int, clientID int) ([]UserEvent, error) {
+	rows, err := db.Query(
+		`SELECT event, COALESCE(details, ''), created_at FROM user_event
+				WHERE user_id = $1 AND client_id = $2
+				ORDER BY created_at, id`,
+		userID,
+		clientID,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	var events []UserEvent
+	for rows.Next() {
+		var event UserEvent
+		err := rows.Scan(&event.Event, &event.Details, &event.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		events = append(events, event)
+	}
+
+	return events, nil
+}
+
+// insertUserEvent records an action taken on a user, by one of its clients, inside an existing transaction
+func insertUserEvent(tx *sql.Tx, userID int, clientID int, event string, details string) error {
+	_, err := tx.Exec(
+		`INSERT INTO user_event (user_id, client_id, event, details) VALUES ($1, $2, $3, $4)`,
+		userID,
+		clientID,
+		event,
+		details,
+	)
+	return err
+}
@@ -0,0 +1,224 @@
+package dal
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+var ErrUsersShareAccount = errors.New("both users have linked the same platform account")
+var ErrMergeNotAuthorized = errors.New("user hasn't logged in to both users to authorize the merge")
+
+// mergedExternalRef is an external reference that has to move to the user that survives a merge
+type mergedExternalRef struct {
+	clientID    int
+	externalRef string
+}
+
+// InsertMergeAuthorization records that the user logged in with an account of authorizedID, to authorize
+// the client to merge sourceID into targetID. Logging in again renews the authorization
+func InsertMergeAuthorization(db *sql.DB, clientID int, sourceID int, targetID int, authorizedID int, expiresAt time.Time) error {
+	_, err := db.Exec(
+		`INSERT INTO user_merge_authorization (client_id, source_user_id, target_user_id, authorized_user_id, expires_at)
+				VALUES ($1, $2, $3, $4, $5)
+				ON CONFLICT (client_id, source_user_id, target_user_id, authorized_user_id)
+				DO UPDATE SET expires_at = EXCLUDED.expires_at`,
+		clientID,
+		sourceID,
+		targetID,
+		authorizedID,
+		expiresAt,
+	)
+	return err
+}
+
+// MergeUsers moves everything mrthn knows about the source user into the target user, then deletes the source user.
+// Clients that knew the source user by a public ID can keep using it, since it becomes an alias of the target user.
+// Every client that has either user ends up with the target user, so the user must have logged in to both of them
+// to authorize the merge first. It returns ErrMergeNotAuthorized otherwise. The authorizations can only be used once.
+// It returns ErrUsersShareAccount if both users have linked the same account of a platform
+func MergeUsers(db *sql.DB, sourceID int, targetID int, clientID int) (err error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		} else {
+			err = tx.Commit()
+		}
+	}()
+
+	// Locking both users keeps other clients from adding them to their userbase while they're merged
+	_, err = tx.Exec(`SELECT id FROM "user" WHERE id IN ($1, $2) FOR UPDATE`, sourceID, targetID)
+	if err != nil {
+		return err
+	}
+
+	// The authorizations are consumed as they're checked. If the merge fails, rolling back restores them
+	var authorizedUsers int
+	err = tx.QueryRow(
+		`WITH used AS (
+					DELETE FROM user_merge_authorization
+					WHERE client_id = $1 AND source_user_id = $2 AND target_user_id = $3 AND expires_at > now()
+					RETURNING authorized_user_id
+				)
+				SELECT COUNT(DISTINCT authorized_user_id) FROM used`,
+		clientID,
+		sourceID,
+		targetID,
+	).Scan(&authorizedUsers)
+	if err != nil {
+		return err
+	}
+
+	if authorizedUsers < 2 {
+		return ErrMergeNotAuthorized
+	}
+
+	// Users can have several accounts of a platform, so only the same account linked twice is a conflict
+	var sharedAccounts int
+	err = tx.QueryRow(
+		`SELECT COUNT(*) FROM credentials s
+				JOIN credentials t ON t.platform_id = s.platform_id AND t.upid = s.upid
+				WHERE s.user_id = $1 AND t.user_id = $2`,
+		sourceID,
+		targetID,
+	).Scan(&sharedAccounts)
+	if err != nil {
+		return err
+	}
+
+	if sharedAccounts > 0 {
+		return ErrUsersShareAccount
+	}
+
+	var sourcePublicID string
+	err = tx.QueryRow(
+		`SELECT public_id FROM userbase WHERE user_id = $1 AND client_id = $2`,
+		sourceID,
+		clientID,
+	).Scan(&sourcePublicID)
+	if err != nil {
+		return err
+	}
+
+	err = mergeUserbases(tx, sourceID, targetID)
+	if err != nil {
+		return err
+	}
+
+	moveQueries := []string{
+		// Anything either user withheld from a client stays withheld
+		`INSERT INTO sharing_preference (user_id, client_id, withheld_type, withheld_name)
+				SELECT $2, client_id, withheld_type, withheld_name FROM sharing_preference WHERE user_id = $1
+				ON CONFLICT DO NOTHING`,
+		// Priorities the target user already has for a resource win over the source user's
+		`INSERT INTO platform_priority (user_id, client_id, resource, platform, rank, excluded)
+				SELECT $2, s.client_id, s.resource, s.platform, s.rank, s.excluded FROM platform_priority s
+				WHERE s.user_id = $1 AND NOT EXISTS (
+					SELECT 1 FROM platform_priority t
+					WHERE t.user_id = $2 AND t.client_id = s.client_id AND t.resource = s.resource
+				)`,
+		`UPDATE credentials SET user_id = $2 WHERE user_id = $1`,
+		`UPDATE user_data SET user_id = $2 WHERE user_id = $1`,
+		`UPDATE user_event SET user_id = $2 WHERE user_id = $1`,
+		`UPDATE consent SET user_id = $2 WHERE user_id = $1`,
+	}
+	for _, query := range moveQueries {
+		_, err = tx.Exec(query, sourceID, targetID)
+		if err != nil {
+			return err
+		}
+	}
+
+	deleteQueries := []string{
+		`DELETE FROM user_merge_authorization WHERE source_user_id = $1 OR target_user_id = $1`,
+		`DELETE FROM sharing_preference WHERE user_id = $1`,
+		`DELETE FROM platform_priority WHERE user_id = $1`,
+		`DELETE FROM "user" WHERE id = $1`,
+	}
+	for _, query := range deleteQueries {
+		_, err = tx.Exec(query, sourceID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return insertUserEvent(tx, targetID, clientID, UserEventMerged, sourcePublicID)
+}
+
+// mergeUserbases moves the source user's userbase memberships to the target user. Clients that already have
+// the target user keep its public ID, and the source's public ID is kept as an alias of it
+func mergeUserbases(tx *sql.Tx, sourceID int, targetID int) error {
+	// Aliases left by earlier merges into the source user now point to the target user
+	_, err := tx.Exec(`UPDATE user_alias SET user_id = $2 WHERE user_id = $1`, sourceID, targetID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(
+		`INSERT INTO user_alias (public_id, client_id, user_id)
+				SELECT s.public_id, s.client_id, $2 FROM userbase s
+				JOIN userbase t ON t.client_id = s.client_id AND t.user_id = $2
+				WHERE s.user_id = $1`,
+		sourceID,
+		targetID,
+	)
+	if err != nil {
+		return err
+	}
+
+	// External references are unique per client, so they can only move once the source's memberships are gone
+	rows, err := tx.Query(
+		`SELECT s.client_id, s.external_ref FROM userbase s
+				JOIN userbase t ON t.client_id = s.client_id AND t.user_id = $2
+				WHERE s.user_id = $1 AND s.external_ref IS NOT NULL AND t.external_ref IS NULL`,
+		sourceID,
+		targetID,
+	)
+	if err != nil {
+		return err
+	}
+
+	var externalRefs []mergedExternalRef
+	for rows.Next() {
+		var ref mergedExternalRef
+		err = rows.Scan(&ref.clientID, &ref.externalRef)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+
+		externalRefs = append(externalRefs, ref)
+	}
+	rows.Close()
+
+	_, err = tx.Exec(
+		`DELETE FROM userbase s USING userbase t
+				WHERE s.user_id = $1 AND t.user_id = $2 AND t.client_id = s.client_id`,
+		sourceID,
+		targetID,
+	)
+	if err != nil {
+		return err
+	}
+
+	for _, ref := range externalRefs {
+		_, err = tx.Exec(
+			`UPDATE userbase SET external_ref = $3 WHERE user_id = $1 AND client_id = $2`,
+			targetID,
+			ref.clientID,
+			ref.externalRef,
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Clients that only had the source user keep knowing it by the same public ID
+	_, err = tx.Exec(`UPDATE userbase SET user_id = $2 WHERE user_id = $1`, sourceID, targetID)
+	return err
+}
@@ -0,0 +1,145 @@
+package dal
+
+import (
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMergeUsers_ShouldMoveEverythingToTarget(t *testing.T) {
+	sourceID := 3
+	targetID := 2
+	clientID := 1
+
+	Mock.ExpectBegin()
+	Mock.ExpectExec(`^SELECT id FROM "user" WHERE id IN \(\$1, \$2\) FOR UPDATE$`).
+		WithArgs(sourceID, targetID).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	Mock.ExpectQuery(`^WITH used AS \( DELETE FROM user_merge_authorization WHERE client_id = \$1 AND source_user_id = \$2 AND target_user_id = \$3 (.+) SELECT COUNT\(DISTINCT authorized_user_id\) FROM used$`).
+		WithArgs(clientID, sourceID, targetID).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(2))
+	Mock.ExpectQuery(`^SELECT COUNT\(\*\) FROM credentials s JOIN credentials t ON t.platform_id = s.platform_id AND t.upid = s.upid WHERE s.user_id = \$1 AND t.user_id = \$2$`).
+		WithArgs(sourceID, targetID).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	Mock.ExpectQuery(`^SELECT public_id FROM userbase WHERE user_id = \$1 AND client_id = \$2$`).
+		WithArgs(sourceID, clientID).
+		WillReturnRows(sqlmock.NewRows([]string{"public_id"}).AddRow("Zq8nM5vJr2Te6Ya0Wk9s1B"))
+	Mock.ExpectExec(`^UPDATE user_alias SET user_id = \$2 WHERE user_id = \$1$`).
+		WithArgs(sourceID, targetID).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	Mock.ExpectExec(`^INSERT INTO user_alias \(public_id, client_id, user_id\) SELECT (.+) WHERE s.user_id = \$1$`).
+		WithArgs(sourceID, targetID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	Mock.ExpectQuery(`^SELECT s.client_id, s.external_ref FROM userbase s (.+)$`).
+		WithArgs(sourceID, targetID).
+		WillReturnRows(sqlmock.NewRows([]string{"client_id", "external_ref"}).AddRow(clientID, "acct-3"))
+	Mock.ExpectExec(`^DELETE FROM userbase s USING userbase t (.+)$`).
+		WithArgs(sourceID, targetID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	Mock.ExpectExec(`^UPDATE userbase SET external_ref = \$3 WHERE user_id = \$1 AND client_id = \$2$`).
+		WithArgs(targetID, clientID, "acct-3").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	// Another client only had the source user, and keeps it
+	Mock.ExpectExec(`^UPDATE userbase SET user_id = \$2 WHERE user_id = \$1$`).
+		WithArgs(sourceID, targetID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	Mock.ExpectExec(`^INSERT INTO sharing_preference (.+) SELECT (.+) WHERE user_id = \$1 ON CONFLICT DO NOTHING$`).
+		WithArgs(sourceID, targetID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	Mock.ExpectExec(`^INSERT INTO platform_priority (.+) SELECT (.+) WHERE s.user_id = \$1 AND NOT EXISTS (.+)$`).
+		WithArgs(sourceID, targetID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	Mock.ExpectExec(`^UPDATE credentials SET user_id = \$2 WHERE user_id = \$1$`).
+		WithArgs(sourceID, targetID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	Mock.ExpectExec(`^UPDATE user_data SET user_id = \$2 WHERE user_id = \$1$`).
+		WithArgs(sourceID, targetID).
+		WillReturnResult(sqlmock.NewResult(0, 30))
+	Mock.ExpectExec(`^UPDATE user_event SET user_id = \$2 WHERE user_id = \$1$`).
+		WithArgs(sourceID, targetID).
+		WillReturnResult(sqlmock.NewResult(0, 4))
+	Mock.ExpectExec(`^UPDATE consent SET user_id = \$2 WHERE user_id = \$1$`).
+		WithArgs(sourceID, targetID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	Mock.ExpectExec(`^DELETE FROM user_merge_authorization WHERE source_user_id = \$1 OR target_user_id = \$1$`).
+		WithArgs(sourceID).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	Mock.ExpectExec(`^DELETE FROM sharing_preference WHERE user_id = \$1$`).
+		WithArgs(sourceID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	Mock.ExpectExec(`^DELETE FROM platform_priority WHERE user_id = \$1$`).
+		WithArgs(sourceID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	Mock.ExpectExec(`^DELETE FROM "user" WHERE id = \$1$`).
+		WithArgs(sourceID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	Mock.ExpectExec(`^INSERT INTO user_event (.+) VALUES (.+)$`).
+		WithArgs(targetID, clientID, UserEventMerged, "Zq8nM5vJr2Te6Ya0Wk9s1B").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	Mock.ExpectCommit()
+
+	// Call the func that we are testing
+	err := MergeUsers(DB, sourceID, targetID, clientID)
+	if err != nil {
+		t.Errorf("error was not expected when merging users: %s", err)
+	}
+
+	// We make sure that all expectations were met
+	if err := Mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestMergeUsers_ShouldRefuseUsersWithSameAccount(t *testing.T) {
+	sourceID := 3
+	targetID := 2
+	clientID := 1
+
+	Mock.ExpectBegin()
+	Mock.ExpectExec(`^SELECT id FROM "user" WHERE id IN \(\$1, \$2\) FOR UPDATE$`).
+		WithArgs(sourceID, targetID).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	Mock.ExpectQuery(`^WITH used AS \( DELETE FROM user_merge_authorization WHERE client_id = \$1 AND source_user_id = \$2 AND target_user_id = \$3 (.+) SELECT COUNT\(DISTINCT authorized_user_id\) FROM used$`).
+		WithArgs(clientID, sourceID, targetID).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(2))
+	Mock.ExpectQuery(`^SELECT COUNT\(\*\) FROM credentials s (.+)$`).
+		WithArgs(sourceID, targetID).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	Mock.ExpectRollback()
+
+	// Call the func that we are testing
+	err := MergeUsers(DB, sourceID, targetID, clientID)
+
+	// We make sure that all expectations were met
+	if err := Mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+
+	assert.Equal(t, ErrUsersShareAccount, err)
+}
+
+func TestMergeUsers_ShouldRefuseWithoutAuthorizationOfBothUsers(t *testing.T) {
+	sourceID := 3
+	targetID := 2
+	clientID := 1
+
+	Mock.ExpectBegin()
+	Mock.ExpectExec(`^SELECT id FROM "user" WHERE id IN \(\$1, \$2\) FOR UPDATE$`).
+		WithArgs(sourceID, targetID).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	Mock.ExpectQuery(`^WITH used AS \( DELETE FROM user_merge_authorization WHERE client_id = \$1 AND source_user_id = \$2 AND target_user_id = \$3 (.+) SELECT COUNT\(DISTINCT authorized_user_id\) FROM used$`).
+		WithArgs(clientID, sourceID, targetID).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	Mock.ExpectRollback()
+
+	// Call the func that we are testing
+	err := MergeUsers(DB, sourceID, targetID, clientID)
+
+	// We make sure that all expectations were met
+	if err := Mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+
+	assert.Equal(t, ErrMergeNotAuthorized, err)
+}
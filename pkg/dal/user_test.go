@@ -0,0 +1,260 @@
+package dal
+
+import (
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetUserbase_ShouldReturnPageOfUsers(t *testing.T) {
+	clientID := 1
+	createdAt := time.Date(2020, 3, 1, 10, 0, 0, 0, time.UTC)
+
+	Mock.ExpectQuery(`^SELECT COUNT\(\*\) FROM userbase WHERE client_id = \$1 AND (.+)$`).
+		WithArgs(clientID, "").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(3))
+
+	rows := sqlmock.NewRows([]string{"user_id", "public_id", "external_ref", "created_at", "platforms", "withheld_platforms"}).
+		AddRow(2, "kX2bW9tQ0uYp7Lr4Hc1d3A", "acct-2", createdAt, "google", "fitbit").
+		AddRow(3, "Zq8nM5vJr2Te6Ya0Wk9s1B", "", createdAt, "", "")
+	Mock.ExpectQuery(`^SELECT u.user_id, u.public_id, (.+) FROM userbase u (.+) LIMIT \$3 OFFSET \$4$`).
+		WithArgs(clientID, "", 2, 1, WithheldPlatform).
+		WillReturnRows(rows)
+
+	// Call the func that we are testing
+	users, total, err := GetUserbase(DB, clientID, "", 2, 1)
+	if err != nil {
+		t.Errorf("error was not expected when getting userbase: %s", err)
+	}
+
+	// We make sure that all expectations were met
+	if err := Mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+
+	assert.Equal(t, 3, total)
+	assert.Equal(t, []UserbaseEntry{
+		{UserID: 2, PublicID: "kX2bW9tQ0uYp7Lr4Hc1d3A", ExternalRef: "acct-2", CreatedAt: createdAt,
+			Platforms: []string{"google"}, WithheldPlatforms: []string{"fitbit"}},
+		{UserID: 3, PublicID: "Zq8nM5vJr2Te6Ya0Wk9s1B", CreatedAt: createdAt},
+	}, users)
+}
+
+func TestMarkCredentialsRefreshFailed_ShouldStoreReason(t *testing.T) {
+	credentialID := 2
+	reason := "failed to refresh token: invalid_grant"
+
+	Mock.ExpectExec(`^UPDATE credentials SET refresh_failed_at = now\(\), refresh_error = \$2 WHERE id = \$1$`).
+		WithArgs(credentialID, reason).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	// Call the func that we are testing
+	err := MarkCredentialsRefreshFailed(DB, credentialID, reason)
+	if err != nil {
+		t.Errorf("error was not expected when marking failed refresh: %s", err)
+	}
+
+	// We make sure that all expectations were met
+	if err := Mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestDeleteUserAccount_ShouldDeleteCredentialsAndData(t *testing.T) {
+	userID := 3
+	clientID := 1
+	account := Account{CredentialID: 7, Platform: "google", Label: "work"}
+
+	Mock.ExpectBegin()
+	Mock.ExpectExec(`^SELECT id FROM "user" WHERE id = \$1 FOR UPDATE$`).WithArgs(userID).WillReturnResult(sqlmock.NewResult(0, 1))
+	Mock.ExpectQuery(`^SELECT COUNT\(\*\) FROM userbase WHERE user_id = \$1 AND client_id <> \$2$`).
+		WithArgs(userID, clientID).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	Mock.ExpectQuery(`^DELETE FROM credentials WHERE id = \$1 AND user_id = \$2 RETURNING platform_id, connection_string$`).
+		WithArgs(account.CredentialID, userID).
+		WillReturnRows(sqlmock.NewRows([]string{"platform_id", "connection_string"}).
+			AddRow(2, "oauth2;Bearer;2020-03-23T04:20:00-0400;access_token;refresh_token;"))
+	Mock.ExpectExec(`^DELETE FROM user_data WHERE user_id = \$1 AND platform_id = \$2 AND NOT EXISTS (.+)$`).
+		WithArgs(userID, 2).
+		WillReturnResult(sqlmock.NewResult(0, 12))
+	Mock.ExpectExec(`^INSERT INTO user_event \(user_id, client_id, event, details\) VALUES \(\$1, \$2, \$3, \$4\)$`).
+		WithArgs(userID, clientID, UserEventPlatformUnlinked, "google (work)").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	Mock.ExpectCommit()
+
+	// Call the func that we are testing
+	erased, deleted, err := DeleteUserAccount(DB, userID, clientID, account)
+	if err != nil {
+		t.Errorf("error was not expected when deleting user account: %s", err)
+	}
+
+	// We make sure that all expectations were met
+	if err := Mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+
+	assert.True(t, deleted)
+	assert.Equal(t, account, erased.Account)
+	assert.Equal(t, "access_token", erased.Tokens.AccessToken)
+}
+
+func TestDeleteUserAccount_UserWithOtherClientsShouldFail(t *testing.T) {
+	userID := 3
+	clientID := 1
+	account := Account{CredentialID: 7, Platform: "google", Label: "work"}
+
+	Mock.ExpectBegin()
+	Mock.ExpectExec(`^SELECT id FROM "user" WHERE id = \$1 FOR UPDATE$`).WithArgs(userID).WillReturnResult(sqlmock.NewResult(0, 1))
+	Mock.ExpectQuery(`^SELECT COUNT\(\*\) FROM userbase WHERE user_id = \$1 AND client_id <> \$2$`).
+		WithArgs(userID, clientID).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	Mock.ExpectRollback()
+
+	// Call the func that we are testing
+	_, deleted, err := DeleteUserAccount(DB, userID, clientID, account)
+
+	// We make sure that all expectations were met
+	if err := Mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+
+	assert.Equal(t, ErrUserHasOtherClients, err)
+	assert.False(t, deleted)
+}
+
+func TestDeleteUser_ShouldEraseUserWithoutOtherClients(t *testing.T) {
+	userID := 4
+	clientID := 1
+
+	Mock.ExpectBegin()
+	Mock.ExpectExec(`^SELECT id FROM "user" WHERE id = \$1 FOR UPDATE$`).WithArgs(userID).WillReturnResult(sqlmock.NewResult(0, 1))
+	Mock.ExpectExec(`^DELETE FROM userbase WHERE user_id = \$1 AND client_id = \$2$`).
+		WithArgs(userID, clientID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	Mock.ExpectExec(`^DELETE FROM user_alias WHERE user_id = \$1 AND client_id = \$2$`).
+		WithArgs(userID, clientID).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	Mock.ExpectExec(`^DELETE FROM sharing_preference WHERE user_id = \$1 AND client_id = \$2$`).
+		WithArgs(userID, clientID).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	Mock.ExpectExec(`^DELETE FROM platform_priority WHERE user_id = \$1 AND client_id = \$2$`).
+		WithArgs(userID, clientID).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	Mock.ExpectExec(`^DELETE FROM consent WHERE user_id = \$1 AND client_id = \$2$`).
+		WithArgs(userID, clientID).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	Mock.ExpectQuery(`^SELECT COUNT\(\*\) FROM userbase WHERE user_id = \$1$`).
+		WithArgs(userID).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	Mock.ExpectQuery(`^SELECT c.id, p.name, COALESCE\(c.label, (.+)\), c.connection_string FROM credentials c (.+) WHERE c.user_id = \$1 (.+)$`).
+		WithArgs(userID).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "label", "connection_string"}).
+			AddRow(7, "fitbit", "Work watch", "oauth2;Bearer;2020-03-23T04:20:00-0400;abc;def;").
+			AddRow(8, "strava", "strava-1", "broken"))
+	Mock.ExpectExec(`^DELETE FROM credentials WHERE user_id = \$1$`).WithArgs(userID).WillReturnResult(sqlmock.NewResult(0, 2))
+	Mock.ExpectExec(`^DELETE FROM user_data WHERE user_id = \$1$`).WithArgs(userID).WillReturnResult(sqlmock.NewResult(0, 30))
+	Mock.ExpectExec(`^DELETE FROM user_event WHERE user_id = \$1$`).WithArgs(userID).WillReturnResult(sqlmock.NewResult(0, 5))
+	Mock.ExpectExec(`^DELETE FROM user_alias WHERE user_id = \$1$`).WithArgs(userID).WillReturnResult(sqlmock.NewResult(0, 0))
+	Mock.ExpectExec(`^DELETE FROM user_merge_authorization WHERE source_user_id = \$1 OR target_user_id = \$1$`).WithArgs(userID).WillReturnResult(sqlmock.NewResult(0, 0))
+	Mock.ExpectExec(`^DELETE FROM sharing_preference WHERE user_id = \$1$`).WithArgs(userID).WillReturnResult(sqlmock.NewResult(0, 0))
+	Mock.ExpectExec(`^DELETE FROM platform_priority WHERE user_id = \$1$`).WithArgs(userID).WillReturnResult(sqlmock.NewResult(0, 0))
+	Mock.ExpectExec(`^DELETE FROM consent WHERE user_id = \$1$`).WithArgs(userID).WillReturnResult(sqlmock.NewResult(0, 1))
+	Mock.ExpectExec(`^DELETE FROM "user" WHERE id = \$1$`).WithArgs(userID).WillReturnResult(sqlmock.NewResult(0, 1))
+	Mock.ExpectCommit()
+
+	// Call the func that we are testing
+	erased, accounts, err := DeleteUser(DB, userID, clientID)
+	if err != nil {
+		t.Errorf("error was not expected when deleting user: %s", err)
+	}
+
+	// We make sure that all expectations were met
+	if err := Mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+
+	assert.True(t, erased)
+	if assert.Len(t, accounts, 2) {
+		assert.Equal(t, Account{CredentialID: 7, Platform: "fitbit", Label: "Work watch"}, accounts[0].Account)
+		assert.Equal(t, "def", accounts[0].Tokens.RefreshToken)
+		assert.Nil(t, accounts[1].Tokens)
+	}
+}
+
+func TestDeleteUser_ShouldKeepUserOfOtherClients(t *testing.T) {
+	userID := 4
+	clientID := 1
+
+	Mock.ExpectBegin()
+	Mock.ExpectExec(`^SELECT id FROM "user" WHERE id = \$1 FOR UPDATE$`).WithArgs(userID).WillReturnResult(sqlmock.NewResult(0, 1))
+	Mock.ExpectExec(`^DELETE FROM userbase WHERE user_id = \$1 AND client_id = \$2$`).
+		WithArgs(userID, clientID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	Mock.ExpectExec(`^DELETE FROM user_alias WHERE user_id = \$1 AND client_id = \$2$`).
+		WithArgs(userID, clientID).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	Mock.ExpectExec(`^DELETE FROM sharing_preference WHERE user_id = \$1 AND client_id = \$2$`).
+		WithArgs(userID, clientID).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	Mock.ExpectExec(`^DELETE FROM platform_priority WHERE user_id = \$1 AND client_id = \$2$`).
+		WithArgs(userID, clientID).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	Mock.ExpectExec(`^DELETE FROM consent WHERE user_id = \$1 AND client_id = \$2$`).
+		WithArgs(userID, clientID).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	Mock.ExpectQuery(`^SELECT COUNT\(\*\) FROM userbase WHERE user_id = \$1$`).
+		WithArgs(userID).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	Mock.ExpectExec(`^INSERT INTO user_event (.+) VALUES (.+)$`).
+		WithArgs(userID, clientID, UserEventRemovedFromUserbase, "").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	Mock.ExpectCommit()
+
+	// Call the func that we are testing
+	erased, accounts, err := DeleteUser(DB, userID, clientID)
+	if err != nil {
+		t.Errorf("error was not expected when deleting user: %s", err)
+	}
+
+	// We make sure that all expectations were met
+	if err := Mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+
+	assert.False(t, erased)
+	assert.Empty(t, accounts)
+}
+
+func TestGetUserByExternalRef_ShouldReturnZeroForUnknownRefs(t *testing.T) {
+	clientID := 1
+
+	Mock.ExpectQuery(`^SELECT user_id, public_id FROM userbase WHERE external_ref = \$1 AND client_id = \$2$`).
+		WithArgs("acct-2", clientID).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id", "public_id"}).AddRow(2, "kX2bW9tQ0uYp7Lr4Hc1d3A"))
+
+	Mock.ExpectQuery(`^SELECT user_id, public_id FROM userbase WHERE external_ref = \$1 AND client_id = \$2$`).
+		WithArgs("acct-9", clientID).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id", "public_id"}))
+
+	// Call the func that we are testing
+	userID, publicID, err := GetUserByExternalRef(DB, "acct-2", clientID)
+	if err != nil {
+		t.Errorf("error was not expected when getting user by external ref: %s", err)
+	}
+
+	unknownUserID, _, err := GetUserByExternalRef(DB, "acct-9", clientID)
+	if err != nil {
+		t.Errorf("error was not expected when getting user by external ref: %s", err)
+	}
+
+	// We make sure that all expectations were met
+	if err := Mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+
+	assert.Equal(t, 2, userID)
+	assert.Equal(t, "kX2bW9tQ0uYp7Lr4Hc1d3A", publicID)
+	assert.Equal(t, 0, unknownUserID)
+}
@@ -11,6 +11,7 @@ import (
 
 // MrthnConfig is the overall structure that will contain our environment configs for the mrthn service
 type MrthnConfig struct {
+	Environment        string // The mode mrthn is running in, such as 'development' or 'production'
 	Server             serverConfig
 	DBConnectionString string
 	Fitbit             platformConfig
@@ -19,8 +20,12 @@ type MrthnConfig struct {
 	Callback           string        // This will be the callback for all services. If we need multiple, this may need to change
 	ClientTimeout      time.Duration // The timeout for the client that is used to make requests for mrthn
 	MrthnWebsiteURL    string        // We will only accept client SignUp requests if it comes from the mrthn website
+	SessionSecret      []byte        // Used to sign the session cookies of the mrthn website
+	Mail               mailConfig    // Used to email password reset tokens to clients. Optional
 }
 
+const minSessionSecretLength = 32
+
 // Server config options
 type serverConfig struct {
 	Port         string
@@ -29,6 +34,15 @@ type serverConfig struct {
 	IdleTimeout  time.Duration
 }
 
+// SMTP server used to send emails. Host is empty when mrthn can't send emails
+type mailConfig struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	From     string
+}
+
 // Config struct specifically for Fitbit client ids, secrets, etc
 type platformConfig struct {
 	ClientID     string
@@ -38,7 +52,7 @@ type platformConfig struct {
 // ReadEnvFile takes the environment variables, and puts them all into an EnvironmentConfig struct
 func ReadEnvFile(env string) (*MrthnConfig, error) {
 	// Create the Environment Config struct we will return to the user
-	setConfig := MrthnConfig{}
+	setConfig := MrthnConfig{Environment: env}
 
 	if env == "development" {
 		// Set environment vars using .env file
@@ -62,6 +76,21 @@ func ReadEnvFile(env string) (*MrthnConfig, error) {
 	}
 	setConfig.MrthnWebsiteURL = mrthnURL
 
+	// Get the secret used to sign sessions
+	sessionSecret := os.Getenv("SESSION_SECRET")
+	if len(sessionSecret) < minSessionSecretLength {
+		return nil, errors.New("environment variable SESSION_SECRET must have at least " +
+			strconv.Itoa(minSessionSecretLength) + " characters")
+	}
+	setConfig.SessionSecret = []byte(sessionSecret)
+
+	// Get the SMTP server, if there is one
+	mail, err := addMailConfig()
+	if err != nil {
+		return nil, err
+	}
+	setConfig.Mail = mail
+
 	// Get the client timeout
 	clientTimeout, err := strconv.Atoi(os.Getenv("CLIENT_TIMEOUT"))
 	if err != nil {
@@ -153,3 +182,27 @@ func addPlatformConfig(service string) (platformConfig, error) {
 
 	return newService, nil
 }
+
+func addMailConfig() (mailConfig, error) {
+	mail := mailConfig{
+		Host:     os.Getenv("SMTP_HOST"),
+		Port:     os.Getenv("SMTP_PORT"),
+		Username: os.Getenv("SMTP_USERNAME"),
+		Password: os.Getenv("SMTP_PASSWORD"),
+		From:     os.Getenv("MAIL_FROM"),
+	}
+
+	if mail.Host == "" {
+		return mailConfig{}, nil
+	}
+
+	if mail.Port == "" {
+		return mailConfig{}, errors.New("environment variable SMTP_PORT is not set")
+	}
+
+	if mail.From == "" {
+		return mailConfig{}, errors.New("environment variable MAIL_FROM is not set")
+	}
+
+	return mail, nil
+}
@@ -0,0 +1,37 @@
+package helpers
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+)
+
+// ValidateRedirectURI checks that uri can be used to send users back to a client.
+// It must be an absolute URL without a fragment. Unless allowHTTP is set, it must also use https
+func ValidateRedirectURI(uri string, allowHTTP bool) error {
+	if strings.Contains(uri, "#") {
+		return errors.New("redirect URI must not contain a fragment")
+	}
+
+	parsed, err := url.Parse(uri)
+	if err != nil {
+		return errors.New("redirect URI is not a valid URL")
+	}
+
+	if !parsed.IsAbs() || parsed.Host == "" {
+		return errors.New("redirect URI must be an absolute URL")
+	}
+
+	switch parsed.Scheme {
+	case "https":
+		return nil
+	case "http":
+		if allowHTTP {
+			return nil
+		}
+
+		return errors.New("redirect URI must use https")
+	default:
+		return errors.New("redirect URI must use https")
+	}
+}
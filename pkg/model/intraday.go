@@ -0,0 +1,89 @@
+package model
+
+import (
+	"errors"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/msgurgel/mrthn/pkg/helpers"
+	"github.com/msgurgel/mrthn/pkg/platform"
+)
+
+// intradayResources maps the resources that have intraday values to the platform's names for them
+var intradayResources = map[string]string{
+	ResourceSteps:     platform.IntradaySteps,
+	ResourceCalories:  platform.IntradayCalories,
+	ResourceHeartRate: platform.IntradayHeartRate,
+}
+
+// GetUserIntraday splits the resource of the date in intervals. The value of each account is the total of the day
+// for steps and calories, and the average of the intervals for heart rate
+func GetUserIntraday(params GetValueParams, resource string, interval time.Duration) ([]ValueResult, error) {
+	platformResource, ok := intradayResources[resource]
+	if !ok {
+		return nil, errors.New("intraday values are not available for " + resource)
+	}
+
+	accounts, err := getAccounts(params.DB, params.UserID, params.Log)
+	if err != nil {
+		return nil, err
+	}
+
+	// Request the samples from each platform that has them
+	var intradayValues []ValueResult
+	unsupported := 0
+	for _, account := range accounts {
+		if !params.Sharing.Shares(resource, account.Platform) {
+			intradayValues = append(intradayValues, withheldResult(account))
+			continue
+		}
+
+		p := platform.Platforms[account.Platform]
+		result, err := p.GetIntraday(account.CredentialID, platformResource, params.Date, interval)
+		if err == platform.ErrResourceUnsupported {
+			unsupported++
+			continue
+		}
+		if err != nil {
+			params.Log.WithFields(logrus.Fields{
+				"err":      err,
+				"userID":   params.UserID,
+				"date":     params.Date.Format(helpers.ISOLayout),
+				"resource": resource,
+				"plat":     p.Name(),
+				"acct":     account.Label,
+			}).Error("failed to call GetIntraday for platform")
+			continue // Try the next platform
+		}
+
+		intradayVal := ValueResult{
+			Platform: p.Name(),
+			Account:  account.Label,
+			Intraday: make([]IntradayValue, 0, len(result)),
+		}
+		for _, sample := range result {
+			intradayVal.Value += sample.Value
+			intradayVal.Intraday = append(intradayVal.Intraday, IntradayValue{
+				Time:  sample.Time.Format(helpers.ISO8601Layout),
+				Value: sample.Value,
+			})
+		}
+
+		if resource == ResourceHeartRate && len(result) > 0 {
+			intradayVal.Value /= float64(len(result))
+		}
+
+		intradayValues = append(intradayValues, intradayVal)
+	}
+
+	if len(accounts) > 0 && unsupported == len(accounts) {
+		return nil, errors.New("none of the user's platforms have intraday " + resource)
+	}
+
+	if !hasValues(intradayValues, len(accounts)-unsupported) {
+		return nil, errors.New("could not connect to any platforms, try again later")
+	}
+
+	return reconcile(intradayValues, params, resource), nil
+}
@@ -0,0 +1,296 @@
+package model
+
+import (
+	"sort"
+	"time"
+
+	"github.com/msgurgel/mrthn/pkg/helpers"
+)
+
+// Strategies to reconcile the values of a user's platform accounts. All keeps every value, max, min and priority
+// pick the value of one account, and mean, median and sum work out a new value out of every account's
+const (
+	StrategyAll      = "all"
+	StrategyMax      = "max"
+	StrategyMin      = "min"
+	StrategyMean     = "mean"
+	StrategyMedian   = "median"
+	StrategyPriority = "priority"
+	StrategySum      = "sum"
+)
+
+var Strategies = []string{
+	StrategyAll, StrategyMax, StrategyMin, StrategyMean, StrategyMedian, StrategyPriority, StrategySum,
+}
+
+// ValueSource is the value one platform account contributed to a reconciled result
+type ValueSource struct {
+	Platform   string  `json:"platform"`
+	Account    string  `json:"account,omitempty"`
+	Value      float64 `json:"value"`
+	MeasuredAt string  `json:"measuredAt,omitempty"`
+}
+
+// reconcile combines the values of the user's accounts with the strategy the client asked for. When it didn't ask
+// for one, the priority it stored for the user's resource is used. Withheld accounts are still reported, so the client knows they exist
+func reconcile(resultValues []ValueResult, params GetValueParams, resource string) []ValueResult {
+	strategy := params.Strategy
+	priority := params.Priority
+	if strategy == "" {
+		stored, ok := params.Priorities[resource]
+		if !ok {
+			return resultValues
+		}
+
+		resultValues = excludePlatforms(resultValues, stored.Excluded)
+
+		strategy = StrategyAll
+		if len(stored.Priority) > 0 {
+			strategy = StrategyPriority
+			priority = stored.Priority
+		}
+	}
+
+	if strategy == StrategyAll {
+		return resultValues
+	}
+
+	var values []ValueResult
+	var withheldValues []ValueResult
+	for _, value := range resultValues {
+		if value.Withheld {
+			withheldValues = append(withheldValues, value)
+		} else {
+			values = append(values, value)
+		}
+	}
+
+	if len(values) == 0 {
+		return withheldValues
+	}
+
+	var reconciled ValueResult
+	switch strategy {
+	case StrategyMax:
+		reconciled = pickValue(values, func(value, picked float64) bool { return value > picked })
+	case StrategyMin:
+		reconciled = pickValue(values, func(value, picked float64) bool { return value < picked })
+	case StrategyPriority:
+		reconciled = pickByPriority(values, priority)
+	default:
+		reconciled = combineValues(values, strategy)
+	}
+
+	reconciled.Strategy = strategy
+	reconciled.Sources = valueSources(values)
+
+	return append([]ValueResult{reconciled}, withheldValues...)
+}
+
+// reconcileByDay reconciles a history of measurements one UTC day at a time, oldest first. Reconciled results
+// that don't come from a single measurement are dated with the latest measurement of their day.
+// Withheld accounts are listed once, after every day
+func reconcileByDay(resultValues []ValueResult, params GetValueParams, resource string) []ValueResult {
+	var days []string
+	dayValues := make(map[string][]ValueResult)
+	var withheldValues []ValueResult
+	for _, value := range resultValues {
+		if value.Withheld {
+			withheldValues = append(withheldValues, value)
+			continue
+		}
+
+		day := measurementDay(value.MeasuredAt)
+		if _, ok := dayValues[day]; !ok {
+			days = append(days, day)
+		}
+		dayValues[day] = append(dayValues[day], value)
+	}
+
+	sort.Strings(days)
+
+	reconciled := make([]ValueResult, 0, len(resultValues))
+	for _, day := range days {
+		latest := latestMeasurement(dayValues[day])
+		for _, value := range reconcile(dayValues[day], params, resource) {
+			if value.MeasuredAt == "" {
+				value.MeasuredAt = latest
+			}
+
+			reconciled = append(reconciled, value)
+		}
+	}
+
+	// Reconciling only withheld values keeps them as they are, unless the user excluded their platform
+	if len(withheldValues) > 0 {
+		reconciled = append(reconciled, reconcile(withheldValues, params, resource)...)
+	}
+
+	return reconciled
+}
+
+// measurementDay returns the UTC day a measurement was taken on
+func measurementDay(measuredAt string) string {
+	measuredTime, err := time.Parse(helpers.ISO8601Layout, measuredAt)
+	if err != nil {
+		return measuredAt
+	}
+
+	return measuredTime.UTC().Format(helpers.ISOLayout)
+}
+
+// latestMeasurement returns when the latest of the measurements was taken
+func latestMeasurement(values []ValueResult) string {
+	var latest time.Time
+	var latestMeasuredAt string
+	for _, value := range values {
+		measuredTime, err := time.Parse(helpers.ISO8601Layout, value.MeasuredAt)
+		if err == nil && measuredTime.After(latest) {
+			latest = measuredTime
+			latestMeasuredAt = value.MeasuredAt
+		}
+	}
+
+	return latestMeasuredAt
+}
+
+// excludePlatforms leaves out the values of platforms the user doesn't trust for a resource
+func excludePlatforms(resultValues []ValueResult, excluded []string) []ValueResult {
+	if len(excluded) == 0 {
+		return resultValues
+	}
+
+	values := make([]ValueResult, 0, len(resultValues))
+	for _, value := range resultValues {
+		if !containsPlatform(excluded, value.Platform) {
+			values = append(values, value)
+		}
+	}
+
+	return values
+}
+
+func containsPlatform(platformNames []string, platformName string) bool {
+	for _, name := range platformNames {
+		if name == platformName {
+			return true
+		}
+	}
+
+	return false
+}
+
+// pickValue picks the value that beats every other one. Accounts are sorted by when they were linked,
+// so ties go to the account the user linked first
+func pickValue(values []ValueResult, beats func(value, picked float64) bool) ValueResult {
+	picked := values[0]
+	for _, value := range values[1:] {
+		if beats(value.Value, picked.Value) {
+			picked = value
+		}
+	}
+
+	return picked
+}
+
+// pickByPriority picks the value of the first platform in the priority order. Platforms that aren't
+// in the order come after the ones that are, in the order they were linked
+func pickByPriority(values []ValueResult, priority []string) ValueResult {
+	for _, platformName := range priority {
+		for _, value := range values {
+			if value.Platform == platformName {
+				return value
+			}
+		}
+	}
+
+	return values[0]
+}
+
+// combineValues works out the mean, median or sum of the values. The details and intraday values
+// are combined the same way, out of the accounts that have them
+func combineValues(values []ValueResult, strategy string) ValueResult {
+	result := ValueResult{}
+
+	figures := make([]float64, 0, len(values))
+	details := make(map[string][]float64)
+	intraday := make(map[string][]float64)
+	for _, value := range values {
+		figures = append(figures, value.Value)
+
+		for name, detail := range value.Details {
+			details[name] = append(details[name], detail)
+		}
+
+		for _, intradayValue := range value.Intraday {
+			intraday[intradayValue.Time] = append(intraday[intradayValue.Time], intradayValue.Value)
+		}
+	}
+
+	result.Value = combineFigures(figures, strategy)
+
+	if len(details) > 0 {
+		result.Details = make(map[string]float64, len(details))
+		for name, detailFigures := range details {
+			result.Details[name] = combineFigures(detailFigures, strategy)
+		}
+	}
+
+	if len(intraday) > 0 {
+		result.Intraday = make([]IntradayValue, 0, len(intraday))
+		for intervalTime, intervalFigures := range intraday {
+			result.Intraday = append(result.Intraday, IntradayValue{
+				Time:  intervalTime,
+				Value: combineFigures(intervalFigures, strategy),
+			})
+		}
+
+		sort.Slice(result.Intraday, func(i, j int) bool {
+			return result.Intraday[i].Time < result.Intraday[j].Time
+		})
+	}
+
+	return result
+}
+
+func combineFigures(figures []float64, strategy string) float64 {
+	switch strategy {
+	case StrategySum:
+		return sumFigures(figures)
+	case StrategyMedian:
+		sorted := append([]float64{}, figures...)
+		sort.Float64s(sorted)
+
+		middle := len(sorted) / 2
+		if len(sorted)%2 == 0 {
+			return (sorted[middle-1] + sorted[middle]) / 2
+		}
+		return sorted[middle]
+	default:
+		return sumFigures(figures) / float64(len(figures))
+	}
+}
+
+func sumFigures(figures []float64) float64 {
+	var sum float64
+	for _, figure := range figures {
+		sum += figure
+	}
+
+	return sum
+}
+
+// valueSources lists what every account contributed to a reconciled result
+func valueSources(values []ValueResult) []ValueSource {
+	sources := make([]ValueSource, 0, len(values))
+	for _, value := range values {
+		sources = append(sources, ValueSource{
+			Platform:   value.Platform,
+			Account:    value.Account,
+			Value:      value.Value,
+			MeasuredAt: value.MeasuredAt,
+		})
+	}
+
+	return sources
+}
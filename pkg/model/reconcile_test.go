@@ -0,0 +1,234 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/msgurgel/mrthn/pkg/dal"
+)
+
+func TestReconcile(t *testing.T) {
+	tests := []struct {
+		name     string
+		values   []ValueResult
+		params   GetValueParams
+		resource string
+		expected []ValueResult
+	}{
+		{
+			name: "max tie goes to the account linked first",
+			values: []ValueResult{
+				{Platform: "fitbit", Account: "Work watch", Value: 5000},
+				{Platform: "google", Account: "Phone", Value: 5000},
+				{Platform: "strava", Account: "strava-1", Value: 4000},
+			},
+			params:   GetValueParams{Strategy: StrategyMax},
+			resource: ResourceSteps,
+			expected: []ValueResult{
+				{
+					Platform: "fitbit",
+					Account:  "Work watch",
+					Value:    5000,
+					Strategy: StrategyMax,
+					Sources: []ValueSource{
+						{Platform: "fitbit", Account: "Work watch", Value: 5000},
+						{Platform: "google", Account: "Phone", Value: 5000},
+						{Platform: "strava", Account: "strava-1", Value: 4000},
+					},
+				},
+			},
+		},
+		{
+			name: "median of an even count is the mean of the middle values",
+			values: []ValueResult{
+				{Platform: "fitbit", Value: 400},
+				{Platform: "google", Value: 100},
+				{Platform: "strava", Value: 300},
+				{Platform: "fitbit", Value: 200},
+			},
+			params:   GetValueParams{Strategy: StrategyMedian},
+			resource: ResourceCalories,
+			expected: []ValueResult{
+				{
+					Value:    250,
+					Strategy: StrategyMedian,
+					Sources: []ValueSource{
+						{Platform: "fitbit", Value: 400},
+						{Platform: "google", Value: 100},
+						{Platform: "strava", Value: 300},
+						{Platform: "fitbit", Value: 200},
+					},
+				},
+			},
+		},
+		{
+			name: "priority falls back to the account linked first",
+			values: []ValueResult{
+				{Platform: "google", Value: 7},
+				{Platform: "strava", Value: 9},
+			},
+			params:   GetValueParams{Strategy: StrategyPriority, Priority: []string{"fitbit"}},
+			resource: ResourceDistance,
+			expected: []ValueResult{
+				{
+					Platform: "google",
+					Value:    7,
+					Strategy: StrategyPriority,
+					Sources: []ValueSource{
+						{Platform: "google", Value: 7},
+						{Platform: "strava", Value: 9},
+					},
+				},
+			},
+		},
+		{
+			name: "stored priority excludes platforms and falls back to the account linked first",
+			values: []ValueResult{
+				{Platform: "strava", Value: 9},
+				{Platform: "google", Value: 7},
+				{Platform: "fitbit", Value: 8},
+			},
+			params: GetValueParams{Priorities: dal.PlatformPriorities{
+				ResourceDistance: {Priority: []string{"fitbit"}, Excluded: []string{"fitbit"}},
+			}},
+			resource: ResourceDistance,
+			expected: []ValueResult{
+				{
+					Platform: "strava",
+					Value:    9,
+					Strategy: StrategyPriority,
+					Sources: []ValueSource{
+						{Platform: "strava", Value: 9},
+						{Platform: "google", Value: 7},
+					},
+				},
+			},
+		},
+		{
+			name: "intraday values are combined by interval",
+			values: []ValueResult{
+				{
+					Platform: "fitbit",
+					Value:    70,
+					Details:  map[string]float64{"resting": 60},
+					Intraday: []IntradayValue{
+						{Time: "2020-02-13T10:00:00+0000", Value: 80},
+						{Time: "2020-02-13T09:00:00+0000", Value: 60},
+					},
+				},
+				{
+					Platform: "google",
+					Value:    90,
+					Intraday: []IntradayValue{
+						{Time: "2020-02-13T10:00:00+0000", Value: 100},
+					},
+				},
+			},
+			params:   GetValueParams{Strategy: StrategyMean},
+			resource: ResourceHeartRate,
+			expected: []ValueResult{
+				{
+					Value:   80,
+					Details: map[string]float64{"resting": 60},
+					Intraday: []IntradayValue{
+						{Time: "2020-02-13T09:00:00+0000", Value: 60},
+						{Time: "2020-02-13T10:00:00+0000", Value: 90},
+					},
+					Strategy: StrategyMean,
+					Sources: []ValueSource{
+						{Platform: "fitbit", Value: 70},
+						{Platform: "google", Value: 90},
+					},
+				},
+			},
+		},
+		{
+			name: "withheld accounts are listed after the reconciled value",
+			values: []ValueResult{
+				{Platform: "fitbit", Withheld: true},
+				{Platform: "google", Value: 3},
+				{Platform: "strava", Value: 5},
+			},
+			params:   GetValueParams{Strategy: StrategySum},
+			resource: ResourceDistance,
+			expected: []ValueResult{
+				{
+					Value:    8,
+					Strategy: StrategySum,
+					Sources: []ValueSource{
+						{Platform: "google", Value: 3},
+						{Platform: "strava", Value: 5},
+					},
+				},
+				{Platform: "fitbit", Withheld: true},
+			},
+		},
+		{
+			name: "only withheld accounts are passed through",
+			values: []ValueResult{
+				{Platform: "fitbit", Withheld: true},
+				{Platform: "google", Withheld: true},
+			},
+			params:   GetValueParams{Strategy: StrategyMax},
+			resource: ResourceSteps,
+			expected: []ValueResult{
+				{Platform: "fitbit", Withheld: true},
+				{Platform: "google", Withheld: true},
+			},
+		},
+		{
+			name: "without a strategy or stored priority every value is kept",
+			values: []ValueResult{
+				{Platform: "fitbit", Value: 1},
+				{Platform: "google", Value: 2},
+			},
+			resource: ResourceSteps,
+			expected: []ValueResult{
+				{Platform: "fitbit", Value: 1},
+				{Platform: "google", Value: 2},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expected, reconcile(test.values, test.params, test.resource))
+		})
+	}
+}
+
+func TestReconcileByDay(t *testing.T) {
+	values := []ValueResult{
+		{Platform: "fitbit", Value: 80, MeasuredAt: "2020-02-13T08:00:00+0000"},
+		{Platform: "fitbit", Value: 79, MeasuredAt: "2020-02-12T08:00:00+0000"},
+		{Platform: "google", Withheld: true},
+		{Platform: "strava", Value: 82, MeasuredAt: "2020-02-13T20:00:00+0000"},
+		// Still the 12th in UTC
+		{Platform: "strava", Value: 81, MeasuredAt: "2020-02-12T21:00:00-0200"},
+	}
+
+	expected := []ValueResult{
+		{
+			Value:      80,
+			MeasuredAt: "2020-02-12T21:00:00-0200",
+			Strategy:   StrategyMean,
+			Sources: []ValueSource{
+				{Platform: "fitbit", Value: 79, MeasuredAt: "2020-02-12T08:00:00+0000"},
+				{Platform: "strava", Value: 81, MeasuredAt: "2020-02-12T21:00:00-0200"},
+			},
+		},
+		{
+			Value:      81,
+			MeasuredAt: "2020-02-13T20:00:00+0000",
+			Strategy:   StrategyMean,
+			Sources: []ValueSource{
+				{Platform: "fitbit", Value: 80, MeasuredAt: "2020-02-13T08:00:00+0000"},
+				{Platform: "strava", Value: 82, MeasuredAt: "2020-02-13T20:00:00+0000"},
+			},
+		},
+		{Platform: "google", Withheld: true},
+	}
+
+	assert.Equal(t, expected, reconcileByDay(values, GetValueParams{Strategy: StrategyMean}, ResourceWeight))
+}
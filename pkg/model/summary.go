@@ -0,0 +1,97 @@
+package model
+
+import (
+	"errors"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/msgurgel/mrthn/pkg/dal"
+	"github.com/msgurgel/mrthn/pkg/helpers"
+	"github.com/msgurgel/mrthn/pkg/platform"
+)
+
+// GetUserDailySummary gets every daily resource of the user's platforms, asking each account only once.
+// The results are grouped by resource, and each resource is reconciled on its own
+func GetUserDailySummary(params GetValueParams) (map[string][]ValueResult, error) {
+	accounts, err := getAccounts(params.DB, params.UserID, params.Log)
+	if err != nil {
+		return nil, err
+	}
+
+	summaryValues := make(map[string][]ValueResult)
+	var withheldAccounts []dal.Account
+	failed := 0
+	for _, account := range accounts {
+		// Accounts of a withheld platform aren't asked for anything
+		if !params.Sharing.SharesPlatform(account.Platform) {
+			withheldAccounts = append(withheldAccounts, account)
+			continue
+		}
+
+		p := platform.Platforms[account.Platform]
+		summary, err := p.GetDailySummary(account.CredentialID, params.Date)
+		if err != nil {
+			params.Log.WithFields(logrus.Fields{
+				"err":    err,
+				"userID": params.UserID,
+				"date":   params.Date.Format(helpers.ISOLayout),
+				"plat":   p.Name(),
+				"acct":   account.Label,
+			}).Error("failed to call GetDailySummary for platform")
+			failed++
+			continue // Try the next platform
+		}
+
+		for _, resource := range summary.Resources {
+			if !params.Sharing.Shares(resource, account.Platform) {
+				summaryValues[resource] = append(summaryValues[resource], withheldResult(account))
+				continue
+			}
+
+			summaryValues[resource] = append(summaryValues[resource], summaryResult(p.Name(), account.Label, summary, resource, params.Units))
+		}
+	}
+
+	if failed > 0 && failed == len(accounts)-len(withheldAccounts) {
+		return nil, errors.New("could not connect to any platforms, try again later")
+	}
+
+	for resource, values := range summaryValues {
+		for _, account := range withheldAccounts {
+			values = append(values, withheldResult(account))
+		}
+
+		summaryValues[resource] = reconcile(values, params, resource)
+	}
+
+	return summaryValues, nil
+}
+
+// summaryResult formats one of the resources of a daily summary the same way as when it's asked for on its own
+func summaryResult(platformName string, label string, summary platform.DailySummary, resource string, units string) ValueResult {
+	switch resource {
+	case platform.SummaryHeartRate:
+		return heartRateResult(platformName, label, summary.HeartRate)
+	case platform.SummaryActiveMinutes:
+		return activeMinutesResult(platformName, label, summary.ActiveMinutes)
+	case platform.SummaryFloors:
+		return climbResult(platformName, label, summary.Climb, ResourceFloors, units)
+	case platform.SummaryElevation:
+		return climbResult(platformName, label, summary.Climb, ResourceElevation, units)
+	}
+
+	result := ValueResult{
+		Platform: platformName,
+		Account:  label,
+	}
+	switch resource {
+	case platform.SummarySteps:
+		result.Value = float64(summary.Steps)
+	case platform.SummaryCalories:
+		result.Value = float64(summary.Calories)
+	case platform.SummaryDistance:
+		result.Value = convertDistance(summary.Distance, units)
+	}
+
+	return result
+}
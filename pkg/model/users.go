@@ -3,6 +3,7 @@ package model
 import (
 	"database/sql"
 	"errors"
+	"strings"
 	"time"
 
 	"github.com/msgurgel/mrthn/pkg/helpers"
@@ -13,36 +14,95 @@ import (
 	"github.com/sirupsen/logrus"
 )
 
+// Resources clients can read. Users can withhold any of them from a client
+const (
+	ResourceSteps         = "steps"
+	ResourceCalories      = "calories"
+	ResourceDistance      = "distance"
+	ResourceHeartRate     = "heartrate"
+	ResourceSleep         = "sleep"
+	ResourceActiveMinutes = "active-minutes"
+	ResourceWeight        = "weight"
+	ResourceBMI           = "bmi"
+	ResourceBodyFat       = "body-fat"
+	ResourceFloors        = "floors"
+	ResourceElevation     = "elevation"
+	ResourceActivities    = "activities" // The user's workouts
+)
+
+var Resources = []string{
+	ResourceSteps, ResourceCalories, ResourceDistance, ResourceHeartRate, ResourceSleep, ResourceActiveMinutes,
+	ResourceWeight, ResourceBMI, ResourceBodyFat, ResourceFloors, ResourceElevation,
+	ResourceActivities,
+}
+
+// Unit systems clients can get values in. Platforms give metric values, which are converted when imperial is asked for
+const (
+	UnitsMetric   = "metric"
+	UnitsImperial = "imperial"
+)
+
+const poundsInAKilogram = 2.20462262
+const milesInAKilometer = 0.621371192
+const feetInAMeter = 3.2808399
+
+// latestMeasurementPeriod is how far back the latest body measurement of a day is looked for
+const latestMeasurementPeriod = "30d"
+
 type ValueResult struct {
-	Platform string  `json:"platform,omitempty"`
-	Value    float64 `json:"value"`
+	Platform   string             `json:"platform,omitempty"`
+	Account    string             `json:"account,omitempty"` // Label of the platform account, since a user can link several of them
+	Value      float64            `json:"value"`
+	Details    map[string]float64 `json:"details,omitempty"`    // Other figures of resources that have more than one, such as the maximum heart rate
+	Withheld   bool               `json:"withheld,omitempty"`   // The user doesn't share this value with the client. Value is always zero
+	MeasuredAt string             `json:"measuredAt,omitempty"` // When a body measurement was taken
+	Intraday   []IntradayValue    `json:"intraday,omitempty"`
+	Strategy   string             `json:"strategy,omitempty"` // How the accounts' values were reconciled into this one
+	Sources    []ValueSource      `json:"sources,omitempty"`  // What each account contributed to a reconciled value
+}
+
+// IntradayValue is the value of a resource during the interval that starts at Time
+type IntradayValue struct {
+	Time  string  `json:"time"`
+	Value float64 `json:"value"`
 }
 
 type GetValueParams struct {
-	DB          *sql.DB
-	Log         *logrus.Logger
-	UserID      int
-	Date        time.Time
-	LargestOnly bool
+	DB         *sql.DB
+	Log        *logrus.Logger
+	UserID     int
+	Date       time.Time
+	Strategy   string                 // How to reconcile the values of the user's accounts. Priorities are applied when empty
+	Priority   []string               // Platforms the priority strategy prefers, most preferred first
+	Priorities dal.PlatformPriorities // Platform priorities the client stored for the user
+	Sharing    dal.SharingPreferences // What the user withholds from the client asking for the values
+	Units      string                 // UnitsMetric or UnitsImperial
 }
 
 // TODO: Can this be refactored, so there isn't as much copied code from GetUserSteps?
 func GetUserCalories(params GetValueParams) ([]ValueResult, error) {
-	platforms, err := getPlatforms(params.DB, params.UserID, params.Log)
+	accounts, err := getAccounts(params.DB, params.UserID, params.Log)
 	if err != nil {
 		return nil, err
 	}
 
 	// Request steps from each platform
 	var caloriesValues []ValueResult
-	for _, p := range platforms {
-		result, err := p.GetCalories(params.UserID, params.Date)
+	for _, account := range accounts {
+		if !params.Sharing.Shares(ResourceCalories, account.Platform) {
+			caloriesValues = append(caloriesValues, withheldResult(account))
+			continue
+		}
+
+		p := platform.Platforms[account.Platform]
+		result, err := p.GetCalories(account.CredentialID, params.Date)
 		if err != nil {
 			params.Log.WithFields(logrus.Fields{
 				"err":    err,
 				"userID": params.UserID,
 				"date":   params.Date.Format(helpers.ISOLayout),
 				"plat":   p.Name(),
+				"acct":   account.Label,
 			}).Error("failed to call GetCalories for platform")
 			continue // Try the next platform
 		}
@@ -50,39 +110,42 @@ func GetUserCalories(params GetValueParams) ([]ValueResult, error) {
 		// Format result and add to caloriesValues
 		caloriesVal := ValueResult{
 			Platform: p.Name(),
+			Account:  account.Label,
 			Value:    float64(result),
 		}
 		caloriesValues = append(caloriesValues, caloriesVal)
 	}
 
-	if len(caloriesValues) == 0 {
+	if !hasValues(caloriesValues, len(accounts)) {
 		return nil, errors.New("could not connect to any platforms, try again later")
 	}
 
-	// If the user only wants the largest amount, filter out the other results
-	if params.LargestOnly {
-		return filterNonLargest(caloriesValues), nil
-	}
-
-	return caloriesValues, nil
+	return reconcile(caloriesValues, params, ResourceCalories), nil
 }
 
 func GetUserSteps(params GetValueParams) ([]ValueResult, error) {
-	platforms, err := getPlatforms(params.DB, params.UserID, params.Log)
+	accounts, err := getAccounts(params.DB, params.UserID, params.Log)
 	if err != nil {
 		return nil, err
 	}
 
 	// Request steps from each platform
 	var stepsValues []ValueResult
-	for _, p := range platforms {
-		result, err := p.GetSteps(params.UserID, params.Date)
+	for _, account := range accounts {
+		if !params.Sharing.Shares(ResourceSteps, account.Platform) {
+			stepsValues = append(stepsValues, withheldResult(account))
+			continue
+		}
+
+		p := platform.Platforms[account.Platform]
+		result, err := p.GetSteps(account.CredentialID, params.Date)
 		if err != nil {
 			params.Log.WithFields(logrus.Fields{
 				"err":    err,
 				"userID": params.UserID,
 				"date":   params.Date.Format(helpers.ISOLayout),
 				"plat":   p.Name(),
+				"acct":   account.Label,
 			}).Error("failed to call GetSteps for platform")
 			continue // Try the next platform
 		}
@@ -90,39 +153,42 @@ func GetUserSteps(params GetValueParams) ([]ValueResult, error) {
 		// Format result and add to stepsValues
 		stepVal := ValueResult{
 			Platform: p.Name(),
+			Account:  account.Label,
 			Value:    float64(result),
 		}
 		stepsValues = append(stepsValues, stepVal)
 	}
 
-	if len(stepsValues) == 0 {
+	if !hasValues(stepsValues, len(accounts)) {
 		return nil, errors.New("could not connect to any platforms, try again later")
 	}
 
-	// If the user only wants the largest amount, filter out the other results
-	if params.LargestOnly {
-		return filterNonLargest(stepsValues), nil
-	}
-
-	return stepsValues, nil
+	return reconcile(stepsValues, params, ResourceSteps), nil
 }
 
 func GetUserDistance(params GetValueParams) ([]ValueResult, error) {
-	platforms, err := getPlatforms(params.DB, params.UserID, params.Log)
+	accounts, err := getAccounts(params.DB, params.UserID, params.Log)
 	if err != nil {
 		return nil, err
 	}
 
 	// Request steps from each platform
 	var distanceValues []ValueResult
-	for _, p := range platforms {
-		result, err := p.GetDistance(params.UserID, params.Date)
+	for _, account := range accounts {
+		if !params.Sharing.Shares(ResourceDistance, account.Platform) {
+			distanceValues = append(distanceValues, withheldResult(account))
+			continue
+		}
+
+		p := platform.Platforms[account.Platform]
+		result, err := p.GetDistance(account.CredentialID, params.Date)
 		if err != nil {
 			params.Log.WithFields(logrus.Fields{
 				"err":    err,
 				"userID": params.UserID,
 				"date":   params.Date.Format(helpers.ISOLayout),
 				"plat":   p.Name(),
+				"acct":   account.Label,
 			}).Error("failed to call GetDistance for platform")
 			continue // Try the next platform
 		}
@@ -130,39 +196,42 @@ func GetUserDistance(params GetValueParams) ([]ValueResult, error) {
 		// Format result and add to stepsValues
 		distanceVal := ValueResult{
 			Platform: p.Name(),
-			Value:    result,
+			Account:  account.Label,
+			Value:    convertDistance(result, params.Units),
 		}
 		distanceValues = append(distanceValues, distanceVal)
 	}
 
-	if len(distanceValues) == 0 {
+	if !hasValues(distanceValues, len(accounts)) {
 		return nil, errors.New("could not connect to any platforms, try again later")
 	}
 
-	// If the user only wants the largest amount, filter out the other results
-	if params.LargestOnly {
-		return filterNonLargest(distanceValues), nil
-	}
-
-	return distanceValues, nil
+	return reconcile(distanceValues, params, ResourceDistance), nil
 }
 
 func GetUserDistanceOverPeriod(params GetValueParams, period string) ([]ValueResult, error) {
-	platforms, err := getPlatforms(params.DB, params.UserID, params.Log)
+	accounts, err := getAccounts(params.DB, params.UserID, params.Log)
 	if err != nil {
 		return nil, err
 	}
 
 	// Request steps from each platform
 	var distanceValues []ValueResult
-	for _, p := range platforms {
-		result, err := p.GetDistanceOverPeriod(params.UserID, params.Date, period)
+	for _, account := range accounts {
+		if !params.Sharing.Shares(ResourceDistance, account.Platform) {
+			distanceValues = append(distanceValues, withheldResult(account))
+			continue
+		}
+
+		p := platform.Platforms[account.Platform]
+		result, err := p.GetDistanceOverPeriod(account.CredentialID, params.Date, period)
 		if err != nil {
 			params.Log.WithFields(logrus.Fields{
 				"err":    err,
 				"userID": params.UserID,
 				"date":   params.Date.Format(helpers.ISOLayout),
 				"plat":   p.Name(),
+				"acct":   account.Label,
 			}).Error("failed to call GetDistanceOverPeriod for platform")
 			continue // Try the next platform
 		}
@@ -170,55 +239,499 @@ func GetUserDistanceOverPeriod(params GetValueParams, period string) ([]ValueRes
 		// Format result and add to distanceValues
 		distanceVal := ValueResult{
 			Platform: p.Name(),
-			Value:    result,
+			Account:  account.Label,
+			Value:    convertDistance(result, params.Units),
 		}
 		distanceValues = append(distanceValues, distanceVal)
 	}
 
-	if len(distanceValues) == 0 {
+	if !hasValues(distanceValues, len(accounts)) {
+		return nil, errors.New("could not connect to any platforms, try again later")
+	}
+
+	return reconcile(distanceValues, params, ResourceDistance), nil
+}
+
+func GetUserHeartRate(params GetValueParams) ([]ValueResult, error) {
+	accounts, err := getAccounts(params.DB, params.UserID, params.Log)
+	if err != nil {
+		return nil, err
+	}
+
+	// Request heart rate from each platform
+	var heartRateValues []ValueResult
+	for _, account := range accounts {
+		if !params.Sharing.Shares(ResourceHeartRate, account.Platform) {
+			heartRateValues = append(heartRateValues, withheldResult(account))
+			continue
+		}
+
+		p := platform.Platforms[account.Platform]
+		result, err := p.GetHeartRate(account.CredentialID, params.Date)
+		if err != nil {
+			params.Log.WithFields(logrus.Fields{
+				"err":    err,
+				"userID": params.UserID,
+				"date":   params.Date.Format(helpers.ISOLayout),
+				"plat":   p.Name(),
+				"acct":   account.Label,
+			}).Error("failed to call GetHeartRate for platform")
+			continue // Try the next platform
+		}
+
+		heartRateValues = append(heartRateValues, heartRateResult(p.Name(), account.Label, result))
+	}
+
+	if !hasValues(heartRateValues, len(accounts)) {
+		return nil, errors.New("could not connect to any platforms, try again later")
+	}
+
+	return reconcile(heartRateValues, params, ResourceHeartRate), nil
+}
+
+func GetUserHeartRateOverPeriod(params GetValueParams, period string) ([]ValueResult, error) {
+	accounts, err := getAccounts(params.DB, params.UserID, params.Log)
+	if err != nil {
+		return nil, err
+	}
+
+	// Request heart rate from each platform
+	var heartRateValues []ValueResult
+	for _, account := range accounts {
+		if !params.Sharing.Shares(ResourceHeartRate, account.Platform) {
+			heartRateValues = append(heartRateValues, withheldResult(account))
+			continue
+		}
+
+		p := platform.Platforms[account.Platform]
+		result, err := p.GetHeartRateOverPeriod(account.CredentialID, params.Date, period)
+		if err != nil {
+			params.Log.WithFields(logrus.Fields{
+				"err":    err,
+				"userID": params.UserID,
+				"date":   params.Date.Format(helpers.ISOLayout),
+				"plat":   p.Name(),
+				"acct":   account.Label,
+			}).Error("failed to call GetHeartRateOverPeriod for platform")
+			continue // Try the next platform
+		}
+
+		heartRateValues = append(heartRateValues, heartRateResult(p.Name(), account.Label, result))
+	}
+
+	if !hasValues(heartRateValues, len(accounts)) {
 		return nil, errors.New("could not connect to any platforms, try again later")
 	}
 
-	// If the user only wants the largest amount, filter out the other results
-	if params.LargestOnly {
-		return filterNonLargest(distanceValues), nil
+	return reconcile(heartRateValues, params, ResourceHeartRate), nil
+}
+
+func GetUserSleep(params GetValueParams) ([]ValueResult, error) {
+	accounts, err := getAccounts(params.DB, params.UserID, params.Log)
+	if err != nil {
+		return nil, err
+	}
+
+	// Request sleep from each platform that tracks it
+	var sleepValues []ValueResult
+	unsupported := 0
+	for _, account := range accounts {
+		if !params.Sharing.Shares(ResourceSleep, account.Platform) {
+			sleepValues = append(sleepValues, withheldResult(account))
+			continue
+		}
+
+		p := platform.Platforms[account.Platform]
+		result, err := p.GetSleep(account.CredentialID, params.Date)
+		if err == platform.ErrResourceUnsupported {
+			unsupported++
+			continue
+		}
+		if err != nil {
+			params.Log.WithFields(logrus.Fields{
+				"err":    err,
+				"userID": params.UserID,
+				"date":   params.Date.Format(helpers.ISOLayout),
+				"plat":   p.Name(),
+				"acct":   account.Label,
+			}).Error("failed to call GetSleep for platform")
+			continue // Try the next platform
+		}
+
+		sleepValues = append(sleepValues, sleepResult(p.Name(), account.Label, result))
+	}
+
+	if len(accounts) > 0 && unsupported == len(accounts) {
+		return nil, errors.New("none of the user's platforms track sleep")
+	}
+
+	if !hasValues(sleepValues, len(accounts)-unsupported) {
+		return nil, errors.New("could not connect to any platforms, try again later")
 	}
 
-	return distanceValues, nil
+	return reconcile(sleepValues, params, ResourceSleep), nil
 }
 
-func getPlatforms(db *sql.DB, userID int, log *logrus.Logger) ([]platform.Platform, error) {
-	platformStr, err := dal.GetPlatformNames(db, userID)
+func GetUserActiveMinutes(params GetValueParams) ([]ValueResult, error) {
+	accounts, err := getAccounts(params.DB, params.UserID, params.Log)
+	if err != nil {
+		return nil, err
+	}
+
+	// Request active minutes from each platform
+	var activeMinutesValues []ValueResult
+	for _, account := range accounts {
+		if !params.Sharing.Shares(ResourceActiveMinutes, account.Platform) {
+			activeMinutesValues = append(activeMinutesValues, withheldResult(account))
+			continue
+		}
+
+		p := platform.Platforms[account.Platform]
+		result, err := p.GetActiveMinutes(account.CredentialID, params.Date)
+		if err != nil {
+			params.Log.WithFields(logrus.Fields{
+				"err":    err,
+				"userID": params.UserID,
+				"date":   params.Date.Format(helpers.ISOLayout),
+				"plat":   p.Name(),
+				"acct":   account.Label,
+			}).Error("failed to call GetActiveMinutes for platform")
+			continue // Try the next platform
+		}
+
+		activeMinutesValues = append(activeMinutesValues, activeMinutesResult(p.Name(), account.Label, result))
+	}
+
+	if !hasValues(activeMinutesValues, len(accounts)) {
+		return nil, errors.New("could not connect to any platforms, try again later")
+	}
+
+	return reconcile(activeMinutesValues, params, ResourceActiveMinutes), nil
+}
+
+func GetUserFloors(params GetValueParams) ([]ValueResult, error) {
+	return getUserClimb(params, ResourceFloors, "")
+}
+
+func GetUserFloorsOverPeriod(params GetValueParams, period string) ([]ValueResult, error) {
+	return getUserClimb(params, ResourceFloors, period)
+}
+
+func GetUserElevation(params GetValueParams) ([]ValueResult, error) {
+	return getUserClimb(params, ResourceElevation, "")
+}
+
+func GetUserElevationOverPeriod(params GetValueParams, period string) ([]ValueResult, error) {
+	return getUserClimb(params, ResourceElevation, period)
+}
+
+// getUserClimb reads the floors or elevation gain of each account, on the date, or over the period when one is given.
+// Both figures are reported, the other one in the details, so clients can compare barometer floors with GPS elevation
+func getUserClimb(params GetValueParams, resource string, period string) ([]ValueResult, error) {
+	accounts, err := getAccounts(params.DB, params.UserID, params.Log)
+	if err != nil {
+		return nil, err
+	}
+
+	// Request the climb from each platform that tracks it
+	var climbValues []ValueResult
+	unsupported := 0
+	for _, account := range accounts {
+		if !params.Sharing.Shares(resource, account.Platform) {
+			climbValues = append(climbValues, withheldResult(account))
+			continue
+		}
+
+		p := platform.Platforms[account.Platform]
+
+		var result platform.Climb
+		if period == "" {
+			result, err = p.GetClimb(account.CredentialID, params.Date)
+		} else {
+			result, err = p.GetClimbOverPeriod(account.CredentialID, params.Date, period)
+		}
+		if err == platform.ErrResourceUnsupported {
+			unsupported++
+			continue
+		}
+		if err != nil {
+			params.Log.WithFields(logrus.Fields{
+				"err":    err,
+				"userID": params.UserID,
+				"date":   params.Date.Format(helpers.ISOLayout),
+				"period": period,
+				"plat":   p.Name(),
+				"acct":   account.Label,
+			}).Error("failed to get climb for platform")
+			continue // Try the next platform
+		}
+
+		climbValues = append(climbValues, climbResult(p.Name(), account.Label, result, resource, params.Units))
+	}
+
+	if len(accounts) > 0 && unsupported == len(accounts) {
+		return nil, errors.New("none of the user's platforms track " + resource)
+	}
+
+	if !hasValues(climbValues, len(accounts)-unsupported) {
+		return nil, errors.New("could not connect to any platforms, try again later")
+	}
+
+	return reconcile(climbValues, params, resource), nil
+}
+
+func GetUserWeight(params GetValueParams) ([]ValueResult, error) {
+	return getUserBodyMeasurements(params, ResourceWeight, platform.BodyWeight, latestMeasurementPeriod, true)
+}
+
+func GetUserWeightOverPeriod(params GetValueParams, period string) ([]ValueResult, error) {
+	return getUserBodyMeasurements(params, ResourceWeight, platform.BodyWeight, period, false)
+}
+
+func GetUserBMI(params GetValueParams) ([]ValueResult, error) {
+	return getUserBodyMeasurements(params, ResourceBMI, platform.BodyBMI, latestMeasurementPeriod, true)
+}
+
+func GetUserBMIOverPeriod(params GetValueParams, period string) ([]ValueResult, error) {
+	return getUserBodyMeasurements(params, ResourceBMI, platform.BodyBMI, period, false)
+}
+
+func GetUserBodyFat(params GetValueParams) ([]ValueResult, error) {
+	return getUserBodyMeasurements(params, ResourceBodyFat, platform.BodyFat, latestMeasurementPeriod, true)
+}
+
+func GetUserBodyFatOverPeriod(params GetValueParams, period string) ([]ValueResult, error) {
+	return getUserBodyMeasurements(params, ResourceBodyFat, platform.BodyFat, period, false)
+}
+
+// getUserBodyMeasurements lists the measurements of each account in the period that ends on the date.
+// When latestOnly is set, only the latest measurement of each account is kept.
+// Accounts without measurements in the period are left out, which isn't a failure
+func getUserBodyMeasurements(params GetValueParams, resource string, measurement string, period string, latestOnly bool) ([]ValueResult, error) {
+	accounts, err := getAccounts(params.DB, params.UserID, params.Log)
+	if err != nil {
+		return nil, err
+	}
+
+	// Request the measurements from each platform that tracks them
+	measurementValues := make([]ValueResult, 0)
+	unsupported := 0
+	failed := 0
+	for _, account := range accounts {
+		if !params.Sharing.Shares(resource, account.Platform) {
+			measurementValues = append(measurementValues, withheldResult(account))
+			continue
+		}
+
+		p := platform.Platforms[account.Platform]
+		result, err := p.GetBodyMeasurements(account.CredentialID, measurement, params.Date, period)
+		if err == platform.ErrResourceUnsupported {
+			unsupported++
+			continue
+		}
+		if err != nil {
+			params.Log.WithFields(logrus.Fields{
+				"err":    err,
+				"userID": params.UserID,
+				"date":   params.Date.Format(helpers.ISOLayout),
+				"plat":   p.Name(),
+				"acct":   account.Label,
+				"meas":   measurement,
+			}).Error("failed to call GetBodyMeasurements for platform")
+			failed++
+			continue // Try the next platform
+		}
+
+		if latestOnly && len(result) > 1 {
+			latest := result[0]
+			for _, m := range result {
+				if m.MeasuredAt.After(latest.MeasuredAt) {
+					latest = m
+				}
+			}
+			result = []platform.BodyMeasurement{latest}
+		}
+
+		for _, m := range result {
+			value := m.Value
+			if measurement == platform.BodyWeight {
+				value = convertWeight(value, params.Units)
+			}
+
+			measurementValues = append(measurementValues, ValueResult{
+				Platform:   p.Name(),
+				Account:    account.Label,
+				Value:      value,
+				MeasuredAt: m.MeasuredAt.Format(helpers.ISO8601Layout),
+			})
+		}
+	}
+
+	if len(accounts) > 0 && unsupported == len(accounts) {
+		return nil, errors.New("none of the user's platforms track " + resource)
+	}
+
+	if failed > 0 && failed == len(accounts)-unsupported {
+		return nil, errors.New("could not connect to any platforms, try again later")
+	}
+
+	// A history keeps one value per day, instead of collapsing the whole period into one
+	if !latestOnly {
+		return reconcileByDay(measurementValues, params, resource), nil
+	}
+
+	return reconcile(measurementValues, params, resource), nil
+}
+
+// convertWeight converts kilograms to the unit system
+func convertWeight(kilograms float64, units string) float64 {
+	if units == UnitsImperial {
+		return kilograms * poundsInAKilogram
+	}
+
+	return kilograms
+}
+
+// convertElevation converts meters to the unit system
+func convertElevation(meters float64, units string) float64 {
+	if units == UnitsImperial {
+		return meters * feetInAMeter
+	}
+
+	return meters
+}
+
+// convertDistance converts kilometers to the unit system
+func convertDistance(kilometers float64, units string) float64 {
+	if units == UnitsImperial {
+		return kilometers * milesInAKilometer
+	}
+
+	return kilometers
+}
+
+// activeMinutesResult uses the total time the user was active, of any intensity, as the value.
+// The minutes of each intensity are in the details
+func activeMinutesResult(platformName string, label string, activeMinutes platform.ActiveMinutes) ValueResult {
+	return ValueResult{
+		Platform: platformName,
+		Account:  label,
+		Value:    float64(activeMinutes.Lightly + activeMinutes.Fairly + activeMinutes.Very),
+		Details: map[string]float64{
+			"minutesSedentary":     float64(activeMinutes.Sedentary),
+			"minutesLightlyActive": float64(activeMinutes.Lightly),
+			"minutesFairlyActive":  float64(activeMinutes.Fairly),
+			"minutesVeryActive":    float64(activeMinutes.Very),
+		},
+	}
+}
+
+// climbResult uses the floors or the elevation gain as the value, depending on the resource, and the other one as a detail
+func climbResult(platformName string, label string, climb platform.Climb, resource string, units string) ValueResult {
+	elevation := convertElevation(climb.Elevation, units)
+	if resource == ResourceElevation {
+		return ValueResult{
+			Platform: platformName,
+			Account:  label,
+			Value:    elevation,
+			Details:  map[string]float64{"floors": float64(climb.Floors)},
+		}
+	}
+
+	return ValueResult{
+		Platform: platformName,
+		Account:  label,
+		Value:    float64(climb.Floors),
+		Details:  map[string]float64{"elevation": elevation},
+	}
+}
+
+// sleepResult uses the minutes asleep as the value. The time in bed, efficiency and sleep stages are in the details
+func sleepResult(platformName string, label string, sleep platform.Sleep) ValueResult {
+	details := map[string]float64{
+		"minutesInBed": float64(sleep.MinutesInBed),
+		"efficiency":   sleep.Efficiency,
+	}
+	for stage, minutes := range sleep.StageMinutes {
+		details["minutes"+strings.Title(stage)] = float64(minutes)
+	}
+
+	return ValueResult{
+		Platform: platformName,
+		Account:  label,
+		Value:    float64(sleep.MinutesAsleep),
+		Details:  details,
+	}
+}
+
+// heartRateResult uses the average heart rate as the value. Platforms that don't report an average, like Fitbit,
+// use the resting heart rate instead. Every figure the platform tracks is in the details, including the minutes spent in each zone
+func heartRateResult(platformName string, label string, heartRate platform.HeartRate) ValueResult {
+	details := make(map[string]float64)
+	if heartRate.Resting > 0 {
+		details["resting"] = heartRate.Resting
+	}
+	if heartRate.Average > 0 {
+		details["average"] = heartRate.Average
+	}
+	if heartRate.Max > 0 {
+		details["max"] = heartRate.Max
+	}
+	if heartRate.Min > 0 {
+		details["min"] = heartRate.Min
+	}
+	for zone, minutes := range heartRate.ZoneMinutes {
+		details["minutesIn"+strings.ReplaceAll(strings.Title(zone), " ", "")] = float64(minutes)
+	}
+
+	value := heartRate.Average
+	if value == 0 {
+		value = heartRate.Resting
+	}
+
+	return ValueResult{
+		Platform: platformName,
+		Account:  label,
+		Value:    value,
+		Details:  details,
+	}
+}
+
+func getAccounts(db *sql.DB, userID int, log *logrus.Logger) ([]dal.Account, error) {
+	accounts, err := dal.GetUserAccounts(db, userID)
 	if err != nil {
 		log.WithFields(logrus.Fields{
 			"err":    err,
 			"userID": userID,
-		}).Error("failed to get platforms associated to user")
+		}).Error("failed to get platform accounts associated to user")
 
 		return nil, errors.New("server error, try again later")
 	}
 
-	platforms := platform.GetPlatforms(platformStr)
-	return platforms, nil
+	return accounts, nil
 }
 
-func filterNonLargest(resultValues []ValueResult) []ValueResult {
-	if len(resultValues) <= 1 {
-		return resultValues
+// withheldResult stands in for the value of an account the user doesn't share with the client.
+// The account's label is left out, since the client isn't allowed to see the account
+func withheldResult(account dal.Account) ValueResult {
+	return ValueResult{
+		Platform: account.Platform,
+		Withheld: true,
 	}
+}
 
-	var maxValue float64 = 0
-	var maxIndex = 0
-
-	for index, caloriesValue := range resultValues {
-		if caloriesValue.Value >= maxValue {
-			maxIndex = index
-			maxValue = caloriesValue.Value
+// hasValues checks that some account returned a value. Having every account withheld isn't a failure
+func hasValues(resultValues []ValueResult, accountCount int) bool {
+	withheldCount := 0
+	for _, value := range resultValues {
+		if value.Withheld {
+			withheldCount++
 		}
 	}
 
-	var maxCaloriesValue []ValueResult
-	maxCaloriesValue = append(maxCaloriesValue, resultValues[maxIndex])
+	if accountCount > 0 && withheldCount == accountCount {
+		return true
+	}
 
-	return maxCaloriesValue
+	return len(resultValues) > withheldCount
 }
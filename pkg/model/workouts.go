@@ -0,0 +1,97 @@
+package model
+
+import (
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/msgurgel/mrthn/pkg/helpers"
+	"github.com/msgurgel/mrthn/pkg/platform"
+)
+
+// WorkoutResult is a workout from one of the user's platform accounts, in the same shape for every platform
+type WorkoutResult struct {
+	Platform         string  `json:"platform,omitempty"`
+	Account          string  `json:"account,omitempty"`
+	Type             string  `json:"type,omitempty"` // One of the platform.Workout types, such as "run"
+	Name             string  `json:"name,omitempty"`
+	Start            string  `json:"start,omitempty"`
+	Duration         int     `json:"duration"` // In seconds
+	Distance         float64 `json:"distance"`
+	Calories         int     `json:"calories"`
+	AverageHeartRate float64 `json:"averageHeartRate,omitempty"`
+	Withheld         bool    `json:"withheld,omitempty"` // The user doesn't share their workouts from this account with the client
+
+	startTime time.Time // Workouts are sorted by it, since platforms give start times in different time zones
+}
+
+// GetUserWorkouts lists the workouts of every account started from the date until the end, oldest first.
+// Withheld accounts come last
+func GetUserWorkouts(params GetValueParams, end time.Time) ([]WorkoutResult, error) {
+	accounts, err := getAccounts(params.DB, params.UserID, params.Log)
+	if err != nil {
+		return nil, err
+	}
+
+	// Request workouts from each platform
+	workouts := make([]WorkoutResult, 0)
+	var withheld []WorkoutResult
+	failed := 0
+	for _, account := range accounts {
+		if !params.Sharing.Shares(ResourceActivities, account.Platform) {
+			withheld = append(withheld, WorkoutResult{
+				Platform: account.Platform,
+				Account:  account.Label,
+				Withheld: true,
+			})
+			continue
+		}
+
+		p := platform.Platforms[account.Platform]
+		result, err := p.GetWorkouts(account.CredentialID, params.Date, end)
+		if err != nil {
+			params.Log.WithFields(logrus.Fields{
+				"err":    err,
+				"userID": params.UserID,
+				"start":  params.Date.Format(helpers.ISOLayout),
+				"end":    end.Format(helpers.ISOLayout),
+				"plat":   p.Name(),
+				"acct":   account.Label,
+			}).Error("failed to call GetWorkouts for platform")
+			failed++
+			continue // Try the next platform
+		}
+
+		for _, workout := range result {
+			// Platforms may list workouts that started just outside of the range
+			if workout.Start.Before(params.Date) || !workout.Start.Before(end) {
+				continue
+			}
+
+			workouts = append(workouts, WorkoutResult{
+				Platform:         p.Name(),
+				Account:          account.Label,
+				Type:             workout.Type,
+				Name:             workout.Name,
+				Start:            workout.Start.Format(helpers.ISO8601Layout),
+				Duration:         int(workout.Duration.Seconds()),
+				Distance:         convertDistance(workout.Distance, params.Units),
+				Calories:         workout.Calories,
+				AverageHeartRate: workout.AverageHeartRate,
+				startTime:        workout.Start,
+			})
+		}
+	}
+
+	if failed > 0 && failed == len(accounts)-len(withheld) {
+		return nil, errors.New("could not connect to any platforms, try again later")
+	}
+
+	sort.SliceStable(workouts, func(i, j int) bool {
+		return workouts[i].startTime.Before(workouts[j].startTime)
+	})
+
+	return append(workouts, withheld...), nil
+}
@@ -7,11 +7,12 @@ import (
 	"errors"
 	"fmt"
 	"io/ioutil"
+	"net/http"
+	"net/url"
 	"strconv"
+	"strings"
 	"time"
 
-	"github.com/msgurgel/mrthn/pkg/auth"
-
 	"golang.org/x/oauth2"
 
 	"github.com/msgurgel/mrthn/pkg/helpers"
@@ -23,22 +24,33 @@ import (
 
 // ResourceEndpoint contains endpoint for any type of resource we want to access from Fitbit
 var resourceEndpoints = map[int]string{
-	stepType:     "activities/steps",
-	distanceType: "activities/distance",
-	caloriesType: "activities/calories",
+	stepType:      "activities/steps",
+	distanceType:  "activities/distance",
+	caloriesType:  "activities/calories",
+	floorsType:    "activities/floors",
+	elevationType: "activities/elevation",
 }
 
 type Fitbit struct {
 	db            *sql.DB
 	log           *logrus.Logger
 	domain        string
+	revokeURL     string
 	authorization *oauth2.Config
 }
 
 type Summary struct {
-	Calories int                      `json:"caloriesOut"`
-	Steps    int                      `json:"steps"`
-	Distance []map[string]interface{} `json:"distances"`
+	Calories             int                      `json:"caloriesOut"`
+	Steps                int                      `json:"steps"`
+	Distance             []map[string]interface{} `json:"distances"`
+	SedentaryMinutes     int                      `json:"sedentaryMinutes"`
+	LightlyActiveMinutes int                      `json:"lightlyActiveMinutes"`
+	FairlyActiveMinutes  int                      `json:"fairlyActiveMinutes"`
+	VeryActiveMinutes    int                      `json:"veryActiveMinutes"`
+	Floors               int                      `json:"floors"`
+	Elevation            float64                  `json:"elevation"` // In meters
+	RestingHeartRate     float64                  `json:"restingHeartRate,omitempty"`
+	HeartRateZones       []heartRateZone          `json:"heartRateZones,omitempty"` // Only for users with a heart rate monitor
 }
 
 type dailyActivity struct {
@@ -51,22 +63,145 @@ type dailyResourceSummary struct {
 	Value    string `json:"value"`
 }
 
+type heartRateZone struct {
+	Name    string `json:"name"`
+	Minutes int    `json:"minutes"`
+}
+
+type heartRateSummary struct {
+	HeartRateZones   []heartRateZone `json:"heartRateZones"`
+	RestingHeartRate float64         `json:"restingHeartRate"`
+}
+
+type dailyHeartRate struct {
+	DateTime string           `json:"dateTime"`
+	Value    heartRateSummary `json:"value"`
+}
+
+type sleepSummary struct {
+	Stages             map[string]int `json:"stages,omitempty"` // Missing for nights too short to be split in stages
+	TotalMinutesAsleep int            `json:"totalMinutesAsleep"`
+	TotalTimeInBed     int            `json:"totalTimeInBed"`
+}
+
+type dailySleep struct {
+	Summary sleepSummary `json:"summary"`
+}
+
+type intradaySample struct {
+	Time  string  `json:"time"`
+	Value float64 `json:"value"`
+}
+
+type intradayDataset struct {
+	Dataset []intradaySample `json:"dataset"`
+}
+
+// fitbitProfile holds the part of the user's profile that tells where the user is
+type fitbitProfile struct {
+	User struct {
+		Timezone            string `json:"timezone"`
+		OffsetFromUTCMillis int64  `json:"offsetFromUTCMillis"`
+	} `json:"user"`
+}
+
+// activityLog is a workout in the Fitbit activity log
+type activityLog struct {
+	ActivityName     string  `json:"activityName"`
+	StartTime        string  `json:"startTime"`
+	Duration         int64   `json:"duration"` // In milliseconds
+	Distance         float64 `json:"distance,omitempty"`
+	Calories         int     `json:"calories"`
+	AverageHeartRate float64 `json:"averageHeartRate,omitempty"`
+}
+
+type activityLogPagination struct {
+	Next string `json:"next"`
+}
+
+type activityLogList struct {
+	Activities []activityLog         `json:"activities"`
+	Pagination activityLogPagination `json:"pagination"`
+}
+
+// bodyLog is an entry of a Fitbit weight or body fat log. Weight logs also have the BMI, and the body fat when it was measured
+type bodyLog struct {
+	Date   string  `json:"date"`
+	Time   string  `json:"time"`
+	Weight float64 `json:"weight,omitempty"`
+	BMI    float64 `json:"bmi,omitempty"`
+	Fat    float64 `json:"fat,omitempty"`
+}
+
+const fitbitHeartRateEndpoint = "activities/heart"
+const fitbitBodyLogEndpoint = "body/log"
+const fitbitActivityLogEndpoint = "activities/list"
+
+// fitbitIntradayEndpoints maps intraday resources to the Fitbit time series they are read from
+var fitbitIntradayEndpoints = map[string]string{
+	IntradaySteps:     "activities/steps",
+	IntradayCalories:  "activities/calories",
+	IntradayHeartRate: fitbitHeartRateEndpoint,
+}
+
+// Fitbit lists up to 100 activities at a time
+const fitbitActivityLogLimit = 100
+
+// fitbitWorkoutTypes maps the names of Fitbit activities to workout types
+var fitbitWorkoutTypes = map[string]string{
+	"Run":          WorkoutRun,
+	"Treadmill":    WorkoutRun,
+	"Walk":         WorkoutWalk,
+	"Hike":         WorkoutHike,
+	"Bike":         WorkoutRide,
+	"Outdoor Bike": WorkoutRide,
+	"Spinning":     WorkoutRide,
+	"Swim":         WorkoutSwim,
+	"Weights":      WorkoutStrength,
+	"Yoga":         WorkoutYoga,
+}
+
+// Fitbit body logs can only be requested a month at a time
+const fitbitMaxBodyLogDays = 31
+
+// fitbitBodyLogs maps body measurements to the Fitbit body log they are kept in
+var fitbitBodyLogs = map[string]string{
+	BodyWeight: "weight",
+	BodyBMI:    "weight", // The BMI is worked out whenever the weight is logged
+	BodyFat:    "fat",
+}
+
+// Sleep stages are only available in version 1.2 of the Fitbit API
+const fitbitSleepAPIVersion = "1.2"
+
+// fitbitSleepStages maps the stages of Fitbit sleep logs to mrthn's
+var fitbitSleepStages = map[string]string{
+	"deep":  SleepStageDeep,
+	"light": SleepStageLight,
+	"rem":   SleepStageREM,
+	"wake":  SleepStageAwake,
+}
+
 const stepType = 1
 const distanceType = 2
 const caloriesType = 3
+const floorsType = 4
+const elevationType = 5
 
 var resourceNames = map[int]string{
-	stepType:     "activities-steps",
-	distanceType: "activities-distance",
-	caloriesType: "activities-calories",
+	stepType:      "activities-steps",
+	distanceType:  "activities-distance",
+	caloriesType:  "activities-calories",
+	floorsType:    "activities-floors",
+	elevationType: "activities-elevation",
 }
 
 func (f Fitbit) Name() string {
 	return "fitbit"
 }
 
-func (f Fitbit) GetSteps(user int, date time.Time) (int, error) {
-	dailyAct, err := f.getDailyActivity(user, date)
+func (f Fitbit) GetSteps(credentialID int, date time.Time) (int, error) {
+	dailyAct, err := f.getDailyActivity(credentialID, date)
 	if err != nil {
 		return 0, err
 	}
@@ -74,8 +209,8 @@ func (f Fitbit) GetSteps(user int, date time.Time) (int, error) {
 	return dailyAct.Summary.Steps, nil
 }
 
-func (f Fitbit) GetCalories(user int, date time.Time) (int, error) {
-	dailyAct, err := f.getDailyActivity(user, date)
+func (f Fitbit) GetCalories(credentialID int, date time.Time) (int, error) {
+	dailyAct, err := f.getDailyActivity(credentialID, date)
 	if err != nil {
 		return 0, err
 	}
@@ -83,8 +218,8 @@ func (f Fitbit) GetCalories(user int, date time.Time) (int, error) {
 	return dailyAct.Summary.Calories, nil
 }
 
-func (f Fitbit) GetDistance(user int, date time.Time) (float64, error) {
-	dailyAct, err := f.getDailyActivity(user, date)
+func (f Fitbit) GetDistance(credentialID int, date time.Time) (float64, error) {
+	dailyAct, err := f.getDailyActivity(credentialID, date)
 	if err != nil {
 		return 0, err
 	}
@@ -93,13 +228,104 @@ func (f Fitbit) GetDistance(user int, date time.Time) (float64, error) {
 	return dailyAct.Summary.Distance[0]["distance"].(float64), nil
 }
 
-func (f Fitbit) GetDistanceOverPeriod(user int, date time.Time, period string) (float64, error) {
-	tokens, err := dal.GetUserTokens(f.db, user, f.Name())
+func (f Fitbit) GetActiveMinutes(credentialID int, date time.Time) (ActiveMinutes, error) {
+	dailyAct, err := f.getDailyActivity(credentialID, date)
+	if err != nil {
+		return ActiveMinutes{}, err
+	}
+
+	return ActiveMinutes{
+		Sedentary: dailyAct.Summary.SedentaryMinutes,
+		Lightly:   dailyAct.Summary.LightlyActiveMinutes,
+		Fairly:    dailyAct.Summary.FairlyActiveMinutes,
+		Very:      dailyAct.Summary.VeryActiveMinutes,
+	}, nil
+}
+
+// GetClimb reads the floors and elevation Fitbit works out from the barometer of the user's device
+func (f Fitbit) GetClimb(credentialID int, date time.Time) (Climb, error) {
+	dailyAct, err := f.getDailyActivity(credentialID, date)
+	if err != nil {
+		return Climb{}, err
+	}
+
+	return Climb{
+		Floors:    dailyAct.Summary.Floors,
+		Elevation: dailyAct.Summary.Elevation,
+	}, nil
+}
+
+func (f Fitbit) GetClimbOverPeriod(credentialID int, date time.Time, period string) (Climb, error) {
+	tokens, err := dal.GetCredentialTokens(f.db, credentialID)
+	if err != nil {
+		return Climb{}, err
+	}
+
+	floors, err := f.callActivityTimeSeries(credentialID, tokens, floorsType, date, period)
+	if err != nil {
+		return Climb{}, err
+	}
+
+	elevation, err := f.callActivityTimeSeries(credentialID, tokens, elevationType, date, period)
+	if err != nil {
+		return Climb{}, err
+	}
+
+	return Climb{
+		Floors:    int(floors),
+		Elevation: elevation,
+	}, nil
+}
+
+// GetDailySummary reads every resource from the daily activity summary, including the heart rate zones
+func (f Fitbit) GetDailySummary(credentialID int, date time.Time) (DailySummary, error) {
+	dailyAct, err := f.getDailyActivity(credentialID, date)
+	if err != nil {
+		return DailySummary{}, err
+	}
+
+	summary := DailySummary{
+		Resources: []string{SummarySteps, SummaryCalories, SummaryDistance, SummaryActiveMinutes, SummaryFloors, SummaryElevation},
+		Steps:     dailyAct.Summary.Steps,
+		Calories:  dailyAct.Summary.Calories,
+		ActiveMinutes: ActiveMinutes{
+			Sedentary: dailyAct.Summary.SedentaryMinutes,
+			Lightly:   dailyAct.Summary.LightlyActiveMinutes,
+			Fairly:    dailyAct.Summary.FairlyActiveMinutes,
+			Very:      dailyAct.Summary.VeryActiveMinutes,
+		},
+		Climb: Climb{
+			Floors:    dailyAct.Summary.Floors,
+			Elevation: dailyAct.Summary.Elevation,
+		},
+	}
+
+	// The total distance of all the activities is in the first map
+	if len(dailyAct.Summary.Distance) > 0 {
+		summary.Distance, _ = dailyAct.Summary.Distance[0]["distance"].(float64)
+	}
+
+	if dailyAct.Summary.RestingHeartRate > 0 || len(dailyAct.Summary.HeartRateZones) > 0 {
+		summary.Resources = append(summary.Resources, SummaryHeartRate)
+		summary.HeartRate = HeartRate{
+			Resting:     dailyAct.Summary.RestingHeartRate,
+			ZoneMinutes: make(map[string]int),
+		}
+		for _, zone := range dailyAct.Summary.HeartRateZones {
+			summary.HeartRate.ZoneMinutes[zone.Name] += zone.Minutes
+		}
+	}
+
+	return summary, nil
+}
+
+func (f Fitbit) GetDistanceOverPeriod(credentialID int, date time.Time, period string) (float64, error) {
+	tokens, err := dal.GetCredentialTokens(f.db, credentialID)
 	if err != nil {
 		return 0, err
 	}
 
-	result, err := f.callActivityTimeSeries(user, tokens, distanceType, date, period)
+	result, err := f.callActivityTimeSeries(credentialID, tokens, distanceType, date, period)
 
 	if err != nil {
 		return 0, nil
@@ -108,24 +334,376 @@ func (f Fitbit) GetDistanceOverPeriod(user int, date time.Time, period string) (
 	return result, nil
 }
 
-func (f *Fitbit) callActivityTimeSeries(userID int, tokens *oauth2.Token, resourceType int, date time.Time, period string) (float64, error) {
-	// Get Access Token associated with user from db
-	newTokens, err := auth.RefreshOAuth2Tokens(tokens, f.authorization)
+func (f Fitbit) GetHeartRate(credentialID int, date time.Time) (HeartRate, error) {
+	return f.getHeartRateTimeSeries(credentialID, date, "1d")
+}
+
+func (f Fitbit) GetHeartRateOverPeriod(credentialID int, date time.Time, period string) (HeartRate, error) {
+	return f.getHeartRateTimeSeries(credentialID, date, period)
+}
+
+func (f Fitbit) GetSleep(credentialID int, date time.Time) (Sleep, error) {
+	tokens, err := dal.GetCredentialTokens(f.db, credentialID)
 	if err != nil {
-		return 0, err
+		return Sleep{}, err
+	}
+
+	newTokens, err := refreshTokens(f.db, f.log, f.authorization, credentialID, f.Name(), tokens)
+	if err != nil {
+		return Sleep{}, err
+	}
+
+	// The domain points to version 1 of the API
+	domain := f.domain
+	if strings.HasSuffix(domain, "/1") {
+		domain = strings.TrimSuffix(domain, "1") + fitbitSleepAPIVersion
 	}
 
-	if newTokens.AccessToken != tokens.AccessToken {
-		// Tokens were updated, let's update the database
-		err := dal.UpdateCredentialsUsingOAuth2Tokens(f.db, userID, newTokens)
+	url := fmt.Sprintf("%s/user/-/sleep/date/%s.json", domain, date.Format(helpers.ISOLayout))
+
+	// Tokens were refreshed. Now make the request
+	client := f.authorization.Client(context.Background(), newTokens)
+	resp, err := client.Get(url)
+	if err != nil {
+		return Sleep{}, err
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return Sleep{}, err
+	}
+	_ = resp.Body.Close()
+
+	sleepLog := dailySleep{}
+	err = json.Unmarshal(body, &sleepLog)
+	if err != nil {
+		return Sleep{}, err
+	}
+
+	result := Sleep{
+		MinutesAsleep: sleepLog.Summary.TotalMinutesAsleep,
+		MinutesInBed:  sleepLog.Summary.TotalTimeInBed,
+		StageMinutes:  make(map[string]int),
+		Efficiency:    sleepEfficiency(sleepLog.Summary.TotalMinutesAsleep, sleepLog.Summary.TotalTimeInBed),
+	}
+	for fitbitStage, minutes := range sleepLog.Summary.Stages {
+		if stage, ok := fitbitSleepStages[fitbitStage]; ok {
+			result.StageMinutes[stage] += minutes
+		}
+	}
+
+	return result, nil
+}
+
+// GetIntraday reads the intraday time series of the resource. Fitbit only gives it to apps it approved,
+// and has samples of every minute or every 15 minutes, which are grouped in the interval
+func (f Fitbit) GetIntraday(credentialID int, resource string, date time.Time, interval time.Duration) ([]Sample, error) {
+	endpoint, ok := fitbitIntradayEndpoints[resource]
+	if !ok {
+		return nil, ErrResourceUnsupported
+	}
+
+	detailLevel := "15min"
+	if interval%(15*time.Minute) != 0 {
+		detailLevel = "1min"
+	}
+
+	tokens, err := dal.GetCredentialTokens(f.db, credentialID)
+	if err != nil {
+		return nil, err
+	}
+
+	newTokens, err := refreshTokens(f.db, f.log, f.authorization, credentialID, f.Name(), tokens)
+	if err != nil {
+		return nil, err
+	}
+
+	// Tokens were refreshed. Now make the requests
+	client := f.authorization.Client(context.Background(), newTokens)
+
+	// Fitbit times intraday samples in the user's own time zone, and other platforms in UTC. The UTC day can
+	// span two of the user's days, so every one of them is read, and the samples outside the UTC day are dropped
+	location, err := f.getUserLocation(client)
+	if err != nil {
+		return nil, err
+	}
+
+	firstDay := truncateToDay(date.In(location))
+	lastDay := truncateToDay(date.Add(24*time.Hour - time.Nanosecond).In(location))
+
+	var samples []Sample
+	for day := firstDay; !day.After(lastDay); day = day.AddDate(0, 0, 1) {
+		daySamples, err := f.getIntradayDay(client, endpoint, resource, day, detailLevel)
 		if err != nil {
-			return 0, errors.New("failed to update db with new oauth2 tokens: " + err.Error())
+			return nil, err
 		}
 
-		f.log.WithFields(logrus.Fields{
-			"user":   userID,
-			"expiry": newTokens.Expiry,
-		}).Info("updated access token")
+		samples = append(samples, daySamples...)
+	}
+
+	return bucketSamples(samples, date, interval, resource), nil
+}
+
+// getIntradayDay reads the intraday samples of one of the user's days
+func (f Fitbit) getIntradayDay(client *http.Client, endpoint string, resource string, day time.Time, detailLevel string) ([]Sample, error) {
+	url := fmt.Sprintf("%s/user/-/%s/date/%s/1d/%s.json", f.domain, endpoint, day.Format(helpers.ISOLayout), detailLevel)
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	_ = resp.Body.Close()
+
+	// The daily summary comes along with the intraday dataset, so only the dataset is unmarshalled
+	var data map[string]json.RawMessage
+	if err := json.Unmarshal(body, &data); err != nil {
+		return nil, err
+	}
+
+	rawDataset, ok := data[strings.ReplaceAll(endpoint, "/", "-")+"-intraday"]
+	if !ok {
+		return nil, errors.New("fitbit did not return intraday data for " + resource)
+	}
+
+	var dataset intradayDataset
+	if err := json.Unmarshal(rawDataset, &dataset); err != nil {
+		return nil, err
+	}
+
+	var samples []Sample
+	for _, sample := range dataset.Dataset {
+		sampleTime, err := time.ParseInLocation(
+			helpers.ISOLayout+" 15:04:05", day.Format(helpers.ISOLayout)+" "+sample.Time, day.Location(),
+		)
+		if err != nil {
+			f.log.WithFields(logrus.Fields{
+				"time": sample.Time,
+				"err":  err,
+			}).Error("bad intraday sample time received from Fitbit")
+			continue
+		}
+
+		samples = append(samples, Sample{
+			Time:  sampleTime.UTC(),
+			Value: sample.Value,
+		})
+	}
+
+	return samples, nil
+}
+
+// truncateToDay returns the midnight that starts the day of t, in t's location
+func truncateToDay(t time.Time) time.Time {
+	year, month, day := t.Date()
+	return time.Date(year, month, day, 0, 0, 0, 0, t.Location())
+}
+
+// getUserLocation reads the user's time zone from their profile. When the zone isn't known to mrthn,
+// the profile's current offset from UTC is used instead
+func (f Fitbit) getUserLocation(client *http.Client) (*time.Location, error) {
+	resp, err := client.Get(f.domain + "/user/-/profile.json")
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	_ = resp.Body.Close()
+
+	var profile fitbitProfile
+	if err := json.Unmarshal(body, &profile); err != nil {
+		return nil, err
+	}
+
+	if profile.User.Timezone != "" {
+		location, err := time.LoadLocation(profile.User.Timezone)
+		if err == nil {
+			return location, nil
+		}
+	}
+
+	return time.FixedZone(profile.User.Timezone, int(profile.User.OffsetFromUTCMillis/1000)), nil
+}
+
+// GetWorkouts reads the activity log, following its pages until it reaches the end of the range
+func (f Fitbit) GetWorkouts(credentialID int, start time.Time, end time.Time) ([]Workout, error) {
+	tokens, err := dal.GetCredentialTokens(f.db, credentialID)
+	if err != nil {
+		return nil, err
+	}
+
+	newTokens, err := refreshTokens(f.db, f.log, f.authorization, credentialID, f.Name(), tokens)
+	if err != nil {
+		return nil, err
+	}
+
+	// Tokens were refreshed. Now make the requests
+	client := f.authorization.Client(context.Background(), newTokens)
+
+	url := fmt.Sprintf("%s/user/-/%s.json?afterDate=%s&sort=asc&offset=0&limit=%d",
+		f.domain, fitbitActivityLogEndpoint, start.Format("2006-01-02T15:04:05"), fitbitActivityLogLimit)
+
+	var workouts []Workout
+	for url != "" {
+		resp, err := client.Get(url)
+		if err != nil {
+			return nil, err
+		}
+
+		body, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			return nil, err
+		}
+		_ = resp.Body.Close()
+
+		var logList activityLogList
+		if err := json.Unmarshal(body, &logList); err != nil {
+			return nil, err
+		}
+
+		url = logList.Pagination.Next
+		for _, activity := range logList.Activities {
+			startTime, err := time.Parse(time.RFC3339, activity.StartTime)
+			if err != nil {
+				f.log.WithFields(logrus.Fields{
+					"startTime": activity.StartTime,
+					"err":       err,
+				}).Error("bad activity start time received from Fitbit")
+				continue
+			}
+
+			// The log is sorted by start time, so the rest of it is past the range
+			if !startTime.Before(end) {
+				url = ""
+				break
+			}
+
+			workoutType, ok := fitbitWorkoutTypes[activity.ActivityName]
+			if !ok {
+				workoutType = WorkoutOther
+			}
+
+			workouts = append(workouts, Workout{
+				Type:             workoutType,
+				Name:             activity.ActivityName,
+				Start:            startTime,
+				Duration:         time.Duration(activity.Duration) * time.Millisecond,
+				Distance:         activity.Distance,
+				Calories:         activity.Calories,
+				AverageHeartRate: activity.AverageHeartRate,
+			})
+		}
+
+		if len(logList.Activities) == 0 {
+			break
+		}
+	}
+
+	return workouts, nil
+}
+
+// GetBodyMeasurements reads the user's weight or body fat log. Periods longer than a month are requested a month at a time
+func (f Fitbit) GetBodyMeasurements(credentialID int, measurement string, date time.Time, period string) ([]BodyMeasurement, error) {
+	logName, ok := fitbitBodyLogs[measurement]
+	if !ok {
+		return nil, ErrResourceUnsupported
+	}
+
+	start, end, err := periodRange(date, period)
+	if err != nil {
+		return nil, err
+	}
+
+	tokens, err := dal.GetCredentialTokens(f.db, credentialID)
+	if err != nil {
+		return nil, err
+	}
+
+	newTokens, err := refreshTokens(f.db, f.log, f.authorization, credentialID, f.Name(), tokens)
+	if err != nil {
+		return nil, err
+	}
+
+	// Tokens were refreshed. Now make the requests
+	client := f.authorization.Client(context.Background(), newTokens)
+
+	var measurements []BodyMeasurement
+	lastDay := end.AddDate(0, 0, -1)
+	for from := start; !from.After(lastDay); from = from.AddDate(0, 0, fitbitMaxBodyLogDays) {
+		to := from.AddDate(0, 0, fitbitMaxBodyLogDays-1)
+		if to.After(lastDay) {
+			to = lastDay
+		}
+
+		url := fmt.Sprintf("%s/user/-/%s/%s/date/%s/%s.json",
+			f.domain, fitbitBodyLogEndpoint, logName, from.Format(helpers.ISOLayout), to.Format(helpers.ISOLayout))
+
+		resp, err := client.Get(url)
+		if err != nil {
+			return nil, err
+		}
+
+		body, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			return nil, err
+		}
+		_ = resp.Body.Close()
+
+		var data map[string][]bodyLog
+		if err := json.Unmarshal(body, &data); err != nil {
+			return nil, err
+		}
+
+		for _, entry := range data[logName] {
+			// Fitbit logs are in the user's own time zone, which isn't given
+			measuredAt, err := time.Parse(helpers.ISOLayout+" 15:04:05", entry.Date+" "+entry.Time)
+			if err != nil {
+				f.log.WithFields(logrus.Fields{
+					"date": entry.Date,
+					"time": entry.Time,
+					"err":  err,
+				}).Error("bad body log time received from Fitbit")
+				continue
+			}
+
+			if measuredAt.Before(start) || !measuredAt.Before(end) {
+				continue
+			}
+
+			value := entry.Weight
+			switch measurement {
+			case BodyBMI:
+				value = entry.BMI
+			case BodyFat:
+				value = entry.Fat
+			}
+
+			measurements = append(measurements, BodyMeasurement{
+				Value:      value,
+				MeasuredAt: measuredAt,
+			})
+		}
+	}
+
+	return measurements, nil
+}
+
+func (f Fitbit) RevokeAccess(tokens *oauth2.Token) error {
+	// Revoking the refresh token also revokes every access token issued with it
+	return sendRevokeRequest(f.revokeURL, url.Values{"token": {tokens.RefreshToken}}, f.authorization, true)
+}
+
+func (f *Fitbit) callActivityTimeSeries(credentialID int, tokens *oauth2.Token, resourceType int, date time.Time, period string) (float64, error) {
+	// Get Access Token associated with user from db
+	newTokens, err := refreshTokens(f.db, f.log, f.authorization, credentialID, f.Name(), tokens)
+	if err != nil {
+		return 0, err
 	}
 
 	// Form the activity series URL
@@ -170,9 +748,9 @@ func (f *Fitbit) callActivityTimeSeries(userID int, tokens *oauth2.Token, resour
 	return totalValue, nil
 }
 
-func (f Fitbit) getDailyActivity(userID int, date time.Time) (dailyActivity, error) {
+func (f Fitbit) getDailyActivity(credentialID int, date time.Time) (dailyActivity, error) {
 	// Get Access Token associated with user from db
-	tokens, err := dal.GetUserTokens(f.db, userID, f.Name())
+	tokens, err := dal.GetCredentialTokens(f.db, credentialID)
 	if err != nil {
 		return dailyActivity{}, err
 	}
@@ -180,7 +758,7 @@ func (f Fitbit) getDailyActivity(userID int, date time.Time) (dailyActivity, err
 	// Call fitbit endpoint passing access token and date
 	dailyAct, err := f.callDailyActivityEndpoint(
 		f.domain+"/user/-/activities/date",
-		userID,
+		credentialID,
 		tokens,
 		date,
 	)
@@ -190,27 +768,14 @@ func (f Fitbit) getDailyActivity(userID int, date time.Time) (dailyActivity, err
 	return dailyAct, nil
 }
 
-func (f *Fitbit) callDailyActivityEndpoint(url string, userID int, tokens *oauth2.Token, date time.Time) (dailyActivity, error) {
+func (f *Fitbit) callDailyActivityEndpoint(url string, credentialID int, tokens *oauth2.Token, date time.Time) (dailyActivity, error) {
 	// Add date to end of the Daily Activity URL
 	url = fmt.Sprintf("%s/%s.json", url, date.Format(helpers.ISOLayout))
-	newTokens, err := auth.RefreshOAuth2Tokens(tokens, f.authorization)
+	newTokens, err := refreshTokens(f.db, f.log, f.authorization, credentialID, f.Name(), tokens)
 	if err != nil {
 		return dailyActivity{}, err
 	}
 
-	if newTokens.AccessToken != tokens.AccessToken {
-		// Tokens were updated, let's update the database
-		err := dal.UpdateCredentialsUsingOAuth2Tokens(f.db, userID, newTokens)
-		if err != nil {
-			return dailyActivity{}, errors.New("failed to update db with new oauth2 tokens: " + err.Error())
-		}
-
-		f.log.WithFields(logrus.Fields{
-			"user":   userID,
-			"expiry": newTokens.Expiry,
-		}).Info("updated access token")
-	}
-
 	// Tokens were refreshed. Now make the request
 	client := f.authorization.Client(context.Background(), newTokens)
 	resp, err := client.Get(url)
@@ -244,3 +809,56 @@ func (f *Fitbit) callDailyActivityEndpoint(url string, userID int, tokens *oauth
 
 	return dailyAct, nil
 }
+
+// getHeartRateTimeSeries averages the resting heart rate of every day in the period, and adds up the time spent in each zone.
+func (f Fitbit) getHeartRateTimeSeries(credentialID int, date time.Time, period string) (HeartRate, error) {
+	tokens, err := dal.GetCredentialTokens(f.db, credentialID)
+	if err != nil {
+		return HeartRate{}, err
+	}
+
+	newTokens, err := refreshTokens(f.db, f.log, f.authorization, credentialID, f.Name(), tokens)
+	if err != nil {
+		return HeartRate{}, err
+	}
+
+	url := fmt.Sprintf("%s/user/-/%s/date/%s/%s.json", f.domain, fitbitHeartRateEndpoint, date.Format(helpers.ISOLayout), period)
+
+	// Tokens were refreshed. Now make the request
+	client := f.authorization.Client(context.Background(), newTokens)
+	resp, err := client.Get(url)
+	if err != nil {
+		return HeartRate{}, err
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return HeartRate{}, err
+	}
+	_ = resp.Body.Close()
+
+	var data map[string][]dailyHeartRate
+	if err := json.Unmarshal(body, &data); err != nil {
+		return HeartRate{}, err
+	}
+
+	result := HeartRate{ZoneMinutes: make(map[string]int)}
+	restingDays := 0
+	for _, day := range data["activities-heart"] {
+		// Days without enough data have no resting heart rate
+		if day.Value.RestingHeartRate > 0 {
+			result.Resting += day.Value.RestingHeartRate
+			restingDays++
+		}
+
+		for _, zone := range day.Value.HeartRateZones {
+			result.ZoneMinutes[zone.Name] += zone.Minutes
+		}
+	}
+
+	if restingDays > 0 {
+		result.Resting /= float64(restingDays)
+	}
+
+	return result, nil
+}
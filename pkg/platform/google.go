@@ -6,10 +6,12 @@ import (
 	"database/sql"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io/ioutil"
+	"net/url"
+	"strconv"
 	"time"
 
-	"github.com/msgurgel/mrthn/pkg/auth"
 	"github.com/msgurgel/mrthn/pkg/dal"
 
 	"github.com/sirupsen/logrus"
@@ -20,6 +22,7 @@ type Google struct {
 	db            *sql.DB
 	log           *logrus.Logger
 	domain        string
+	revokeURL     string
 	authorization *oauth2.Config
 }
 
@@ -30,13 +33,80 @@ const googleFitEndpoint string = "/users/me/dataset:aggregate"
 const aggregatedStepsID string = "derived:com.google.step_count.delta:com.google.android.gms:estimated_steps"
 const aggregatedCaloriesID string = "derived:com.google.calories.expended:com.google.android.gms:merge_calories_expended"
 const aggregatedDistanceID string = "derived:com.google.distance.delta:com.google.android.gms:merge_distance_delta"
+const aggregatedHeartRateID string = "derived:com.google.heart_rate.bpm:com.google.android.gms:merge_heart_rate_bpm"
+const aggregatedActiveMinutesID string = "derived:com.google.active_minutes:com.google.android.gms:merge_active_minutes"
+const aggregatedHeartMinutesID string = "derived:com.google.heart_minutes:com.google.android.gms:merge_heart_minutes"
+const mergedWeightID string = "derived:com.google.weight:com.google.android.gms:merge_weight"
+const mergedHeightID string = "derived:com.google.height:com.google.android.gms:merge_height"
+const mergedBodyFatID string = "derived:com.google.body.fat.percentage:com.google.android.gms:merge_body_fat_percentage"
+
+// googleIntradayDataSources maps intraday resources to the Google Fit data sources they are aggregated from
+var googleIntradayDataSources = map[string]string{
+	IntradaySteps:     aggregatedStepsID,
+	IntradayCalories:  aggregatedCaloriesID,
+	IntradayHeartRate: aggregatedHeartRateID,
+}
+
+// googleWorkoutTypes maps the Google Fit activity types to workout types
+var googleWorkoutTypes = map[int]string{
+	1:   WorkoutRide, // Biking
+	7:   WorkoutWalk,
+	8:   WorkoutRun,
+	14:  WorkoutRide, // Biking variants, such as road and stationary biking
+	15:  WorkoutRide,
+	16:  WorkoutRide,
+	17:  WorkoutRide,
+	18:  WorkoutRide,
+	19:  WorkoutRide,
+	35:  WorkoutHike,
+	56:  WorkoutRun, // Jogging
+	57:  WorkoutRun, // Running on sand
+	58:  WorkoutRun, // Running on a treadmill
+	80:  WorkoutStrength,
+	82:  WorkoutSwim,
+	83:  WorkoutSwim,
+	84:  WorkoutSwim,
+	93:  WorkoutWalk, // Walking variants, such as fitness walking
+	94:  WorkoutWalk,
+	95:  WorkoutWalk,
+	100: WorkoutYoga,
+}
+
+// googleBodyDataSources maps body measurements to the Google Fit data sources they are read from.
+// The BMI isn't tracked, so it's worked out from the weight and height
+var googleBodyDataSources = map[string]string{
+	BodyWeight: mergedWeightID,
+	BodyFat:    mergedBodyFatID,
+}
 
 const millisecondsInADay int64 = 86400000
 
+// Sleep segments are aggregated by data type, since they come from whatever app the user tracks sleep with
+const sleepSegmentType string = "com.google.sleep.segment"
+
+// Sleep stages of Google Fit sleep segments
+const (
+	googleSleepStageAwake    = 1
+	googleSleepStageAsleep   = 2 // Sleep of an unknown stage
+	googleSleepStageOutOfBed = 3
+	googleSleepStageLight    = 4
+	googleSleepStageDeep     = 5
+	googleSleepStageREM      = 6
+)
+
+// googleSleepStages maps the sleep stages of Google Fit sleep segments to mrthn's
+var googleSleepStages = map[int]string{
+	googleSleepStageAwake: SleepStageAwake,
+	googleSleepStageLight: SleepStageLight,
+	googleSleepStageDeep:  SleepStageDeep,
+	googleSleepStageREM:   SleepStageREM,
+}
+
 // GoogleFitRequest is a struct for sending a Google request
 type GoogleFitRequest struct {
 	AggregateBy     []map[string]string `json:"aggregateBy"`
-	BucketByTime    map[string]int64    `json:"bucketByTime"`
+	BucketByTime    map[string]int64    `json:"bucketByTime,omitempty"`
+	BucketBySession map[string]int64    `json:"bucketBySession,omitempty"` // Makes a bucket of every session, instead of fixed periods
 	StartTimeMillis int64               `json:"startTimeMillis"`
 	EndTimeMillis   int64               `json:"endTimeMillis"`
 }
@@ -61,11 +131,23 @@ type DataSet struct {
 }
 
 type Point struct {
-	Values GoogleValuesResponse `json:"value,omitempty"`
+	StartTimeNanos string               `json:"startTimeNanos,omitempty"`
+	EndTimeNanos   string               `json:"endTimeNanos,omitempty"`
+	Values         GoogleValuesResponse `json:"value,omitempty"`
+}
+
+// GoogleFitSession is a workout the user recorded in Google Fit
+type GoogleFitSession struct {
+	Name            string `json:"name"`
+	ActivityType    int    `json:"activityType"`
+	StartTimeMillis string `json:"startTimeMillis"`
+	EndTimeMillis   string `json:"endTimeMillis"`
 }
 
 type Bucket struct {
-	Datasets []DataSet `json:"dataset"`
+	StartTimeMillis string            `json:"startTimeMillis,omitempty"`
+	Session         *GoogleFitSession `json:"session,omitempty"` // Only for buckets made by session
+	Datasets        []DataSet         `json:"dataset"`
 }
 
 type Error struct {
@@ -78,12 +160,18 @@ type GoogleFitWholeResponse struct {
 	Error   Error    `json:"error,omitempty"`
 }
 
+// GoogleFitDatasetResponse holds the raw data points of a data source, instead of an aggregate of them
+type GoogleFitDatasetResponse struct {
+	DataSet
+	Error Error `json:"error,omitempty"`
+}
+
 func (g Google) Name() string {
 	return "google"
 }
 
-func (g Google) GetSteps(userID int, date time.Time) (int, error) {
-	response, err := g.makeGoogleFitRequest(userID, date, aggregatedStepsID, "")
+func (g Google) GetSteps(credentialID int, date time.Time) (int, error) {
+	response, err := g.makeGoogleFitRequest(credentialID, date, aggregatedStepsID, "")
 	if err != nil {
 		return 0, err
 	}
@@ -98,8 +186,8 @@ func (g Google) GetSteps(userID int, date time.Time) (int, error) {
 
 }
 
-func (g Google) GetCalories(userID int, date time.Time) (int, error) {
-	response, err := g.makeGoogleFitRequest(userID, date, aggregatedCaloriesID, "")
+func (g Google) GetCalories(credentialID int, date time.Time) (int, error) {
+	response, err := g.makeGoogleFitRequest(credentialID, date, aggregatedCaloriesID, "")
 
 	if err != nil {
 		return 0, err
@@ -113,8 +201,8 @@ func (g Google) GetCalories(userID int, date time.Time) (int, error) {
 	return int(intValue.(float64)), nil
 }
 
-func (g Google) GetDistance(userID int, date time.Time) (float64, error) {
-	response, err := g.makeGoogleFitRequest(userID, date, aggregatedDistanceID, "")
+func (g Google) GetDistance(credentialID int, date time.Time) (float64, error) {
+	response, err := g.makeGoogleFitRequest(credentialID, date, aggregatedDistanceID, "")
 	if err != nil {
 		return 0, err
 	}
@@ -128,8 +216,8 @@ func (g Google) GetDistance(userID int, date time.Time) (float64, error) {
 	return floatValue.(float64) / 1000, nil
 }
 
-func (g Google) GetDistanceOverPeriod(userID int, date time.Time, period string) (float64, error) {
-	response, err := g.makeGoogleFitRequest(userID, date, aggregatedDistanceID, period)
+func (g Google) GetDistanceOverPeriod(credentialID int, date time.Time, period string) (float64, error) {
+	response, err := g.makeGoogleFitRequest(credentialID, date, aggregatedDistanceID, period)
 	if err != nil {
 		return 0, err
 	}
@@ -143,34 +231,392 @@ func (g Google) GetDistanceOverPeriod(userID int, date time.Time, period string)
 	return floatValue.(float64) / 1000, nil
 }
 
-func (g Google) makeGoogleFitRequest(userID int, date time.Time, dataSourceID string, period string) (GoogleValuesResponse, error) {
-	// Get Access Token associated with user from db
-	tokens, err := dal.GetUserTokens(g.db, userID, g.Name())
+func (g Google) GetHeartRate(credentialID int, date time.Time) (HeartRate, error) {
+	return g.getHeartRate(credentialID, date, "")
+}
 
-	// Before we can make the request, refresh the access tokens
-	newTokens, err := auth.RefreshOAuth2Tokens(tokens, g.authorization)
+func (g Google) GetHeartRateOverPeriod(credentialID int, date time.Time, period string) (HeartRate, error) {
+	return g.getHeartRate(credentialID, date, period)
+}
+
+// GetActiveMinutes combines active minutes with heart points. Moderate activity earns one heart point a minute,
+// and vigorous activity earns two, so the heart minutes can be split in fairly and very active minutes.
+// The rest of the active minutes are lightly active. Google Fit doesn't track sedentary time
+func (g Google) GetActiveMinutes(credentialID int, date time.Time) (ActiveMinutes, error) {
+	activeResponse, err := g.makeGoogleFitRequest(credentialID, date, aggregatedActiveMinutesID, "")
 	if err != nil {
-		return GoogleValuesResponse{}, err
+		return ActiveMinutes{}, err
+	}
+
+	heartResponse, err := g.makeGoogleFitRequest(credentialID, date, aggregatedHeartMinutesID, "")
+	if err != nil {
+		return ActiveMinutes{}, err
+	}
+
+	return googleActiveMinutes(activeResponse, heartResponse), nil
+}
+
+// GetDailySummary aggregates every data source of the summary in one request, with a bucket for the day.
+// Google Fit doesn't track floors or elevation gain
+func (g Google) GetDailySummary(credentialID int, date time.Time) (DailySummary, error) {
+	startTimeMillis := date.UnixNano() / 1000000
+	responseValue, err := g.sendGoogleFitRequest(credentialID, GoogleFitRequest{
+		AggregateBy: []map[string]string{
+			{"dataSourceId": aggregatedStepsID},
+			{"dataSourceId": aggregatedCaloriesID},
+			{"dataSourceId": aggregatedDistanceID},
+			{"dataSourceId": aggregatedHeartRateID},
+			{"dataSourceId": aggregatedActiveMinutesID},
+			{"dataSourceId": aggregatedHeartMinutesID},
+		},
+		BucketByTime:    map[string]int64{"durationMillis": millisecondsInADay},
+		StartTimeMillis: startTimeMillis,
+		EndTimeMillis:   startTimeMillis + millisecondsInADay,
+	})
+	if err != nil {
+		return DailySummary{}, err
+	}
+
+	if responseValue.Error.Message != "" {
+		return DailySummary{}, errors.New("failed to request daily summary: " + responseValue.Error.Message)
+	}
+
+	summary := DailySummary{
+		Resources: []string{SummarySteps, SummaryCalories, SummaryDistance, SummaryHeartRate, SummaryActiveMinutes},
+	}
+	if len(responseValue.Buckets) < 1 {
+		return summary, nil
+	}
+
+	// The datasets are in the order they were asked for
+	bucket := responseValue.Buckets[0]
+	if values := datasetValues(bucket, 0); len(values) > 0 {
+		steps, _ := values[0]["intVal"].(float64)
+		summary.Steps = int(steps)
+	}
+	if values := datasetValues(bucket, 1); len(values) > 0 {
+		calories, _ := values[0]["fpVal"].(float64)
+		summary.Calories = int(calories)
+	}
+	if values := datasetValues(bucket, 2); len(values) > 0 {
+		distance, _ := values[0]["fpVal"].(float64)
+		summary.Distance = distance / 1000 // Google Fit returns meters when we want km
+	}
+	summary.HeartRate = googleHeartRate(datasetValues(bucket, 3))
+	summary.ActiveMinutes = googleActiveMinutes(datasetValues(bucket, 4), datasetValues(bucket, 5))
+
+	return summary, nil
+}
+
+// GetSleep adds up the sleep segments between noon of the day before and noon of the date
+func (g Google) GetSleep(credentialID int, date time.Time) (Sleep, error) {
+	startTimeMillis := date.UnixNano()/1000000 - millisecondsInADay/2
+
+	responseValue, err := g.sendGoogleFitRequest(credentialID, GoogleFitRequest{
+		AggregateBy:     []map[string]string{{"dataTypeName": sleepSegmentType}},
+		BucketByTime:    map[string]int64{"durationMillis": millisecondsInADay},
+		StartTimeMillis: startTimeMillis,
+		EndTimeMillis:   startTimeMillis + millisecondsInADay,
+	})
+	if err != nil {
+		return Sleep{}, err
+	}
+
+	if responseValue.Error.Message != "" {
+		return Sleep{}, errors.New("failed to request sleep segments: " + responseValue.Error.Message)
+	}
+
+	result := Sleep{StageMinutes: make(map[string]int)}
+	for _, bucket := range responseValue.Buckets {
+		for _, dataset := range bucket.Datasets {
+			for _, point := range dataset.Points {
+				if len(point.Values) < 1 {
+					continue
+				}
+
+				stageValue, _ := point.Values[0]["intVal"].(float64)
+				stage := int(stageValue)
+				if stage == googleSleepStageOutOfBed {
+					continue
+				}
+
+				minutes, err := segmentMinutes(point)
+				if err != nil {
+					return Sleep{}, err
+				}
+
+				result.MinutesInBed += minutes
+				if name, ok := googleSleepStages[stage]; ok {
+					result.StageMinutes[name] += minutes
+				}
+
+				// Every other stage in bed is a stage of sleep
+				if stage != googleSleepStageAwake {
+					result.MinutesAsleep += minutes
+				}
+			}
+		}
+	}
+
+	result.Efficiency = sleepEfficiency(result.MinutesAsleep, result.MinutesInBed)
+
+	return result, nil
+}
+
+// GetClimb isn't supported, as Google Fit doesn't track floors or elevation gain
+func (g Google) GetClimb(credentialID int, date time.Time) (Climb, error) {
+	return Climb{}, ErrResourceUnsupported
+}
+
+func (g Google) GetClimbOverPeriod(credentialID int, date time.Time, period string) (Climb, error) {
+	return Climb{}, ErrResourceUnsupported
+}
+
+// GetIntraday aggregates the resource in buckets as long as the interval, instead of a single bucket for the day
+func (g Google) GetIntraday(credentialID int, resource string, date time.Time, interval time.Duration) ([]Sample, error) {
+	dataSourceID, ok := googleIntradayDataSources[resource]
+	if !ok {
+		return nil, ErrResourceUnsupported
+	}
+
+	startTimeMillis := date.UnixNano() / 1000000
+	responseValue, err := g.sendGoogleFitRequest(credentialID, GoogleFitRequest{
+		AggregateBy:     []map[string]string{{"dataSourceId": dataSourceID}},
+		BucketByTime:    map[string]int64{"durationMillis": int64(interval / time.Millisecond)},
+		StartTimeMillis: startTimeMillis,
+		EndTimeMillis:   startTimeMillis + millisecondsInADay,
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	if newTokens.AccessToken != tokens.AccessToken {
-		// Tokens were updated, let's update the database
-		err := dal.UpdateCredentialsUsingOAuth2Tokens(g.db, userID, newTokens)
+	if responseValue.Error.Message != "" {
+		return nil, errors.New("failed to request intraday " + resource + ": " + responseValue.Error.Message)
+	}
+
+	// Steps are counted in integers. Calories are floats, and so is the average heart rate, which comes first in its summary
+	valueKey := "fpVal"
+	if resource == IntradaySteps {
+		valueKey = "intVal"
+	}
+
+	var samples []Sample
+	for _, bucket := range responseValue.Buckets {
+		values := datasetValues(bucket, 0)
+		if len(values) < 1 {
+			continue
+		}
+
+		bucketStart, err := strconv.ParseInt(bucket.StartTimeMillis, 10, 64)
 		if err != nil {
-			return GoogleValuesResponse{}, errors.New("failed to update db with new oauth2 tokens: " + err.Error())
+			return nil, err
 		}
 
-		g.log.WithFields(logrus.Fields{
-			"user":   userID,
-			"expiry": newTokens.Expiry,
-		}).Info("updated access token")
+		value, _ := values[0][valueKey].(float64)
+		samples = append(samples, Sample{
+			Time:  time.Unix(0, bucketStart*int64(time.Millisecond)).UTC(),
+			Value: value,
+		})
 	}
 
-	// Tokens were refreshed. Prepare to make the request.
+	return samples, nil
+}
+
+// GetWorkouts reads the user's sessions, along with the distance, calories and heart rate recorded during each of them
+func (g Google) GetWorkouts(credentialID int, start time.Time, end time.Time) ([]Workout, error) {
+	responseValue, err := g.sendGoogleFitRequest(credentialID, GoogleFitRequest{
+		AggregateBy: []map[string]string{
+			{"dataSourceId": aggregatedDistanceID},
+			{"dataSourceId": aggregatedCaloriesID},
+			{"dataSourceId": aggregatedHeartRateID},
+		},
+		BucketBySession: map[string]int64{"minDurationMillis": 0},
+		StartTimeMillis: start.UnixNano() / 1000000,
+		EndTimeMillis:   end.UnixNano() / 1000000,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if responseValue.Error.Message != "" {
+		return nil, errors.New("failed to request sessions: " + responseValue.Error.Message)
+	}
+
+	var workouts []Workout
+	for _, bucket := range responseValue.Buckets {
+		if bucket.Session == nil {
+			continue
+		}
+
+		startMillis, err := strconv.ParseInt(bucket.Session.StartTimeMillis, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+
+		endMillis, err := strconv.ParseInt(bucket.Session.EndTimeMillis, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+
+		workoutType, ok := googleWorkoutTypes[bucket.Session.ActivityType]
+		if !ok {
+			workoutType = WorkoutOther
+		}
+
+		workout := Workout{
+			Type:     workoutType,
+			Name:     bucket.Session.Name,
+			Start:    time.Unix(0, startMillis*int64(time.Millisecond)).UTC(),
+			Duration: time.Duration(endMillis-startMillis) * time.Millisecond,
+		}
+
+		// The datasets are in the order they were asked for
+		if values := datasetValues(bucket, 0); len(values) > 0 {
+			distance, _ := values[0]["fpVal"].(float64)
+			workout.Distance = distance / 1000 // Google Fit returns meters when we want km
+		}
+		if values := datasetValues(bucket, 1); len(values) > 0 {
+			calories, _ := values[0]["fpVal"].(float64)
+			workout.Calories = int(calories)
+		}
+		if values := datasetValues(bucket, 2); len(values) > 0 {
+			workout.AverageHeartRate, _ = values[0]["fpVal"].(float64)
+		}
+
+		workouts = append(workouts, workout)
+	}
+
+	return workouts, nil
+}
+
+func (g Google) GetBodyMeasurements(credentialID int, measurement string, date time.Time, period string) ([]BodyMeasurement, error) {
+	start, end, err := periodRange(date, period)
+	if err != nil {
+		return nil, err
+	}
+
+	if measurement == BodyBMI {
+		return g.getBMI(credentialID, start, end)
+	}
+
+	dataSourceID, ok := googleBodyDataSources[measurement]
+	if !ok {
+		return nil, ErrResourceUnsupported
+	}
+
+	return g.getBodyDataset(credentialID, dataSourceID, start, end)
+}
+
+func (g Google) RevokeAccess(tokens *oauth2.Token) error {
+	// Revoking the refresh token also revokes every access token issued with it
+	return sendRevokeRequest(g.revokeURL, url.Values{"token": {tokens.RefreshToken}}, g.authorization, false)
+}
+
+// getHeartRate reads the com.google.heart_rate.summary aggregate of the heart rate samples in the period
+func (g Google) getHeartRate(credentialID int, date time.Time, period string) (HeartRate, error) {
+	response, err := g.makeGoogleFitRequest(credentialID, date, aggregatedHeartRateID, period)
+	if err != nil {
+		return HeartRate{}, err
+	}
+
+	return googleHeartRate(response), nil
+}
+
+// getBMI works the BMI out from every weight in the period, and the latest height measured before it.
+// Heights from before the period are read too, since users seldom measure their height
+func (g Google) getBMI(credentialID int, start time.Time, end time.Time) ([]BodyMeasurement, error) {
+	weights, err := g.getBodyDataset(credentialID, mergedWeightID, start, end)
+	if err != nil {
+		return nil, err
+	}
+
+	heights, err := g.getBodyDataset(credentialID, mergedHeightID, time.Unix(0, 0), end)
+	if err != nil {
+		return nil, err
+	}
+
+	var measurements []BodyMeasurement
+	for _, weight := range weights {
+		var height BodyMeasurement
+		for _, h := range heights {
+			if !h.MeasuredAt.After(weight.MeasuredAt) && h.MeasuredAt.After(height.MeasuredAt) {
+				height = h
+			}
+		}
+
+		// Heights are in meters
+		if height.Value > 0 {
+			measurements = append(measurements, BodyMeasurement{
+				Value:      weight.Value / (height.Value * height.Value),
+				MeasuredAt: weight.MeasuredAt,
+			})
+		}
+	}
+
+	return measurements, nil
+}
+
+// getBodyDataset reads every point of a body measurement data source in the period
+func (g Google) getBodyDataset(credentialID int, dataSourceID string, start time.Time, end time.Time) ([]BodyMeasurement, error) {
+	// Get Access Token associated with user from db
+	tokens, err := dal.GetCredentialTokens(g.db, credentialID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Before we can make the request, refresh the access tokens
+	newTokens, err := refreshTokens(g.db, g.log, g.authorization, credentialID, g.Name(), tokens)
+	if err != nil {
+		return nil, err
+	}
+
+	// Tokens were refreshed. Now make the request
 	client := g.authorization.Client(context.Background(), newTokens)
 
-	url := g.domain + googleFitEndpoint
+	url := fmt.Sprintf("%s/users/me/dataSources/%s/datasets/%d-%d", g.domain, dataSourceID, start.UnixNano(), end.UnixNano())
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
 
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	_ = resp.Body.Close()
+
+	responseValue := GoogleFitDatasetResponse{}
+	err = json.Unmarshal(body, &responseValue)
+	if err != nil {
+		return nil, err
+	}
+
+	if responseValue.Error.Message != "" {
+		return nil, errors.New("failed to request " + dataSourceID + ": " + responseValue.Error.Message)
+	}
+
+	var measurements []BodyMeasurement
+	for _, point := range responseValue.Points {
+		if len(point.Values) < 1 {
+			continue
+		}
+
+		measuredAt, err := strconv.ParseInt(point.StartTimeNanos, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+
+		value, _ := point.Values[0]["fpVal"].(float64)
+		measurements = append(measurements, BodyMeasurement{
+			Value:      value,
+			MeasuredAt: time.Unix(0, measuredAt).UTC(),
+		})
+	}
+
+	return measurements, nil
+}
+
+func (g Google) makeGoogleFitRequest(credentialID int, date time.Time, dataSourceID string, period string) (GoogleValuesResponse, error) {
 	UnixTimeDateInt := date.UnixNano() / 1000000
 
 	var UnixTimeLimit int64
@@ -205,24 +651,60 @@ func (g Google) makeGoogleFitRequest(userID int, date time.Time, dataSourceID st
 	dataSourceMap["dataSourceId"] = dataSourceID
 	aggregateBy[0] = dataSourceMap
 
-	// Need to create the request body
-	requestBody, err := json.Marshal(GoogleFitRequest{
+	responseValue, err := g.sendGoogleFitRequest(credentialID, GoogleFitRequest{
 		AggregateBy:     aggregateBy,
 		BucketByTime:    bucketByTime,
 		StartTimeMillis: UnixTimeDateInt,
 		EndTimeMillis:   UnixTimeLimit,
 	})
+	if err != nil {
+		return GoogleValuesResponse{}, err
+	}
+
+	// First, check if there was an error in the response
+	if responseValue.Error.Message != "" {
+		return nil, nil
+	}
+
+	// The data source might be empty, if the user doesn't have fitness data for that day of this type
+	if len(responseValue.Buckets) < 1 || len(responseValue.Buckets[0].Datasets) < 1 || len(responseValue.Buckets[0].Datasets[0].Points) < 1 {
+		return nil, nil
+	}
+
+	return responseValue.Buckets[0].Datasets[0].Points[0].Values, nil
+
+}
+
+// sendGoogleFitRequest sends the aggregate request to Google Fit with the account's tokens. Errors that Google Fit
+// reports in the response body are logged, and left in the response for the caller to check
+func (g Google) sendGoogleFitRequest(credentialID int, request GoogleFitRequest) (GoogleFitWholeResponse, error) {
+	// Get Access Token associated with user from db
+	tokens, err := dal.GetCredentialTokens(g.db, credentialID)
+
+	// Before we can make the request, refresh the access tokens
+	newTokens, err := refreshTokens(g.db, g.log, g.authorization, credentialID, g.Name(), tokens)
+	if err != nil {
+		return GoogleFitWholeResponse{}, err
+	}
+
+	// Tokens were refreshed. Prepare to make the request.
+	client := g.authorization.Client(context.Background(), newTokens)
+
+	url := g.domain + googleFitEndpoint
+
+	// Need to create the request body
+	requestBody, err := json.Marshal(request)
 
 	if err != nil {
 		g.log.WithFields(logrus.Fields{
 			"error":           err.Error(),
-			"aggregateBy":     aggregateBy,
-			"bucketByTime":    bucketByTime,
-			"startTimeMillis": UnixTimeDateInt,
-			"endTimeMillis":   UnixTimeLimit,
+			"aggregateBy":     request.AggregateBy,
+			"bucketByTime":    request.BucketByTime,
+			"startTimeMillis": request.StartTimeMillis,
+			"endTimeMillis":   request.EndTimeMillis,
 		}).Error("failed to marshal google fit request")
 
-		return GoogleValuesResponse{}, err
+		return GoogleFitWholeResponse{}, err
 	}
 
 	resp, err := client.Post(url, "application/json", bytes.NewBuffer(requestBody))
@@ -231,7 +713,7 @@ func (g Google) makeGoogleFitRequest(userID int, date time.Time, dataSourceID st
 			"error": err.Error(),
 		}).Error("failed to request data from Google Fit")
 
-		return GoogleValuesResponse{}, err
+		return GoogleFitWholeResponse{}, err
 	}
 
 	body, err := ioutil.ReadAll(resp.Body)
@@ -240,7 +722,7 @@ func (g Google) makeGoogleFitRequest(userID int, date time.Time, dataSourceID st
 			"error": err.Error(),
 		}).Error("failed to read response data from Google Fit")
 
-		return GoogleValuesResponse{}, nil
+		return GoogleFitWholeResponse{}, nil
 	}
 	_ = resp.Body.Close()
 
@@ -253,24 +735,89 @@ func (g Google) makeGoogleFitRequest(userID int, date time.Time, dataSourceID st
 			"responseBody": string(body),
 		}).Error("failed to unmarshal Google Fit response")
 
-		return GoogleValuesResponse{}, err
+		return GoogleFitWholeResponse{}, err
 	}
 
-	// First, check if there was an error in the response
 	if responseValue.Error.Message != "" {
 		g.log.WithFields(logrus.Fields{
 			"error":        responseValue.Error.Message,
 			"code":         responseValue.Error.Code,
 			"responseBody": string(body),
 		}).Error("received bad response from Google Fit")
-		return nil, nil
 	}
 
-	// The data source might be empty, if the user doesn't have fitness data for that day of this type
-	if len(responseValue.Buckets[0].Datasets[0].Points) < 1 {
-		return nil, nil
+	return responseValue, nil
+}
+
+// segmentMinutes returns how long a Google Fit data point lasted
+func segmentMinutes(point Point) (int, error) {
+	start, err := strconv.ParseInt(point.StartTimeNanos, 10, 64)
+	if err != nil {
+		return 0, err
 	}
 
-	return responseValue.Buckets[0].Datasets[0].Points[0].Values, nil
+	end, err := strconv.ParseInt(point.EndTimeNanos, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+
+	return int(time.Duration(end - start).Minutes()), nil
+}
+
+// datasetValues returns the values of the first point of one of the bucket's datasets, if it has any
+func datasetValues(bucket Bucket, index int) GoogleValuesResponse {
+	if len(bucket.Datasets) <= index || len(bucket.Datasets[index].Points) < 1 {
+		return nil
+	}
+
+	return bucket.Datasets[index].Points[0].Values
+}
+
+// googleHeartRate reads a com.google.heart_rate.summary, which holds the average, maximum and minimum, in that order
+func googleHeartRate(values GoogleValuesResponse) HeartRate {
+	if len(values) < 3 {
+		return HeartRate{}
+	}
+
+	average, _ := values[0]["fpVal"].(float64)
+	max, _ := values[1]["fpVal"].(float64)
+	min, _ := values[2]["fpVal"].(float64)
+
+	return HeartRate{
+		Average: average,
+		Max:     max,
+		Min:     min,
+	}
+}
+
+// googleActiveMinutes splits the active minutes by intensity, using the heart minutes summary
+func googleActiveMinutes(activeValues GoogleValuesResponse, heartValues GoogleValuesResponse) ActiveMinutes {
+	activeMinutes := 0
+	if len(activeValues) > 0 {
+		value, _ := activeValues[0]["intVal"].(float64)
+		activeMinutes = int(value)
+	}
+
+	// The heart minutes summary holds the heart points, then the time they were earned in, in milliseconds
+	heartPoints := 0
+	heartMinutes := 0
+	if len(heartValues) > 1 {
+		points, _ := heartValues[0]["fpVal"].(float64)
+		duration, _ := heartValues[1]["intVal"].(float64)
+		heartPoints = int(points)
+		heartMinutes = int(time.Duration(duration * float64(time.Millisecond)).Minutes())
+	}
+
+	result := ActiveMinutes{}
+	if heartPoints > heartMinutes {
+		result.Very = heartPoints - heartMinutes
+	}
+	if heartMinutes > result.Very {
+		result.Fairly = heartMinutes - result.Very
+	}
+	if activeMinutes > heartMinutes {
+		result.Lightly = activeMinutes - heartMinutes
+	}
 
+	return result
 }
@@ -1,9 +1,19 @@
 package platform
 
 import (
+	"context"
 	"database/sql"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 
+	"golang.org/x/oauth2"
+
 	"github.com/msgurgel/mrthn/pkg/auth"
 
 	"github.com/msgurgel/mrthn/pkg/dal"
@@ -13,12 +23,204 @@ import (
 
 var Platforms map[string]Platform
 
+// ErrResourceUnsupported is returned by platforms for resources they don't track
+var ErrResourceUnsupported = errors.New("platform does not track this resource")
+
+// Platform fetches data from one of the accounts a user linked, identified by the ID of its credentials
 type Platform interface {
 	Name() string
-	GetSteps(user int, date time.Time) (int, error)
-	GetCalories(user int, date time.Time) (int, error)
-	GetDistance(user int, date time.Time) (float64, error)
-	GetDistanceOverPeriod(user int, date time.Time, period string) (float64, error)
+	GetSteps(credentialID int, date time.Time) (int, error)
+	GetCalories(credentialID int, date time.Time) (int, error)
+	GetDistance(credentialID int, date time.Time) (float64, error)
+	GetDistanceOverPeriod(credentialID int, date time.Time, period string) (float64, error)
+	GetHeartRate(credentialID int, date time.Time) (HeartRate, error)
+	GetHeartRateOverPeriod(credentialID int, date time.Time, period string) (HeartRate, error)
+	GetSleep(credentialID int, date time.Time) (Sleep, error) // Sleep of the night that ended on the date
+	GetActiveMinutes(credentialID int, date time.Time) (ActiveMinutes, error)
+	GetClimb(credentialID int, date time.Time) (Climb, error)
+	GetClimbOverPeriod(credentialID int, date time.Time, period string) (Climb, error)
+	GetWorkouts(credentialID int, start time.Time, end time.Time) ([]Workout, error) // Workouts started from start until end
+	GetIntraday(credentialID int, resource string, date time.Time, interval time.Duration) ([]Sample, error)
+	GetDailySummary(credentialID int, date time.Time) (DailySummary, error) // Every daily resource the platform can get in one request
+	// GetBodyMeasurements lists the measurements taken in the period that ends on the date
+	GetBodyMeasurements(credentialID int, measurement string, date time.Time, period string) ([]BodyMeasurement, error)
+	RevokeAccess(tokens *oauth2.Token) error // Revokes the account's tokens at the platform, so mrthn can't use them anymore
+}
+
+// HeartRate summarizes a user's heart rate, in beats per minute. Platforms only fill in the figures they track
+type HeartRate struct {
+	Resting     float64
+	Average     float64
+	Max         float64
+	Min         float64
+	ZoneMinutes map[string]int // Minutes spent in each heart rate zone, by the platform's name for the zone
+}
+
+// ActiveMinutes splits a day by how intense the user's activity was. Platforms that can't tell how long
+// the user was sedentary leave it at zero
+type ActiveMinutes struct {
+	Sedentary int
+	Lightly   int
+	Fairly    int
+	Very      int
+}
+
+// Resources a daily summary can have
+const (
+	SummarySteps         = "steps"
+	SummaryCalories      = "calories"
+	SummaryDistance      = "distance"
+	SummaryHeartRate     = "heartrate"
+	SummaryActiveMinutes = "active-minutes"
+	SummaryFloors        = "floors"
+	SummaryElevation     = "elevation"
+)
+
+// DailySummary is a day of the user's activity, from a single request to the platform.
+// Resources lists which of the other fields the platform filled in
+type DailySummary struct {
+	Resources     []string
+	Steps         int
+	Calories      int
+	Distance      float64 // In kilometers
+	HeartRate     HeartRate
+	ActiveMinutes ActiveMinutes
+	Climb         Climb
+}
+
+// Resources platforms can give samples of through the day
+const (
+	IntradaySteps     = "steps"
+	IntradayCalories  = "calories"
+	IntradayHeartRate = "heartrate"
+)
+
+// Sample is the value of an intraday resource during the interval that starts at Time.
+// Steps and calories are the total of the interval, and heart rates its average
+type Sample struct {
+	Time  time.Time
+	Value float64
+}
+
+// Kinds of workouts. Platforms map their own activity types to these, and anything else is WorkoutOther
+const (
+	WorkoutRun      = "run"
+	WorkoutWalk     = "walk"
+	WorkoutHike     = "hike"
+	WorkoutRide     = "ride"
+	WorkoutSwim     = "swim"
+	WorkoutStrength = "strength"
+	WorkoutYoga     = "yoga"
+	WorkoutOther    = "other"
+)
+
+// Workout is an activity the user recorded. Platforms leave out the figures they don't have
+type Workout struct {
+	Type             string
+	Name             string // Title of the workout on the platform, such as "Morning Run"
+	Start            time.Time
+	Duration         time.Duration
+	Distance         float64 // In kilometers
+	Calories         int
+	AverageHeartRate float64
+}
+
+// metersInAFloor is the elevation gain that counts as climbing a floor. Fitbit counts floors the same way
+const metersInAFloor = 3.0
+
+// Climb is how much the user climbed. Platforms that only track one of the figures work the other one out
+// from it, using metersInAFloor
+type Climb struct {
+	Floors    int
+	Elevation float64 // Elevation gain, in meters
+}
+
+// Body measurements platforms can be asked for
+const (
+	BodyWeight = "weight"   // In kilograms
+	BodyBMI    = "bmi"      // Body mass index
+	BodyFat    = "body-fat" // Percentage of the body weight
+)
+
+// BodyMeasurement is a value the user logged or a scale measured, at the time it was taken
+type BodyMeasurement struct {
+	Value      float64
+	MeasuredAt time.Time
+}
+
+// Sleep stages, as named in Sleep.StageMinutes
+const (
+	SleepStageDeep  = "deep"
+	SleepStageLight = "light"
+	SleepStageREM   = "rem"
+	SleepStageAwake = "awake"
+)
+
+// Sleep summarizes a night of sleep. Efficiency is the percentage of the time in bed spent asleep
+type Sleep struct {
+	MinutesAsleep int
+	MinutesInBed  int
+	StageMinutes  map[string]int // Minutes in each sleep stage, for platforms that track them
+	Efficiency    float64
+}
+
+// sleepEfficiency is the percentage of the time in bed spent asleep
+func sleepEfficiency(minutesAsleep int, minutesInBed int) float64 {
+	if minutesInBed == 0 {
+		return 0
+	}
+
+	return float64(minutesAsleep) / float64(minutesInBed) * 100
+}
+
+// periodRange returns when the period that ends on the date starts and ends. The date itself is included
+func periodRange(date time.Time, period string) (time.Time, time.Time, error) {
+	seconds, ok := periodToSeconds[period]
+	if !ok {
+		return time.Time{}, time.Time{}, errors.New("invalid period value")
+	}
+
+	end := date.Add(24 * time.Hour)
+	return end.Add(-time.Duration(seconds) * time.Second), end, nil
+}
+
+// bucketSamples groups the samples of the date in intervals of the given length. Steps and calories are added up,
+// while heart rates are averaged. Intervals without samples are left out
+func bucketSamples(samples []Sample, date time.Time, interval time.Duration, resource string) []Sample {
+	sums := make(map[int64]float64)
+	counts := make(map[int64]int)
+	var indexes []int64
+	end := date.Add(24 * time.Hour)
+	for _, sample := range samples {
+		if sample.Time.Before(date) || !sample.Time.Before(end) {
+			continue
+		}
+
+		index := int64(sample.Time.Sub(date) / interval)
+		if counts[index] == 0 {
+			indexes = append(indexes, index)
+		}
+
+		sums[index] += sample.Value
+		counts[index]++
+	}
+
+	sort.Slice(indexes, func(i, j int) bool { return indexes[i] < indexes[j] })
+
+	result := make([]Sample, 0, len(indexes))
+	for _, index := range indexes {
+		value := sums[index]
+		if resource == IntradayHeartRate {
+			value /= float64(counts[index])
+		}
+
+		result = append(result, Sample{
+			Time:  date.Add(time.Duration(index) * interval),
+			Value: value,
+		})
+	}
+
+	return result
 }
 
 func InitializePlatforms(db *sql.DB, log *logrus.Logger, authTypes auth.Types) {
@@ -29,12 +231,20 @@ func InitializePlatforms(db *sql.DB, log *logrus.Logger, authTypes auth.Types) {
 		}).Fatal("unable to get domains from the db")
 	}
 
+	revokeURLs, err := dal.GetPlatformRevokeURLs(db)
+	if err != nil {
+		log.WithFields(logrus.Fields{
+			"err": err,
+		}).Fatal("unable to get revoke URLs from the db")
+	}
+
 	Platforms = make(map[string]Platform)
 
 	Platforms["fitbit"] = Fitbit{
 		db:            db,
 		log:           log,
 		domain:        domains["fitbit"],
+		revokeURL:     revokeURLs["fitbit"],
 		authorization: authTypes.Oauth2.Configs["fitbit"],
 	}
 
@@ -42,6 +252,7 @@ func InitializePlatforms(db *sql.DB, log *logrus.Logger, authTypes auth.Types) {
 		db:            db,
 		log:           log,
 		domain:        domains["google"],
+		revokeURL:     revokeURLs["google"],
 		authorization: authTypes.Oauth2.Configs["google"],
 	}
 
@@ -49,6 +260,7 @@ func InitializePlatforms(db *sql.DB, log *logrus.Logger, authTypes auth.Types) {
 		db:            db,
 		log:           log,
 		domain:        domains["strava"],
+		revokeURL:     revokeURLs["strava"],
 		authorization: authTypes.Oauth2.Configs["strava"],
 	}
 }
@@ -72,3 +284,78 @@ func IsPlatformAvailable(platform string) bool {
 
 	return false
 }
+
+// refreshTokens refreshes the tokens of a platform account when they have expired, and saves the new ones.
+// The outcome is recorded in the account's credentials, so clients can see when they stop working
+func refreshTokens(db *sql.DB, log *logrus.Logger, conf *oauth2.Config, credentialID int, platformName string, tokens *oauth2.Token) (*oauth2.Token, error) {
+	newTokens, err := auth.RefreshOAuth2Tokens(tokens, conf)
+	if err != nil {
+		markErr := dal.MarkCredentialsRefreshFailed(db, credentialID, err.Error())
+		if markErr != nil {
+			log.WithFields(logrus.Fields{
+				"credentials": credentialID,
+				"platform":    platformName,
+				"err":         markErr,
+			}).Error("failed to record failed token refresh")
+		}
+
+		return nil, err
+	}
+
+	if newTokens.AccessToken != tokens.AccessToken {
+		// Tokens were updated, let's update the database
+		err := dal.UpdateCredentialsUsingOAuth2Tokens(db, credentialID, newTokens)
+		if err != nil {
+			return nil, errors.New("failed to update db with new oauth2 tokens: " + err.Error())
+		}
+
+		err = dal.MarkCredentialsRefreshed(db, credentialID)
+		if err != nil {
+			log.WithFields(logrus.Fields{
+				"credentials": credentialID,
+				"platform":    platformName,
+				"err":         err,
+			}).Error("failed to record token refresh")
+		}
+
+		log.WithFields(logrus.Fields{
+			"credentials": credentialID,
+			"expiry":      newTokens.Expiry,
+		}).Info("updated access token")
+	}
+
+	return newTokens, nil
+}
+
+// sendRevokeRequest posts the form to a platform's revocation endpoint, and checks that it was accepted
+func sendRevokeRequest(revokeURL string, form url.Values, conf *oauth2.Config, basicAuth bool) error {
+	if revokeURL == "" {
+		return errors.New("platform has no revoke URL configured")
+	}
+
+	req, err := http.NewRequest(http.MethodPost, revokeURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if basicAuth {
+		req.SetBasicAuth(conf.ClientID, conf.ClientSecret)
+	}
+
+	// Without a token, this is the same HTTP client the platform calls go through
+	client := oauth2.NewClient(context.Background(), nil)
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+
+	body, _ := ioutil.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusMultipleChoices {
+		return errors.New("revocation failed with status " + strconv.Itoa(resp.StatusCode) + ": " + string(body))
+	}
+
+	return nil
+}
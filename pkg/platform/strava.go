@@ -7,6 +7,7 @@ import (
 	"errors"
 	"fmt"
 	"io/ioutil"
+	"net/url"
 	"strconv"
 	"time"
 
@@ -21,24 +22,74 @@ type Strava struct {
 	db            *sql.DB
 	log           *logrus.Logger
 	domain        string
+	revokeURL     string
 	authorization *oauth2.Config
 }
 
 // The endpoint for Strava activities
 const stravaActivityEndpoint string = "/athlete/activities"
 
+// The endpoint for the time and heart rate streams of an activity
+const stravaStreamsEndpoint string = "/activities/%d/streams?keys=time,heartrate&key_by_type=true"
+
+// Strava lists 30 activities at a time unless asked for more, up to 200
+const stravaActivitiesPerPage = 200
+
+// The endpoint for the profile of the athlete
+const stravaAthleteEndpoint string = "/athlete"
+
 const secondsInADay int64 = 86400
 
 // StravaActivity represents an activity that would be returned by the query
 type StravaActivity struct {
-	Distance   float64 `json:"distance,omitempty"`
-	Kilojoules float64 `json:"kilojoules,omitempty"`
+	ID               int64     `json:"id"`
+	Distance         float64   `json:"distance,omitempty"`
+	Kilojoules       float64   `json:"kilojoules,omitempty"`
+	MovingTime       int       `json:"moving_time,omitempty"`
+	HasHeartRate     bool      `json:"has_heartrate,omitempty"`
+	AverageHeartRate float64   `json:"average_heartrate,omitempty"`
+	MaxHeartRate     float64   `json:"max_heartrate,omitempty"`
+	ElevationGain    float64   `json:"total_elevation_gain,omitempty"`
+	Name             string    `json:"name,omitempty"`
+	Type             string    `json:"type,omitempty"`
+	StartDate        time.Time `json:"start_date"`
+	ElapsedTime      int       `json:"elapsed_time,omitempty"`
+}
+
+// stravaWorkoutTypes maps Strava activity types to workout types
+var stravaWorkoutTypes = map[string]string{
+	"Run":            WorkoutRun,
+	"VirtualRun":     WorkoutRun,
+	"Walk":           WorkoutWalk,
+	"Hike":           WorkoutHike,
+	"Ride":           WorkoutRide,
+	"VirtualRide":    WorkoutRide,
+	"EBikeRide":      WorkoutRide,
+	"Swim":           WorkoutSwim,
+	"WeightTraining": WorkoutStrength,
+	"Crossfit":       WorkoutStrength,
+	"Yoga":           WorkoutYoga,
+}
+
+// StravaStream is a series of values recorded during an activity. Time is in seconds since the activity started
+type StravaStream struct {
+	Data []float64 `json:"data"`
+}
+
+// StravaAthlete represents the parts of the athlete's profile that we read
+type StravaAthlete struct {
+	Weight    float64   `json:"weight,omitempty"` // In kilograms
+	UpdatedAt time.Time `json:"updated_at"`
 }
 
 // ActivityStats represents the aggregated activity data of a returned query
 type ActivityStats struct {
-	totalCalories int
-	totalDistance float64
+	totalCalories    int
+	totalDistance    float64
+	averageHeartRate float64 // Averaged over the activities that recorded heart rate, weighted by their moving time
+	maxHeartRate     float64
+	totalMovingTime  int     // In seconds
+	elevationGain    float64 // In meters
 }
 
 // periodToSeconds maps a valid period string to their corresponding seconds value
@@ -56,12 +107,12 @@ func (s Strava) Name() string {
 	return "strava"
 }
 
-func (s Strava) GetSteps(userID int, date time.Time) (int, error) {
+func (s Strava) GetSteps(credentialID int, date time.Time) (int, error) {
 	return 0, nil
 }
 
-func (s Strava) GetCalories(userID int, date time.Time) (int, error) {
-	dailyAct, err := s.getStravaActivityCount(userID, date, "")
+func (s Strava) GetCalories(credentialID int, date time.Time) (int, error) {
+	dailyAct, err := s.getStravaActivityCount(credentialID, date, "")
 	if err != nil {
 		return 0, err
 	}
@@ -69,9 +120,9 @@ func (s Strava) GetCalories(userID int, date time.Time) (int, error) {
 	return dailyAct.totalCalories, nil
 }
 
-func (s Strava) GetDistance(userID int, date time.Time) (float64, error) {
+func (s Strava) GetDistance(credentialID int, date time.Time) (float64, error) {
 
-	dailyAct, err := s.getStravaActivityCount(userID, date, "")
+	dailyAct, err := s.getStravaActivityCount(credentialID, date, "")
 	if err != nil {
 		return 0, err
 	}
@@ -82,8 +133,8 @@ func (s Strava) GetDistance(userID int, date time.Time) (float64, error) {
 	return kilometerValue, nil
 }
 
-func (s Strava) GetDistanceOverPeriod(userID int, date time.Time, period string) (float64, error) {
-	activityStats, err := s.getStravaActivityCount(userID, date, period)
+func (s Strava) GetDistanceOverPeriod(credentialID int, date time.Time, period string) (float64, error) {
+	activityStats, err := s.getStravaActivityCount(credentialID, date, period)
 	if err != nil {
 		return 0, err
 	}
@@ -94,34 +145,242 @@ func (s Strava) GetDistanceOverPeriod(userID int, date time.Time, period string)
 	return kilometerValue, nil
 }
 
-func (s Strava) getStravaActivityCount(userID int, date time.Time, period string) (ActivityStats, error) {
-	// Get Access Token associated with user from db
-	tokens, err := dal.GetUserTokens(s.db, userID, s.Name())
+func (s Strava) GetHeartRate(credentialID int, date time.Time) (HeartRate, error) {
+	return s.GetHeartRateOverPeriod(credentialID, date, "")
+}
 
-	// Before we can make the request, refresh the access tokens
-	newTokens, err := auth.RefreshOAuth2Tokens(tokens, s.authorization)
+func (s Strava) GetHeartRateOverPeriod(credentialID int, date time.Time, period string) (HeartRate, error) {
+	activityStats, err := s.getStravaActivityCount(credentialID, date, period)
 	if err != nil {
-		return ActivityStats{}, err
+		return HeartRate{}, err
+	}
+
+	return HeartRate{
+		Average: activityStats.averageHeartRate,
+		Max:     activityStats.maxHeartRate,
+	}, nil
+}
+
+func (s Strava) GetSleep(credentialID int, date time.Time) (Sleep, error) {
+	return Sleep{}, ErrResourceUnsupported
+}
+
+// GetActiveMinutes counts the time spent moving in activities as fairly active. Strava only knows about
+// the activities the user recorded, so it can't tell how the rest of the day was spent
+func (s Strava) GetActiveMinutes(credentialID int, date time.Time) (ActiveMinutes, error) {
+	activityStats, err := s.getStravaActivityCount(credentialID, date, "")
+	if err != nil {
+		return ActiveMinutes{}, err
+	}
+
+	return ActiveMinutes{
+		Fairly: activityStats.totalMovingTime / 60,
+	}, nil
+}
+
+// GetDailySummary gets everything from a single query of the day's activities. Strava doesn't count steps
+func (s Strava) GetDailySummary(credentialID int, date time.Time) (DailySummary, error) {
+	activityStats, err := s.getStravaActivityCount(credentialID, date, "")
+	if err != nil {
+		return DailySummary{}, err
+	}
+
+	summary := DailySummary{
+		Resources: []string{SummaryCalories, SummaryDistance, SummaryActiveMinutes, SummaryFloors, SummaryElevation},
+		Calories:  activityStats.totalCalories,
+		Distance:  activityStats.totalDistance / 1000, // mrthn returns distances in kilometers, not meters
+		ActiveMinutes: ActiveMinutes{
+			Fairly: activityStats.totalMovingTime / 60,
+		},
+		Climb: Climb{
+			Floors:    int(activityStats.elevationGain / metersInAFloor),
+			Elevation: activityStats.elevationGain,
+		},
+	}
+
+	// Only activities recorded with a heart rate monitor have heart rate data
+	if activityStats.averageHeartRate > 0 {
+		summary.Resources = append(summary.Resources, SummaryHeartRate)
+		summary.HeartRate = HeartRate{
+			Average: activityStats.averageHeartRate,
+			Max:     activityStats.maxHeartRate,
+		}
+	}
+
+	return summary, nil
+}
+
+func (s Strava) GetClimb(credentialID int, date time.Time) (Climb, error) {
+	return s.GetClimbOverPeriod(credentialID, date, "")
+}
+
+// GetClimbOverPeriod adds up the elevation gain of the activities, measured by GPS.
+// Strava doesn't count floors, so they are worked out from the elevation gain
+func (s Strava) GetClimbOverPeriod(credentialID int, date time.Time, period string) (Climb, error) {
+	activityStats, err := s.getStravaActivityCount(credentialID, date, period)
+	if err != nil {
+		return Climb{}, err
+	}
+
+	return Climb{
+		Floors:    int(activityStats.elevationGain / metersInAFloor),
+		Elevation: activityStats.elevationGain,
+	}, nil
+}
+
+func (s Strava) GetWorkouts(credentialID int, start time.Time, end time.Time) ([]Workout, error) {
+	activityList, err := s.getStravaActivities(credentialID, start.Unix(), end.Unix())
+	if err != nil {
+		return nil, err
+	}
+
+	var workouts []Workout
+	for _, activity := range activityList {
+		workoutType, ok := stravaWorkoutTypes[activity.Type]
+		if !ok {
+			workoutType = WorkoutOther
+		}
+
+		workout := Workout{
+			Type:     workoutType,
+			Name:     activity.Name,
+			Start:    activity.StartDate,
+			Duration: time.Duration(activity.ElapsedTime) * time.Second,
+			Distance: activity.Distance / 1000, // mrthn returns distances in kilometers, not meters
+			Calories: stravaCalories(activity),
+		}
+
+		// Only activities recorded with a heart rate monitor have heart rate data
+		if activity.HasHeartRate {
+			workout.AverageHeartRate = activity.AverageHeartRate
+		}
+
+		workouts = append(workouts, workout)
+	}
+
+	return workouts, nil
+}
+
+// GetIntraday only has heart rate, from the streams of the activities recorded with a heart rate monitor
+func (s Strava) GetIntraday(credentialID int, resource string, date time.Time, interval time.Duration) ([]Sample, error) {
+	if resource != IntradayHeartRate {
+		return nil, ErrResourceUnsupported
+	}
+
+	activityList, err := s.getStravaActivities(credentialID, date.Unix(), date.Unix()+secondsInADay)
+	if err != nil {
+		return nil, err
+	}
+
+	tokens, err := dal.GetCredentialTokens(s.db, credentialID)
+	if err != nil {
+		return nil, err
 	}
 
-	if newTokens.AccessToken != tokens.AccessToken {
-		// Tokens were updated, let's update the database
-		err := dal.UpdateCredentialsUsingOAuth2Tokens(s.db, userID, newTokens)
+	newTokens, err := refreshTokens(s.db, s.log, s.authorization, credentialID, s.Name(), tokens)
+	if err != nil {
+		return nil, err
+	}
+
+	// Tokens were refreshed. Now make the requests
+	client := s.authorization.Client(context.Background(), newTokens)
+
+	var samples []Sample
+	for _, activity := range activityList {
+		if !activity.HasHeartRate {
+			continue
+		}
+
+		resp, err := client.Get(s.domain + fmt.Sprintf(stravaStreamsEndpoint, activity.ID))
+		if err != nil {
+			return nil, err
+		}
+
+		body, err := ioutil.ReadAll(resp.Body)
 		if err != nil {
-			return ActivityStats{}, errors.New("failed to update db with new oauth2 tokens: " + err.Error())
+			return nil, err
+		}
+		_ = resp.Body.Close()
+
+		var streams map[string]StravaStream
+		err = json.Unmarshal(body, &streams)
+		if err != nil {
+			return nil, err
+		}
+
+		times := streams["time"].Data
+		heartRates := streams["heartrate"].Data
+		for i := 0; i < len(times) && i < len(heartRates); i++ {
+			samples = append(samples, Sample{
+				Time:  activity.StartDate.Add(time.Duration(times[i]) * time.Second),
+				Value: heartRates[i],
+			})
 		}
+	}
 
-		s.log.WithFields(logrus.Fields{
-			"user":   userID,
-			"expiry": newTokens.Expiry,
-		}).Info("updated access token")
+	return bucketSamples(samples, date, interval, resource), nil
+}
+
+// GetBodyMeasurements returns the weight in the athlete's profile. Strava only keeps the current weight,
+// so it's dated when the profile was last updated, and left out if that wasn't during the period
+func (s Strava) GetBodyMeasurements(credentialID int, measurement string, date time.Time, period string) ([]BodyMeasurement, error) {
+	if measurement != BodyWeight {
+		return nil, ErrResourceUnsupported
 	}
 
-	// Tokens were refreshed. Prepare to make the request.
+	start, end, err := periodRange(date, period)
+	if err != nil {
+		return nil, err
+	}
+
+	tokens, err := dal.GetCredentialTokens(s.db, credentialID)
+	if err != nil {
+		return nil, err
+	}
+
+	newTokens, err := refreshTokens(s.db, s.log, s.authorization, credentialID, s.Name(), tokens)
+	if err != nil {
+		return nil, err
+	}
+
+	// Tokens were refreshed. Now make the request
 	client := s.authorization.Client(context.Background(), newTokens)
+	resp, err := client.Get(s.domain + stravaAthleteEndpoint)
+	if err != nil {
+		return nil, err
+	}
 
-	url := s.domain + stravaActivityEndpoint
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	_ = resp.Body.Close()
+
+	var athlete StravaAthlete
+	err = json.Unmarshal(body, &athlete)
+	if err != nil {
+		return nil, err
+	}
+
+	if athlete.Weight == 0 || athlete.UpdatedAt.Before(start) || !athlete.UpdatedAt.Before(end) {
+		return nil, nil
+	}
+
+	return []BodyMeasurement{{Value: athlete.Weight, MeasuredAt: athlete.UpdatedAt}}, nil
+}
+
+func (s Strava) RevokeAccess(tokens *oauth2.Token) error {
+	// Strava deauthorizes the whole application using a valid access token, so refresh it first.
+	// The refreshed tokens aren't stored, since they are about to be revoked
+	newTokens, err := auth.RefreshOAuth2Tokens(tokens, s.authorization)
+	if err != nil {
+		return err
+	}
 
+	return sendRevokeRequest(s.revokeURL, url.Values{"access_token": {newTokens.AccessToken}}, s.authorization, false)
+}
+
+func (s Strava) getStravaActivityCount(credentialID int, date time.Time, period string) (ActivityStats, error) {
 	// To filter the activates received by a certain day, we need the epoch time
 	epochTimeDateInt := date.Unix()
 	var epochTimeLimit int64
@@ -145,23 +404,7 @@ func (s Strava) getStravaActivityCount(userID int, date time.Time, period string
 		}
 	}
 
-	// Need to add the epoch timestamps as filter queries to the URL
-	url += fmt.Sprintf("?before=%s&after=%s", strconv.FormatInt(epochTimeLimit, 10), strconv.FormatInt(epochTimeDateInt, 10))
-
-	resp, err := client.Get(url)
-	if err != nil {
-		return ActivityStats{}, err
-	}
-
-	body, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return ActivityStats{}, nil
-	}
-	_ = resp.Body.Close()
-
-	// Unmarshal the JSON response into a list of Strava Activity struct
-	var activityList []StravaActivity
-	err = json.Unmarshal(body, &activityList)
+	activityList, err := s.getStravaActivities(credentialID, epochTimeDateInt, epochTimeLimit)
 	if err != nil {
 		return ActivityStats{}, err
 	}
@@ -172,7 +415,10 @@ func (s Strava) getStravaActivityCount(userID int, date time.Time, period string
 		totalDistance: 0,
 	}
 
+	heartRateSeconds := 0
 	for _, s := range activityList {
+		result.totalMovingTime += s.MovingTime
+		result.elevationGain += s.ElevationGain
 
 		// Depending on the activity type, it might not have distance or calories present
 		if s.Distance != 0 {
@@ -180,13 +426,70 @@ func (s Strava) getStravaActivityCount(userID int, date time.Time, period string
 		}
 
 		if s.Kilojoules != 0 {
-			// Need to convert the kilojoules to calories
+			result.totalCalories += stravaCalories(s)
+		}
 
-			caloriesCount := s.Kilojoules / 4.814
+		// Only activities recorded with a heart rate monitor have heart rate data
+		if s.HasHeartRate {
+			result.averageHeartRate += s.AverageHeartRate * float64(s.MovingTime)
+			heartRateSeconds += s.MovingTime
 
-			result.totalCalories += int(caloriesCount)
+			if s.MaxHeartRate > result.maxHeartRate {
+				result.maxHeartRate = s.MaxHeartRate
+			}
 		}
 	}
 
+	if heartRateSeconds > 0 {
+		result.averageHeartRate /= float64(heartRateSeconds)
+	}
+
 	return result, nil
 }
+
+// getStravaActivities lists the activities the athlete started between the epoch timestamps
+func (s Strava) getStravaActivities(credentialID int, after int64, before int64) ([]StravaActivity, error) {
+	// Get Access Token associated with user from db
+	tokens, err := dal.GetCredentialTokens(s.db, credentialID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Before we can make the request, refresh the access tokens
+	newTokens, err := refreshTokens(s.db, s.log, s.authorization, credentialID, s.Name(), tokens)
+	if err != nil {
+		return nil, err
+	}
+
+	// Tokens were refreshed. Prepare to make the request.
+	client := s.authorization.Client(context.Background(), newTokens)
+
+	// Need to add the epoch timestamps as filter queries to the URL
+	url := s.domain + stravaActivityEndpoint
+	url += fmt.Sprintf("?before=%s&after=%s&per_page=%d", strconv.FormatInt(before, 10), strconv.FormatInt(after, 10), stravaActivitiesPerPage)
+
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	_ = resp.Body.Close()
+
+	// Unmarshal the JSON response into a list of Strava Activity struct
+	var activityList []StravaActivity
+	err = json.Unmarshal(body, &activityList)
+	if err != nil {
+		return nil, err
+	}
+
+	return activityList, nil
+}
+
+// stravaCalories converts the kilojoules of an activity to calories
+func stravaCalories(activity StravaActivity) int {
+	return int(activity.Kilojoules / 4.814)
+}
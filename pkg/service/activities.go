@@ -0,0 +1,102 @@
+/*
+ * mrthn API
+ *
+ * One login for all your fitness data needs.
+ *
+ * API version: 0.1.0
+ */
+package service
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+
+	"github.com/msgurgel/mrthn/pkg/dal"
+	"github.com/msgurgel/mrthn/pkg/helpers"
+	"github.com/msgurgel/mrthn/pkg/model"
+)
+
+// maxWorkoutDays limits how many days of workouts can be listed at once, since every platform is asked for all of them
+const maxWorkoutDays = 90
+
+// GetUserActivities lists the workouts the user recorded on any of their platforms, from start until end
+func (api *Api) GetUserActivities(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	start, err := helpers.ParseISODate(query.Get("start"))
+	if err != nil {
+		api.respondWithError(w, http.StatusBadRequest, "'start' parameter was invalid")
+		return
+	}
+
+	end, err := helpers.ParseISODate(query.Get("end"))
+	if err != nil {
+		api.respondWithError(w, http.StatusBadRequest, "'end' parameter was invalid")
+		return
+	}
+
+	if end.Before(start) {
+		api.respondWithError(w, http.StatusBadRequest, "'end' parameter can't be before 'start'")
+		return
+	}
+
+	// The end date is included in the range
+	end = end.AddDate(0, 0, 1)
+	if end.After(start.AddDate(0, 0, maxWorkoutDays)) {
+		api.respondWithError(w, http.StatusBadRequest,
+			"'start' and 'end' parameters can't be more than "+strconv.Itoa(maxWorkoutDays)+" days apart")
+		return
+	}
+
+	units, err := parseUnits(query.Get("units"))
+	if err != nil {
+		api.respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// Check if the client has access to this user
+	userID, publicID, ok := api.getQueriedUser(w, r, mux.Vars(r)["userID"])
+	if !ok {
+		return
+	}
+
+	// The user's consent may have expired, and they may not share everything with the client
+	sharing, ok := api.getSharingPreferences(w, r, userID)
+	if !ok {
+		return
+	}
+
+	params := model.GetValueParams{
+		DB:      api.db,
+		Log:     api.log,
+		UserID:  userID,
+		Date:    start,
+		Sharing: sharing,
+		Units:   units,
+	}
+	workouts, err := model.GetUserWorkouts(params, end)
+	if err != nil {
+		api.log.WithFields(logrus.Fields{
+			"func":   "GetUserActivities",
+			"userID": userID,
+			"err":    err,
+		}).Error("failed to get user workouts")
+
+		// TODO: Change this to a more fitting HTTP code
+		api.respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	api.recordUserEvent(r, userID, dal.UserEventDataAccessed,
+		fmt.Sprintf("activities from %s to %s", query.Get("start"), query.Get("end")))
+
+	response := WorkoutsResponse{
+		ID:       publicID,
+		Workouts: workouts,
+	}
+	api.respondWithJSON(w, http.StatusOK, response)
+}
@@ -8,14 +8,14 @@
 package service
 
 import (
-	"crypto/rand"
 	"database/sql"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
 	"net/http"
+	"net/url"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gorilla/context"
@@ -30,10 +30,13 @@ import (
 )
 
 type verifiedParams struct {
-	userID      int
+	userID      string // Public ID the client knows the user by
 	date        time.Time
 	period      string
 	largestOnly bool
+	strategy    string
+	priority    []string // Platforms the priority strategy prefers, most preferred first
+	units       string
 }
 
 type getValueDailyFunc func(params model.GetValueParams) ([]model.ValueResult, error)
@@ -43,8 +46,27 @@ type Api struct {
 	log         *logrus.Logger
 	authMethods auth.Types
 	db          *sql.DB
+	sessions    sessionManager
+	mail        mailer
+	development bool   // Relaxes checks that can't pass on a local machine, such as requiring https
+	websiteURL  string // Users are sent back to the mrthn website after signing in to the user portal
 }
 
+// Kinds of user IDs a client can query users by
+const (
+	idTypeMrthn       = "mrthn"       // The public ID mrthn gave the user
+	idTypeExternalRef = "externalRef" // The client's own ID for the user
+)
+
+const maxAccountLabelLength = 64
+const maxConsentDays = 3650
+
+// consentExpiredMessage tells the client how to get the user's consent again
+const consentExpiredMessage = "User consent has expired. Send the user through /login again to renew it"
+
+// authorizationFailedError is sent to the client's callback instead of a user ID when the user couldn't be signed in
+const authorizationFailedError = "authorization_failed"
+
 var allowedPeriods = []string{"1d", "7d", "30d", "1w", "1m", "3m", "6m"}
 
 // paramsMapRegular is used for most calls to the mrthn API
@@ -52,13 +74,20 @@ var paramsMapRegular = map[string]bool{
 	"userID":      true,
 	"date":        true,
 	"largestOnly": false,
+	"strategy":    false,
+	"priority":    false,
+	"units":       false,
 }
 
-func NewApi(db *sql.DB, logger *logrus.Logger, authTypes auth.Types) Api {
+func NewApi(db *sql.DB, logger *logrus.Logger, authTypes auth.Types, sessions sessionManager, mail mailer, development bool, websiteURL string) Api {
 	return Api{
 		log:         logger,
 		db:          db,
 		authMethods: authTypes,
+		sessions:    sessions,
+		mail:        mail,
+		development: development,
+		websiteURL:  websiteURL,
 	}
 }
 
@@ -67,50 +96,31 @@ func (api *Api) Index(w http.ResponseWriter, r *http.Request) {
 }
 
 func (api *Api) GetToken(w http.ResponseWriter, r *http.Request) {
-	// Get Client ID from request (check if clientID is in db)
-	idStr := r.FormValue("id")
-	clientID, err := strconv.Atoi(idStr)
+	clientID := context.Get(r, "session_client_id").(int) // This was set during client session middleware
 
-	// Generate random secret
-	secret := make([]byte, 64)
-	if _, err := io.ReadFull(rand.Reader, secret); err != nil {
-		api.log.WithFields(logrus.Fields{
-			"id":  clientID,
-			"err": err,
-		}).Error("failed to generate secret token")
-
-		api.respondWithError(w, http.StatusInternalServerError,
-			"Something went wrong. Try again later...")
-		return
+	// Every call creates a new key, so tokens generated before keep working
+	label := r.FormValue("label")
+	if label == "" {
+		label = defaultKeyLabel
 	}
 
-	// Store secret in the DB as part of the Client table
-	rows, err := dal.InsertSecretInExistingClient(api.db, clientID, secret)
-	if err != nil {
-		api.log.WithFields(logrus.Fields{
-			"func": "GetToken",
-			"err":  err,
-		}).Error("failed to update client with new generated secret")
-
-		api.respondWithError(w, http.StatusInternalServerError,
-			"Something went wrong. Try again later...")
+	_, tokenString, err := api.createClientKey(clientID, label, sql.NullTime{})
+	if err == dal.ErrTooManyClientKeys {
+		api.respondWithError(w, http.StatusConflict, tooManyKeysMessage)
 		return
 	}
-
-	// Make sure that we updated the client with the new secret
-	if rows != 1 {
+	if err != nil {
 		api.log.WithFields(logrus.Fields{
 			"func":     "GetToken",
 			"clientID": clientID,
-		}).Warn("received /get-token request with invalid client ID")
+			"err":      err,
+		}).Error("failed to create new client key")
 
-		api.respondWithError(w, http.StatusBadRequest, "client ID does not exist")
+		api.respondWithError(w, http.StatusInternalServerError,
+			"Something went wrong. Try again later...")
 		return
 	}
 
-	// Add client ID as part of the JWT claims
-	tokenString, _ := generateJWT(clientID, secret)
-
 	// Send the token back to the requester
 	_, err = w.Write([]byte(tokenString))
 	if err != nil {
@@ -139,6 +149,22 @@ func (api *Api) GetValueDaily(w http.ResponseWriter, r *http.Request) {
 		api.getValueDaily(w, r, model.GetUserSteps)
 	case "calories":
 		api.getValueDaily(w, r, model.GetUserCalories)
+	case "heartrate":
+		api.getValueDaily(w, r, model.GetUserHeartRate)
+	case "sleep":
+		api.getValueDaily(w, r, model.GetUserSleep)
+	case "active-minutes":
+		api.getValueDaily(w, r, model.GetUserActiveMinutes)
+	case "weight":
+		api.getValueDaily(w, r, model.GetUserWeight)
+	case "bmi":
+		api.getValueDaily(w, r, model.GetUserBMI)
+	case "body-fat":
+		api.getValueDaily(w, r, model.GetUserBodyFat)
+	case "floors":
+		api.getValueDaily(w, r, model.GetUserFloors)
+	case "elevation":
+		api.getValueDaily(w, r, model.GetUserElevation)
 	default:
 		api.respondWithError(w, http.StatusBadRequest,
 			fmt.Sprintf("'resource' field must be a proper resource, received:'%s'", pathVariable))
@@ -206,16 +232,9 @@ func (api *Api) Login(w http.ResponseWriter, r *http.Request) {
 	// Add validated service and callback to the params struct
 	params.Service = service[0]
 
-	// Get callback URL from database
-	callback, err := dal.GetClientCallback(api.db, parseToken.clientID)
-	if err != nil {
-		api.log.WithFields(logrus.Fields{
-			"client": parseToken.clientID,
-			"err":    err,
-		}).Error("failed to get callback url from database")
-		api.respondWithError(w, http.StatusInternalServerError,
-			"Unable to retrieve callback URL. Did you remember to set it in your Profile page at https://mrthn.dev ? ",
-		)
+	// Pick the URL the user will be sent back to
+	callback, callbackOk := api.getLoginRedirectURI(w, r, parseToken.clientID)
+	if !callbackOk {
 		return
 	}
 	params.CallbackURL = callback
@@ -236,64 +255,68 @@ func (api *Api) Login(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		// Check if is a number
-		userID, err := strconv.Atoi(userIDStrings[0])
-		if err != nil {
-			api.log.WithFields(logrus.Fields{
-				"func":     "Login",
-				"clientID": parseToken.clientID,
-				"userID":   userIDStrings,
-			}).Error("userID was not a number")
+		// Check if client has access to the specified user
+		userID, _, hasAccess := api.getClientUser(w, parseToken.clientID, userIDStrings[0])
+		if !hasAccess {
+			return
+		}
 
-			api.respondWithError(w, http.StatusBadRequest,
-				"optional parameter 'userID' was expected to be a number")
+		// Users can link several accounts of the same platform, so there's no need to check which ones they have
+		// Add the validated userID to the params struct
+		params.UserID = userID
+	}
 
+	// Check if the optional parameter mergeUserID was given. Instead of linking an account, the user logs in to authorize
+	// merging that user into the one in userID. It has to be done once with an account of each user
+	if mergeUserID := r.URL.Query().Get("mergeUserID"); mergeUserID != "" {
+		if params.UserID == 0 {
+			api.respondWithError(w, http.StatusBadRequest, "'mergeUserID' parameter needs the 'userID' of the user to merge into")
 			return
 		}
 
-		// Check if client has access to the specified user
-		hasAccess := api.clientHasAccessToUser(w, parseToken.clientID, userID)
+		mergeID, _, hasAccess := api.getClientUser(w, parseToken.clientID, mergeUserID)
 		if !hasAccess {
 			return
 		}
 
-		// Check if user already has linked account of the given platform
-		userPlatforms, err := dal.GetPlatformNames(api.db, userID)
-		if err != nil {
-			api.log.WithFields(logrus.Fields{
-				"func": "Login",
-				"user": userID,
-				"err":  err,
-			}).Error("failed to get platform of user from db")
-			api.respondWithError(w, http.StatusInternalServerError, "something went wrong, try again later.")
-
+		if mergeID == params.UserID {
+			api.respondWithError(w, http.StatusBadRequest, "A user can't be merged into itself")
 			return
 		}
 
-		var found bool
-		for _, p := range userPlatforms {
-			if p == service[0] {
-				found = true
-				break
-			}
+		params.MergeUserID = mergeID
+	}
+
+	// Check if the optional parameter label was given. It names the account, to tell it apart from other accounts of the platform
+	if label := r.URL.Query().Get("label"); label != "" {
+		if len(label) > maxAccountLabelLength {
+			api.respondWithError(w, http.StatusBadRequest,
+				"'label' parameter can't be longer than "+strconv.Itoa(maxAccountLabelLength)+" characters")
+			return
 		}
 
-		if found {
-			// User already has an account linked from the given platform
-			api.log.WithFields(logrus.Fields{
-				"func":     "Login",
-				"platform": service[0],
-				"client":   parseToken.clientID,
-				"user":     userID,
-			}).Warn("client tried to link already linked platform account")
+		params.Label = label
+	}
 
-			api.respondWithError(w, http.StatusBadRequest, "user has already linked account of service '"+service[0]+"'")
+	// Check if the optional parameter consentDays was given. The user's consent expires after that many days
+	if consentDaysStr := r.URL.Query().Get("consentDays"); consentDaysStr != "" {
+		consentDays, err := strconv.Atoi(consentDaysStr)
+		if err != nil || consentDays < 1 || consentDays > maxConsentDays {
+			api.respondWithError(w, http.StatusBadRequest,
+				"'consentDays' parameter must be an integer between 1 and "+strconv.Itoa(maxConsentDays))
+			return
+		}
 
+		params.ConsentDays = consentDays
+	}
+
+	// Check if the optional parameter externalRef was given. It's stored once the user is known
+	if externalRef := r.URL.Query().Get("externalRef"); externalRef != "" {
+		if !api.checkExternalRefAvailable(w, parseToken.clientID, params.UserID, externalRef) {
 			return
 		}
 
-		// Add the validated userID to the params struct
-		params.UserID = userID
+		params.ExternalRef = externalRef
 	}
 
 	// TODO: This is dependent on OAuth2. When new auth types are needed, this will have to be changed
@@ -320,16 +343,29 @@ func (api *Api) Callback(w http.ResponseWriter, r *http.Request) {
 		}).Error("failed to retrieve OAuth2 token for user")
 
 		if callback != "" {
-			api.sendAuthorizationResult(w, r, 0, callback)
+			api.sendFailedAuthorizationResult(w, r, callback)
 		}
 
 		return
 	}
 
+	// Users signing in to the user portal don't link anything
+	if Oauth2Result.Portal {
+		api.signInToPortal(w, r, &Oauth2Result, callback)
+		return
+	}
+
+	// Neither do users authorizing a merge
+	if Oauth2Result.MergeUserID != 0 {
+		api.authorizeMerge(w, r, &Oauth2Result, callback)
+		return
+	}
+
 	// Is this request for a new user or an existing user?
-	if Oauth2Result.UserID == 0 {
+	userID := Oauth2Result.UserID
+	if userID == 0 {
 		// New user
-		userID, err := api.createUser(&Oauth2Result)
+		userID, err = api.createUser(&Oauth2Result)
 		if err != nil {
 			api.log.WithFields(logrus.Fields{
 				"func": "Callback",
@@ -339,24 +375,46 @@ func (api *Api) Callback(w http.ResponseWriter, r *http.Request) {
 
 			return
 		}
-
-		api.sendAuthorizationResult(w, r, userID, callback)
 	} else {
 		// Existing user
-		err = api.createUserCredentials(&Oauth2Result, Oauth2Result.UserID)
+		err = api.linkUserAccount(&Oauth2Result, userID)
 		if err != nil {
 			api.log.WithFields(logrus.Fields{
 				"func":   "Callback",
-				"userID": Oauth2Result.UserID,
+				"userID": userID,
 				"err":    err,
 			}).Error("failed to add new credentials to existing user")
 			api.sendFailedAuthorizationResult(w, r, callback)
 
 			return
 		}
+	}
 
-		api.sendAuthorizationResult(w, r, Oauth2Result.UserID, callback)
+	// The platform is already linked, so failing to store the reference doesn't fail the login.
+	// The client can still set it afterwards
+	if Oauth2Result.ExternalRef != "" {
+		err = dal.SetUserExternalRef(api.db, userID, Oauth2Result.ClientID, Oauth2Result.ExternalRef)
+		if err != nil {
+			api.log.WithFields(logrus.Fields{
+				"func":        "Callback",
+				"userID":      userID,
+				"externalRef": Oauth2Result.ExternalRef,
+				"err":         err,
+			}).Error("failed to set user external reference")
+		}
+	}
+
+	// Like the external reference, failing to record the consent doesn't fail the login
+	err = api.recordConsent(&Oauth2Result, userID)
+	if err != nil {
+		api.log.WithFields(logrus.Fields{
+			"func":   "Callback",
+			"userID": userID,
+			"err":    err,
+		}).Error("failed to record user consent")
 	}
+
+	api.sendPublicUserID(w, r, userID, Oauth2Result.ClientID, callback)
 }
 
 func (api *Api) SignUp(w http.ResponseWriter, r *http.Request) {
@@ -443,7 +501,19 @@ func (api *Api) SignUp(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	newClientID, err := dal.InsertNewClient(api.db, clientName, clientPassword)
+	// The email is optional. Without it, the client can't reset its password if it forgets it
+	clientEmail := r.Form.Get("email")
+	if clientEmail != "" && !isEmailValid(clientEmail) {
+		response := ClientSignUpResponse{
+			Success: false,
+			Error:   "Parameter 'email' must be a valid email address",
+		}
+		api.respondWithJSON(w, http.StatusBadRequest, response)
+
+		return
+	}
+
+	newClientID, err := dal.InsertNewClient(api.db, clientName, clientPassword, clientEmail)
 	if err != nil {
 		api.respondWithError(w, http.StatusInternalServerError, "Error occurred while attempting to create client")
 		api.log.WithFields(logrus.Fields{
@@ -517,33 +587,86 @@ func (api *Api) SignIn(w http.ResponseWriter, r *http.Request) {
 
 	clientID, err := dal.SignInClient(api.db, clientName, clientPassWord)
 	if err != nil {
-		if clientID == 0 {
-			// There wasn't any client name in the database that matched that name
+		switch err {
+		case dal.ErrInvalidCredentials, dal.ErrClientLocked:
+			// Don't tell the caller whether it was the name or the password that was wrong. Locked clients
+			// get the same response, otherwise locking would tell which client names exist
 			response := ClientSignInResponse{
-				Success:  false,
-				ClientID: 0,
-				Error:    "No client has the requested name: " + clientName,
+				Success: false,
+				Error:   "Incorrect client name or password",
 			}
-			api.respondWithJSON(w, http.StatusBadRequest, response)
+			api.respondWithJSON(w, http.StatusUnauthorized, response)
+		default:
+			api.log.WithFields(logrus.Fields{
+				"func": "SignIn",
+				"err":  err,
+			}).Error("failed to sign in client")
 
-			return
+			response := ClientSignInResponse{
+				Success: false,
+				Error:   "Error occurred while signing in",
+			}
+			api.respondWithJSON(w, http.StatusInternalServerError, response)
 		}
 
-		// If the clientID wasn't found, that means the password didn't match
+		return
+	}
+
+	// Start a session, so the client can manage itself from the mrthn website
+	generation, err := dal.GetClientSessionGeneration(api.db, clientID)
+	if err != nil {
+		api.log.WithFields(logrus.Fields{
+			"func": "SignIn",
+			"err":  err,
+		}).Error("failed to get client session generation")
+
 		response := ClientSignInResponse{
-			Success:  false,
-			ClientID: clientID,
-			Error:    "Incorrect password",
+			Success: false,
+			Error:   "Error occurred while signing in",
 		}
-		api.respondWithJSON(w, http.StatusBadRequest, response)
+		api.respondWithJSON(w, http.StatusInternalServerError, response)
+
+		return
+	}
+
+	clientSession, err := api.sessions.create(w, clientSessionCookie, clientSessionKind, clientID, generation)
+	if err != nil {
+		api.log.WithFields(logrus.Fields{
+			"func": "SignIn",
+			"err":  err,
+		}).Error("failed to create client session")
+
+		response := ClientSignInResponse{
+			Success: false,
+			Error:   "Error occurred while signing in",
+		}
+		api.respondWithJSON(w, http.StatusInternalServerError, response)
 
 		return
 	}
 
 	// Send a success message back
 	response := ClientSignInResponse{
-		Success:  true,
-		ClientID: clientID,
+		Success:   true,
+		ClientID:  clientID,
+		CSRFToken: clientSession.CSRFToken,
+	}
+	api.respondWithJSON(w, http.StatusOK, response)
+}
+
+func (api *Api) SignOut(w http.ResponseWriter, r *http.Request) {
+	api.sessions.clear(w, clientSessionCookie)
+	api.respondWithJSON(w, http.StatusOK, ClientActionResponse{Success: true})
+}
+
+func (api *Api) GetSession(w http.ResponseWriter, r *http.Request) {
+	// The session was already validated by the client session middleware
+	clientSession, _ := api.sessions.read(r, clientSessionCookie, clientSessionKind)
+
+	response := ClientSignInResponse{
+		Success:   true,
+		ClientID:  clientSession.SubjectID,
+		CSRFToken: clientSession.CSRFToken,
 	}
 	api.respondWithJSON(w, http.StatusOK, response)
 }
@@ -681,6 +804,17 @@ func (api *Api) UpdateClientCallback(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	err = helpers.ValidateRedirectURI(newCallback, api.development)
+	if err != nil {
+		response := CallbackUpdateResponse{
+			Success: false,
+			Error:   err.Error(),
+		}
+		api.respondWithJSON(w, http.StatusBadRequest, response)
+
+		return
+	}
+
 	// We have the new callback so now update the client with it
 	result, err := dal.UpdateCallback(api.db, clientID, newCallback)
 	if err != nil {
@@ -733,6 +867,18 @@ func (api *Api) GetValueOverPeriod(w http.ResponseWriter, r *http.Request) {
 		api.respondWithJSON(w, http.StatusInternalServerError, "Steps over period not yet implemented!")
 	case "calories":
 		api.respondWithJSON(w, http.StatusInternalServerError, "Calories over period not yet implemented!")
+	case "heartrate":
+		api.getValueOverPeriod(w, r, model.GetUserHeartRateOverPeriod)
+	case "weight":
+		api.getValueOverPeriod(w, r, model.GetUserWeightOverPeriod)
+	case "bmi":
+		api.getValueOverPeriod(w, r, model.GetUserBMIOverPeriod)
+	case "body-fat":
+		api.getValueOverPeriod(w, r, model.GetUserBodyFatOverPeriod)
+	case "floors":
+		api.getValueOverPeriod(w, r, model.GetUserFloorsOverPeriod)
+	case "elevation":
+		api.getValueOverPeriod(w, r, model.GetUserElevationOverPeriod)
 	default:
 		api.respondWithError(w, http.StatusBadRequest,
 			fmt.Sprintf("'resource' field must be a proper resource, received:'%s'", pathVariable))
@@ -741,24 +887,61 @@ func (api *Api) GetValueOverPeriod(w http.ResponseWriter, r *http.Request) {
 
 // Private Functions
 
-func (api *Api) sendAuthorizationResult(w http.ResponseWriter, r *http.Request, userId int, Callback string) {
-	// Add the url parameters to the callback url
-	Callback += fmt.Sprintf("?userId=%d", userId)
+// sendPublicUserID sends the client the ID it knows the user by, instead of mrthn's own user ID
+func (api *Api) sendPublicUserID(w http.ResponseWriter, r *http.Request, userID int, clientID int, Callback string) {
+	publicID, err := dal.GetPublicUserID(api.db, userID, clientID)
+	if err != nil {
+		api.log.WithFields(logrus.Fields{
+			"userID":   userID,
+			"clientID": clientID,
+			"err":      err,
+		}).Error("failed to get public user ID")
+		api.sendFailedAuthorizationResult(w, r, Callback)
+
+		return
+	}
+
+	api.sendAuthorizationResult(w, r, publicID, Callback)
+}
 
+func (api *Api) sendAuthorizationResult(w http.ResponseWriter, r *http.Request, userId string, Callback string) {
 	api.log.WithFields(logrus.Fields{
 		"callback": Callback,
 		"userId":   userId,
 	}).Info("sending login result to client")
 
-	http.Redirect(w, r, Callback, http.StatusTemporaryRedirect)
+	api.redirectToCallback(w, r, Callback, "userId", userId)
 }
 
+// sendFailedAuthorizationResult tells the client the user couldn't be signed in. No user ID is sent,
+// so a failed sign in can't be mistaken for a user
 func (api *Api) sendFailedAuthorizationResult(w http.ResponseWriter, r *http.Request, Callback string) {
 	api.log.WithFields(logrus.Fields{
 		"callback": Callback,
 	}).Info("sending failed login result to client")
 
-	http.Redirect(w, r, Callback, http.StatusInternalServerError)
+	api.redirectToCallback(w, r, Callback, "error", authorizationFailedError)
+}
+
+// redirectToCallback adds the url parameter to the callback url, keeping any query the client put in it
+func (api *Api) redirectToCallback(w http.ResponseWriter, r *http.Request, Callback string, key string, value string) {
+	callbackURL, err := url.Parse(Callback)
+	if err != nil {
+		api.log.WithFields(logrus.Fields{
+			"func":     "redirectToCallback",
+			"callback": Callback,
+			"err":      err.Error(),
+		}).Error("failed to parse client callback")
+
+		api.respondWithError(w, http.StatusInternalServerError, "Something went wrong... Try again later")
+		return
+	}
+
+	query := callbackURL.Query()
+	query.Set(key, value)
+	callbackURL.RawQuery = query.Encode()
+
+	http.Redirect(w, r, callbackURL.String(), http.StatusTemporaryRedirect)
 }
 
 func (api *Api) getRequestParams(r *http.Request, fields logrus.Fields, params map[string]bool) (resultMap map[string]string, err error) {
@@ -826,17 +1009,33 @@ func (api *Api) getValueDaily(w http.ResponseWriter, r *http.Request, dailyFunc
 	}
 
 	// Check if the client has access to this user
-	if !api.clientCanQueryUser(w, r, verifiedParams.userID) {
+	userID, publicID, ok := api.getQueriedUser(w, r, verifiedParams.userID)
+	if !ok {
+		return
+	}
+
+	// The user's consent may have expired, and they may not share everything with the client
+	sharing, ok := api.getSharingPreferences(w, r, userID)
+	if !ok {
+		return
+	}
+
+	priorities, ok := api.getPlatformPriorities(w, r, userID)
+	if !ok {
 		return
 	}
 
 	// Now that the parameters have been parsed, we can call the API method
 	params := model.GetValueParams{
-		DB:          api.db,
-		Log:         api.log,
-		UserID:      verifiedParams.userID,
-		Date:        verifiedParams.date,
-		LargestOnly: verifiedParams.largestOnly,
+		DB:         api.db,
+		Log:        api.log,
+		UserID:     userID,
+		Date:       verifiedParams.date,
+		Strategy:   verifiedParams.strategy,
+		Priority:   verifiedParams.priority,
+		Priorities: priorities,
+		Sharing:    sharing,
+		Units:      verifiedParams.units,
 	}
 	values, err := dailyFunc(params)
 	if err != nil {
@@ -845,8 +1044,11 @@ func (api *Api) getValueDaily(w http.ResponseWriter, r *http.Request, dailyFunc
 		return
 	}
 
+	api.recordUserEvent(r, userID, dal.UserEventDataAccessed,
+		fmt.Sprintf("%s on %s", mux.Vars(r)["resource"], verifiedParams.date.Format(helpers.ISOLayout)))
+
 	response := GetValueResponse{
-		ID:     verifiedParams.userID,
+		ID:     publicID,
 		Result: values,
 	}
 	api.respondWithJSON(w, http.StatusOK, response)
@@ -886,16 +1088,32 @@ func (api *Api) getValueOverPeriod(w http.ResponseWriter, r *http.Request, perio
 	}
 
 	// Check if the client has access to this user
-	if !api.clientCanQueryUser(w, r, verifiedParams.userID) {
+	userID, publicID, ok := api.getQueriedUser(w, r, verifiedParams.userID)
+	if !ok {
+		return
+	}
+
+	// The user's consent may have expired, and they may not share everything with the client
+	sharing, ok := api.getSharingPreferences(w, r, userID)
+	if !ok {
+		return
+	}
+
+	priorities, ok := api.getPlatformPriorities(w, r, userID)
+	if !ok {
 		return
 	}
 
 	params := model.GetValueParams{
-		DB:          api.db,
-		Log:         api.log,
-		UserID:      verifiedParams.userID,
-		Date:        verifiedParams.date,
-		LargestOnly: verifiedParams.largestOnly,
+		DB:         api.db,
+		Log:        api.log,
+		UserID:     userID,
+		Date:       verifiedParams.date,
+		Strategy:   verifiedParams.strategy,
+		Priority:   verifiedParams.priority,
+		Priorities: priorities,
+		Sharing:    sharing,
+		Units:      verifiedParams.units,
 	}
 
 	values, err := periodFunc(params, verifiedParams.period)
@@ -905,15 +1123,21 @@ func (api *Api) getValueOverPeriod(w http.ResponseWriter, r *http.Request, perio
 		return
 	}
 
+	api.recordUserEvent(r, userID, dal.UserEventDataAccessed,
+		fmt.Sprintf("%s over %s from %s", mux.Vars(r)["resource"], verifiedParams.period, verifiedParams.date.Format(helpers.ISOLayout)))
+
 	response := GetValueResponse{
-		ID:     verifiedParams.userID,
+		ID:     publicID,
 		Result: values,
 	}
 	api.respondWithJSON(w, http.StatusOK, response)
 }
 
-func (api *Api) clientHasAccessToUser(w http.ResponseWriter, clientID, userID int) bool {
-	dbUser, err := dal.GetUserInUserbase(api.db, userID, clientID)
+// getClientUser resolves the public ID a client knows a user by into the user's ID and current public ID,
+// which differs from the given one if the user was merged into another.
+// If the client has no user with that public ID, an error is sent back to the caller
+func (api *Api) getClientUser(w http.ResponseWriter, clientID int, publicID string) (int, string, bool) {
+	userID, currentPublicID, err := dal.GetUserByPublicID(api.db, publicID, clientID)
 	if err != nil {
 		api.log.WithFields(logrus.Fields{
 			"err": err,
@@ -921,39 +1145,168 @@ func (api *Api) clientHasAccessToUser(w http.ResponseWriter, clientID, userID in
 
 		api.respondWithError(w, http.StatusInternalServerError, "Something went wrong... Try again later")
 
-		return false
+		return 0, "", false
 	}
 
-	if dbUser != userID {
+	if userID == 0 {
 		// Client does not have permission to access this user!
 		api.log.WithFields(logrus.Fields{
-			"userID":   userID,
+			"userID":   publicID,
 			"clientID": clientID,
 		}).Warn("client tried to access unauthorized or non-existent user")
 
 		api.respondWithError(w, http.StatusNotFound, "User with specified ID was not found")
 
+		return 0, "", false
+	}
+
+	return userID, currentPublicID, true
+}
+
+// checkConsent makes sure the user's consent to the client that made the request hasn't expired.
+// If it has, an error asking the client to send the user through /login again is sent back to the caller
+func (api *Api) checkConsent(w http.ResponseWriter, r *http.Request, userID int) bool {
+	clientID := context.Get(r, "client_id").(int) // This was set during JWT validation middleware
+
+	expiresAt, err := dal.GetConsentExpiry(api.db, userID, clientID)
+	if err != nil {
+		api.log.WithFields(logrus.Fields{
+			"userID":   userID,
+			"clientID": clientID,
+			"err":      err,
+		}).Error("failed to get user consent")
+
+		api.respondWithError(w, http.StatusInternalServerError, "Something went wrong... Try again later")
+		return false
+	}
+
+	if expiresAt.Valid && time.Now().After(expiresAt.Time) {
+		api.log.WithFields(logrus.Fields{
+			"userID":   userID,
+			"clientID": clientID,
+		}).Info("client tried to access user with expired consent")
+
+		api.respondWithJSON(w, http.StatusForbidden, ConsentExpiredResponse{
+			Error:     consentExpiredMessage,
+			ExpiredAt: expiresAt.Time.Format(helpers.ISO8601Layout),
+		})
 		return false
 	}
 
 	return true
 }
 
-func (api *Api) clientCanQueryUser(w http.ResponseWriter, r *http.Request, userID int) bool {
+// getSharingPreferences returns what the user withholds from the client that made the request.
+// If they can't be read, an error is sent back to the caller
+func (api *Api) getSharingPreferences(w http.ResponseWriter, r *http.Request, userID int) (dal.SharingPreferences, bool) {
+	clientID := context.Get(r, "client_id").(int) // This was set during JWT validation middleware
+
+	sharing, err := dal.GetSharingPreferences(api.db, userID, clientID)
+	if err != nil {
+		api.log.WithFields(logrus.Fields{
+			"userID":   userID,
+			"clientID": clientID,
+			"err":      err,
+		}).Error("failed to get user sharing preferences")
+
+		api.respondWithError(w, http.StatusInternalServerError, "Something went wrong... Try again later")
+		return dal.SharingPreferences{}, false
+	}
+
+	return sharing, true
+}
+
+// getPlatformPriorities returns the platform priorities the client that made the request stored for the user.
+// If they can't be read, an error is sent back to the caller
+func (api *Api) getPlatformPriorities(w http.ResponseWriter, r *http.Request, userID int) (dal.PlatformPriorities, bool) {
+	clientID := context.Get(r, "client_id").(int) // This was set during JWT validation middleware
+
+	priorities, err := dal.GetPlatformPriorities(api.db, userID, clientID)
+	if err != nil {
+		api.log.WithFields(logrus.Fields{
+			"userID":   userID,
+			"clientID": clientID,
+			"err":      err,
+		}).Error("failed to get user platform priorities")
+
+		api.respondWithError(w, http.StatusInternalServerError, "Something went wrong... Try again later")
+		return nil, false
+	}
+
+	return priorities, true
+}
+
+// getQueriedUser resolves the user ID sent by the client that made the request, identified by its JWT.
+// The ID is the public ID by default, or the client's external reference when the 'idType' parameter asks for it.
+// Along with the user's ID, it returns the public ID the client knows the user by.
+// Except for deletions, the user's consent to the client must not have expired
+func (api *Api) getQueriedUser(w http.ResponseWriter, r *http.Request, requestedID string) (int, string, bool) {
 	clientID := context.Get(r, "client_id") // This was set during JWT validation middleware
 	if clientID == nil {
 		api.log.Error("failed to get client ID from JWT token")
 		api.respondWithError(w, http.StatusInternalServerError, "Something went wrong... Try again later")
 
-		return false
+		return 0, "", false
+	}
+
+	var userID int
+	var publicID string
+	var ok bool
+	switch idType := r.URL.Query().Get("idType"); idType {
+	case "", idTypeMrthn:
+		userID, publicID, ok = api.getClientUser(w, clientID.(int), requestedID)
+	case idTypeExternalRef:
+		userID, publicID, ok = api.getClientUserByExternalRef(w, clientID.(int), requestedID)
+	default:
+		api.respondWithError(w, http.StatusBadRequest,
+			fmt.Sprintf("'idType' parameter must either be '%s' or '%s', received '%s'", idTypeMrthn, idTypeExternalRef, idType))
+
+		return 0, "", false
+	}
+
+	if !ok {
+		return 0, "", false
+	}
+
+	// Clients must still be able to remove what they have of a user once its consent expired
+	if r.Method != http.MethodDelete && !api.checkConsent(w, r, userID) {
+		return 0, "", false
+	}
+
+	return userID, publicID, true
+}
+
+// getClientUserByExternalRef resolves the client's own ID for a user into the user's ID and public ID.
+// If the client has no user with that reference, an error is sent back to the caller
+func (api *Api) getClientUserByExternalRef(w http.ResponseWriter, clientID int, externalRef string) (int, string, bool) {
+	userID, publicID, err := dal.GetUserByExternalRef(api.db, externalRef, clientID)
+	if err != nil {
+		api.log.WithFields(logrus.Fields{
+			"err": err,
+		}).Error("failed to get user from the database")
+
+		api.respondWithError(w, http.StatusInternalServerError, "Something went wrong... Try again later")
+
+		return 0, "", false
+	}
+
+	if userID == 0 {
+		api.log.WithFields(logrus.Fields{
+			"externalRef": externalRef,
+			"clientID":    clientID,
+		}).Warn("client tried to access non-existent user")
+
+		api.respondWithError(w, http.StatusNotFound, "User with specified external reference was not found")
+
+		return 0, "", false
 	}
 
-	return api.clientHasAccessToUser(w, clientID.(int), userID)
+	return userID, publicID, true
 }
 
 func (api *Api) createUser(Oauth2Params *auth.OAuth2Result) (int, error) {
 	// Before we create the user, check the ID to see if it's in the database
-	userID, err := dal.GetUserByPlatformID(api.db, Oauth2Params.PlatformID, Oauth2Params.PlatformName)
+	userID, credentialID, err := dal.GetUserByPlatformID(api.db, Oauth2Params.PlatformID, Oauth2Params.PlatformName)
 	if err != nil {
 		return 0, err
 	}
@@ -962,19 +1315,19 @@ func (api *Api) createUser(Oauth2Params *auth.OAuth2Result) (int, error) {
 		// This user already exists in the mrthn User table.
 
 		// Update their credentials, since they logged in again
-		err = dal.UpdateCredentialsUsingOAuth2Tokens(api.db, userID, Oauth2Params.Token)
+		err = api.updateAccountTokens(Oauth2Params, credentialID)
 		if err != nil {
 			return 0, err
 		}
 
 		// The user may not exist in the clients userbase.
 		// Check if they do.
-		userID, err := dal.GetUserInUserbase(api.db, userID, Oauth2Params.ClientID)
+		userbaseID, err := dal.GetUserInUserbase(api.db, userID, Oauth2Params.ClientID)
 		if err != nil {
 			return 0, err
 		}
 
-		if userID == 0 {
+		if userbaseID == 0 {
 			// The user exists, but is not in the clients userbase. Add it.
 			err := dal.InsertUserToUserbase(api.db, userID, Oauth2Params.ClientID)
 			if err != nil {
@@ -1006,6 +1359,49 @@ func (api *Api) createUser(Oauth2Params *auth.OAuth2Result) (int, error) {
 	return newUserID, nil
 }
 
+// linkUserAccount adds the platform account the user logged in with to an existing user.
+// If the user already linked that account, its tokens are replaced instead
+func (api *Api) linkUserAccount(Oauth2Params *auth.OAuth2Result, userID int) error {
+	ownerID, credentialID, err := dal.GetUserByPlatformID(api.db, Oauth2Params.PlatformID, Oauth2Params.PlatformName)
+	if err != nil {
+		return err
+	}
+
+	if ownerID == 0 {
+		return api.createUserCredentials(Oauth2Params, userID)
+	}
+
+	if ownerID != userID {
+		return errors.New("platform account is already linked to another user")
+	}
+
+	return api.updateAccountTokens(Oauth2Params, credentialID)
+}
+
+// recordConsent stores the user's authorization of the client, which expires if the client asked for it to
+func (api *Api) recordConsent(Oauth2Params *auth.OAuth2Result, userID int) error {
+	var expiresAt sql.NullTime
+	if Oauth2Params.ConsentDays > 0 {
+		expiresAt = sql.NullTime{
+			Time:  time.Now().AddDate(0, 0, Oauth2Params.ConsentDays),
+			Valid: true,
+		}
+	}
+
+	return dal.InsertConsent(api.db, userID, Oauth2Params.ClientID, Oauth2Params.PlatformName, Oauth2Params.Scopes, expiresAt)
+}
+
+// updateAccountTokens replaces the tokens of a platform account the user logged in with again
+func (api *Api) updateAccountTokens(Oauth2Params *auth.OAuth2Result, credentialID int) error {
+	err := dal.UpdateCredentialsUsingOAuth2Tokens(api.db, credentialID, Oauth2Params.Token)
+	if err != nil {
+		return err
+	}
+
+	// The new tokens work, even if the old ones couldn't be refreshed
+	return dal.MarkCredentialsRefreshed(api.db, credentialID)
+}
+
 func (api *Api) createUserCredentials(Oauth2Params *auth.OAuth2Result, userID int) error {
 	var connectionParams = []string{
 		"oauth2",
@@ -1024,6 +1420,7 @@ func (api *Api) createUserCredentials(Oauth2Params *auth.OAuth2Result, userID in
 		ClientID:         Oauth2Params.ClientID,
 		PlatformName:     Oauth2Params.PlatformName,
 		UPID:             Oauth2Params.PlatformID,
+		Label:            Oauth2Params.Label,
 		ConnectionString: connStr,
 	}
 	userID, err = dal.InsertUserCredentials(api.db, params)
@@ -1038,6 +1435,32 @@ func (api *Api) createUserCredentials(Oauth2Params *auth.OAuth2Result, userID in
 	return nil
 }
 
+// getClientIDFromPath parses the clientID path variable. If it's invalid, a failure is sent back to the caller
+func (api *Api) getClientIDFromPath(w http.ResponseWriter, r *http.Request, funcName string) (int, bool) {
+	vars := mux.Vars(r)
+	clientID, err := strconv.Atoi(vars["clientID"])
+	if err != nil {
+		api.log.WithFields(logrus.Fields{
+			"func":     funcName,
+			"err":      "clientID received must be an integer",
+			"received": vars["clientID"],
+		}).Error("failed to parse client 'clientID' parameter")
+
+		api.respondWithFailure(w, http.StatusBadRequest, "clientID must be an integer")
+		return 0, false
+	}
+
+	return clientID, true
+}
+
+// respondWithFailure sends an error in the format used by the endpoints called by the mrthn website
+func (api *Api) respondWithFailure(w http.ResponseWriter, code int, message string) {
+	api.respondWithJSON(w, code, ClientActionResponse{
+		Success: false,
+		Error:   message,
+	})
+}
+
 func (api *Api) respondWithError(w http.ResponseWriter, code int, message string) {
 	err := api.respondWithJSON(w, code, map[string]string{"error": message})
 	if err == nil {
@@ -1103,11 +1526,10 @@ func verifyParameters(obtainedParams map[string]string) (verifiedParams, error)
 	result := verifiedParams{}
 
 	// Verify the userID
-	userID, err := strconv.Atoi(obtainedParams["userID"])
-	if err != nil {
-		return verifiedParams{}, errors.New("'userID' parameter must be an integer")
+	if obtainedParams["userID"] == "" {
+		return verifiedParams{}, errors.New("'userID' parameter must not be empty")
 	}
-	result.userID = userID
+	result.userID = obtainedParams["userID"]
 
 	// Verify the date
 	date, err := helpers.ParseISODate(obtainedParams["date"])
@@ -1153,5 +1575,79 @@ func verifyParameters(obtainedParams map[string]string) (verifiedParams, error)
 		result.largestOnly = false
 	}
 
+	result.strategy, err = parseStrategy(obtainedParams["strategy"], result.largestOnly)
+	if err != nil {
+		return verifiedParams{}, err
+	}
+
+	result.priority, err = parsePriority(obtainedParams["priority"], result.strategy)
+	if err != nil {
+		return verifiedParams{}, err
+	}
+
+	result.units, err = parseUnits(obtainedParams["units"])
+	if err != nil {
+		return verifiedParams{}, err
+	}
+
 	return result, nil
 }
+
+// parseStrategy checks how a client wants the values of the user's platforms reconciled. largestOnly is
+// the same as the max strategy. Without either, the strategy is left empty, so the priorities stored for the user apply
+func parseStrategy(strategy string, largestOnly bool) (string, error) {
+	if strategy == "" {
+		if largestOnly {
+			return model.StrategyMax, nil
+		}
+		return "", nil
+	}
+
+	if largestOnly && strategy != model.StrategyMax {
+		return "", errors.New(fmt.Sprintf("'largestOnly' parameter can't be used with the '%s' strategy", strategy))
+	}
+
+	for _, s := range model.Strategies {
+		if s == strategy {
+			return strategy, nil
+		}
+	}
+
+	return "", errors.New(fmt.Sprintf("'strategy' parameter must be one of '%s', received '%s'",
+		strings.Join(model.Strategies, "', '"), strategy))
+}
+
+// parsePriority checks the comma separated platforms the priority strategy prefers
+func parsePriority(priority string, strategy string) ([]string, error) {
+	platformNames := splitList(priority)
+	if strategy != model.StrategyPriority {
+		if len(platformNames) > 0 {
+			return nil, errors.New("'priority' parameter can only be used with the 'priority' strategy")
+		}
+		return nil, nil
+	}
+
+	if len(platformNames) == 0 {
+		return nil, errors.New("'priority' parameter must list at least one platform when using the 'priority' strategy")
+	}
+
+	for _, platformName := range platformNames {
+		if !platform.IsPlatformAvailable(platformName) {
+			return nil, errors.New(fmt.Sprintf("'priority' parameter has an unknown platform, received '%s'", platformName))
+		}
+	}
+
+	return platformNames, nil
+}
+
+// parseUnits checks the unit system a client asked for. Values are metric unless the client asks otherwise
+func parseUnits(units string) (string, error) {
+	switch units {
+	case "", model.UnitsMetric:
+		return model.UnitsMetric, nil
+	case model.UnitsImperial:
+		return model.UnitsImperial, nil
+	default:
+		return "", errors.New(fmt.Sprintf("'units' parameter must either be 'metric' or 'imperial', received '%s'", units))
+	}
+}
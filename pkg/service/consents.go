@@ -0,0 +1,80 @@
+/*
+ * mrthn API
+ *
+ * One login for all your fitness data needs.
+ *
+ * API version: 0.1.0
+ */
+package service
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/context"
+	"github.com/sirupsen/logrus"
+
+	"github.com/msgurgel/mrthn/pkg/dal"
+	"github.com/msgurgel/mrthn/pkg/helpers"
+)
+
+const defaultExpiringConsentDays = 7
+
+// GetExpiringConsents lists the users whose consent expires within the given days, or already expired.
+// Those users have to go through /login again for the client to keep reading their data
+func (api *Api) GetExpiringConsents(w http.ResponseWriter, r *http.Request) {
+	clientID := context.Get(r, "client_id").(int) // This was set during JWT validation middleware
+
+	days, ok := api.getPositiveQueryParam(w, r, "days", defaultExpiringConsentDays)
+	if !ok {
+		return
+	}
+
+	if days > maxConsentDays {
+		api.respondWithError(w, http.StatusBadRequest,
+			"'days' parameter can't be larger than "+strconv.Itoa(maxConsentDays))
+		return
+	}
+
+	consents, err := dal.GetExpiringConsents(api.db, clientID, time.Now().AddDate(0, 0, days))
+	if err != nil {
+		api.log.WithFields(logrus.Fields{
+			"func":     "GetExpiringConsents",
+			"clientID": clientID,
+			"err":      err,
+		}).Error("failed to get expiring consents")
+
+		api.respondWithError(w, http.StatusInternalServerError, "Something went wrong... Try again later")
+		return
+	}
+
+	response := ExpiringConsentsResponse{
+		Consents: make([]ConsentResponse, 0, len(consents)),
+	}
+	for _, consent := range consents {
+		response.Consents = append(response.Consents, formatConsent(consent))
+	}
+
+	api.respondWithJSON(w, http.StatusOK, response)
+}
+
+func formatConsent(consent dal.Consent) ConsentResponse {
+	result := ConsentResponse{
+		UserID:      consent.PublicID,
+		ExternalRef: consent.ExternalRef,
+		Platform:    consent.Platform,
+		Scopes:      consent.Scopes,
+		GrantedAt:   consent.GrantedAt.Format(helpers.ISO8601Layout),
+	}
+
+	if result.Scopes == nil {
+		result.Scopes = []string{}
+	}
+
+	if consent.ExpiresAt.Valid {
+		result.ExpiresAt = consent.ExpiresAt.Time.Format(helpers.ISO8601Layout)
+	}
+
+	return result
+}
@@ -0,0 +1,58 @@
+/*
+ * mrthn API
+ *
+ * One login for all your fitness data needs.
+ *
+ * API version: 0.1.0
+ */
+package service
+
+import (
+	"net/http"
+
+	"github.com/gorilla/context"
+	"github.com/sirupsen/logrus"
+
+	"github.com/msgurgel/mrthn/pkg/dal"
+	"github.com/msgurgel/mrthn/pkg/helpers"
+)
+
+const maxEventsPerRequest = 100
+
+// GetClientEvents returns the events of the client, such as users revoking its access, oldest first.
+// Clients poll it with the ID of the last event they saw in the 'after' parameter
+func (api *Api) GetClientEvents(w http.ResponseWriter, r *http.Request) {
+	clientID := context.Get(r, "client_id").(int) // This was set during JWT validation middleware
+
+	afterID, ok := api.getPositiveQueryParam(w, r, "after", 0)
+	if !ok {
+		return
+	}
+
+	events, err := dal.GetClientEvents(api.db, clientID, afterID, maxEventsPerRequest)
+	if err != nil {
+		api.log.WithFields(logrus.Fields{
+			"func":     "GetClientEvents",
+			"clientID": clientID,
+			"err":      err,
+		}).Error("failed to get client events")
+
+		api.respondWithError(w, http.StatusInternalServerError, "Something went wrong... Try again later")
+		return
+	}
+
+	response := ClientEventsResponse{
+		Events: make([]ClientEventResponse, 0, len(events)),
+	}
+	for _, event := range events {
+		response.Events = append(response.Events, ClientEventResponse{
+			ID:          event.ID,
+			Event:       event.Event,
+			UserID:      event.PublicID,
+			ExternalRef: event.ExternalRef,
+			CreatedAt:   event.CreatedAt.Format(helpers.ISO8601Layout),
+		})
+	}
+
+	api.respondWithJSON(w, http.StatusOK, response)
+}
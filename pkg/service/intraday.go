@@ -0,0 +1,132 @@
+/*
+ * mrthn API
+ *
+ * One login for all your fitness data needs.
+ *
+ * API version: 0.1.0
+ */
+package service
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/context"
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+
+	"github.com/msgurgel/mrthn/pkg/dal"
+	"github.com/msgurgel/mrthn/pkg/helpers"
+	"github.com/msgurgel/mrthn/pkg/model"
+)
+
+const defaultIntradayInterval = "1h"
+
+// intradayIntervals are the lengths of the intervals clients can split a day in
+var intradayIntervals = map[string]time.Duration{
+	"1m":  time.Minute,
+	"15m": 15 * time.Minute,
+	"1h":  time.Hour,
+}
+
+// GetValueIntraday splits a day of steps, calories or heart rate in intervals. Platforms treat these as sensitive,
+// so only clients that were granted the intraday scope can read them
+func (api *Api) GetValueIntraday(w http.ResponseWriter, r *http.Request) {
+	clientID := context.Get(r, "client_id").(int) // This was set during JWT validation middleware
+
+	granted, err := dal.HasClientScope(api.db, clientID, dal.ClientScopeIntraday)
+	if err != nil {
+		api.log.WithFields(logrus.Fields{
+			"func":     "GetValueIntraday",
+			"clientID": clientID,
+			"err":      err,
+		}).Error("failed to check client scope")
+
+		api.respondWithError(w, http.StatusInternalServerError, "Something went wrong... Try again later")
+		return
+	}
+
+	if !granted {
+		api.respondWithError(w, http.StatusForbidden, "Client isn't allowed to read intraday data")
+		return
+	}
+
+	resource := mux.Vars(r)["resource"]
+	switch resource {
+	case model.ResourceSteps, model.ResourceCalories, model.ResourceHeartRate:
+	default:
+		api.respondWithError(w, http.StatusBadRequest,
+			fmt.Sprintf("'resource' field must be 'steps', 'calories' or 'heartrate', received:'%s'", resource))
+		return
+	}
+
+	intervalName := r.URL.Query().Get("interval")
+	if intervalName == "" {
+		intervalName = defaultIntradayInterval
+	}
+
+	interval, ok := intradayIntervals[intervalName]
+	if !ok {
+		api.respondWithError(w, http.StatusBadRequest,
+			fmt.Sprintf("'interval' parameter must be '1m', '15m' or '1h', received '%s'", intervalName))
+		return
+	}
+
+	requestParams, err := api.getRequestParams(r, logrus.Fields{"func": "GetValueIntraday"}, paramsMapRegular)
+	if err != nil {
+		api.respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// Verify the parameters we got from the request
+	verifiedParams, err := verifyParameters(requestParams)
+	if err != nil {
+		api.respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// Check if the client has access to this user
+	userID, publicID, ok := api.getQueriedUser(w, r, verifiedParams.userID)
+	if !ok {
+		return
+	}
+
+	// The user's consent may have expired, and they may not share everything with the client
+	sharing, ok := api.getSharingPreferences(w, r, userID)
+	if !ok {
+		return
+	}
+
+	priorities, ok := api.getPlatformPriorities(w, r, userID)
+	if !ok {
+		return
+	}
+
+	params := model.GetValueParams{
+		DB:         api.db,
+		Log:        api.log,
+		UserID:     userID,
+		Date:       verifiedParams.date,
+		Strategy:   verifiedParams.strategy,
+		Priority:   verifiedParams.priority,
+		Priorities: priorities,
+		Sharing:    sharing,
+		Units:      verifiedParams.units,
+	}
+	values, err := model.GetUserIntraday(params, resource, interval)
+	if err != nil {
+		// TODO: Change this to a more fitting HTTP code
+		api.respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	api.recordUserEvent(r, userID, dal.UserEventDataAccessed,
+		fmt.Sprintf("%s intraday on %s", resource, verifiedParams.date.Format(helpers.ISOLayout)))
+
+	response := GetValueResponse{
+		ID:     publicID,
+		Result: values,
+	}
+	api.respondWithJSON(w, http.StatusOK, response)
+}
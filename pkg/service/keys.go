@@ -0,0 +1,210 @@
+/*
+ * mrthn API
+ *
+ * One login for all your fitness data needs.
+ *
+ * API version: 0.1.0
+ */
+package service
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+
+	"github.com/msgurgel/mrthn/pkg/dal"
+	"github.com/msgurgel/mrthn/pkg/helpers"
+)
+
+// defaultKeyLabel is used for keys created through /get-token without a label
+const defaultKeyLabel = "default"
+
+const maxKeyLabelLength = 64
+
+// tooManyKeysMessage tells the client how to make room for a new key
+var tooManyKeysMessage = "Client already has " + strconv.Itoa(dal.MaxActiveClientKeys) + " active keys. Revoke one before creating another"
+
+func (api *Api) GetClientKeys(w http.ResponseWriter, r *http.Request) {
+	clientID, ok := api.getClientIDFromPath(w, r, "GetClientKeys")
+	if !ok {
+		return
+	}
+
+	keys, err := dal.GetClientKeys(api.db, clientID)
+	if err != nil {
+		api.log.WithFields(logrus.Fields{
+			"func":     "GetClientKeys",
+			"clientID": clientID,
+			"err":      err,
+		}).Error("failed to get client keys")
+
+		api.respondWithFailure(w, http.StatusInternalServerError, "Error occurred while retrieving client keys")
+		return
+	}
+
+	response := ClientKeysResponse{
+		Success: true,
+		Keys:    make([]ClientKey, 0, len(keys)),
+	}
+	for _, key := range keys {
+		response.Keys = append(response.Keys, formatClientKey(key))
+	}
+
+	api.respondWithJSON(w, http.StatusOK, response)
+}
+
+func (api *Api) CreateClientKey(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseMultipartForm(500)
+	if err != nil {
+		api.respondWithFailure(w, http.StatusInternalServerError, "Error occurred while attempting to parse form values")
+
+		api.log.WithFields(logrus.Fields{
+			"func": "CreateClientKey",
+			"err":  err,
+		}).Error("failed to parse request form values")
+
+		return
+	}
+
+	clientID, ok := api.getClientIDFromPath(w, r, "CreateClientKey")
+	if !ok {
+		return
+	}
+
+	label := r.Form.Get("label")
+	if label == "" || len(label) > maxKeyLabelLength {
+		api.respondWithFailure(w, http.StatusBadRequest,
+			"Expected parameter 'label' in request, with at most "+strconv.Itoa(maxKeyLabelLength)+" characters")
+		return
+	}
+
+	// The expiry date is optional. Keys without one are valid until they are revoked
+	var expiresAt sql.NullTime
+	if expiryStr := r.Form.Get("expiresAt"); expiryStr != "" {
+		expiry, err := helpers.ParseISODate(expiryStr)
+		if err != nil || !expiry.After(time.Now()) {
+			api.respondWithFailure(w, http.StatusBadRequest,
+				"'expiresAt' parameter must be a future date in the format YYYY-MM-DD")
+			return
+		}
+
+		expiresAt = sql.NullTime{Time: expiry, Valid: true}
+	}
+
+	keyID, tokenString, err := api.createClientKey(clientID, label, expiresAt)
+	if err == dal.ErrTooManyClientKeys {
+		api.respondWithFailure(w, http.StatusConflict, tooManyKeysMessage)
+		return
+	}
+	if err != nil {
+		api.log.WithFields(logrus.Fields{
+			"func":     "CreateClientKey",
+			"clientID": clientID,
+			"err":      err,
+		}).Error("failed to create new client key")
+
+		api.respondWithFailure(w, http.StatusInternalServerError, "Error occurred while creating client key")
+		return
+	}
+
+	key := formatClientKey(dal.ClientKey{
+		ID:        keyID,
+		Label:     label,
+		CreatedAt: time.Now(),
+		ExpiresAt: expiresAt,
+	})
+	response := CreateClientKeyResponse{
+		Success: true,
+		Key:     &key,
+		Token:   tokenString,
+	}
+	api.respondWithJSON(w, http.StatusCreated, response)
+}
+
+func (api *Api) RevokeClientKey(w http.ResponseWriter, r *http.Request) {
+	clientID, ok := api.getClientIDFromPath(w, r, "RevokeClientKey")
+	if !ok {
+		return
+	}
+
+	keyID, err := strconv.Atoi(mux.Vars(r)["keyID"])
+	if err != nil {
+		api.respondWithFailure(w, http.StatusBadRequest, "keyID must be an integer")
+		return
+	}
+
+	revoked, err := dal.RevokeClientKey(api.db, clientID, keyID)
+	if err != nil {
+		api.log.WithFields(logrus.Fields{
+			"func":     "RevokeClientKey",
+			"clientID": clientID,
+			"keyID":    keyID,
+			"err":      err,
+		}).Error("failed to revoke client key")
+
+		api.respondWithFailure(w, http.StatusInternalServerError, "Error occurred while revoking client key")
+		return
+	}
+
+	if !revoked {
+		api.respondWithFailure(w, http.StatusNotFound, "No active key matches passed in keyID")
+		return
+	}
+
+	api.log.WithFields(logrus.Fields{
+		"clientID": clientID,
+		"keyID":    keyID,
+	}).Info("client key revoked")
+
+	api.respondWithJSON(w, http.StatusOK, ClientActionResponse{Success: true})
+}
+
+// createClientKey generates a new secret for the client, stores it and returns the key ID and a JWT signed with it
+func (api *Api) createClientKey(clientID int, label string, expiresAt sql.NullTime) (int, string, error) {
+	secret := make([]byte, 64)
+	if _, err := io.ReadFull(rand.Reader, secret); err != nil {
+		return 0, "", err
+	}
+
+	keyID, err := dal.InsertClientKey(api.db, clientID, label, secret, expiresAt)
+	if err != nil {
+		return 0, "", err
+	}
+
+	tokenString, err := generateJWT(clientID, keyID, secret, expiresAt)
+	if err != nil {
+		return 0, "", err
+	}
+
+	api.log.WithFields(logrus.Fields{
+		"clientID": clientID,
+		"keyID":    keyID,
+		"label":    label,
+	}).Info("new client key created")
+
+	return keyID, tokenString, nil
+}
+
+func formatClientKey(key dal.ClientKey) ClientKey {
+	result := ClientKey{
+		ID:        key.ID,
+		Label:     key.Label,
+		CreatedAt: key.CreatedAt.Format(helpers.ISO8601Layout),
+	}
+
+	if key.LastUsed.Valid {
+		result.LastUsed = key.LastUsed.Time.Format(helpers.ISO8601Layout)
+	}
+
+	if key.ExpiresAt.Valid {
+		result.ExpiresAt = key.ExpiresAt.Time.Format(helpers.ISO8601Layout)
+	}
+
+	return result
+}
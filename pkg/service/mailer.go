@@ -0,0 +1,57 @@
+/*
+ * mrthn API
+ *
+ * One login for all your fitness data needs.
+ *
+ * API version: 0.1.0
+ */
+package service
+
+import (
+	"net"
+	"net/smtp"
+	"strings"
+
+	"github.com/msgurgel/mrthn/pkg/environment"
+)
+
+// mailer sends plain text emails to clients through the SMTP server set in the environment
+type mailer struct {
+	host     string
+	port     string
+	username string
+	password string
+	from     string
+}
+
+func newMailer(config *environment.MrthnConfig) mailer {
+	return mailer{
+		host:     config.Mail.Host,
+		port:     config.Mail.Port,
+		username: config.Mail.Username,
+		password: config.Mail.Password,
+		from:     config.Mail.From,
+	}
+}
+
+// enabled is false when no SMTP server was set, and emails can't be sent
+func (m mailer) enabled() bool {
+	return m.host != ""
+}
+
+// send emails the body to a single address. The address must have been validated already
+func (m mailer) send(to string, subject string, body string) error {
+	var auth smtp.Auth
+	if m.username != "" {
+		auth = smtp.PlainAuth("", m.username, m.password, m.host)
+	}
+
+	message := "From: " + m.from + "\r\n" +
+		"To: " + to + "\r\n" +
+		"Subject: " + subject + "\r\n" +
+		"Content-Type: text/plain; charset=UTF-8\r\n" +
+		"\r\n" +
+		strings.ReplaceAll(body, "\n", "\r\n")
+
+	return smtp.SendMail(net.JoinHostPort(m.host, m.port), auth, m.from, []string{to}, []byte(message))
+}
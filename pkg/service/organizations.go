@@ -0,0 +1,659 @@
+/*
+ * mrthn API
+ *
+ * One login for all your fitness data needs.
+ *
+ * API version: 0.1.0
+ */
+package service
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/context"
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+
+	"github.com/msgurgel/mrthn/pkg/dal"
+	"github.com/msgurgel/mrthn/pkg/helpers"
+)
+
+const maxOrganizationNameLength = 50
+const inviteLifetime = 7 * 24 * time.Hour
+
+// roleRanks orders the organization roles. Each role can do everything the roles ranked below it can
+var roleRanks = map[string]int{
+	dal.RoleViewer:    1,
+	dal.RoleDeveloper: 2,
+	dal.RoleOwner:     3,
+}
+
+// hasRole checks if role is at least as privileged as minRole. Clients that aren't members have an empty role
+func hasRole(role string, minRole string) bool {
+	rank, ok := roleRanks[role]
+	if !ok {
+		return false
+	}
+
+	return rank >= roleRanks[minRole]
+}
+
+func (api *Api) GetOrganizations(w http.ResponseWriter, r *http.Request) {
+	clientID := context.Get(r, "session_client_id").(int) // This was set during client session middleware
+
+	organizations, err := dal.GetClientOrganizations(api.db, clientID)
+	if err != nil {
+		api.log.WithFields(logrus.Fields{
+			"func":     "GetOrganizations",
+			"clientID": clientID,
+			"err":      err,
+		}).Error("failed to get client organizations")
+
+		api.respondWithFailure(w, http.StatusInternalServerError, "Error occurred while retrieving organizations")
+		return
+	}
+
+	response := OrganizationsResponse{
+		Success:       true,
+		Organizations: make([]Organization, 0, len(organizations)),
+	}
+	for _, organization := range organizations {
+		response.Organizations = append(response.Organizations, Organization{
+			ID:   organization.ID,
+			Name: organization.Name,
+			Role: organization.Role,
+		})
+	}
+
+	api.respondWithJSON(w, http.StatusOK, response)
+}
+
+func (api *Api) CreateOrganization(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseMultipartForm(500)
+	if err != nil {
+		api.respondWithFailure(w, http.StatusInternalServerError, "Error occurred while attempting to parse form values")
+
+		api.log.WithFields(logrus.Fields{
+			"func": "CreateOrganization",
+			"err":  err,
+		}).Error("failed to parse request form values")
+
+		return
+	}
+
+	clientID := context.Get(r, "session_client_id").(int) // This was set during client session middleware
+
+	name := r.Form.Get("name")
+	if name == "" || len(name) > maxOrganizationNameLength {
+		api.respondWithFailure(w, http.StatusBadRequest,
+			"Expected parameter 'name' in request, with at most "+strconv.Itoa(maxOrganizationNameLength)+" characters")
+		return
+	}
+
+	organizationID, err := dal.InsertOrganization(api.db, name, clientID)
+	if err != nil {
+		api.log.WithFields(logrus.Fields{
+			"func":     "CreateOrganization",
+			"clientID": clientID,
+			"err":      err,
+		}).Error("failed to insert organization")
+
+		api.respondWithFailure(w, http.StatusInternalServerError, "Error occurred while creating organization")
+		return
+	}
+
+	response := OrganizationsResponse{
+		Success:       true,
+		Organizations: []Organization{{ID: organizationID, Name: name, Role: dal.RoleOwner}},
+	}
+	api.respondWithJSON(w, http.StatusCreated, response)
+}
+
+func (api *Api) GetOrganizationMembers(w http.ResponseWriter, r *http.Request) {
+	organizationID, ok := api.getOrganizationIDFromPath(w, r, "GetOrganizationMembers")
+	if !ok {
+		return
+	}
+
+	members, err := dal.GetOrganizationMembers(api.db, organizationID)
+	if err != nil {
+		api.log.WithFields(logrus.Fields{
+			"func":           "GetOrganizationMembers",
+			"organizationID": organizationID,
+			"err":            err,
+		}).Error("failed to get organization members")
+
+		api.respondWithFailure(w, http.StatusInternalServerError, "Error occurred while retrieving members")
+		return
+	}
+
+	response := OrganizationMembersResponse{
+		Success: true,
+		Members: make([]OrganizationMember, 0, len(members)),
+	}
+	for _, member := range members {
+		response.Members = append(response.Members, OrganizationMember{
+			ClientID: member.ClientID,
+			Name:     member.Name,
+			Role:     member.Role,
+			JoinedAt: member.JoinedAt.Format(helpers.ISO8601Layout),
+		})
+	}
+
+	api.respondWithJSON(w, http.StatusOK, response)
+}
+
+func (api *Api) UpdateOrganizationMember(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseMultipartForm(500)
+	if err != nil {
+		api.respondWithFailure(w, http.StatusInternalServerError, "Error occurred while attempting to parse form values")
+
+		api.log.WithFields(logrus.Fields{
+			"func": "UpdateOrganizationMember",
+			"err":  err,
+		}).Error("failed to parse request form values")
+
+		return
+	}
+
+	organizationID, memberID, ok := api.getMemberIDsFromPath(w, r, "UpdateOrganizationMember")
+	if !ok {
+		return
+	}
+
+	role := r.Form.Get("role")
+	if !dal.IsValidRole(role) {
+		api.respondWithFailure(w, http.StatusBadRequest, "'role' parameter must be one of 'owner', 'developer' or 'viewer'")
+		return
+	}
+
+	if role != dal.RoleOwner && !api.keepsAnOwner(w, organizationID, memberID, "UpdateOrganizationMember") {
+		return
+	}
+
+	updated, err := dal.UpdateMemberRole(api.db, organizationID, memberID, role)
+	if err != nil {
+		api.log.WithFields(logrus.Fields{
+			"func":           "UpdateOrganizationMember",
+			"organizationID": organizationID,
+			"memberID":       memberID,
+			"err":            err,
+		}).Error("failed to update member role")
+
+		api.respondWithFailure(w, http.StatusInternalServerError, "Error occurred while updating member")
+		return
+	}
+
+	if !updated {
+		api.respondWithFailure(w, http.StatusNotFound, "No member matches passed in memberID")
+		return
+	}
+
+	api.log.WithFields(logrus.Fields{
+		"organizationID": organizationID,
+		"memberID":       memberID,
+		"role":           role,
+	}).Info("organization member role changed")
+
+	api.respondWithJSON(w, http.StatusOK, ClientActionResponse{Success: true})
+}
+
+// RemoveOrganizationMember lets owners remove any member, and every member leave the organization
+func (api *Api) RemoveOrganizationMember(w http.ResponseWriter, r *http.Request) {
+	organizationID, memberID, ok := api.getMemberIDsFromPath(w, r, "RemoveOrganizationMember")
+	if !ok {
+		return
+	}
+
+	clientID := context.Get(r, "session_client_id").(int) // This was set during client session middleware
+	if memberID != clientID {
+		role, err := dal.GetMemberRole(api.db, organizationID, clientID)
+		if err != nil {
+			api.log.WithFields(logrus.Fields{
+				"func":           "RemoveOrganizationMember",
+				"organizationID": organizationID,
+				"clientID":       clientID,
+				"err":            err,
+			}).Error("failed to get member role")
+
+			api.respondWithFailure(w, http.StatusInternalServerError, "Error occurred while removing member")
+			return
+		}
+
+		if !hasRole(role, dal.RoleOwner) {
+			api.respondWithFailure(w, http.StatusForbidden, "Only owners can remove other members")
+			return
+		}
+	}
+
+	if !api.keepsAnOwner(w, organizationID, memberID, "RemoveOrganizationMember") {
+		return
+	}
+
+	removed, err := dal.DeleteMember(api.db, organizationID, memberID)
+	if err != nil {
+		api.log.WithFields(logrus.Fields{
+			"func":           "RemoveOrganizationMember",
+			"organizationID": organizationID,
+			"memberID":       memberID,
+			"err":            err,
+		}).Error("failed to delete member")
+
+		api.respondWithFailure(w, http.StatusInternalServerError, "Error occurred while removing member")
+		return
+	}
+
+	if !removed {
+		api.respondWithFailure(w, http.StatusNotFound, "No member matches passed in memberID")
+		return
+	}
+
+	api.log.WithFields(logrus.Fields{
+		"organizationID": organizationID,
+		"memberID":       memberID,
+		"removedBy":      clientID,
+	}).Info("organization member removed")
+
+	api.respondWithJSON(w, http.StatusOK, ClientActionResponse{Success: true})
+}
+
+func (api *Api) GetOrganizationClients(w http.ResponseWriter, r *http.Request) {
+	organizationID, ok := api.getOrganizationIDFromPath(w, r, "GetOrganizationClients")
+	if !ok {
+		return
+	}
+
+	clients, err := dal.GetOrganizationClients(api.db, organizationID)
+	if err != nil {
+		api.log.WithFields(logrus.Fields{
+			"func":           "GetOrganizationClients",
+			"organizationID": organizationID,
+			"err":            err,
+		}).Error("failed to get organization clients")
+
+		api.respondWithFailure(w, http.StatusInternalServerError, "Error occurred while retrieving clients")
+		return
+	}
+
+	response := OrganizationClientsResponse{
+		Success: true,
+		Clients: make([]OrganizationClient, 0, len(clients)),
+	}
+	for _, client := range clients {
+		response.Clients = append(response.Clients, OrganizationClient{
+			ID:   client.ID,
+			Name: client.Name,
+		})
+	}
+
+	api.respondWithJSON(w, http.StatusOK, response)
+}
+
+// TransferClient moves a client to another organization. The signed in client must own both organizations
+func (api *Api) TransferClient(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseMultipartForm(500)
+	if err != nil {
+		api.respondWithFailure(w, http.StatusInternalServerError, "Error occurred while attempting to parse form values")
+
+		api.log.WithFields(logrus.Fields{
+			"func": "TransferClient",
+			"err":  err,
+		}).Error("failed to parse request form values")
+
+		return
+	}
+
+	clientID, ok := api.getClientIDFromPath(w, r, "TransferClient")
+	if !ok {
+		return
+	}
+
+	organizationID, err := strconv.Atoi(r.Form.Get("organizationID"))
+	if err != nil {
+		api.respondWithFailure(w, http.StatusBadRequest, "Expected integer parameter 'organizationID' in request")
+		return
+	}
+
+	sessionClientID := context.Get(r, "session_client_id").(int) // This was set during client session middleware
+	role, err := dal.GetMemberRole(api.db, organizationID, sessionClientID)
+	if err != nil {
+		api.log.WithFields(logrus.Fields{
+			"func":           "TransferClient",
+			"organizationID": organizationID,
+			"clientID":       sessionClientID,
+			"err":            err,
+		}).Error("failed to get member role")
+
+		api.respondWithFailure(w, http.StatusInternalServerError, "Error occurred while transferring client")
+		return
+	}
+
+	if !hasRole(role, dal.RoleOwner) {
+		api.respondWithFailure(w, http.StatusForbidden, "Clients can only be transferred to organizations you own")
+		return
+	}
+
+	err = dal.UpdateClientOrganization(api.db, clientID, organizationID)
+	if err != nil {
+		api.log.WithFields(logrus.Fields{
+			"func":           "TransferClient",
+			"organizationID": organizationID,
+			"clientID":       clientID,
+			"err":            err,
+		}).Error("failed to update client organization")
+
+		api.respondWithFailure(w, http.StatusInternalServerError, "Error occurred while transferring client")
+		return
+	}
+
+	api.log.WithFields(logrus.Fields{
+		"clientID":       clientID,
+		"organizationID": organizationID,
+		"transferredBy":  sessionClientID,
+	}).Info("client transferred to another organization")
+
+	api.respondWithJSON(w, http.StatusOK, ClientActionResponse{Success: true})
+}
+
+func (api *Api) GetOrganizationInvites(w http.ResponseWriter, r *http.Request) {
+	organizationID, ok := api.getOrganizationIDFromPath(w, r, "GetOrganizationInvites")
+	if !ok {
+		return
+	}
+
+	invites, err := dal.GetOrganizationInvites(api.db, organizationID)
+	if err != nil {
+		api.log.WithFields(logrus.Fields{
+			"func":           "GetOrganizationInvites",
+			"organizationID": organizationID,
+			"err":            err,
+		}).Error("failed to get organization invites")
+
+		api.respondWithFailure(w, http.StatusInternalServerError, "Error occurred while retrieving invites")
+		return
+	}
+
+	api.respondWithJSON(w, http.StatusOK, formatOrganizationInvites(invites))
+}
+
+func (api *Api) CreateOrganizationInvite(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseMultipartForm(500)
+	if err != nil {
+		api.respondWithFailure(w, http.StatusInternalServerError, "Error occurred while attempting to parse form values")
+
+		api.log.WithFields(logrus.Fields{
+			"func": "CreateOrganizationInvite",
+			"err":  err,
+		}).Error("failed to parse request form values")
+
+		return
+	}
+
+	organizationID, ok := api.getOrganizationIDFromPath(w, r, "CreateOrganizationInvite")
+	if !ok {
+		return
+	}
+
+	inviteeName := r.Form.Get("name")
+	if inviteeName == "" {
+		api.respondWithFailure(w, http.StatusBadRequest, "Expected parameter 'name' in request")
+		return
+	}
+
+	role := r.Form.Get("role")
+	if !dal.IsValidRole(role) {
+		api.respondWithFailure(w, http.StatusBadRequest, "'role' parameter must be one of 'owner', 'developer' or 'viewer'")
+		return
+	}
+
+	inviteeID, err := dal.GetClientID(api.db, inviteeName)
+	if err != nil {
+		api.log.WithFields(logrus.Fields{
+			"func":           "CreateOrganizationInvite",
+			"organizationID": organizationID,
+			"err":            err,
+		}).Error("failed to get invitee client ID")
+
+		api.respondWithFailure(w, http.StatusInternalServerError, "Error occurred while creating invite")
+		return
+	}
+
+	if inviteeID == 0 {
+		api.respondWithFailure(w, http.StatusNotFound, "No client account matches passed in name")
+		return
+	}
+
+	clientID := context.Get(r, "session_client_id").(int) // This was set during client session middleware
+	expiresAt := time.Now().Add(inviteLifetime)
+
+	inviteID, err := dal.InsertOrganizationInvite(api.db, organizationID, inviteeID, clientID, role, expiresAt)
+	if err != nil {
+		api.log.WithFields(logrus.Fields{
+			"func":           "CreateOrganizationInvite",
+			"organizationID": organizationID,
+			"inviteeID":      inviteeID,
+			"err":            err,
+		}).Error("failed to insert organization invite")
+
+		api.respondWithFailure(w, http.StatusInternalServerError, "Error occurred while creating invite")
+		return
+	}
+
+	api.log.WithFields(logrus.Fields{
+		"organizationID": organizationID,
+		"inviteeID":      inviteeID,
+		"invitedBy":      clientID,
+		"role":           role,
+	}).Info("client invited to organization")
+
+	response := OrganizationInvitesResponse{
+		Success: true,
+		Invites: []OrganizationInvite{{
+			ID:             inviteID,
+			OrganizationID: organizationID,
+			InviteeID:      inviteeID,
+			InviteeName:    inviteeName,
+			Role:           role,
+			ExpiresAt:      expiresAt.Format(helpers.ISO8601Layout),
+		}},
+	}
+	api.respondWithJSON(w, http.StatusCreated, response)
+}
+
+func (api *Api) CancelOrganizationInvite(w http.ResponseWriter, r *http.Request) {
+	organizationID, ok := api.getOrganizationIDFromPath(w, r, "CancelOrganizationInvite")
+	if !ok {
+		return
+	}
+
+	inviteID, err := strconv.Atoi(mux.Vars(r)["inviteID"])
+	if err != nil {
+		api.respondWithFailure(w, http.StatusBadRequest, "inviteID must be an integer")
+		return
+	}
+
+	deleted, err := dal.DeleteOrganizationInvite(api.db, organizationID, inviteID)
+	if err != nil {
+		api.log.WithFields(logrus.Fields{
+			"func":           "CancelOrganizationInvite",
+			"organizationID": organizationID,
+			"inviteID":       inviteID,
+			"err":            err,
+		}).Error("failed to delete organization invite")
+
+		api.respondWithFailure(w, http.StatusInternalServerError, "Error occurred while cancelling invite")
+		return
+	}
+
+	if !deleted {
+		api.respondWithFailure(w, http.StatusNotFound, "No invite matches passed in inviteID")
+		return
+	}
+
+	api.respondWithJSON(w, http.StatusOK, ClientActionResponse{Success: true})
+}
+
+func (api *Api) GetPendingInvites(w http.ResponseWriter, r *http.Request) {
+	clientID := context.Get(r, "session_client_id").(int) // This was set during client session middleware
+
+	invites, err := dal.GetPendingInvites(api.db, clientID)
+	if err != nil {
+		api.log.WithFields(logrus.Fields{
+			"func":     "GetPendingInvites",
+			"clientID": clientID,
+			"err":      err,
+		}).Error("failed to get pending invites")
+
+		api.respondWithFailure(w, http.StatusInternalServerError, "Error occurred while retrieving invites")
+		return
+	}
+
+	api.respondWithJSON(w, http.StatusOK, formatOrganizationInvites(invites))
+}
+
+func (api *Api) AcceptOrganizationInvite(w http.ResponseWriter, r *http.Request) {
+	clientID := context.Get(r, "session_client_id").(int) // This was set during client session middleware
+
+	inviteID, err := strconv.Atoi(mux.Vars(r)["inviteID"])
+	if err != nil {
+		api.respondWithFailure(w, http.StatusBadRequest, "inviteID must be an integer")
+		return
+	}
+
+	accepted, err := dal.AcceptOrganizationInvite(api.db, inviteID, clientID)
+	if err != nil {
+		api.log.WithFields(logrus.Fields{
+			"func":     "AcceptOrganizationInvite",
+			"clientID": clientID,
+			"inviteID": inviteID,
+			"err":      err,
+		}).Error("failed to accept organization invite")
+
+		api.respondWithFailure(w, http.StatusInternalServerError, "Error occurred while accepting invite")
+		return
+	}
+
+	if !accepted {
+		api.respondWithFailure(w, http.StatusNotFound, "No pending invite matches passed in inviteID")
+		return
+	}
+
+	api.log.WithFields(logrus.Fields{
+		"clientID": clientID,
+		"inviteID": inviteID,
+	}).Info("organization invite accepted")
+
+	api.respondWithJSON(w, http.StatusOK, ClientActionResponse{Success: true})
+}
+
+func (api *Api) DeclineOrganizationInvite(w http.ResponseWriter, r *http.Request) {
+	clientID := context.Get(r, "session_client_id").(int) // This was set during client session middleware
+
+	inviteID, err := strconv.Atoi(mux.Vars(r)["inviteID"])
+	if err != nil {
+		api.respondWithFailure(w, http.StatusBadRequest, "inviteID must be an integer")
+		return
+	}
+
+	declined, err := dal.DeclineOrganizationInvite(api.db, inviteID, clientID)
+	if err != nil {
+		api.log.WithFields(logrus.Fields{
+			"func":     "DeclineOrganizationInvite",
+			"clientID": clientID,
+			"inviteID": inviteID,
+			"err":      err,
+		}).Error("failed to decline organization invite")
+
+		api.respondWithFailure(w, http.StatusInternalServerError, "Error occurred while declining invite")
+		return
+	}
+
+	if !declined {
+		api.respondWithFailure(w, http.StatusNotFound, "No invite matches passed in inviteID")
+		return
+	}
+
+	api.respondWithJSON(w, http.StatusOK, ClientActionResponse{Success: true})
+}
+
+// keepsAnOwner makes sure an organization isn't left without owners when a member loses its role
+func (api *Api) keepsAnOwner(w http.ResponseWriter, organizationID int, memberID int, funcName string) bool {
+	role, err := dal.GetMemberRole(api.db, organizationID, memberID)
+	if err == nil && role == dal.RoleOwner {
+		var owners int
+		owners, err = dal.CountOrganizationOwners(api.db, organizationID)
+		if err == nil && owners <= 1 {
+			api.respondWithFailure(w, http.StatusBadRequest, "Organizations must have at least one owner")
+			return false
+		}
+	}
+
+	if err != nil {
+		api.log.WithFields(logrus.Fields{
+			"func":           funcName,
+			"organizationID": organizationID,
+			"memberID":       memberID,
+			"err":            err,
+		}).Error("failed to count organization owners")
+
+		api.respondWithFailure(w, http.StatusInternalServerError, "Error occurred while updating members")
+		return false
+	}
+
+	return true
+}
+
+func (api *Api) getOrganizationIDFromPath(w http.ResponseWriter, r *http.Request, funcName string) (int, bool) {
+	vars := mux.Vars(r)
+	organizationID, err := strconv.Atoi(vars["organizationID"])
+	if err != nil {
+		api.log.WithFields(logrus.Fields{
+			"func":     funcName,
+			"err":      "organizationID received must be an integer",
+			"received": vars["organizationID"],
+		}).Error("failed to parse 'organizationID' parameter")
+
+		api.respondWithFailure(w, http.StatusBadRequest, "organizationID must be an integer")
+		return 0, false
+	}
+
+	return organizationID, true
+}
+
+func (api *Api) getMemberIDsFromPath(w http.ResponseWriter, r *http.Request, funcName string) (int, int, bool) {
+	organizationID, ok := api.getOrganizationIDFromPath(w, r, funcName)
+	if !ok {
+		return 0, 0, false
+	}
+
+	memberID, err := strconv.Atoi(mux.Vars(r)["memberID"])
+	if err != nil {
+		api.respondWithFailure(w, http.StatusBadRequest, "memberID must be an integer")
+		return 0, 0, false
+	}
+
+	return organizationID, memberID, true
+}
+
+func formatOrganizationInvites(invites []dal.OrganizationInvite) OrganizationInvitesResponse {
+	response := OrganizationInvitesResponse{
+		Success: true,
+		Invites: make([]OrganizationInvite, 0, len(invites)),
+	}
+	for _, invite := range invites {
+		response.Invites = append(response.Invites, OrganizationInvite{
+			ID:               invite.ID,
+			OrganizationID:   invite.OrganizationID,
+			OrganizationName: invite.OrganizationName,
+			InviteeID:        invite.InviteeID,
+			InviteeName:      invite.InviteeName,
+			Role:             invite.Role,
+			CreatedAt:        invite.CreatedAt.Format(helpers.ISO8601Layout),
+			ExpiresAt:        invite.ExpiresAt.Format(helpers.ISO8601Layout),
+		})
+	}
+
+	return response
+}
@@ -0,0 +1,323 @@
+/*
+ * mrthn API
+ *
+ * One login for all your fitness data needs.
+ *
+ * API version: 0.1.0
+ */
+package service
+
+import (
+	"net/http"
+	"net/mail"
+	"strconv"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/msgurgel/mrthn/pkg/dal"
+	"github.com/msgurgel/mrthn/pkg/helpers"
+)
+
+const minPasswordLength = 8
+const maxEmailLength = 254
+
+// Reset tokens are emailed to the client that forgot its password as soon as they are issued,
+// so they only need to last long enough for it to be used
+const resetTokenLifetime = time.Hour
+
+func (api *Api) ChangeClientPassword(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseMultipartForm(500)
+	if err != nil {
+		api.respondWithFailure(w, http.StatusInternalServerError, "Error occurred while attempting to parse form values")
+
+		api.log.WithFields(logrus.Fields{
+			"func": "ChangeClientPassword",
+			"err":  err,
+		}).Error("failed to parse request form values")
+
+		return
+	}
+
+	clientID, ok := api.getClientIDFromPath(w, r, "ChangeClientPassword")
+	if !ok {
+		return
+	}
+
+	currentPassword := r.Form.Get("currentPassword")
+	if currentPassword == "" {
+		api.respondWithFailure(w, http.StatusBadRequest, "Expected parameter 'currentPassword' in request")
+		return
+	}
+
+	newPassword := r.Form.Get("newPassword")
+	if !api.isPasswordAcceptable(w, newPassword) {
+		return
+	}
+
+	generation, err := dal.ChangeClientPassword(api.db, clientID, currentPassword, newPassword)
+	if err != nil {
+		api.respondWithPasswordError(w, err, "ChangeClientPassword", clientID)
+		return
+	}
+
+	api.log.WithFields(logrus.Fields{
+		"clientID": clientID,
+	}).Info("client password changed")
+
+	// Every other session was signed out by the change. Issue the caller's session again so it stays signed in
+	clientSession, err := api.sessions.create(w, clientSessionCookie, clientSessionKind, clientID, generation)
+	if err != nil {
+		api.log.WithFields(logrus.Fields{
+			"func":     "ChangeClientPassword",
+			"clientID": clientID,
+			"err":      err,
+		}).Error("failed to create client session")
+
+		api.respondWithFailure(w, http.StatusInternalServerError, "Password changed, but the session couldn't be renewed. Sign in again")
+		return
+	}
+
+	response := ClientSignInResponse{
+		Success:   true,
+		ClientID:  clientID,
+		CSRFToken: clientSession.CSRFToken,
+	}
+	api.respondWithJSON(w, http.StatusOK, response)
+}
+
+func (api *Api) RequestPasswordReset(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseMultipartForm(500)
+	if err != nil {
+		api.respondWithFailure(w, http.StatusInternalServerError, "Error occurred while attempting to parse form values")
+
+		api.log.WithFields(logrus.Fields{
+			"func": "RequestPasswordReset",
+			"err":  err,
+		}).Error("failed to parse request form values")
+
+		return
+	}
+
+	clientName := r.Form.Get("name")
+	if clientName == "" {
+		api.respondWithFailure(w, http.StatusBadRequest, "Expected parameter 'name' in request")
+		return
+	}
+
+	if !api.mail.enabled() {
+		api.respondWithFailure(w, http.StatusServiceUnavailable, "Password resets are not available at the moment")
+		return
+	}
+
+	clientID, email, err := dal.GetClientEmail(api.db, clientName)
+	if err != nil {
+		api.log.WithFields(logrus.Fields{
+			"func": "RequestPasswordReset",
+			"err":  err,
+		}).Error("failed to get client email")
+
+		api.respondWithFailure(w, http.StatusInternalServerError, "Error occurred while creating reset token")
+		return
+	}
+
+	// The token is only ever sent to the client's email. The response is the same whether
+	// or not the client exists or has an email, so it can't be used to find out either
+	if email == "" {
+		api.log.WithFields(logrus.Fields{
+			"clientID": clientID,
+		}).Info("password reset requested for a client without an email")
+
+		api.respondWithJSON(w, http.StatusAccepted, ClientActionResponse{Success: true})
+		return
+	}
+
+	token, err := generateRandomToken(32)
+	if err != nil {
+		api.log.WithFields(logrus.Fields{
+			"func":     "RequestPasswordReset",
+			"clientID": clientID,
+			"err":      err,
+		}).Error("failed to generate password reset token")
+
+		api.respondWithFailure(w, http.StatusInternalServerError, "Error occurred while creating reset token")
+		return
+	}
+
+	expiresAt := time.Now().Add(resetTokenLifetime)
+	err = dal.InsertPasswordResetToken(api.db, clientID, token, expiresAt)
+	if err != nil {
+		api.log.WithFields(logrus.Fields{
+			"func":     "RequestPasswordReset",
+			"clientID": clientID,
+			"err":      err,
+		}).Error("failed to store password reset token")
+
+		api.respondWithFailure(w, http.StatusInternalServerError, "Error occurred while creating reset token")
+		return
+	}
+
+	body := "A password reset was requested for the mrthn client '" + clientName + "'.\n\n" +
+		"Reset token: " + token + "\n\n" +
+		"Use it on " + api.websiteURL + " before " + expiresAt.UTC().Format(helpers.ISO8601Layout) + ". " +
+		"If you didn't request it, ignore this email. Your password hasn't changed.\n"
+
+	err = api.mail.send(email, "Reset your mrthn password", body)
+	if err != nil {
+		api.log.WithFields(logrus.Fields{
+			"func":     "RequestPasswordReset",
+			"clientID": clientID,
+			"err":      err,
+		}).Error("failed to email password reset token")
+
+		api.respondWithFailure(w, http.StatusInternalServerError, "Error occurred while sending reset token")
+		return
+	}
+
+	api.log.WithFields(logrus.Fields{
+		"clientID": clientID,
+	}).Info("client password reset token emailed")
+
+	api.respondWithJSON(w, http.StatusAccepted, ClientActionResponse{Success: true})
+}
+
+func (api *Api) ChangeClientEmail(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseMultipartForm(500)
+	if err != nil {
+		api.respondWithFailure(w, http.StatusInternalServerError, "Error occurred while attempting to parse form values")
+
+		api.log.WithFields(logrus.Fields{
+			"func": "ChangeClientEmail",
+			"err":  err,
+		}).Error("failed to parse request form values")
+
+		return
+	}
+
+	clientID, ok := api.getClientIDFromPath(w, r, "ChangeClientEmail")
+	if !ok {
+		return
+	}
+
+	// Reset tokens are sent to this email, so changing it needs the password too
+	currentPassword := r.Form.Get("currentPassword")
+	if currentPassword == "" {
+		api.respondWithFailure(w, http.StatusBadRequest, "Expected parameter 'currentPassword' in request")
+		return
+	}
+
+	email := r.Form.Get("email")
+	if !isEmailValid(email) {
+		api.respondWithFailure(w, http.StatusBadRequest, "Parameter 'email' must be a valid email address")
+		return
+	}
+
+	err = dal.VerifyClientPassword(api.db, clientID, currentPassword)
+	if err != nil {
+		api.respondWithPasswordError(w, err, "ChangeClientEmail", clientID)
+		return
+	}
+
+	err = dal.SetClientEmail(api.db, clientID, email)
+	if err != nil {
+		api.log.WithFields(logrus.Fields{
+			"func":     "ChangeClientEmail",
+			"clientID": clientID,
+			"err":      err,
+		}).Error("failed to change client email")
+
+		api.respondWithFailure(w, http.StatusInternalServerError, "Error occurred while changing email")
+		return
+	}
+
+	api.log.WithFields(logrus.Fields{
+		"clientID": clientID,
+	}).Info("client email changed")
+
+	api.respondWithJSON(w, http.StatusOK, ClientActionResponse{Success: true})
+}
+
+func (api *Api) ResetClientPassword(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseMultipartForm(500)
+	if err != nil {
+		api.respondWithFailure(w, http.StatusInternalServerError, "Error occurred while attempting to parse form values")
+
+		api.log.WithFields(logrus.Fields{
+			"func": "ResetClientPassword",
+			"err":  err,
+		}).Error("failed to parse request form values")
+
+		return
+	}
+
+	clientName := r.Form.Get("name")
+	token := r.Form.Get("token")
+	if clientName == "" || token == "" {
+		api.respondWithFailure(w, http.StatusBadRequest, "Expected parameters 'name' and 'token' in request")
+		return
+	}
+
+	newPassword := r.Form.Get("newPassword")
+	if !api.isPasswordAcceptable(w, newPassword) {
+		return
+	}
+
+	clientID, err := dal.ResetClientPassword(api.db, clientName, token, newPassword)
+	if err != nil {
+		if err == dal.ErrInvalidResetToken {
+			api.respondWithFailure(w, http.StatusBadRequest, "Incorrect client name or reset token")
+			return
+		}
+
+		api.log.WithFields(logrus.Fields{
+			"func": "ResetClientPassword",
+			"err":  err,
+		}).Error("failed to reset client password")
+
+		api.respondWithFailure(w, http.StatusInternalServerError, "Error occurred while resetting password")
+		return
+	}
+
+	api.log.WithFields(logrus.Fields{
+		"clientID": clientID,
+	}).Info("client password reset using reset token")
+
+	api.respondWithJSON(w, http.StatusOK, ClientActionResponse{Success: true})
+}
+
+// isEmailValid only accepts a bare address, without a display name, that fits in the client table
+func isEmailValid(email string) bool {
+	if len(email) > maxEmailLength {
+		return false
+	}
+
+	address, err := mail.ParseAddress(email)
+	return err == nil && address.Address == email
+}
+
+func (api *Api) isPasswordAcceptable(w http.ResponseWriter, password string) bool {
+	if len(password) < minPasswordLength {
+		api.respondWithFailure(w, http.StatusBadRequest,
+			"New password must have at least "+strconv.Itoa(minPasswordLength)+" characters")
+		return false
+	}
+
+	return true
+}
+
+func (api *Api) respondWithPasswordError(w http.ResponseWriter, err error, funcName string, clientID int) {
+	switch err {
+	case dal.ErrInvalidCredentials:
+		api.respondWithFailure(w, http.StatusUnauthorized, "Incorrect password")
+	case dal.ErrClientLocked:
+		api.respondWithFailure(w, http.StatusTooManyRequests, "Too many failed sign in attempts. Try again later")
+	default:
+		api.log.WithFields(logrus.Fields{
+			"func":     funcName,
+			"clientID": clientID,
+			"err":      err,
+		}).Error("failed to verify client password")
+
+		api.respondWithFailure(w, http.StatusInternalServerError, "Error occurred while verifying password")
+	}
+}
@@ -0,0 +1,341 @@
+/*
+ * mrthn API
+ *
+ * One login for all your fitness data needs.
+ *
+ * API version: 0.1.0
+ */
+package service
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/context"
+	"github.com/sirupsen/logrus"
+
+	"github.com/msgurgel/mrthn/pkg/auth"
+	"github.com/msgurgel/mrthn/pkg/dal"
+	"github.com/msgurgel/mrthn/pkg/helpers"
+	"github.com/msgurgel/mrthn/pkg/model"
+	"github.com/msgurgel/mrthn/pkg/platform"
+)
+
+// PortalLogin sends the user to one of their linked platforms to sign in to the user portal
+func (api *Api) PortalLogin(w http.ResponseWriter, r *http.Request) {
+	service := r.URL.Query().Get("service")
+	if !platform.IsPlatformAvailable(service) {
+		api.log.WithFields(logrus.Fields{
+			"func":    "PortalLogin",
+			"service": service,
+		}).Error("invalid service was given")
+
+		api.respondWithFailure(w, http.StatusBadRequest, "invalid service. accepted are 'google' and 'fitbit'")
+		return
+	}
+
+	// TODO: This is dependent on OAuth2. When new auth types are needed, this will have to be changed
+	requestStateObject, err := api.authMethods.Oauth2.CreateStateObject(auth.CreateStateObjectParams{
+		Service:     service,
+		CallbackURL: api.websiteURL + "/portal",
+		Portal:      true,
+	})
+	if err != nil {
+		api.log.WithFields(logrus.Fields{
+			"func":    "PortalLogin",
+			"service": service,
+			"err":     err,
+		}).Error("failed to create state object")
+
+		api.respondWithFailure(w, http.StatusInternalServerError, "Something went wrong... Try again later")
+		return
+	}
+
+	http.Redirect(w, r, requestStateObject.URL, http.StatusTemporaryRedirect)
+}
+
+// signInToPortal starts a user portal session for the user that owns the platform account they logged in with.
+// Accounts that aren't linked to any user can't be used to sign in
+func (api *Api) signInToPortal(w http.ResponseWriter, r *http.Request, Oauth2Result *auth.OAuth2Result, callback string) {
+	userID, credentialID, err := dal.GetUserByPlatformID(api.db, Oauth2Result.PlatformID, Oauth2Result.PlatformName)
+	if err != nil {
+		api.log.WithFields(logrus.Fields{
+			"func": "signInToPortal",
+			"err":  err,
+		}).Error("failed to find the user of the platform account")
+
+		http.Redirect(w, r, callback+"?signedIn=false", http.StatusTemporaryRedirect)
+		return
+	}
+
+	if userID == 0 {
+		api.log.WithFields(logrus.Fields{
+			"func":     "signInToPortal",
+			"platform": Oauth2Result.PlatformName,
+		}).Warn("tried to sign in to the user portal with an account that isn't linked")
+
+		http.Redirect(w, r, callback+"?signedIn=false", http.StatusTemporaryRedirect)
+		return
+	}
+
+	// The user just logged in, so these tokens are the freshest ones
+	err = api.updateAccountTokens(Oauth2Result, credentialID)
+	if err != nil {
+		api.log.WithFields(logrus.Fields{
+			"func":   "signInToPortal",
+			"userID": userID,
+			"err":    err,
+		}).Error("failed to update account tokens")
+	}
+
+	_, err = api.sessions.create(w, userSessionCookie, userSessionKind, userID, 0)
+	if err != nil {
+		api.log.WithFields(logrus.Fields{
+			"func":   "signInToPortal",
+			"userID": userID,
+			"err":    err,
+		}).Error("failed to create user session")
+
+		http.Redirect(w, r, callback+"?signedIn=false", http.StatusTemporaryRedirect)
+		return
+	}
+
+	http.Redirect(w, r, callback+"?signedIn=true", http.StatusTemporaryRedirect)
+}
+
+func (api *Api) GetUserSession(w http.ResponseWriter, r *http.Request) {
+	// The session was already validated by the user session middleware
+	userSession, _ := api.sessions.read(r, userSessionCookie, userSessionKind)
+
+	response := UserSessionResponse{
+		Success:   true,
+		CSRFToken: userSession.CSRFToken,
+	}
+	api.respondWithJSON(w, http.StatusOK, response)
+}
+
+func (api *Api) PortalSignOut(w http.ResponseWriter, r *http.Request) {
+	api.sessions.clear(w, userSessionCookie)
+	api.respondWithJSON(w, http.StatusOK, ClientActionResponse{Success: true})
+}
+
+// GetPortalClients lists the clients that can read the signed in user's data
+func (api *Api) GetPortalClients(w http.ResponseWriter, r *http.Request) {
+	userID := context.Get(r, "session_user_id").(int) // This was set during user session middleware
+
+	clients, err := dal.GetUserClients(api.db, userID)
+	if err != nil {
+		api.log.WithFields(logrus.Fields{
+			"func":   "GetPortalClients",
+			"userID": userID,
+			"err":    err,
+		}).Error("failed to get user clients")
+
+		api.respondWithFailure(w, http.StatusInternalServerError, "Something went wrong... Try again later")
+		return
+	}
+
+	response := PortalClientsResponse{
+		Success: true,
+		Clients: make([]PortalClient, 0, len(clients)),
+	}
+	for _, client := range clients {
+		portalClient := PortalClient{
+			ID:        client.ClientID,
+			Name:      client.Name,
+			AddedAt:   client.AddedAt.Format(helpers.ISO8601Layout),
+			Resources: client.Resources,
+		}
+
+		if portalClient.Resources == nil {
+			portalClient.Resources = []string{}
+		}
+
+		if client.LastAccessedAt.Valid {
+			portalClient.LastAccessedAt = client.LastAccessedAt.Time.Format(helpers.ISO8601Layout)
+		}
+
+		response.Clients = append(response.Clients, portalClient)
+	}
+
+	api.respondWithJSON(w, http.StatusOK, response)
+}
+
+// RevokeClientAccess removes the signed in user from the client's userbase. The client is notified through its events
+func (api *Api) RevokeClientAccess(w http.ResponseWriter, r *http.Request) {
+	userID := context.Get(r, "session_user_id").(int) // This was set during user session middleware
+
+	clientID, ok := api.getClientIDFromPath(w, r, "RevokeClientAccess")
+	if !ok {
+		return
+	}
+
+	revoked, err := dal.RevokeClientAccess(api.db, userID, clientID)
+	if err != nil {
+		api.log.WithFields(logrus.Fields{
+			"func":     "RevokeClientAccess",
+			"userID":   userID,
+			"clientID": clientID,
+			"err":      err,
+		}).Error("failed to revoke client access")
+
+		api.respondWithFailure(w, http.StatusInternalServerError, "Something went wrong... Try again later")
+		return
+	}
+
+	if !revoked {
+		api.respondWithFailure(w, http.StatusNotFound, "Client doesn't have access to your data")
+		return
+	}
+
+	api.log.WithFields(logrus.Fields{
+		"userID":   userID,
+		"clientID": clientID,
+	}).Info("user revoked client access")
+
+	api.respondWithJSON(w, http.StatusOK, ClientActionResponse{Success: true})
+}
+
+// GetClientSharing returns what the signed in user withholds from the client
+func (api *Api) GetClientSharing(w http.ResponseWriter, r *http.Request) {
+	userID := context.Get(r, "session_user_id").(int) // This was set during user session middleware
+
+	clientID, ok := api.getPortalClientFromPath(w, r, userID, "GetClientSharing")
+	if !ok {
+		return
+	}
+
+	sharing, err := dal.GetSharingPreferences(api.db, userID, clientID)
+	if err != nil {
+		api.log.WithFields(logrus.Fields{
+			"func":     "GetClientSharing",
+			"userID":   userID,
+			"clientID": clientID,
+			"err":      err,
+		}).Error("failed to get sharing preferences")
+
+		api.respondWithFailure(w, http.StatusInternalServerError, "Something went wrong... Try again later")
+		return
+	}
+
+	api.respondWithJSON(w, http.StatusOK, ClientSharingResponse{
+		Success:  true,
+		Withheld: newSharingResponse(sharing),
+	})
+}
+
+// UpdateClientSharing replaces what the signed in user withholds from the client
+func (api *Api) UpdateClientSharing(w http.ResponseWriter, r *http.Request) {
+	userID := context.Get(r, "session_user_id").(int) // This was set during user session middleware
+
+	clientID, ok := api.getPortalClientFromPath(w, r, userID, "UpdateClientSharing")
+	if !ok {
+		return
+	}
+
+	sharing := dal.SharingPreferences{
+		WithheldResources: splitList(r.FormValue("withheldResources")),
+		WithheldPlatforms: splitList(r.FormValue("withheldPlatforms")),
+	}
+
+	for _, resource := range sharing.WithheldResources {
+		if !isResource(resource) {
+			api.respondWithFailure(w, http.StatusBadRequest, "Unknown resource '"+resource+"'")
+			return
+		}
+	}
+
+	for _, platformName := range sharing.WithheldPlatforms {
+		if !platform.IsPlatformAvailable(platformName) {
+			api.respondWithFailure(w, http.StatusBadRequest, "Unknown platform '"+platformName+"'")
+			return
+		}
+	}
+
+	err := dal.SetSharingPreferences(api.db, userID, clientID, sharing)
+	if err != nil {
+		api.log.WithFields(logrus.Fields{
+			"func":     "UpdateClientSharing",
+			"userID":   userID,
+			"clientID": clientID,
+			"err":      err,
+		}).Error("failed to set sharing preferences")
+
+		api.respondWithFailure(w, http.StatusInternalServerError, "Something went wrong... Try again later")
+		return
+	}
+
+	api.respondWithJSON(w, http.StatusOK, ClientSharingResponse{
+		Success:  true,
+		Withheld: newSharingResponse(sharing),
+	})
+}
+
+// getPortalClientFromPath parses the clientID path variable, and checks that the client has the signed in user.
+// If it doesn't, a failure is sent back to the caller
+func (api *Api) getPortalClientFromPath(w http.ResponseWriter, r *http.Request, userID int, funcName string) (int, bool) {
+	clientID, ok := api.getClientIDFromPath(w, r, funcName)
+	if !ok {
+		return 0, false
+	}
+
+	userbaseID, err := dal.GetUserInUserbase(api.db, userID, clientID)
+	if err != nil {
+		api.log.WithFields(logrus.Fields{
+			"func":     funcName,
+			"userID":   userID,
+			"clientID": clientID,
+			"err":      err,
+		}).Error("failed to check client userbase")
+
+		api.respondWithFailure(w, http.StatusInternalServerError, "Something went wrong... Try again later")
+		return 0, false
+	}
+
+	if userbaseID == 0 {
+		api.respondWithFailure(w, http.StatusNotFound, "Client doesn't have access to your data")
+		return 0, false
+	}
+
+	return clientID, true
+}
+
+func newSharingResponse(sharing dal.SharingPreferences) SharingResponse {
+	response := SharingResponse{
+		Resources: sharing.WithheldResources,
+		Platforms: sharing.WithheldPlatforms,
+	}
+
+	if response.Resources == nil {
+		response.Resources = []string{}
+	}
+
+	if response.Platforms == nil {
+		response.Platforms = []string{}
+	}
+
+	return response
+}
+
+// splitList splits a comma separated form value, ignoring empty items
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			items = append(items, item)
+		}
+	}
+
+	return items
+}
+
+func isResource(resource string) bool {
+	for _, r := range model.Resources {
+		if r == resource {
+			return true
+		}
+	}
+
+	return false
+}
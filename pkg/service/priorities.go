@@ -0,0 +1,120 @@
+/*
+ * mrthn API
+ *
+ * One login for all your fitness data needs.
+ *
+ * API version: 0.1.0
+ */
+package service
+
+import (
+	"net/http"
+
+	"github.com/gorilla/context"
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+
+	"github.com/msgurgel/mrthn/pkg/dal"
+	"github.com/msgurgel/mrthn/pkg/model"
+	"github.com/msgurgel/mrthn/pkg/platform"
+)
+
+// GetUserPriorities lists the platform priorities the client stored for the user, by resource
+func (api *Api) GetUserPriorities(w http.ResponseWriter, r *http.Request) {
+	// Check if the client has access to this user
+	userID, publicID, ok := api.getQueriedUser(w, r, mux.Vars(r)["userID"])
+	if !ok {
+		return
+	}
+
+	priorities, ok := api.getPlatformPriorities(w, r, userID)
+	if !ok {
+		return
+	}
+
+	api.respondWithJSON(w, http.StatusOK, PrioritiesResponse{ID: publicID, Priorities: newPrioritiesResponse(priorities)})
+}
+
+// SetUserPriority stores the order the user trusts their platforms in for a resource, and the platforms they don't trust at all.
+// Values of the resource are reconciled with it whenever the client doesn't ask for a strategy
+func (api *Api) SetUserPriority(w http.ResponseWriter, r *http.Request) {
+	// Check if the client has access to this user
+	userID, publicID, ok := api.getQueriedUser(w, r, mux.Vars(r)["userID"])
+	if !ok {
+		return
+	}
+
+	// Workouts are listed as they are, so there's nothing to reconcile
+	resource := mux.Vars(r)["resource"]
+	if !isResource(resource) || resource == model.ResourceActivities {
+		api.respondWithError(w, http.StatusBadRequest, "Unknown resource '"+resource+"'")
+		return
+	}
+
+	priority := dal.PlatformPriority{
+		Priority: splitList(r.FormValue("priority")),
+		Excluded: splitList(r.FormValue("excluded")),
+	}
+
+	for _, platformName := range append(append([]string{}, priority.Priority...), priority.Excluded...) {
+		if !platform.IsPlatformAvailable(platformName) {
+			api.respondWithError(w, http.StatusBadRequest, "Unknown platform '"+platformName+"'")
+			return
+		}
+	}
+
+	for _, platformName := range priority.Excluded {
+		for _, prioritized := range priority.Priority {
+			if prioritized == platformName {
+				api.respondWithError(w, http.StatusBadRequest, "Platform '"+platformName+"' can't be both prioritized and excluded")
+				return
+			}
+		}
+	}
+
+	clientID := context.Get(r, "client_id").(int) // This was set during JWT validation middleware
+	err := dal.SetPlatformPriority(api.db, userID, clientID, resource, priority)
+	if err != nil {
+		api.log.WithFields(logrus.Fields{
+			"func":     "SetUserPriority",
+			"userID":   userID,
+			"resource": resource,
+			"err":      err,
+		}).Error("failed to set user platform priority")
+
+		api.respondWithError(w, http.StatusInternalServerError, "Something went wrong... Try again later")
+		return
+	}
+
+	api.respondWithJSON(w, http.StatusOK, ResourcePriorityResponse{
+		ID:       publicID,
+		Resource: resource,
+		Priority: newPlatformPriorityResponse(priority),
+	})
+}
+
+func newPrioritiesResponse(priorities dal.PlatformPriorities) map[string]PlatformPriorityResponse {
+	response := make(map[string]PlatformPriorityResponse, len(priorities))
+	for resource, priority := range priorities {
+		response[resource] = newPlatformPriorityResponse(priority)
+	}
+
+	return response
+}
+
+func newPlatformPriorityResponse(priority dal.PlatformPriority) PlatformPriorityResponse {
+	response := PlatformPriorityResponse{
+		Priority: priority.Priority,
+		Excluded: priority.Excluded,
+	}
+
+	if response.Priority == nil {
+		response.Priority = []string{}
+	}
+
+	if response.Excluded == nil {
+		response.Excluded = []string{}
+	}
+
+	return response
+}
@@ -0,0 +1,282 @@
+/*
+ * mrthn API
+ *
+ * One login for all your fitness data needs.
+ *
+ * API version: 0.1.0
+ */
+package service
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+
+	"github.com/msgurgel/mrthn/pkg/dal"
+	"github.com/msgurgel/mrthn/pkg/helpers"
+)
+
+const maxRedirectURIsPerClient = 10
+
+func (api *Api) GetClientRedirectURIs(w http.ResponseWriter, r *http.Request) {
+	clientID, ok := api.getClientIDFromPath(w, r, "GetClientRedirectURIs")
+	if !ok {
+		return
+	}
+
+	uris, err := dal.GetClientRedirectURIs(api.db, clientID)
+	if err != nil {
+		api.log.WithFields(logrus.Fields{
+			"func":     "GetClientRedirectURIs",
+			"clientID": clientID,
+			"err":      err,
+		}).Error("failed to get client redirect URIs")
+
+		api.respondWithFailure(w, http.StatusInternalServerError, "Error occurred while retrieving redirect URIs")
+		return
+	}
+
+	response := RedirectURIsResponse{
+		Success:      true,
+		RedirectURIs: make([]RedirectURI, 0, len(uris)),
+	}
+	for _, uri := range uris {
+		response.RedirectURIs = append(response.RedirectURIs, formatRedirectURI(uri))
+	}
+
+	api.respondWithJSON(w, http.StatusOK, response)
+}
+
+func (api *Api) CreateClientRedirectURI(w http.ResponseWriter, r *http.Request) {
+	clientID, uri, ok := api.getRedirectURIParams(w, r, "CreateClientRedirectURI")
+	if !ok {
+		return
+	}
+
+	uris, err := dal.GetClientRedirectURIs(api.db, clientID)
+	if err != nil {
+		api.log.WithFields(logrus.Fields{
+			"func":     "CreateClientRedirectURI",
+			"clientID": clientID,
+			"err":      err,
+		}).Error("failed to get client redirect URIs")
+
+		api.respondWithFailure(w, http.StatusInternalServerError, "Error occurred while registering redirect URI")
+		return
+	}
+
+	if len(uris) >= maxRedirectURIsPerClient {
+		api.respondWithFailure(w, http.StatusBadRequest,
+			"Clients can't register more than "+strconv.Itoa(maxRedirectURIsPerClient)+" redirect URIs")
+		return
+	}
+
+	for _, registered := range uris {
+		if registered.URI == uri {
+			api.respondWithFailure(w, http.StatusBadRequest, "Redirect URI is already registered")
+			return
+		}
+	}
+
+	uriID, err := dal.InsertClientRedirectURI(api.db, clientID, uri)
+	if err != nil {
+		api.log.WithFields(logrus.Fields{
+			"func":     "CreateClientRedirectURI",
+			"clientID": clientID,
+			"err":      err,
+		}).Error("failed to insert client redirect URI")
+
+		api.respondWithFailure(w, http.StatusInternalServerError, "Error occurred while registering redirect URI")
+		return
+	}
+
+	response := RedirectURIsResponse{
+		Success:      true,
+		RedirectURIs: []RedirectURI{{ID: uriID, URI: uri}},
+	}
+	api.respondWithJSON(w, http.StatusCreated, response)
+}
+
+func (api *Api) UpdateClientRedirectURI(w http.ResponseWriter, r *http.Request) {
+	clientID, uri, ok := api.getRedirectURIParams(w, r, "UpdateClientRedirectURI")
+	if !ok {
+		return
+	}
+
+	uriID, err := strconv.Atoi(mux.Vars(r)["uriID"])
+	if err != nil {
+		api.respondWithFailure(w, http.StatusBadRequest, "uriID must be an integer")
+		return
+	}
+
+	updated, err := dal.UpdateClientRedirectURI(api.db, clientID, uriID, uri)
+	if err != nil {
+		api.log.WithFields(logrus.Fields{
+			"func":     "UpdateClientRedirectURI",
+			"clientID": clientID,
+			"uriID":    uriID,
+			"err":      err,
+		}).Error("failed to update client redirect URI")
+
+		api.respondWithFailure(w, http.StatusInternalServerError, "Error occurred while updating redirect URI")
+		return
+	}
+
+	if !updated {
+		api.respondWithFailure(w, http.StatusNotFound, "No redirect URI matches passed in uriID")
+		return
+	}
+
+	response := RedirectURIsResponse{
+		Success:      true,
+		RedirectURIs: []RedirectURI{{ID: uriID, URI: uri}},
+	}
+	api.respondWithJSON(w, http.StatusOK, response)
+}
+
+func (api *Api) DeleteClientRedirectURI(w http.ResponseWriter, r *http.Request) {
+	clientID, ok := api.getClientIDFromPath(w, r, "DeleteClientRedirectURI")
+	if !ok {
+		return
+	}
+
+	uriID, err := strconv.Atoi(mux.Vars(r)["uriID"])
+	if err != nil {
+		api.respondWithFailure(w, http.StatusBadRequest, "uriID must be an integer")
+		return
+	}
+
+	deleted, err := dal.DeleteClientRedirectURI(api.db, clientID, uriID)
+	if err != nil {
+		api.log.WithFields(logrus.Fields{
+			"func":     "DeleteClientRedirectURI",
+			"clientID": clientID,
+			"uriID":    uriID,
+			"err":      err,
+		}).Error("failed to delete client redirect URI")
+
+		api.respondWithFailure(w, http.StatusInternalServerError, "Error occurred while deleting redirect URI")
+		return
+	}
+
+	if !deleted {
+		api.respondWithFailure(w, http.StatusNotFound, "No redirect URI matches passed in uriID")
+		return
+	}
+
+	api.respondWithJSON(w, http.StatusOK, ClientActionResponse{Success: true})
+}
+
+// getRedirectURIParams parses the client ID and the validated 'uri' form value of the request
+func (api *Api) getRedirectURIParams(w http.ResponseWriter, r *http.Request, funcName string) (int, string, bool) {
+	err := r.ParseMultipartForm(500)
+	if err != nil {
+		api.respondWithFailure(w, http.StatusInternalServerError, "Error occurred while attempting to parse form values")
+
+		api.log.WithFields(logrus.Fields{
+			"func": funcName,
+			"err":  err,
+		}).Error("failed to parse request form values")
+
+		return 0, "", false
+	}
+
+	clientID, ok := api.getClientIDFromPath(w, r, funcName)
+	if !ok {
+		return 0, "", false
+	}
+
+	uri := r.Form.Get("uri")
+	if uri == "" {
+		api.respondWithFailure(w, http.StatusBadRequest, "Expected parameter 'uri' in request")
+		return 0, "", false
+	}
+
+	err = helpers.ValidateRedirectURI(uri, api.development)
+	if err != nil {
+		api.respondWithFailure(w, http.StatusBadRequest, err.Error())
+		return 0, "", false
+	}
+
+	return clientID, uri, true
+}
+
+// getLoginRedirectURI picks where the user is sent back to after logging in. If the client asked for a
+// specific redirect URI, it must exactly match one it registered. Otherwise, the client's callback is used,
+// as long as it's a valid redirect URI. Callbacks set before they were validated may not be
+func (api *Api) getLoginRedirectURI(w http.ResponseWriter, r *http.Request, clientID int) (string, bool) {
+	requestedURIs, requestedOk := r.URL.Query()["redirect_uri"]
+	if !requestedOk {
+		callback, err := dal.GetClientCallback(api.db, clientID)
+		if err != nil || callback == "" {
+			api.log.WithFields(logrus.Fields{
+				"client": clientID,
+				"err":    err,
+			}).Error("failed to get callback url from database")
+			api.respondWithError(w, http.StatusInternalServerError,
+				"Unable to retrieve callback URL. Did you remember to set it in your Profile page at https://mrthn.dev ? ",
+			)
+			return "", false
+		}
+
+		err = helpers.ValidateRedirectURI(callback, api.development)
+		if err != nil {
+			api.log.WithFields(logrus.Fields{
+				"client":   clientID,
+				"callback": callback,
+				"err":      err,
+			}).Warn("client callback is not a valid redirect URI")
+			api.respondWithError(w, http.StatusBadRequest,
+				"Client callback URL is not a valid redirect URI: "+err.Error()+". Update it, or pass a registered 'redirect_uri'")
+			return "", false
+		}
+
+		return callback, true
+	}
+
+	if len(requestedURIs) != 1 {
+		api.respondWithError(w, http.StatusBadRequest, "more than one optional parameter 'redirect_uri' was passed")
+		return "", false
+	}
+
+	requestedURI := requestedURIs[0]
+
+	// The client's callback is always allowed, as long as it's still a valid redirect URI
+	callback, err := dal.GetClientCallback(api.db, clientID)
+	if err == nil && callback == requestedURI && helpers.ValidateRedirectURI(callback, api.development) == nil {
+		return callback, true
+	}
+
+	registered, err := dal.IsClientRedirectURI(api.db, clientID, requestedURI)
+	if err != nil {
+		api.log.WithFields(logrus.Fields{
+			"client": clientID,
+			"err":    err,
+		}).Error("failed to check redirect URI in database")
+		api.respondWithError(w, http.StatusInternalServerError, "something went wrong, try again later.")
+		return "", false
+	}
+
+	if !registered {
+		api.log.WithFields(logrus.Fields{
+			"func":        "Login",
+			"clientID":    clientID,
+			"redirectURI": requestedURI,
+		}).Warn("client asked for an unregistered redirect URI")
+
+		api.respondWithError(w, http.StatusBadRequest, "'redirect_uri' does not match any URI registered by the client")
+		return "", false
+	}
+
+	return requestedURI, true
+}
+
+func formatRedirectURI(uri dal.RedirectURI) RedirectURI {
+	return RedirectURI{
+		ID:        uri.ID,
+		URI:       uri.URI,
+		CreatedAt: uri.CreatedAt.Format(helpers.ISO8601Layout),
+	}
+}
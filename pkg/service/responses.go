@@ -12,10 +12,20 @@ import (
 )
 
 type GetValueResponse struct {
-	ID     int                 `json:"id,omitempty"`
+	ID     string              `json:"id,omitempty"`
 	Result []model.ValueResult `json:"result,omitempty"`
 }
 
+type DailySummaryResponse struct {
+	ID        string                         `json:"id,omitempty"`
+	Resources map[string][]model.ValueResult `json:"resources"`
+}
+
+type WorkoutsResponse struct {
+	ID       string                `json:"id,omitempty"`
+	Workouts []model.WorkoutResult `json:"workouts"`
+}
+
 type ClientSignUpResponse struct {
 	Success    bool   `json:"success"`
 	ClientID   int    `json:"clientID"`
@@ -24,9 +34,10 @@ type ClientSignUpResponse struct {
 }
 
 type ClientSignInResponse struct {
-	Success  bool   `json:"success"`
-	ClientID int    `json:"clientID,omitempty"`
-	Error    string `json:"error,omitempty"`
+	Success   bool   `json:"success"`
+	ClientID  int    `json:"clientID,omitempty"`
+	CSRFToken string `json:"csrfToken,omitempty"` // Must be sent in the X-CSRF-Token header of state-changing requests
+	Error     string `json:"error,omitempty"`
 }
 
 type GetCallbackResponse struct {
@@ -40,3 +51,267 @@ type CallbackUpdateResponse struct {
 	Error           string `json:"error,omitempty"`
 	UpdatedCallback string `json:"updatedCallback,omitempty"`
 }
+
+// ClientActionResponse is sent back by the endpoints called by the mrthn website that have no other results
+type ClientActionResponse struct {
+	Success bool   `json:"success"`
+	Error   string `json:"error,omitempty"`
+}
+
+type ClientKey struct {
+	ID        int    `json:"id"`
+	Label     string `json:"label"`
+	CreatedAt string `json:"createdAt"`
+	LastUsed  string `json:"lastUsed,omitempty"`
+	ExpiresAt string `json:"expiresAt,omitempty"`
+}
+
+type ClientKeysResponse struct {
+	Success bool        `json:"success"`
+	Error   string      `json:"error,omitempty"`
+	Keys    []ClientKey `json:"keys,omitempty"`
+}
+
+type CreateClientKeyResponse struct {
+	Success bool       `json:"success"`
+	Error   string     `json:"error,omitempty"`
+	Key     *ClientKey `json:"key,omitempty"`
+	Token   string     `json:"token,omitempty"` // Only sent when the key is created. mrthn can't generate it again
+}
+
+type RedirectURI struct {
+	ID        int    `json:"id"`
+	URI       string `json:"uri"`
+	CreatedAt string `json:"createdAt,omitempty"`
+}
+
+type RedirectURIsResponse struct {
+	Success      bool          `json:"success"`
+	Error        string        `json:"error,omitempty"`
+	RedirectURIs []RedirectURI `json:"redirectURIs,omitempty"`
+}
+
+type Organization struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+	Role string `json:"role,omitempty"`
+}
+
+type OrganizationsResponse struct {
+	Success       bool           `json:"success"`
+	Error         string         `json:"error,omitempty"`
+	Organizations []Organization `json:"organizations,omitempty"`
+}
+
+type OrganizationMember struct {
+	ClientID int    `json:"clientID"`
+	Name     string `json:"name"`
+	Role     string `json:"role"`
+	JoinedAt string `json:"joinedAt"`
+}
+
+type OrganizationMembersResponse struct {
+	Success bool                 `json:"success"`
+	Error   string               `json:"error,omitempty"`
+	Members []OrganizationMember `json:"members,omitempty"`
+}
+
+type OrganizationClient struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+type OrganizationClientsResponse struct {
+	Success bool                 `json:"success"`
+	Error   string               `json:"error,omitempty"`
+	Clients []OrganizationClient `json:"clients,omitempty"`
+}
+
+type OrganizationInvite struct {
+	ID               int    `json:"id"`
+	OrganizationID   int    `json:"organizationID"`
+	OrganizationName string `json:"organizationName"`
+	InviteeID        int    `json:"inviteeID"`
+	InviteeName      string `json:"inviteeName"`
+	Role             string `json:"role"`
+	CreatedAt        string `json:"createdAt,omitempty"`
+	ExpiresAt        string `json:"expiresAt"`
+}
+
+type OrganizationInvitesResponse struct {
+	Success bool                 `json:"success"`
+	Error   string               `json:"error,omitempty"`
+	Invites []OrganizationInvite `json:"invites,omitempty"`
+}
+
+type UserSummary struct {
+	ID                string   `json:"id"`
+	ExternalRef       string   `json:"externalRef,omitempty"`
+	CreatedAt         string   `json:"createdAt"`
+	Platforms         []string `json:"platforms"`
+	WithheldPlatforms []string `json:"withheldPlatforms,omitempty"` // Linked, but the user doesn't share them with the client
+}
+
+type UsersResponse struct {
+	Users   []UserSummary `json:"users"`
+	Page    int           `json:"page"`
+	PerPage int           `json:"perPage"`
+	Total   int           `json:"total"`
+}
+
+type LinkedPlatform struct {
+	Name            string `json:"name"`
+	Account         string `json:"account,omitempty"` // Label of the account, since a user can link several accounts of a platform
+	LinkedAt        string `json:"linkedAt,omitempty"`
+	Status          string `json:"status,omitempty"`
+	LastRefreshed   string `json:"lastRefreshed,omitempty"`
+	RefreshFailedAt string `json:"refreshFailedAt,omitempty"`
+	RefreshError    string `json:"refreshError,omitempty"`
+	Withheld        bool   `json:"withheld,omitempty"` // The user doesn't share the platform with the client, so only its name is sent
+}
+
+type UserResponse struct {
+	ID          string           `json:"id"`
+	ExternalRef string           `json:"externalRef,omitempty"`
+	CreatedAt   string           `json:"createdAt"`
+	Platforms   []LinkedPlatform `json:"platforms"`
+}
+
+type ExternalRefResponse struct {
+	ID          string `json:"id"`
+	ExternalRef string `json:"externalRef"` // Empty when the reference was removed
+}
+
+type MergeUsersResponse struct {
+	ID       string `json:"id"`
+	MergedID string `json:"mergedId"` // Now an alias of the user it was merged into
+}
+
+type UnlinkPlatformResponse struct {
+	ID       string   `json:"id"`
+	Platform string   `json:"platform"`
+	Accounts []string `json:"accounts"` // Labels of the accounts that were unlinked
+	Revoked  bool     `json:"revoked"`  // False when the platform didn't accept the revocation, e.g. because the user already revoked access there
+}
+
+type DeleteUserResponse struct {
+	ID     string `json:"id"`
+	Erased bool   `json:"erased"` // True when no other client had the user, so everything mrthn knew about it was deleted
+}
+
+type ExportedAccount struct {
+	Platform      string `json:"platform"`
+	Label         string `json:"label,omitempty"`
+	LinkedAt      string `json:"linkedAt,omitempty"`
+	LastRefreshed string `json:"lastRefreshed,omitempty"`
+	Withheld      bool   `json:"withheld,omitempty"` // The user doesn't share the platform with the client, so only the platform is sent
+}
+
+type ExportedMetrics struct {
+	Platform string   `json:"platform"`
+	Date     string   `json:"date"`
+	Steps    int      `json:"steps"`
+	Calories int      `json:"calories"`
+	Distance float64  `json:"distance"`
+	Withheld []string `json:"withheld,omitempty"` // Resources the user doesn't share with the client. Their values are always zero
+}
+
+type ExportedEvent struct {
+	Event   string `json:"event"`
+	Details string `json:"details,omitempty"`
+	Time    string `json:"time"`
+}
+
+// UserExport holds everything mrthn stores about a user, as seen by the client that requested it
+type UserExport struct {
+	ID             string                              `json:"id"`
+	ExternalRef    string                              `json:"externalRef,omitempty"`
+	ExportedAt     string                              `json:"exportedAt"`
+	AddedAt        string                              `json:"addedAt"`
+	LinkedAccounts []ExportedAccount                   `json:"linkedAccounts"`
+	Metrics        []ExportedMetrics                   `json:"metrics"`
+	AccessHistory  []ExportedEvent                     `json:"accessHistory"`
+	Withheld       SharingResponse                     `json:"withheld"` // What the user doesn't share with the client
+	Consents       []ConsentResponse                   `json:"consents"`
+	Priorities     map[string]PlatformPriorityResponse `json:"priorities"` // Platform priorities the client stored for the user
+}
+
+// UserSessionResponse is sent back by the user portal session endpoint
+type UserSessionResponse struct {
+	Success   bool   `json:"success"`
+	CSRFToken string `json:"csrfToken,omitempty"` // Must be sent in the X-CSRF-Token header of state-changing requests
+	Error     string `json:"error,omitempty"`
+}
+
+// PortalClient is a client with access to the user's data, as shown in the user portal
+type PortalClient struct {
+	ID             int      `json:"id"`
+	Name           string   `json:"name"`
+	AddedAt        string   `json:"addedAt"`
+	LastAccessedAt string   `json:"lastAccessedAt,omitempty"`
+	Resources      []string `json:"resources"`
+}
+
+type PortalClientsResponse struct {
+	Success bool           `json:"success"`
+	Clients []PortalClient `json:"clients"`
+}
+
+type ClientEventResponse struct {
+	ID          int    `json:"id"`
+	Event       string `json:"event"`
+	UserID      string `json:"userId"`
+	ExternalRef string `json:"externalRef,omitempty"`
+	CreatedAt   string `json:"createdAt"`
+}
+
+type ClientEventsResponse struct {
+	Events []ClientEventResponse `json:"events"`
+}
+
+// SharingResponse lists what a user withholds from a client. Everything else is shared
+type SharingResponse struct {
+	Resources []string `json:"resources"`
+	Platforms []string `json:"platforms"`
+}
+
+// PlatformPriorityResponse is the order a user trusts a resource's platforms in, most trusted first
+type PlatformPriorityResponse struct {
+	Priority []string `json:"priority"`
+	Excluded []string `json:"excluded"` // Platforms left out of the resource
+}
+
+type PrioritiesResponse struct {
+	ID         string                              `json:"id"`
+	Priorities map[string]PlatformPriorityResponse `json:"priorities"`
+}
+
+type ResourcePriorityResponse struct {
+	ID       string                   `json:"id"`
+	Resource string                   `json:"resource"`
+	Priority PlatformPriorityResponse `json:"priority"`
+}
+
+type ClientSharingResponse struct {
+	Success  bool            `json:"success"`
+	Withheld SharingResponse `json:"withheld"`
+}
+
+type ConsentResponse struct {
+	UserID      string   `json:"userId,omitempty"`
+	ExternalRef string   `json:"externalRef,omitempty"`
+	Platform    string   `json:"platform"`
+	Scopes      []string `json:"scopes"`
+	GrantedAt   string   `json:"grantedAt"`
+	ExpiresAt   string   `json:"expiresAt,omitempty"`
+}
+
+type ExpiringConsentsResponse struct {
+	Consents []ConsentResponse `json:"consents"`
+}
+
+// ConsentExpiredResponse is sent back when the client reads the data of a user whose consent has expired
+type ConsentExpiredResponse struct {
+	Error     string `json:"error"`
+	ExpiredAt string `json:"expiredAt"`
+}
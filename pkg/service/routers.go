@@ -12,6 +12,8 @@ import (
 	"net/http"
 
 	"github.com/msgurgel/mrthn/pkg/auth"
+	"github.com/msgurgel/mrthn/pkg/dal"
+	"github.com/msgurgel/mrthn/pkg/environment"
 
 	"github.com/rs/cors"
 
@@ -20,20 +22,33 @@ import (
 )
 
 type Route struct {
-	Name                string
-	Method              string
-	Pattern             string
-	Secure              bool
-	MrthnWebsiteOnly    bool
-	HandlerFunc         http.HandlerFunc
+	Name             string
+	Method           string
+	Pattern          string
+	Secure           bool
+	MrthnWebsiteOnly bool
+	ClientSession    bool // Requires a client signed in to the mrthn website
+	MemberRole       string // Minimum role in the organization that owns the route's client. Empty means only the client itself
+	UserSession      bool   // Requires a user signed in to the mrthn user portal
+	HandlerFunc      http.HandlerFunc
 }
 
 type Routes []Route
 
-func NewRouter(db *sql.DB, logger *logrus.Logger, authTypes auth.Types, mrthnWebsiteURL string) *mux.Router {
-	routes := prepareRoutes(db, logger, authTypes)
+func NewRouter(db *sql.DB, logger *logrus.Logger, authTypes auth.Types, env *environment.MrthnConfig) *mux.Router {
+	development := env.Environment == "development"
+	sessions := newSessionManager(env.SessionSecret, !development)
+	routes := prepareRoutes(db, logger, authTypes, sessions, newMailer(env), development, env.MrthnWebsiteURL)
 	router := mux.NewRouter().StrictSlash(true)
 
+	// Requests from the mrthn website carry the session cookie, so they need their own CORS rules
+	websiteCors := cors.New(cors.Options{
+		AllowedOrigins:   []string{env.MrthnWebsiteURL},
+		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE"},
+		AllowedHeaders:   []string{"Content-Type", csrfHeader},
+		AllowCredentials: true,
+	})
+
 	// Initialize routes
 	for _, route := range routes {
 		var handler http.Handler
@@ -44,19 +59,37 @@ func NewRouter(db *sql.DB, logger *logrus.Logger, authTypes auth.Types, mrthnWeb
 			handler = jwtMiddleware(db, logger, handler)
 		}
 
+		// Client Session Middleware
+		if route.ClientSession {
+			handler = clientSessionMiddleware(db, logger, sessions, route.MemberRole, handler)
+		}
+
+		// User Session Middleware
+		if route.UserSession {
+			handler = userSessionMiddleware(logger, sessions, handler)
+		}
+
 		// Check mrthn Website Origin Middleware
 		if route.MrthnWebsiteOnly {
-			handler = checkMrthnURL(logger, handler, mrthnWebsiteURL)
+			handler = checkMrthnURL(logger, handler, env.MrthnWebsiteURL)
 		}
 
 		// CORS Middleware
-		handler = cors.Default().Handler(handler)
+		methods := []string{route.Method}
+		if route.MrthnWebsiteOnly || route.ClientSession || route.UserSession {
+			handler = websiteCors.Handler(handler)
+
+			// Let the browser send preflight requests
+			methods = append(methods, "OPTIONS")
+		} else {
+			handler = cors.Default().Handler(handler)
+		}
 
 		// Logger Middleware
 		handler = Logger(logger, handler, route.Name)
 
 		router.
-			Methods(route.Method).
+			Methods(methods...).
 			Path(route.Pattern).
 			Name(route.Name).
 			Handler(handler)
@@ -65,8 +98,8 @@ func NewRouter(db *sql.DB, logger *logrus.Logger, authTypes auth.Types, mrthnWeb
 	return router
 }
 
-func prepareRoutes(db *sql.DB, logger *logrus.Logger, authTypes auth.Types) Routes {
-	api := NewApi(db, logger, authTypes)
+func prepareRoutes(db *sql.DB, logger *logrus.Logger, authTypes auth.Types, sessions sessionManager, mail mailer, development bool, websiteURL string) Routes {
+	api := NewApi(db, logger, authTypes, sessions, mail, development, websiteURL)
 
 	routes := Routes{
 		Route{
@@ -75,24 +108,45 @@ func prepareRoutes(db *sql.DB, logger *logrus.Logger, authTypes auth.Types) Rout
 			"/",
 			false,
 			false,
+			false,
+			"",
+			false,
 			api.Index,
 		},
 
 		Route{
 			"GetToken",
-			"GET",
+			"POST",
 			"/get-token",
 			false,
+			false,
 			true,
+			"",
+			false,
 			api.GetToken,
 		},
 
+		Route{
+			"GetDailySummary",
+			"GET",
+			"/user/{userID}/summary/daily",
+			true,
+			false,
+			false,
+			"",
+			false,
+			api.GetDailySummary,
+		},
+
 		Route{
 			"GetValueDaily",
 			"GET",
 			"/user/{userID}/{resource}/daily",
 			true,
 			false,
+			false,
+			"",
+			false,
 			api.GetValueDaily,
 		},
 
@@ -102,6 +156,9 @@ func prepareRoutes(db *sql.DB, logger *logrus.Logger, authTypes auth.Types) Rout
 			"/login",
 			false,
 			false,
+			false,
+			"",
+			false,
 			api.Login,
 		},
 
@@ -111,6 +168,9 @@ func prepareRoutes(db *sql.DB, logger *logrus.Logger, authTypes auth.Types) Rout
 			"/callback",
 			false,
 			false,
+			false,
+			"",
+			false,
 			api.Callback,
 		},
 
@@ -119,7 +179,10 @@ func prepareRoutes(db *sql.DB, logger *logrus.Logger, authTypes auth.Types) Rout
 			"POST",
 			"/client/{clientID}/callback",
 			false,
+			false,
 			true,
+			dal.RoleDeveloper,
+			false,
 			api.UpdateClientCallback,
 		},
 
@@ -128,33 +191,598 @@ func prepareRoutes(db *sql.DB, logger *logrus.Logger, authTypes auth.Types) Rout
 			"GET",
 			"/client/{clientID}/callback",
 			false,
+			false,
 			true,
+			dal.RoleViewer,
+			false,
 			api.GetClientCallback,
 		},
 
+		Route{
+			"GetClientKeys",
+			"GET",
+			"/client/{clientID}/keys",
+			false,
+			false,
+			true,
+			dal.RoleViewer,
+			false,
+			api.GetClientKeys,
+		},
+
+		Route{
+			"CreateClientKey",
+			"POST",
+			"/client/{clientID}/keys",
+			false,
+			false,
+			true,
+			dal.RoleDeveloper,
+			false,
+			api.CreateClientKey,
+		},
+
+		Route{
+			"RevokeClientKey",
+			"DELETE",
+			"/client/{clientID}/keys/{keyID}",
+			false,
+			false,
+			true,
+			dal.RoleDeveloper,
+			false,
+			api.RevokeClientKey,
+		},
+
+		Route{
+			"GetClientRedirectURIs",
+			"GET",
+			"/client/{clientID}/redirect-uris",
+			false,
+			false,
+			true,
+			dal.RoleViewer,
+			false,
+			api.GetClientRedirectURIs,
+		},
+
+		Route{
+			"CreateClientRedirectURI",
+			"POST",
+			"/client/{clientID}/redirect-uris",
+			false,
+			false,
+			true,
+			dal.RoleDeveloper,
+			false,
+			api.CreateClientRedirectURI,
+		},
+
+		Route{
+			"UpdateClientRedirectURI",
+			"PUT",
+			"/client/{clientID}/redirect-uris/{uriID}",
+			false,
+			false,
+			true,
+			dal.RoleDeveloper,
+			false,
+			api.UpdateClientRedirectURI,
+		},
+
+		Route{
+			"DeleteClientRedirectURI",
+			"DELETE",
+			"/client/{clientID}/redirect-uris/{uriID}",
+			false,
+			false,
+			true,
+			dal.RoleDeveloper,
+			false,
+			api.DeleteClientRedirectURI,
+		},
+
+		Route{
+			"ChangeClientPassword",
+			"POST",
+			"/client/{clientID}/password",
+			false,
+			false,
+			true,
+			"",
+			false,
+			api.ChangeClientPassword,
+		},
+
+		Route{
+			"RequestPasswordReset",
+			"POST",
+			"/password-reset-token",
+			false,
+			true,
+			false,
+			"",
+			false,
+			api.RequestPasswordReset,
+		},
+
+		Route{
+			"ChangeClientEmail",
+			"PUT",
+			"/client/{clientID}/email",
+			false,
+			false,
+			true,
+			"",
+			false,
+			api.ChangeClientEmail,
+		},
+
+		Route{
+			"ResetClientPassword",
+			"POST",
+			"/password-reset",
+			false,
+			true,
+			false,
+			"",
+			false,
+			api.ResetClientPassword,
+		},
+
 		Route{
 			"SignUp",
 			"POST",
 			"/signup",
 			false,
 			true,
+			false,
+			"",
+			false,
 			api.SignUp,
 		},
+
 		Route{
 			"SignIn",
 			"POST",
 			"/signin",
 			false,
 			true,
+			false,
+			"",
+			false,
 			api.SignIn,
 		},
 
+		Route{
+			"SignOut",
+			"POST",
+			"/signout",
+			false,
+			false,
+			true,
+			"",
+			false,
+			api.SignOut,
+		},
+
+		Route{
+			"GetSession",
+			"GET",
+			"/session",
+			false,
+			false,
+			true,
+			"",
+			false,
+			api.GetSession,
+		},
+
+		Route{
+			"GetOrganizations",
+			"GET",
+			"/organizations",
+			false,
+			false,
+			true,
+			"",
+			false,
+			api.GetOrganizations,
+		},
+
+		Route{
+			"CreateOrganization",
+			"POST",
+			"/organizations",
+			false,
+			false,
+			true,
+			"",
+			false,
+			api.CreateOrganization,
+		},
+
+		Route{
+			"GetOrganizationMembers",
+			"GET",
+			"/organization/{organizationID}/members",
+			false,
+			false,
+			true,
+			dal.RoleViewer,
+			false,
+			api.GetOrganizationMembers,
+		},
+
+		Route{
+			"UpdateOrganizationMember",
+			"PUT",
+			"/organization/{organizationID}/members/{memberID}",
+			false,
+			false,
+			true,
+			dal.RoleOwner,
+			false,
+			api.UpdateOrganizationMember,
+		},
+
+		Route{
+			"RemoveOrganizationMember",
+			"DELETE",
+			"/organization/{organizationID}/members/{memberID}",
+			false,
+			false,
+			true,
+			dal.RoleViewer,
+			false,
+			api.RemoveOrganizationMember,
+		},
+
+		Route{
+			"GetOrganizationClients",
+			"GET",
+			"/organization/{organizationID}/clients",
+			false,
+			false,
+			true,
+			dal.RoleViewer,
+			false,
+			api.GetOrganizationClients,
+		},
+
+		Route{
+			"GetOrganizationInvites",
+			"GET",
+			"/organization/{organizationID}/invites",
+			false,
+			false,
+			true,
+			dal.RoleOwner,
+			false,
+			api.GetOrganizationInvites,
+		},
+
+		Route{
+			"CreateOrganizationInvite",
+			"POST",
+			"/organization/{organizationID}/invites",
+			false,
+			false,
+			true,
+			dal.RoleOwner,
+			false,
+			api.CreateOrganizationInvite,
+		},
+
+		Route{
+			"CancelOrganizationInvite",
+			"DELETE",
+			"/organization/{organizationID}/invites/{inviteID}",
+			false,
+			false,
+			true,
+			dal.RoleOwner,
+			false,
+			api.CancelOrganizationInvite,
+		},
+
+		Route{
+			"GetPendingInvites",
+			"GET",
+			"/invites",
+			false,
+			false,
+			true,
+			"",
+			false,
+			api.GetPendingInvites,
+		},
+
+		Route{
+			"AcceptOrganizationInvite",
+			"POST",
+			"/invites/{inviteID}/accept",
+			false,
+			false,
+			true,
+			"",
+			false,
+			api.AcceptOrganizationInvite,
+		},
+
+		Route{
+			"DeclineOrganizationInvite",
+			"DELETE",
+			"/invites/{inviteID}",
+			false,
+			false,
+			true,
+			"",
+			false,
+			api.DeclineOrganizationInvite,
+		},
+
+		Route{
+			"TransferClient",
+			"PUT",
+			"/client/{clientID}/organization",
+			false,
+			false,
+			true,
+			dal.RoleOwner,
+			false,
+			api.TransferClient,
+		},
+
+		Route{
+			"GetUsers",
+			"GET",
+			"/users",
+			true,
+			false,
+			false,
+			"",
+			false,
+			api.GetUsers,
+		},
+
+		Route{
+			"GetUser",
+			"GET",
+			"/user/{userID}",
+			true,
+			false,
+			false,
+			"",
+			false,
+			api.GetUser,
+		},
+
+		Route{
+			"SetUserExternalRef",
+			"PUT",
+			"/user/{userID}/external-ref",
+			true,
+			false,
+			false,
+			"",
+			false,
+			api.SetUserExternalRef,
+		},
+
+		Route{
+			"MergeUsers",
+			"POST",
+			"/user/{userID}/merge",
+			true,
+			false,
+			false,
+			"",
+			false,
+			api.MergeUsers,
+		},
+
+		Route{
+			"UnlinkUserPlatform",
+			"DELETE",
+			"/user/{userID}/platforms/{platform}",
+			true,
+			false,
+			false,
+			"",
+			false,
+			api.UnlinkUserPlatform,
+		},
+
+		Route{
+			"DeleteUser",
+			"DELETE",
+			"/user/{userID}",
+			true,
+			false,
+			false,
+			"",
+			false,
+			api.DeleteUser,
+		},
+
+		Route{
+			"ExportUser",
+			"GET",
+			"/user/{userID}/export",
+			true,
+			false,
+			false,
+			"",
+			false,
+			api.ExportUser,
+		},
+
+		Route{
+			"GetUserPriorities",
+			"GET",
+			"/user/{userID}/priorities",
+			true,
+			false,
+			false,
+			"",
+			false,
+			api.GetUserPriorities,
+		},
+
+		Route{
+			"SetUserPriority",
+			"PUT",
+			"/user/{userID}/priorities/{resource}",
+			true,
+			false,
+			false,
+			"",
+			false,
+			api.SetUserPriority,
+		},
+
+		Route{
+			"GetUserActivities",
+			"GET",
+			"/user/{userID}/activities",
+			true,
+			false,
+			false,
+			"",
+			false,
+			api.GetUserActivities,
+		},
+
+		Route{
+			"PortalLogin",
+			"GET",
+			"/portal/login",
+			false,
+			false,
+			false,
+			"",
+			false,
+			api.PortalLogin,
+		},
+
+		Route{
+			"GetUserSession",
+			"GET",
+			"/portal/session",
+			false,
+			false,
+			false,
+			"",
+			true,
+			api.GetUserSession,
+		},
+
+		Route{
+			"PortalSignOut",
+			"POST",
+			"/portal/signout",
+			false,
+			false,
+			false,
+			"",
+			true,
+			api.PortalSignOut,
+		},
+
+		Route{
+			"GetPortalClients",
+			"GET",
+			"/portal/clients",
+			false,
+			false,
+			false,
+			"",
+			true,
+			api.GetPortalClients,
+		},
+
+		Route{
+			"RevokeClientAccess",
+			"DELETE",
+			"/portal/clients/{clientID}",
+			false,
+			false,
+			false,
+			"",
+			true,
+			api.RevokeClientAccess,
+		},
+
+		Route{
+			"GetClientSharing",
+			"GET",
+			"/portal/clients/{clientID}/sharing",
+			false,
+			false,
+			false,
+			"",
+			true,
+			api.GetClientSharing,
+		},
+
+		Route{
+			"UpdateClientSharing",
+			"PUT",
+			"/portal/clients/{clientID}/sharing",
+			false,
+			false,
+			false,
+			"",
+			true,
+			api.UpdateClientSharing,
+		},
+
+		Route{
+			"GetExpiringConsents",
+			"GET",
+			"/consents/expiring",
+			true,
+			false,
+			false,
+			"",
+			false,
+			api.GetExpiringConsents,
+		},
+
+		Route{
+			"GetClientEvents",
+			"GET",
+			"/events",
+			true,
+			false,
+			false,
+			"",
+			false,
+			api.GetClientEvents,
+		},
+
+		Route{
+			"GetValueIntraday",
+			"GET",
+			"/user/{userID}/{resource}/intraday",
+			true,
+			false,
+			false,
+			"",
+			false,
+			api.GetValueIntraday,
+		},
+
 		Route{
 			"GetValueOverPeriod",
 			"GET",
 			"/user/{userID}/{resource}/over-period",
 			true,
 			false,
+			false,
+			"",
+			false,
 			api.GetValueOverPeriod,
 		},
 	}
@@ -0,0 +1,323 @@
+/*
+ * mrthn API
+ *
+ * One login for all your fitness data needs.
+ *
+ * API version: 0.1.0
+ */
+package service
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/context"
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+
+	"github.com/msgurgel/mrthn/pkg/dal"
+)
+
+const clientSessionCookie = "mrthn_session"
+const clientSessionKind = "client"
+const userSessionCookie = "mrthn_user_session"
+const userSessionKind = "user"
+const sessionLifetime = 12 * time.Hour
+
+// csrfHeader must be set, with the CSRF token of the session, in every state-changing request
+const csrfHeader = "X-CSRF-Token"
+
+// session is stored in a cookie, signed with the session secret so it can't be tampered with
+type session struct {
+	Kind       string `json:"kind"`
+	SubjectID  int    `json:"sub"`
+	Generation int    `json:"gen"` // Client sessions stop working when the client's password changes
+	CSRFToken  string `json:"csrf"`
+	ExpiresAt  int64  `json:"exp"`
+}
+
+type sessionManager struct {
+	secret       []byte
+	secureCookie bool // Cookies are only sent over HTTPS. Disabled in development
+}
+
+func newSessionManager(secret []byte, secureCookie bool) sessionManager {
+	return sessionManager{
+		secret:       secret,
+		secureCookie: secureCookie,
+	}
+}
+
+// create issues a new session for the subject and sets its cookie in the response
+func (sm sessionManager) create(w http.ResponseWriter, cookieName string, kind string, subjectID int, generation int) (session, error) {
+	csrfToken, err := generateRandomToken(32)
+	if err != nil {
+		return session{}, err
+	}
+
+	s := session{
+		Kind:       kind,
+		SubjectID:  subjectID,
+		Generation: generation,
+		CSRFToken:  csrfToken,
+		ExpiresAt:  time.Now().Add(sessionLifetime).Unix(),
+	}
+
+	payload, err := json.Marshal(s)
+	if err != nil {
+		return session{}, err
+	}
+
+	encodedPayload := base64.RawURLEncoding.EncodeToString(payload)
+	cookieValue := encodedPayload + "." + sm.sign(encodedPayload)
+
+	http.SetCookie(w, sm.cookie(cookieName, cookieValue, time.Unix(s.ExpiresAt, 0)))
+
+	return s, nil
+}
+
+// read returns the session stored in the request's cookie, as long as it's valid and of the expected kind
+func (sm sessionManager) read(r *http.Request, cookieName string, kind string) (session, error) {
+	cookie, err := r.Cookie(cookieName)
+	if err != nil {
+		return session{}, errors.New("session cookie is missing")
+	}
+
+	parts := strings.Split(cookie.Value, ".")
+	if len(parts) != 2 {
+		return session{}, errors.New("session cookie is malformed")
+	}
+
+	if !hmac.Equal([]byte(parts[1]), []byte(sm.sign(parts[0]))) {
+		return session{}, errors.New("session cookie signature is invalid")
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return session{}, err
+	}
+
+	s := session{}
+	err = json.Unmarshal(payload, &s)
+	if err != nil {
+		return session{}, err
+	}
+
+	if s.Kind != kind {
+		return session{}, errors.New("session is of kind '" + s.Kind + "', expected '" + kind + "'")
+	}
+
+	if time.Now().Unix() > s.ExpiresAt {
+		return session{}, errors.New("session has expired")
+	}
+
+	return s, nil
+}
+
+// clear removes the session cookie from the caller
+func (sm sessionManager) clear(w http.ResponseWriter, cookieName string) {
+	http.SetCookie(w, sm.cookie(cookieName, "", time.Unix(0, 0)))
+}
+
+func (sm sessionManager) cookie(name string, value string, expires time.Time) *http.Cookie {
+	cookie := &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     "/",
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   sm.secureCookie,
+		SameSite: http.SameSiteLaxMode,
+	}
+
+	// The mrthn website is served from a different site than the API,
+	// so the cookie has to be allowed in cross-site requests
+	if sm.secureCookie {
+		cookie.SameSite = http.SameSiteNoneMode
+	}
+
+	return cookie
+}
+
+func (sm sessionManager) sign(payload string) string {
+	mac := hmac.New(sha256.New, sm.secret)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// clientSessionMiddleware only lets requests with a valid client session through. Sessions issued before
+// the client's password changed are rejected. State-changing requests must also send the session's CSRF token. Routes under /client/{clientID} or /organization/{organizationID}
+// need the signed in client to have at least memberRole in the organization. Without a memberRole,
+// /client/{clientID} routes can only be used by that client itself
+func clientSessionMiddleware(db *sql.DB, log *logrus.Logger, sessions sessionManager, memberRole string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s, err := sessions.read(r, clientSessionCookie, clientSessionKind)
+		if err != nil {
+			log.WithFields(logrus.Fields{
+				"err": err,
+			}).Warn("request without a valid client session")
+
+			sendSessionError(w, log, http.StatusUnauthorized, "Session is missing or has expired. Sign in again")
+			return
+		}
+
+		generation, err := dal.GetClientSessionGeneration(db, s.SubjectID)
+		if err != nil && err != sql.ErrNoRows {
+			log.WithFields(logrus.Fields{
+				"clientID": s.SubjectID,
+				"err":      err,
+			}).Error("failed to get the session generation of the signed in client")
+
+			sendSessionError(w, log, http.StatusInternalServerError, "Error occurred while checking the session")
+			return
+		}
+
+		if err == sql.ErrNoRows || generation != s.Generation {
+			log.WithFields(logrus.Fields{
+				"clientID": s.SubjectID,
+			}).Warn("request with a client session issued before the password changed")
+
+			sendSessionError(w, log, http.StatusUnauthorized, "Session is missing or has expired. Sign in again")
+			return
+		}
+
+		if !isSafeMethod(r.Method) {
+			csrfToken := r.Header.Get(csrfHeader)
+			if subtle.ConstantTimeCompare([]byte(csrfToken), []byte(s.CSRFToken)) != 1 {
+				log.WithFields(logrus.Fields{
+					"clientID": s.SubjectID,
+				}).Warn("request with missing or invalid CSRF token")
+
+				sendSessionError(w, log, http.StatusForbidden, "CSRF token is missing or invalid")
+				return
+			}
+		}
+
+		allowed, err := isSessionAllowed(db, mux.Vars(r), s.SubjectID, memberRole)
+		if err != nil {
+			log.WithFields(logrus.Fields{
+				"clientID": s.SubjectID,
+				"err":      err,
+			}).Error("failed to check the role of the signed in client")
+
+			sendSessionError(w, log, http.StatusInternalServerError, "Error occurred while checking permissions")
+			return
+		}
+
+		if !allowed {
+			log.WithFields(logrus.Fields{
+				"clientID": s.SubjectID,
+				"vars":     mux.Vars(r),
+				"role":     memberRole,
+			}).Warn("client tried to manage a client or organization without the required role")
+
+			sendSessionError(w, log, http.StatusForbidden, "Not allowed to manage this client or organization")
+			return
+		}
+
+		context.Set(r, "session_client_id", s.SubjectID)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// userSessionMiddleware only lets requests with a valid user portal session through.
+// State-changing requests must also send the session's CSRF token
+func userSessionMiddleware(log *logrus.Logger, sessions sessionManager, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s, err := sessions.read(r, userSessionCookie, userSessionKind)
+		if err != nil {
+			log.WithFields(logrus.Fields{
+				"err": err,
+			}).Warn("request without a valid user session")
+
+			sendSessionError(w, log, http.StatusUnauthorized, "Session is missing or has expired. Sign in again")
+			return
+		}
+
+		if !isSafeMethod(r.Method) {
+			csrfToken := r.Header.Get(csrfHeader)
+			if subtle.ConstantTimeCompare([]byte(csrfToken), []byte(s.CSRFToken)) != 1 {
+				log.WithFields(logrus.Fields{
+					"userID": s.SubjectID,
+				}).Warn("request with missing or invalid CSRF token")
+
+				sendSessionError(w, log, http.StatusForbidden, "CSRF token is missing or invalid")
+				return
+			}
+		}
+
+		context.Set(r, "session_user_id", s.SubjectID)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// isSessionAllowed checks the signed in client against the client or organization the route acts on
+func isSessionAllowed(db *sql.DB, vars map[string]string, sessionClientID int, memberRole string) (bool, error) {
+	if pathClientID, ok := vars["clientID"]; ok {
+		if memberRole == "" {
+			return pathClientID == strconv.Itoa(sessionClientID), nil
+		}
+
+		clientID, err := strconv.Atoi(pathClientID)
+		if err != nil {
+			return false, nil
+		}
+
+		role, err := dal.GetMemberRoleForClient(db, sessionClientID, clientID)
+		if err != nil {
+			return false, err
+		}
+
+		if !hasRole(role, memberRole) {
+			return false, nil
+		}
+	}
+
+	if pathOrganizationID, ok := vars["organizationID"]; ok {
+		organizationID, err := strconv.Atoi(pathOrganizationID)
+		if err != nil {
+			return false, nil
+		}
+
+		role, err := dal.GetMemberRole(db, organizationID, sessionClientID)
+		if err != nil {
+			return false, err
+		}
+
+		if !hasRole(role, memberRole) {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+func isSafeMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
+
+func sendSessionError(w http.ResponseWriter, log *logrus.Logger, code int, message string) {
+	response, _ := json.Marshal(ClientActionResponse{
+		Success: false,
+		Error:   message,
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+
+	_, err := w.Write(response)
+	if err != nil {
+		log.WithFields(logrus.Fields{
+			"err": err,
+		}).Error("failed to send response to client")
+	}
+}
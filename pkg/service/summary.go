@@ -0,0 +1,101 @@
+/*
+ * mrthn API
+ *
+ * One login for all your fitness data needs.
+ *
+ * API version: 0.1.0
+ */
+package service
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/msgurgel/mrthn/pkg/dal"
+	"github.com/msgurgel/mrthn/pkg/helpers"
+	"github.com/msgurgel/mrthn/pkg/model"
+)
+
+// GetDailySummary returns every daily resource of a user at once. Each platform is only asked once,
+// instead of once per resource
+func (api *Api) GetDailySummary(w http.ResponseWriter, r *http.Request) {
+	requestParams, err := api.getRequestParams(r, logrus.Fields{"func": "GetDailySummary"}, paramsMapRegular)
+	if err != nil {
+		api.respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// Verify the parameters we got from the request
+	verifiedParams, err := verifyParameters(requestParams)
+	if err != nil {
+		api.respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// Check if the client has access to this user
+	userID, publicID, ok := api.getQueriedUser(w, r, verifiedParams.userID)
+	if !ok {
+		return
+	}
+
+	// The user's consent may have expired, and they may not share everything with the client
+	sharing, ok := api.getSharingPreferences(w, r, userID)
+	if !ok {
+		return
+	}
+
+	priorities, ok := api.getPlatformPriorities(w, r, userID)
+	if !ok {
+		return
+	}
+
+	params := model.GetValueParams{
+		DB:         api.db,
+		Log:        api.log,
+		UserID:     userID,
+		Date:       verifiedParams.date,
+		Strategy:   verifiedParams.strategy,
+		Priority:   verifiedParams.priority,
+		Priorities: priorities,
+		Sharing:    sharing,
+		Units:      verifiedParams.units,
+	}
+	resources, err := model.GetUserDailySummary(params)
+	if err != nil {
+		// TODO: Change this to a more fitting HTTP code
+		api.respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	// Access history is kept by resource, so the user can see which ones each client read
+	for _, resource := range readResources(resources) {
+		api.recordUserEvent(r, userID, dal.UserEventDataAccessed,
+			fmt.Sprintf("%s summary on %s", resource, verifiedParams.date.Format(helpers.ISOLayout)))
+	}
+
+	response := DailySummaryResponse{
+		ID:        publicID,
+		Resources: resources,
+	}
+	api.respondWithJSON(w, http.StatusOK, response)
+}
+
+// readResources lists the resources of the summary the client got a value of. Resources the user withholds are left out
+func readResources(resources map[string][]model.ValueResult) []string {
+	var read []string
+	for resource, values := range resources {
+		for _, value := range values {
+			if !value.Withheld {
+				read = append(read, resource)
+				break
+			}
+		}
+	}
+
+	sort.Strings(read)
+
+	return read
+}
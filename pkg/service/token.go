@@ -1,9 +1,12 @@
 package service
 
 import (
+	"crypto/rand"
 	"database/sql"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
+	"io"
 	"net/http"
 	"strconv"
 	"strings"
@@ -17,31 +20,59 @@ import (
 
 type parseToken struct {
 	clientID int
+	keyID    int
 	valid    bool
 }
 
-func generateJWT(clientID int, secret []byte) (string, error) {
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.StandardClaims{
+func generateJWT(clientID int, keyID int, secret []byte, expiresAt sql.NullTime) (string, error) {
+	claims := jwt.StandardClaims{
 		// TODO: Make client ID not an integer
 		Audience: strconv.Itoa(clientID),
 		Issuer:   "mrthn",
-	})
+	}
+
+	// Tokens can't outlive the key that signed them
+	if expiresAt.Valid {
+		claims.ExpiresAt = expiresAt.Time.Unix()
+	}
 
-	// TODO: Add expiration to the token
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+
+	// The key ID tells us which of the client's keys to validate the token with
+	token.Header["kid"] = strconv.Itoa(keyID)
 
 	// Sign the token with the given secret
-	tokenString, _ := token.SignedString(secret)
+	tokenString, err := token.SignedString(secret)
+	if err != nil {
+		return "", err
+	}
+
 	return tokenString, nil
 }
 
+// generateRandomToken returns a random URL-safe string, generated from the given amount of bytes
+func generateRandomToken(numBytes int) (string, error) {
+	data := make([]byte, numBytes)
+	if _, err := io.ReadFull(rand.Reader, data); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
 func validateJWT(db *sql.DB, tokenString string) (parseToken, error) {
+	var keyID int
 	token, err := jwt.ParseWithClaims(tokenString, &jwt.StandardClaims{}, func(token *jwt.Token) (interface{}, error) {
-		if claims, ok := token.Claims.(*jwt.StandardClaims); ok {
-			clientID, _ := strconv.Atoi(claims.Audience)
-			return dal.GetClientSecret(db, clientID)
-		} else {
+		claims, ok := token.Claims.(*jwt.StandardClaims)
+		if !ok {
 			return nil, errors.New("unable to parse JWT claims")
 		}
+
+		clientID, _ := strconv.Atoi(claims.Audience)
+		var secret []byte
+		var err error
+		keyID, secret, err = getSigningKey(db, token, clientID)
+		return secret, err
 	})
 	if err != nil {
 		return parseToken{}, err
@@ -50,8 +81,13 @@ func validateJWT(db *sql.DB, tokenString string) (parseToken, error) {
 	if token.Valid {
 		claims, _ := token.Claims.(*jwt.StandardClaims)
 		clientID, _ := strconv.Atoi(claims.Audience)
+
+		// Not being able to track usage should not stop the request
+		_ = dal.UpdateClientKeyLastUsed(db, keyID)
+
 		return parseToken{
 			clientID: clientID,
+			keyID:    keyID,
 			valid:    true,
 		}, nil
 	}
@@ -60,6 +96,32 @@ func validateJWT(db *sql.DB, tokenString string) (parseToken, error) {
 
 }
 
+// getSigningKey finds the client key a token was signed with. Tokens generated before clients had several keys
+// have no 'kid' header, and are validated with the client's legacy key until the client revokes it
+func getSigningKey(db *sql.DB, token *jwt.Token, clientID int) (int, []byte, error) {
+	kid, ok := token.Header["kid"]
+	if !ok {
+		return dal.GetClientLegacyKey(db, clientID)
+	}
+
+	kidString, ok := kid.(string)
+	if !ok {
+		return 0, nil, errors.New("JWT 'kid' header must be a string")
+	}
+
+	keyID, err := strconv.Atoi(kidString)
+	if err != nil {
+		return 0, nil, errors.New("JWT 'kid' header must be an integer")
+	}
+
+	secret, err := dal.GetClientKeySecret(db, clientID, keyID)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	return keyID, secret, nil
+}
+
 func jwtMiddleware(db *sql.DB, log *logrus.Logger, next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		var token string
@@ -0,0 +1,695 @@
+/*
+ * mrthn API
+ *
+ * One login for all your fitness data needs.
+ *
+ * API version: 0.1.0
+ */
+package service
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/context"
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+
+	"github.com/msgurgel/mrthn/pkg/auth"
+	"github.com/msgurgel/mrthn/pkg/dal"
+	"github.com/msgurgel/mrthn/pkg/helpers"
+	"github.com/msgurgel/mrthn/pkg/model"
+	"github.com/msgurgel/mrthn/pkg/platform"
+)
+
+const defaultUsersPerPage = 50
+const maxUsersPerPage = 100
+const maxExternalRefLength = 255
+
+// The client merges the users right after the user logs in to both of them, so authorizations don't need to last long
+const mergeAuthorizationLifetime = time.Hour
+
+// Health of the credentials a user has for a platform
+const (
+	credentialsHealthy       = "healthy"
+	credentialsRefreshFailed = "refresh_failed"
+)
+
+func (api *Api) GetUsers(w http.ResponseWriter, r *http.Request) {
+	clientID := context.Get(r, "client_id").(int) // This was set during JWT validation middleware
+
+	page, ok := api.getPositiveQueryParam(w, r, "page", 1)
+	if !ok {
+		return
+	}
+
+	perPage, ok := api.getPositiveQueryParam(w, r, "perPage", defaultUsersPerPage)
+	if !ok {
+		return
+	}
+
+	if perPage > maxUsersPerPage {
+		api.respondWithError(w, http.StatusBadRequest,
+			"'perPage' parameter can't be larger than "+strconv.Itoa(maxUsersPerPage))
+		return
+	}
+
+	externalRef := r.URL.Query().Get("externalRef")
+	users, total, err := dal.GetUserbase(api.db, clientID, externalRef, perPage, (page-1)*perPage)
+	if err != nil {
+		api.log.WithFields(logrus.Fields{
+			"func":     "GetUsers",
+			"clientID": clientID,
+			"err":      err,
+		}).Error("failed to get client userbase")
+
+		api.respondWithError(w, http.StatusInternalServerError, "Something went wrong... Try again later")
+		return
+	}
+
+	response := UsersResponse{
+		Users:   make([]UserSummary, 0, len(users)),
+		Page:    page,
+		PerPage: perPage,
+		Total:   total,
+	}
+	for _, user := range users {
+		platforms := user.Platforms
+		if platforms == nil {
+			platforms = []string{}
+		}
+
+		response.Users = append(response.Users, UserSummary{
+			ID:                user.PublicID,
+			ExternalRef:       user.ExternalRef,
+			CreatedAt:         user.CreatedAt.Format(helpers.ISO8601Layout),
+			Platforms:         platforms,
+			WithheldPlatforms: user.WithheldPlatforms,
+		})
+	}
+
+	api.respondWithJSON(w, http.StatusOK, response)
+}
+
+func (api *Api) GetUser(w http.ResponseWriter, r *http.Request) {
+	// Check if the client has access to this user
+	userID, publicID, ok := api.getQueriedUser(w, r, mux.Vars(r)["userID"])
+	if !ok {
+		return
+	}
+
+	clientID := context.Get(r, "client_id").(int) // This was set during JWT validation middleware
+	user, err := dal.GetUserbaseEntry(api.db, userID, clientID)
+	if err != nil {
+		api.log.WithFields(logrus.Fields{
+			"func":   "GetUser",
+			"userID": userID,
+			"err":    err,
+		}).Error("failed to get user from the userbase")
+
+		api.respondWithError(w, http.StatusInternalServerError, "Something went wrong... Try again later")
+		return
+	}
+
+	links, err := dal.GetUserPlatformLinks(api.db, userID)
+	if err != nil {
+		api.log.WithFields(logrus.Fields{
+			"func":   "GetUser",
+			"userID": userID,
+			"err":    err,
+		}).Error("failed to get user platform links")
+
+		api.respondWithError(w, http.StatusInternalServerError, "Something went wrong... Try again later")
+		return
+	}
+
+	sharing, err := dal.GetSharingPreferences(api.db, userID, clientID)
+	if err != nil {
+		api.log.WithFields(logrus.Fields{
+			"func":   "GetUser",
+			"userID": userID,
+			"err":    err,
+		}).Error("failed to get user sharing preferences")
+
+		api.respondWithError(w, http.StatusInternalServerError, "Something went wrong... Try again later")
+		return
+	}
+
+	response := UserResponse{
+		ID:          publicID,
+		ExternalRef: user.ExternalRef,
+		CreatedAt:   user.CreatedAt.Format(helpers.ISO8601Layout),
+		Platforms:   make([]LinkedPlatform, 0, len(links)),
+	}
+	for _, link := range links {
+		response.Platforms = append(response.Platforms, formatPlatformLink(link, sharing))
+	}
+
+	api.respondWithJSON(w, http.StatusOK, response)
+}
+
+// SetUserExternalRef lets the client store its own ID for the user, so it can query the user by it.
+// An empty reference removes it
+func (api *Api) SetUserExternalRef(w http.ResponseWriter, r *http.Request) {
+	// Check if the client has access to this user
+	userID, publicID, ok := api.getQueriedUser(w, r, mux.Vars(r)["userID"])
+	if !ok {
+		return
+	}
+
+	clientID := context.Get(r, "client_id").(int) // This was set during JWT validation middleware
+	externalRef := r.FormValue("externalRef")
+	if externalRef != "" && !api.checkExternalRefAvailable(w, clientID, userID, externalRef) {
+		return
+	}
+
+	err := dal.SetUserExternalRef(api.db, userID, clientID, externalRef)
+	if err != nil {
+		api.log.WithFields(logrus.Fields{
+			"func":        "SetUserExternalRef",
+			"userID":      userID,
+			"externalRef": externalRef,
+			"err":         err,
+		}).Error("failed to set user external reference")
+
+		api.respondWithError(w, http.StatusInternalServerError, "Something went wrong... Try again later")
+		return
+	}
+
+	api.respondWithJSON(w, http.StatusOK, ExternalRefResponse{ID: publicID, ExternalRef: externalRef})
+}
+
+// MergeUsers moves another user of the client, along with its linked platforms and data, into the user in the path.
+// The merged user's ID keeps working as an alias of the user it was merged into. The user must have authorized it first
+// by logging in to both users, since every other client that has either of them ends up with the merged user
+func (api *Api) MergeUsers(w http.ResponseWriter, r *http.Request) {
+	// Check if the client has access to both users
+	targetID, targetPublicID, ok := api.getQueriedUser(w, r, mux.Vars(r)["userID"])
+	if !ok {
+		return
+	}
+
+	sourceRequestedID := r.FormValue("sourceUserId")
+	if sourceRequestedID == "" {
+		api.respondWithError(w, http.StatusBadRequest, "missing mandatory parameter 'sourceUserId'")
+		return
+	}
+
+	sourceID, sourcePublicID, ok := api.getQueriedUser(w, r, sourceRequestedID)
+	if !ok {
+		return
+	}
+
+	if sourceID == targetID {
+		api.respondWithError(w, http.StatusBadRequest, "A user can't be merged into itself")
+		return
+	}
+
+	clientID := context.Get(r, "client_id").(int) // This was set during JWT validation middleware
+	err := dal.MergeUsers(api.db, sourceID, targetID, clientID)
+	if err != nil {
+		if err == dal.ErrUsersShareAccount {
+			api.respondWithError(w, http.StatusConflict, "Both users have linked the same platform account")
+			return
+		}
+
+		if err == dal.ErrMergeNotAuthorized {
+			api.respondWithError(w, http.StatusForbidden,
+				"The user must authorize the merge first, by going through /login with 'mergeUserID' once with an account of each user")
+			return
+		}
+
+		api.log.WithFields(logrus.Fields{
+			"func":     "MergeUsers",
+			"sourceID": sourceID,
+			"targetID": targetID,
+			"err":      err,
+		}).Error("failed to merge users")
+
+		api.respondWithError(w, http.StatusInternalServerError, "Something went wrong... Try again later")
+		return
+	}
+
+	api.log.WithFields(logrus.Fields{
+		"sourceID": sourceID,
+		"targetID": targetID,
+		"clientID": clientID,
+	}).Info("users merged")
+
+	api.respondWithJSON(w, http.StatusOK, MergeUsersResponse{ID: targetPublicID, MergedID: sourcePublicID})
+}
+
+// authorizeMerge records that the user logged in with an account of one of the users being merged. Logging in
+// to both of them shows the same person owns them. The client gets the ID of the user the account belongs to
+func (api *Api) authorizeMerge(w http.ResponseWriter, r *http.Request, Oauth2Result *auth.OAuth2Result, callback string) {
+	ownerID, credentialID, err := dal.GetUserByPlatformID(api.db, Oauth2Result.PlatformID, Oauth2Result.PlatformName)
+	if err != nil {
+		api.log.WithFields(logrus.Fields{
+			"func": "authorizeMerge",
+			"err":  err,
+		}).Error("failed to get the user of the platform account")
+		api.sendFailedAuthorizationResult(w, r, callback)
+
+		return
+	}
+
+	if ownerID == 0 || (ownerID != Oauth2Result.UserID && ownerID != Oauth2Result.MergeUserID) {
+		api.log.WithFields(logrus.Fields{
+			"func":     "authorizeMerge",
+			"clientID": Oauth2Result.ClientID,
+			"targetID": Oauth2Result.UserID,
+			"sourceID": Oauth2Result.MergeUserID,
+		}).Warn("user authorized a merge with an account of neither user")
+		api.sendFailedAuthorizationResult(w, r, callback)
+
+		return
+	}
+
+	// The user just logged in with the account, so its tokens are the newest ones. Failing to store them doesn't fail the authorization
+	err = api.updateAccountTokens(Oauth2Result, credentialID)
+	if err != nil {
+		api.log.WithFields(logrus.Fields{
+			"func":   "authorizeMerge",
+			"userID": ownerID,
+			"err":    err,
+		}).Error("failed to update user tokens")
+	}
+
+	expiresAt := time.Now().Add(mergeAuthorizationLifetime)
+	err = dal.InsertMergeAuthorization(api.db, Oauth2Result.ClientID, Oauth2Result.MergeUserID, Oauth2Result.UserID, ownerID, expiresAt)
+	if err != nil {
+		api.log.WithFields(logrus.Fields{
+			"func":   "authorizeMerge",
+			"userID": ownerID,
+			"err":    err,
+		}).Error("failed to record merge authorization")
+		api.sendFailedAuthorizationResult(w, r, callback)
+
+		return
+	}
+
+	api.sendPublicUserID(w, r, ownerID, Oauth2Result.ClientID, callback)
+}
+
+// UnlinkUserPlatform revokes mrthn's access to one of the user's platforms and forgets its credentials and data
+func (api *Api) UnlinkUserPlatform(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	platformName := vars["platform"]
+	if !platform.IsPlatformAvailable(platformName) {
+		api.respondWithError(w, http.StatusBadRequest,
+			fmt.Sprintf("'platform' field must be a supported platform, received:'%s'", platformName))
+		return
+	}
+
+	// Check if the client has access to this user
+	userID, publicID, ok := api.getQueriedUser(w, r, vars["userID"])
+	if !ok {
+		return
+	}
+
+	accounts, err := dal.GetUserAccounts(api.db, userID)
+	if err != nil {
+		api.log.WithFields(logrus.Fields{
+			"func":   "UnlinkUserPlatform",
+			"userID": userID,
+			"err":    err,
+		}).Error("failed to get user accounts")
+
+		api.respondWithError(w, http.StatusInternalServerError, "Something went wrong... Try again later")
+		return
+	}
+
+	// Without an account label, every account of the platform is unlinked
+	label := r.URL.Query().Get("account")
+	var unlinking []dal.Account
+	for _, account := range accounts {
+		if account.Platform == platformName && (label == "" || account.Label == label) {
+			unlinking = append(unlinking, account)
+		}
+	}
+
+	if len(unlinking) == 0 {
+		if label != "" {
+			api.respondWithError(w, http.StatusNotFound, "User has not linked account '"+label+"' of platform '"+platformName+"'")
+			return
+		}
+
+		api.respondWithError(w, http.StatusNotFound, "User has not linked platform '"+platformName+"'")
+		return
+	}
+
+	clientID := context.Get(r, "client_id").(int) // This was set during JWT validation middleware
+	response := UnlinkPlatformResponse{
+		ID:       publicID,
+		Platform: platformName,
+		Accounts: []string{},
+	}
+	var deletedAccounts []dal.ErasedAccount
+	for _, account := range unlinking {
+		erased, deleted, err := dal.DeleteUserAccount(api.db, userID, clientID, account)
+		if err == dal.ErrUserHasOtherClients {
+			// Another client relies on the same credentials. The client can still stop having the user
+			api.respondWithError(w, http.StatusConflict,
+				"Other clients also have this user, so its platforms can't be unlinked. Delete the user from your userbase instead")
+			return
+		}
+		if err != nil {
+			api.log.WithFields(logrus.Fields{
+				"func":     "UnlinkUserPlatform",
+				"userID":   userID,
+				"platform": platformName,
+				"account":  account.Label,
+				"err":      err,
+			}).Error("failed to delete user account")
+
+			api.respondWithError(w, http.StatusInternalServerError, "Something went wrong... Try again later")
+			return
+		}
+
+		if deleted {
+			deletedAccounts = append(deletedAccounts, erased)
+			response.Accounts = append(response.Accounts, account.Label)
+		}
+	}
+
+	// The accounts are only gone once their deletion is committed, so mrthn gives up its access to them afterwards.
+	// The platform may refuse, since the user may have already revoked access to mrthn on the platform itself
+	response.Revoked = api.revokeAccounts(userID, deletedAccounts)
+
+	api.log.WithFields(logrus.Fields{
+		"userID":   userID,
+		"clientID": clientID,
+		"platform": platformName,
+		"accounts": response.Accounts,
+		"revoked":  response.Revoked,
+	}).Info("platform unlinked from user")
+
+	api.respondWithJSON(w, http.StatusOK, response)
+}
+
+// DeleteUser removes the user from the client's userbase. If no other client has the user,
+// its tokens are revoked at every platform and all of its data is erased
+func (api *Api) DeleteUser(w http.ResponseWriter, r *http.Request) {
+	// Check if the client has access to this user
+	userID, publicID, ok := api.getQueriedUser(w, r, mux.Vars(r)["userID"])
+	if !ok {
+		return
+	}
+
+	clientID := context.Get(r, "client_id").(int) // This was set during JWT validation middleware
+	erased, accounts, err := dal.DeleteUser(api.db, userID, clientID)
+	if err != nil {
+		api.log.WithFields(logrus.Fields{
+			"func":   "DeleteUser",
+			"userID": userID,
+			"err":    err,
+		}).Error("failed to delete user")
+
+		api.respondWithError(w, http.StatusInternalServerError, "Something went wrong... Try again later")
+		return
+	}
+
+	// The user is only gone once the erasure is committed, so mrthn gives up its access to the user's platforms afterwards
+	if erased {
+		api.revokeAccounts(userID, accounts)
+	}
+
+	api.log.WithFields(logrus.Fields{
+		"userID":   userID,
+		"clientID": clientID,
+		"erased":   erased,
+	}).Info("user deleted from client userbase")
+
+	api.respondWithJSON(w, http.StatusOK, DeleteUserResponse{ID: publicID, Erased: erased})
+}
+
+// ExportUser sends back an archive of the user's linked accounts, stored metrics and the client's access history
+func (api *Api) ExportUser(w http.ResponseWriter, r *http.Request) {
+	// Check if the client has access to this user
+	userID, publicID, ok := api.getQueriedUser(w, r, mux.Vars(r)["userID"])
+	if !ok {
+		return
+	}
+
+	clientID := context.Get(r, "client_id").(int) // This was set during JWT validation middleware
+
+	// Exporting is itself an access, so it's recorded before the history is read
+	api.recordUserEvent(r, userID, dal.UserEventDataExported, "")
+
+	export, err := api.buildUserExport(userID, clientID)
+	if err != nil {
+		api.log.WithFields(logrus.Fields{
+			"func":   "ExportUser",
+			"userID": userID,
+			"err":    err,
+		}).Error("failed to build user export")
+
+		api.respondWithError(w, http.StatusInternalServerError, "Something went wrong... Try again later")
+		return
+	}
+
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"mrthn-user-%s.json\"", publicID))
+	api.respondWithJSON(w, http.StatusOK, export)
+}
+
+func (api *Api) buildUserExport(userID int, clientID int) (UserExport, error) {
+	user, err := dal.GetUserbaseEntry(api.db, userID, clientID)
+	if err != nil {
+		return UserExport{}, err
+	}
+
+	links, err := dal.GetUserPlatformLinks(api.db, userID)
+	if err != nil {
+		return UserExport{}, err
+	}
+
+	data, err := dal.GetUserData(api.db, userID)
+	if err != nil {
+		return UserExport{}, err
+	}
+
+	events, err := dal.GetUserEvents(api.db, userID, clientID)
+	if err != nil {
+		return UserExport{}, err
+	}
+
+	sharing, err := dal.GetSharingPreferences(api.db, userID, clientID)
+	if err != nil {
+		return UserExport{}, err
+	}
+
+	consents, err := dal.GetUserConsents(api.db, userID, clientID)
+	if err != nil {
+		return UserExport{}, err
+	}
+
+	priorities, err := dal.GetPlatformPriorities(api.db, userID, clientID)
+	if err != nil {
+		return UserExport{}, err
+	}
+
+	export := UserExport{
+		ID:             user.PublicID,
+		ExternalRef:    user.ExternalRef,
+		ExportedAt:     time.Now().Format(helpers.ISO8601Layout),
+		AddedAt:        user.CreatedAt.Format(helpers.ISO8601Layout),
+		LinkedAccounts: make([]ExportedAccount, 0, len(links)),
+		Metrics:        make([]ExportedMetrics, 0, len(data)),
+		AccessHistory:  make([]ExportedEvent, 0, len(events)),
+		Withheld:       newSharingResponse(sharing),
+		Consents:       make([]ConsentResponse, 0, len(consents)),
+		Priorities:     newPrioritiesResponse(priorities),
+	}
+
+	for _, link := range links {
+		export.LinkedAccounts = append(export.LinkedAccounts, exportedAccount(link, sharing))
+	}
+
+	for _, day := range data {
+		export.Metrics = append(export.Metrics, exportedMetrics(day, sharing))
+	}
+
+	for _, event := range events {
+		export.AccessHistory = append(export.AccessHistory, ExportedEvent{
+			Event:   event.Event,
+			Details: event.Details,
+			Time:    event.CreatedAt.Format(helpers.ISO8601Layout),
+		})
+	}
+
+	for _, consent := range consents {
+		export.Consents = append(export.Consents, formatConsent(consent))
+	}
+
+	return export, nil
+}
+
+// exportedAccount formats one of the user's platform accounts. Like the values read from a withheld account,
+// an account of a platform the user doesn't share with the client only has its platform listed
+func exportedAccount(link dal.PlatformLink, sharing dal.SharingPreferences) ExportedAccount {
+	if !sharing.SharesPlatform(link.Platform) {
+		return ExportedAccount{Platform: link.Platform, Withheld: true}
+	}
+
+	account := ExportedAccount{
+		Platform: link.Platform,
+		Label:    link.Label,
+	}
+
+	account.LinkedAt = link.LinkedAt.Format(helpers.ISO8601Layout)
+	if link.LastRefreshed.Valid {
+		account.LastRefreshed = link.LastRefreshed.Time.Format(helpers.ISO8601Layout)
+	}
+
+	return account
+}
+
+// exportedMetrics formats a day of metrics, leaving out the resources the user doesn't share with the client
+func exportedMetrics(day dal.UserData, sharing dal.SharingPreferences) ExportedMetrics {
+	metrics := ExportedMetrics{
+		Platform: day.Platform,
+		Date:     day.Date.Format(helpers.ISOLayout),
+	}
+
+	if sharing.Shares(model.ResourceSteps, day.Platform) {
+		metrics.Steps = day.Steps
+	} else {
+		metrics.Withheld = append(metrics.Withheld, model.ResourceSteps)
+	}
+
+	if sharing.Shares(model.ResourceCalories, day.Platform) {
+		metrics.Calories = day.Calories
+	} else {
+		metrics.Withheld = append(metrics.Withheld, model.ResourceCalories)
+	}
+
+	if sharing.Shares(model.ResourceDistance, day.Platform) {
+		metrics.Distance = day.Distance
+	} else {
+		metrics.Withheld = append(metrics.Withheld, model.ResourceDistance)
+	}
+
+	return metrics
+}
+
+// revokeAccounts revokes the tokens of deleted platform accounts. Failures are only logged, since the user
+// may have already revoked access to mrthn on the platform itself. It returns false if any of them failed
+func (api *Api) revokeAccounts(userID int, accounts []dal.ErasedAccount) bool {
+	revoked := true
+	for _, account := range accounts {
+		if account.Tokens == nil {
+			revoked = false
+
+			api.log.WithFields(logrus.Fields{
+				"userID":   userID,
+				"platform": account.Platform,
+				"account":  account.Label,
+			}).Warn("erased user account had no tokens to revoke")
+			continue
+		}
+
+		err := platform.Platforms[account.Platform].RevokeAccess(account.Tokens)
+		if err != nil {
+			revoked = false
+
+			api.log.WithFields(logrus.Fields{
+				"userID":   userID,
+				"platform": account.Platform,
+				"account":  account.Label,
+				"err":      err,
+			}).Warn("failed to revoke user tokens at the platform")
+		}
+	}
+
+	return revoked
+}
+
+// recordUserEvent adds an action taken by the requesting client to the user's access history.
+// Failing to record it doesn't stop the request
+func (api *Api) recordUserEvent(r *http.Request, userID int, event string, details string) {
+	clientID := context.Get(r, "client_id").(int) // This was set during JWT validation middleware
+
+	err := dal.InsertUserEvent(api.db, userID, clientID, event, details)
+	if err != nil {
+		api.log.WithFields(logrus.Fields{
+			"userID":   userID,
+			"clientID": clientID,
+			"event":    event,
+			"err":      err,
+		}).Error("failed to record user event")
+	}
+}
+
+// checkExternalRefAvailable makes sure the client can give the external reference to the user.
+// userID is zero when the user isn't known yet. If the reference can't be used, an error is sent back to the caller
+func (api *Api) checkExternalRefAvailable(w http.ResponseWriter, clientID int, userID int, externalRef string) bool {
+	if len(externalRef) > maxExternalRefLength {
+		api.respondWithError(w, http.StatusBadRequest,
+			"'externalRef' parameter can't be longer than "+strconv.Itoa(maxExternalRefLength)+" characters")
+		return false
+	}
+
+	ownerID, _, err := dal.GetUserByExternalRef(api.db, externalRef, clientID)
+	if err != nil {
+		api.log.WithFields(logrus.Fields{
+			"clientID":    clientID,
+			"externalRef": externalRef,
+			"err":         err,
+		}).Error("failed to get user by external reference")
+
+		api.respondWithError(w, http.StatusInternalServerError, "Something went wrong... Try again later")
+		return false
+	}
+
+	if ownerID != 0 && ownerID != userID {
+		api.respondWithError(w, http.StatusConflict, "'externalRef' is already used by another user")
+		return false
+	}
+
+	return true
+}
+
+// getPositiveQueryParam parses an optional positive integer from the query, using defaultValue when it's missing
+func (api *Api) getPositiveQueryParam(w http.ResponseWriter, r *http.Request, name string, defaultValue int) (int, bool) {
+	valueStr := r.URL.Query().Get(name)
+	if valueStr == "" {
+		return defaultValue, true
+	}
+
+	value, err := strconv.Atoi(valueStr)
+	if err != nil || value < 1 {
+		api.respondWithError(w, http.StatusBadRequest, "'"+name+"' parameter must be a positive integer")
+		return 0, false
+	}
+
+	return value, true
+}
+
+// formatPlatformLink describes one of the user's platform accounts. Like the values read from a withheld account,
+// an account of a platform the user doesn't share with the client only has its platform listed
+func formatPlatformLink(link dal.PlatformLink, sharing dal.SharingPreferences) LinkedPlatform {
+	if !sharing.SharesPlatform(link.Platform) {
+		return LinkedPlatform{Name: link.Platform, Withheld: true}
+	}
+
+	result := LinkedPlatform{
+		Name:     link.Platform,
+		Account:  link.Label,
+		LinkedAt: link.LinkedAt.Format(helpers.ISO8601Layout),
+		Status:   credentialsHealthy,
+	}
+
+	if link.LastRefreshed.Valid {
+		result.LastRefreshed = link.LastRefreshed.Time.Format(helpers.ISO8601Layout)
+	}
+
+	if link.RefreshFailedAt.Valid {
+		result.Status = credentialsRefreshFailed
+		result.RefreshFailedAt = link.RefreshFailedAt.Time.Format(helpers.ISO8601Layout)
+		result.RefreshError = link.RefreshError.String
+	}
+
+	return result
+}